@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/apikeys"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// registerAPIKeyRoutes adds API key management for org admins. Keys can never
+// manage other keys.
+func registerAPIKeyRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, apiKeyService *apikeys.APIKeyService) {
+	keys := org.Group("/api-keys", middleware.RequirePermission(orgService, orgs.PermAPIKeysManage))
+	{
+		keys.POST("", func(c *gin.Context) {
+			var req CreateAPIKeyRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "INVALID_REQUEST",
+					Message:    err.Error(),
+					StatusCode: http.StatusBadRequest,
+				}))
+				return
+			}
+
+			if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "INVALID_REQUEST",
+					Message:    "expires_at must be in the future",
+					StatusCode: http.StatusBadRequest,
+				}))
+				return
+			}
+
+			key, rawKey, err := apiKeyService.Create(c.Param("orgID"), req.Name, req.Scopes, req.ExpiresAt, middleware.AuditActor(c))
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, apikeys.ErrInvalidScope) {
+					status = http.StatusBadRequest
+				}
+				c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "API_KEY_CREATE_ERROR",
+					Message:    "Failed to create API key",
+					Details:    err.Error(),
+					StatusCode: status,
+				}))
+				return
+			}
+
+			// The plaintext key is only ever returned in this response
+			c.JSON(http.StatusCreated, types.NewSuccessResponse(gin.H{"api_key": key, "key": rawKey}, nil))
+		})
+
+		keys.GET("", func(c *gin.Context) {
+			list, err := apiKeyService.List(c.Param("orgID"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "API_KEY_FETCH_ERROR",
+					Message:    "Failed to fetch API keys",
+					Details:    err.Error(),
+					StatusCode: http.StatusInternalServerError,
+				}))
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+		})
+
+		keys.DELETE("/:keyID", func(c *gin.Context) {
+			err := apiKeyService.Revoke(c.Param("orgID"), c.Param("keyID"), middleware.AuditActor(c))
+			if errors.Is(err, apikeys.ErrKeyNotFound) {
+				c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "API_KEY_NOT_FOUND",
+					Message:    "API key not found",
+					StatusCode: http.StatusNotFound,
+				}))
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "API_KEY_REVOKE_ERROR",
+					Message:    "Failed to revoke API key",
+					Details:    err.Error(),
+					StatusCode: http.StatusInternalServerError,
+				}))
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "API key revoked"}, nil))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type SubscribeRequest struct {
+	Currency string `json:"currency"`
+}
+
+// respondBillingError maps billing errors to responses, using code for
+// anything unexpected
+func respondBillingError(c *gin.Context, err error, code string) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, billing.ErrPlanNotFound):
+		status, code = http.StatusNotFound, "PLAN_NOT_FOUND"
+	case errors.Is(err, money.ErrUnknownCurrency), errors.Is(err, money.ErrInvalidAmount), errors.Is(err, billing.ErrInvalidPlanPrice):
+		status, code = http.StatusBadRequest, "INVALID_AMOUNT"
+	case errors.Is(err, billing.ErrPriceNotAvailable):
+		status, code = http.StatusUnprocessableEntity, "PRICE_NOT_AVAILABLE"
+	case errors.Is(err, billing.ErrCurrencyLocked):
+		status, code = http.StatusConflict, "CURRENCY_LOCKED"
+	case errors.Is(err, billing.ErrInvoiceNotFound):
+		status, code = http.StatusNotFound, "INVOICE_NOT_FOUND"
+	case errors.Is(err, billing.ErrInvalidCreditAmount), errors.Is(err, billing.ErrInvalidCreditReason):
+		status, code = http.StatusBadRequest, "INVALID_CREDIT"
+	case errors.Is(err, billing.ErrInvoiceNotFinalized), errors.Is(err, billing.ErrCreditExceedsInvoice),
+		errors.Is(err, billing.ErrInsufficientCredit), errors.Is(err, billing.ErrNoBillingCurrency):
+		status, code = http.StatusUnprocessableEntity, "CREDIT_NOT_ALLOWED"
+	case errors.Is(err, billing.ErrPaymentNotFound):
+		status, code = http.StatusNotFound, "PAYMENT_NOT_FOUND"
+	case errors.Is(err, billing.ErrInvalidPayment):
+		status, code = http.StatusUnprocessableEntity, "INVALID_PAYMENT"
+	case errors.Is(err, billing.ErrConsolidatedInvoiceNotFound):
+		status, code = http.StatusNotFound, "INVOICE_NOT_FOUND"
+	case errors.Is(err, billing.ErrConsolidatedInvoicePaid):
+		status, code = http.StatusConflict, "INVOICE_ALREADY_PAID"
+	case errors.Is(err, billing.ErrInvoiceNotPaid), errors.Is(err, billing.ErrRefundExceedsCaptured):
+		status, code = http.StatusUnprocessableEntity, "REFUND_NOT_ALLOWED"
+	case errors.Is(err, billing.ErrRefundFailed):
+		status, code = http.StatusBadGateway, "REFUND_FAILED"
+	case errors.Is(err, billing.ErrCouponNotFound):
+		status, code = http.StatusNotFound, "COUPON_NOT_FOUND"
+	case errors.Is(err, billing.ErrCouponExists):
+		status, code = http.StatusConflict, "COUPON_EXISTS"
+	case errors.Is(err, billing.ErrInvalidCoupon):
+		status, code = http.StatusBadRequest, "INVALID_COUPON"
+	case errors.Is(err, billing.ErrScheduleNotFound):
+		status, code = http.StatusNotFound, "SCHEDULE_NOT_FOUND"
+	case errors.Is(err, billing.ErrNoActiveSubscription):
+		status, code = http.StatusUnprocessableEntity, "NO_ACTIVE_SUBSCRIPTION"
+	case errors.Is(err, billing.ErrInvalidSchedule), errors.Is(err, billing.ErrPhaseInPast), errors.Is(err, billing.ErrInvalidQuantity):
+		status, code = http.StatusBadRequest, "INVALID_SCHEDULE"
+	case errors.Is(err, billing.ErrInvalidPauseBehavior), errors.Is(err, billing.ErrInvalidResumeDate):
+		status, code = http.StatusBadRequest, "INVALID_PAUSE"
+	case errors.Is(err, billing.ErrSubscriptionPaused), errors.Is(err, billing.ErrSubscriptionNotPaused),
+		errors.Is(err, billing.ErrScheduleActive), errors.Is(err, billing.ErrScheduleWhilePaused):
+		status, code = http.StatusConflict, "PAUSE_NOT_ALLOWED"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
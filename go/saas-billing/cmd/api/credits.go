@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type PageQuery struct {
+	Page     int `form:"page" binding:"omitempty,min=1"`
+	PageSize int `form:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// Omit amount_cents to credit everything left on the invoice
+type CreateCreditNoteRequest struct {
+	AmountCents int    `json:"amount_cents" binding:"omitempty,min=1"`
+	Reason      string `json:"reason" binding:"required"`
+	Memo        string `json:"memo"`
+}
+
+// A negative amount takes credit back
+type CreditAdjustmentRequest struct {
+	AmountCents int    `json:"amount_cents" binding:"required"`
+	Description string `json:"description" binding:"required"`
+}
+
+// registerCreditRoutes adds the organization's view of its credit balance
+// and credit notes
+func registerCreditRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	read := org.Group("/billing", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead))
+
+	read.GET("/credit-balance", func(c *gin.Context) {
+		balance, err := billingService.GetCreditBalance(c.Param("orgID"))
+		if err != nil {
+			respondBillingError(c, err, "CREDIT_BALANCE_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(balance, nil))
+	})
+
+	read.GET("/credit-balance/transactions", func(c *gin.Context) {
+		var q PageQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if q.Page == 0 {
+			q.Page = 1
+		}
+		if q.PageSize == 0 {
+			q.PageSize = 20
+		}
+
+		transactions, total, err := billingService.ListCreditTransactions(c.Param("orgID"), q.Page, q.PageSize)
+		if err != nil {
+			respondBillingError(c, err, "CREDIT_BALANCE_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewPaginatedResponse(transactions, q.Page, q.PageSize, total))
+	})
+
+	read.GET("/credit-notes", func(c *gin.Context) {
+		notes, err := billingService.ListCreditNotes(c.Param("orgID"), c.Query("invoice_id"))
+		if err != nil {
+			respondBillingError(c, err, "CREDIT_NOTES_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(notes, nil))
+	})
+}
+
+// registerCreditAdminRoutes lets platform admins issue credit notes and
+// grant credit. Organizations can't credit themselves.
+func registerCreditAdminRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/organizations/:orgID")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.POST("/invoices/:invoiceID/credit-notes", func(c *gin.Context) {
+		var req CreateCreditNoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		note, err := billingService.CreateCreditNote(c.Param("orgID"), c.Param("invoiceID"), req.AmountCents,
+			req.Reason, req.Memo, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "CREDIT_NOTE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(note, nil))
+	})
+
+	admin.POST("/credit-balance/adjustments", func(c *gin.Context) {
+		var req CreditAdjustmentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		transaction, err := billingService.AdjustCreditBalance(c.Param("orgID"), req.AmountCents, req.Description, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "CREDIT_BALANCE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(transaction, nil))
+	})
+}
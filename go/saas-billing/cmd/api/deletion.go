@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+// purgeInterval is how often the purge job looks for deleted organizations
+// past their retention period
+const purgeInterval = time.Hour
+
+// registerDeletionRoutes adds organization deletion and restore. Restoring
+// can't go through the permission middleware because deleted organizations
+// have no effective members; the service checks ownership itself.
+func registerDeletionRoutes(orgGroup *gin.RouterGroup, orgService *orgs.OrganizationService) {
+	orgGroup.GET("/deleted", middleware.RequireUser(), func(c *gin.Context) {
+		list, err := orgService.ListDeleted(c.GetString("userID"))
+		if err != nil {
+			respondDeletionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	orgGroup.DELETE("/:orgID", middleware.RequireRole(orgService, "owner"), func(c *gin.Context) {
+		result, err := orgService.Delete(c.Param("orgID"), middleware.AuditActor(c))
+		if err != nil {
+			respondDeletionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(result, nil))
+	})
+
+	orgGroup.POST("/:orgID/restore", middleware.RequireUser(), func(c *gin.Context) {
+		if err := orgService.Restore(c.Param("orgID"), middleware.AuditActor(c)); err != nil {
+			respondDeletionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Organization restored"}, nil))
+	})
+}
+
+// startPurgeJob purges deleted organizations in the background until the
+// process exits
+func startPurgeJob(orgService *orgs.OrganizationService) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := orgService.PurgeDeleted()
+			if err != nil {
+				logger.Error("Failed to purge deleted organizations", err, logger.Fields{"purged": purged})
+			} else if purged > 0 {
+				logger.Info("Purged deleted organizations", logger.Fields{"purged": purged})
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func respondDeletionError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "ORGANIZATION_DELETE_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrOrganizationNotFound):
+		status, code = http.StatusNotFound, "ORGANIZATION_NOT_FOUND"
+	case errors.Is(err, orgs.ErrOrganizationDeleted):
+		status, code = http.StatusConflict, "ORGANIZATION_DELETED"
+	case errors.Is(err, orgs.ErrHasChildren):
+		status, code = http.StatusConflict, "HAS_CHILD_ORGANIZATIONS"
+	case errors.Is(err, orgs.ErrNotDeleted):
+		status, code = http.StatusConflict, "NOT_RESTORABLE"
+	case errors.Is(err, orgs.ErrRestoreNotAllowed):
+		status, code = http.StatusForbidden, "RESTORE_NOT_ALLOWED"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
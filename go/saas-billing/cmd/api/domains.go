@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/sso"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type AddDomainRequest struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+type UpdateDomainRequest struct {
+	JoinPolicy  *string `json:"join_policy" binding:"omitempty,oneof=off auto request"`
+	DefaultRole *string `json:"default_role" binding:"omitempty,min=1,max=50"`
+	EnforceSSO  *bool   `json:"enforce_sso"`
+}
+
+type ApproveJoinRequestRequest struct {
+	Role string `json:"role" binding:"max=50"`
+}
+
+// registerEmailVerificationRoutes adds verification link redemption, which
+// is also when users join organizations through their email domain
+func registerEmailVerificationRoutes(public, protected *gin.RouterGroup, userService *users.UserService, domainService *orgs.DomainService) {
+	public.POST("/verify-email", func(c *gin.Context) {
+		var req VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		user, err := userService.VerifyEmail(req.Token)
+		if errors.Is(err, users.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_VERIFICATION_TOKEN",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if errors.Is(err, users.ErrEmailTaken) {
+			respondUserError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "EMAIL_VERIFICATION_ERROR",
+				Message:    "Failed to verify email",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		// The address is verified either way; a failed join is logged and
+		// doesn't fail the request
+		join, err := domainService.JoinByDomain(user.ID, user.Email)
+		if err != nil {
+			logger.Error("Failed to join organization by domain", err, logger.Fields{"user_id": user.ID})
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"email_verified": true, "domain_join": join}, nil))
+	})
+
+	protected.POST("/auth/verify-email/resend", middleware.RequireUser(), func(c *gin.Context) {
+		err := userService.ResendVerification(c.GetString("userID"))
+		if errors.Is(err, users.ErrEmailAlreadyVerified) {
+			c.JSON(http.StatusConflict, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "EMAIL_ALREADY_VERIFIED",
+				Message:    err.Error(),
+				StatusCode: http.StatusConflict,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "EMAIL_VERIFICATION_ERROR",
+				Message:    "Failed to send verification email",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Verification email sent"}, nil))
+	})
+}
+
+// registerDomainRoutes adds domain verification, domain settings and join
+// requests
+func registerDomainRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, domainService *orgs.DomainService, ssoService *sso.SSOService) {
+	domains := org.Group("/domains", middleware.RequirePermission(orgService, orgs.PermOrgManage))
+
+	domains.GET("", func(c *gin.Context) {
+		list, err := domainService.ListDomains(c.Param("orgID"))
+		if err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	domains.POST("", func(c *gin.Context) {
+		var req AddDomainRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		domain, err := domainService.AddDomain(c.Param("orgID"), req.Domain, middleware.AuditActor(c))
+		if err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(domain, nil))
+	})
+
+	domains.POST("/:domainID/verify", func(c *gin.Context) {
+		domain, err := domainService.VerifyDomain(c.Param("orgID"), c.Param("domainID"), middleware.AuditActor(c))
+		if err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(domain, nil))
+	})
+
+	domains.PATCH("/:domainID", func(c *gin.Context) {
+		var req UpdateDomainRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		orgID := c.Param("orgID")
+		if req.DefaultRole != nil {
+			if *req.DefaultRole == "owner" {
+				respondMemberError(c, orgs.ErrOwnerRoleProtected)
+				return
+			}
+			if err := orgService.CanAssignRole(orgID, c.GetString("userRole"), *req.DefaultRole); err != nil {
+				respondMemberError(c, err)
+				return
+			}
+		}
+
+		// Enforcing SSO without a working connection for the domain would
+		// lock everyone at it out
+		if req.EnforceSSO != nil && *req.EnforceSSO {
+			domain, err := domainService.GetDomain(orgID, c.Param("domainID"))
+			if err != nil {
+				respondDomainError(c, err)
+				return
+			}
+			if !ssoCoversDomain(ssoService, orgID, domain.Domain) {
+				c.JSON(http.StatusConflict, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "SSO_NOT_CONFIGURED",
+					Message:    "Enable an SSO connection that allows this domain first",
+					StatusCode: http.StatusConflict,
+				}))
+				return
+			}
+		}
+
+		domain, err := domainService.UpdateDomainSettings(orgID, c.Param("domainID"), orgs.DomainSettings{
+			JoinPolicy:  req.JoinPolicy,
+			DefaultRole: req.DefaultRole,
+			EnforceSSO:  req.EnforceSSO,
+		}, middleware.AuditActor(c))
+		if err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(domain, nil))
+	})
+
+	domains.DELETE("/:domainID", func(c *gin.Context) {
+		if err := domainService.DeleteDomain(c.Param("orgID"), c.Param("domainID"), middleware.AuditActor(c)); err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Domain removed"}, nil))
+	})
+
+	requests := org.Group("/join-requests", middleware.RequirePermission(orgService, orgs.PermMembersManage))
+
+	requests.GET("", func(c *gin.Context) {
+		list, err := domainService.ListJoinRequests(c.Param("orgID"))
+		if err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	requests.POST("/:requestID/approve", func(c *gin.Context) {
+		var req ApproveJoinRequestRequest
+		// The body is optional
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		orgID := c.Param("orgID")
+		if req.Role != "" {
+			if err := orgService.CanAssignRole(orgID, c.GetString("userRole"), req.Role); err != nil {
+				respondMemberError(c, err)
+				return
+			}
+		}
+
+		if err := domainService.ApproveJoinRequest(orgID, c.Param("requestID"), req.Role, middleware.AuditActor(c)); err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Join request approved"}, nil))
+	})
+
+	requests.POST("/:requestID/reject", func(c *gin.Context) {
+		if err := domainService.RejectJoinRequest(c.Param("orgID"), c.Param("requestID"), middleware.AuditActor(c)); err != nil {
+			respondDomainError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Join request rejected"}, nil))
+	})
+}
+
+// ssoCoversDomain reports whether the organization has an enabled SSO
+// connection, its own or its parent's, that accepts the domain
+func ssoCoversDomain(ssoService *sso.SSOService, orgID, domain string) bool {
+	conn, err := ssoService.EffectiveConnection(orgID)
+	if err != nil || !conn.Enabled {
+		return false
+	}
+	for _, d := range conn.AllowedDomains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePasswordAllowed rejects password sign-up and sign-in for emails at
+// a domain that enforces SSO. It reports whether the request may continue.
+// The response doesn't name the organization, since anyone can ask.
+func requirePasswordAllowed(c *gin.Context, domainService *orgs.DomainService, email string) bool {
+	orgID, err := domainService.SSORequired(email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+			Code:       "SSO_CHECK_ERROR",
+			Message:    "Failed to check sign-in policy",
+			Details:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		}))
+		return false
+	}
+	if orgID != "" {
+		c.JSON(http.StatusForbidden, types.NewErrorResponse(&types.ErrorInfo{
+			Code:       "SSO_REQUIRED",
+			Message:    orgs.ErrSSORequired.Error(),
+			StatusCode: http.StatusForbidden,
+		}))
+		return false
+	}
+	return true
+}
+
+func respondDomainError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "DOMAIN_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrInvalidDomain):
+		status, code = http.StatusBadRequest, "INVALID_DOMAIN"
+	case errors.Is(err, orgs.ErrDomainNotFound):
+		status, code = http.StatusNotFound, "DOMAIN_NOT_FOUND"
+	case errors.Is(err, orgs.ErrDomainExists):
+		status, code = http.StatusConflict, "DOMAIN_EXISTS"
+	case errors.Is(err, orgs.ErrDomainClaimed):
+		status, code = http.StatusConflict, "DOMAIN_CLAIMED"
+	case errors.Is(err, orgs.ErrDomainNotVerified):
+		status, code = http.StatusConflict, "DOMAIN_NOT_VERIFIED"
+	case errors.Is(err, orgs.ErrVerificationFailed):
+		status, code = http.StatusUnprocessableEntity, "VERIFICATION_FAILED"
+	case errors.Is(err, orgs.ErrJoinRequestNotFound):
+		status, code = http.StatusNotFound, "JOIN_REQUEST_NOT_FOUND"
+	case errors.Is(err, orgs.ErrSeatLimitReached):
+		status, code = http.StatusPaymentRequired, "SEAT_LIMIT_REACHED"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type SetParentRequest struct {
+	ParentID string `json:"parent_id" binding:"required"`
+}
+
+type ConsolidatedInvoiceRequest struct {
+	StartDate time.Time `json:"start_date" binding:"required"`
+	EndDate   time.Time `json:"end_date" binding:"required"`
+}
+
+// registerHierarchyRoutes adds parent/child links and the parent's
+// consolidated invoices
+func registerHierarchyRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	org.GET("/children", middleware.RequirePermission(orgService, orgs.PermMembersRead), func(c *gin.Context) {
+		children, err := orgService.ListChildren(c.Param("orgID"))
+		if err != nil {
+			respondHierarchyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(children, nil))
+	})
+
+	// Linking needs org:manage in both organizations; the parent side is
+	// checked by the service
+	org.PUT("/parent", middleware.RequirePermission(orgService, orgs.PermOrgManage), func(c *gin.Context) {
+		var req SetParentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		if err := orgService.SetParent(c.Param("orgID"), req.ParentID, middleware.AuditActor(c)); err != nil {
+			respondHierarchyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"parent_id": req.ParentID}, nil))
+	})
+
+	org.DELETE("/parent", middleware.RequirePermission(orgService, orgs.PermOrgManage), func(c *gin.Context) {
+		if err := orgService.RemoveParent(c.Param("orgID"), middleware.AuditActor(c)); err != nil {
+			respondHierarchyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Parent organization removed"}, nil))
+	})
+
+	org.POST("/billing/consolidated-invoices", middleware.RequireScope("billing:write"), middleware.RequirePermission(orgService, orgs.PermBillingManage), func(c *gin.Context) {
+		var req ConsolidatedInvoiceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if !req.EndDate.After(req.StartDate) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    "end_date must be after start_date",
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		inv, err := billingService.CreateConsolidatedInvoice(c.Param("orgID"), req.StartDate, req.EndDate, middleware.AuditActor(c))
+		if err != nil {
+			respondHierarchyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(inv, nil))
+	})
+
+	org.GET("/billing/consolidated-invoices", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
+		invoices, err := billingService.GetConsolidatedInvoices(c.Param("orgID"))
+		if err != nil {
+			respondHierarchyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(invoices, nil))
+	})
+}
+
+func respondHierarchyError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "HIERARCHY_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrInvalidHierarchy):
+		status, code = http.StatusConflict, "INVALID_HIERARCHY"
+	case errors.Is(err, orgs.ErrParentPermission):
+		status, code = http.StatusForbidden, "PARENT_PERMISSION_REQUIRED"
+	case errors.Is(err, orgs.ErrNoParent):
+		status, code = http.StatusNotFound, "NO_PARENT"
+	case errors.Is(err, billing.ErrChildOrganization):
+		status, code = http.StatusConflict, "CHILD_ORGANIZATION"
+	case errors.Is(err, billing.ErrNothingToConsolidate):
+		status, code = http.StatusUnprocessableEntity, "NOTHING_TO_CONSOLIDATE"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
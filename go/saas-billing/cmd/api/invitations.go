@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,max=50"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password"` // only needed when no account exists yet
+}
+
+type DeclineInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// registerInvitationRoutes lets owners and admins manage invitations
+func registerInvitationRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, invitationService *orgs.InvitationService) {
+	invitations := org.Group("/invitations")
+
+	invitations.POST("", middleware.RequirePermission(orgService, orgs.PermMembersInvite), func(c *gin.Context) {
+		var req CreateInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		orgID := c.Param("orgID")
+		if err := orgService.CanAssignRole(orgID, c.GetString("userRole"), req.Role); err != nil {
+			respondMemberError(c, err)
+			return
+		}
+
+		inv, err := invitationService.Create(orgID, req.Email, req.Role, middleware.AuditActor(c))
+		if errors.Is(err, orgs.ErrAlreadyMember) || errors.Is(err, orgs.ErrAlreadyInvited) {
+			c.JSON(http.StatusConflict, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVITATION_CONFLICT",
+				Message:    err.Error(),
+				StatusCode: http.StatusConflict,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVITATION_CREATE_ERROR",
+				Message:    "Failed to create invitation",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(inv, nil))
+	})
+
+	invitations.GET("", middleware.RequirePermission(orgService, orgs.PermMembersInvite), func(c *gin.Context) {
+		list, err := invitationService.ListPending(c.Param("orgID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVITATION_FETCH_ERROR",
+				Message:    "Failed to fetch invitations",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	invitations.DELETE("/:invitationID", middleware.RequirePermission(orgService, orgs.PermMembersInvite), func(c *gin.Context) {
+		err := invitationService.Revoke(c.Param("orgID"), c.Param("invitationID"), middleware.AuditActor(c))
+		if errors.Is(err, orgs.ErrInvitationNotFound) {
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVITATION_NOT_FOUND",
+				Message:    "Pending invitation not found",
+				StatusCode: http.StatusNotFound,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVITATION_REVOKE_ERROR",
+				Message:    "Failed to revoke invitation",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Invitation revoked"}, nil))
+	})
+}
+
+// registerInvitationResponseRoutes handles the link emailed to the invitee.
+// The signed token is the credential, so these routes are public.
+func registerInvitationResponseRoutes(public *gin.RouterGroup, invitationService *orgs.InvitationService) {
+	public.POST("/invitations/accept", func(c *gin.Context) {
+		var req AcceptInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		result, err := invitationService.Accept(req.Token, req.Password)
+		if err != nil {
+			respondInvitationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(result, nil))
+	})
+
+	public.POST("/invitations/decline", func(c *gin.Context) {
+		var req DeclineInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		if err := invitationService.Decline(req.Token); err != nil {
+			respondInvitationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Invitation declined"}, nil))
+	})
+}
+
+func respondInvitationError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "INVITATION_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrInvitationNotFound):
+		status, code = http.StatusNotFound, "INVITATION_NOT_FOUND"
+	case errors.Is(err, orgs.ErrInvitationExpired):
+		status, code = http.StatusGone, "INVITATION_EXPIRED"
+	case errors.Is(err, orgs.ErrSeatLimitReached):
+		status, code = http.StatusPaymentRequired, "SEAT_LIMIT_REACHED"
+	case errors.Is(err, orgs.ErrAlreadyMember):
+		status, code = http.StatusConflict, "ALREADY_MEMBER"
+	case errors.Is(err, orgs.ErrPasswordRequired):
+		status, code = http.StatusBadRequest, "PASSWORD_REQUIRED"
+	case errors.Is(err, users.ErrWeakPassword):
+		status, code = http.StatusBadRequest, "WEAK_PASSWORD"
+	case errors.Is(err, orgs.ErrSSORequired):
+		status, code = http.StatusForbidden, "SSO_REQUIRED"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// LedgerExportQuery filters the ledger export. Times are RFC 3339.
+type LedgerExportQuery struct {
+	Currency string    `form:"currency"`
+	Since    time.Time `form:"since"`
+	Until    time.Time `form:"until"`
+}
+
+func (q LedgerExportQuery) filter() ledger.Filter {
+	return ledger.Filter{Currency: q.Currency, Since: q.Since, Until: q.Until}
+}
+
+// RevenueReportQuery is a LedgerExportQuery split into days or months
+type RevenueReportQuery struct {
+	LedgerExportQuery
+	Period string `form:"period"`
+}
+
+var ledgerExportHeader = []string{
+	"entry_id", "seq", "posted_at", "org_id", "currency", "type", "reference_type", "reference_id",
+	"account", "debit", "credit", "description",
+}
+
+// registerLedgerRoutes adds the platform-wide trial balance and ledger
+// export. Only platform admins can see them.
+func registerLedgerRoutes(protected *gin.RouterGroup, userService *users.UserService, ledgerService *ledger.LedgerService) {
+	admin := protected.Group("/admin/ledger")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("/trial-balance", func(c *gin.Context) {
+		var q struct {
+			AsOf time.Time `form:"as_of"`
+		}
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		balances, err := ledgerService.TrialBalances(q.AsOf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "TRIAL_BALANCE_ERROR",
+				Message:    "Failed to compute trial balance",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(balances, nil))
+	})
+
+	admin.GET("/revenue", func(c *gin.Context) {
+		var q RevenueReportQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if q.Period == "" {
+			q.Period = "month"
+		}
+
+		periods, err := ledgerService.RevenueReport(q.filter(), q.Period)
+		if errors.Is(err, ledger.ErrInvalidPeriod) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "REVENUE_REPORT_ERROR",
+				Message:    "Failed to compute revenue report",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(periods, nil))
+	})
+
+	// One CSV row per ledger line, in posting order, with amounts in major
+	// units. Rows are streamed, so a failure part way through can only be
+	// logged; the status is already sent.
+	admin.GET("/export", func(c *gin.Context) {
+		var q LedgerExportQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ledger-%s.csv"`, time.Now().UTC().Format("20060102")))
+		c.Status(http.StatusOK)
+
+		w := csv.NewWriter(c.Writer)
+		err := w.Write(ledgerExportHeader)
+		if err == nil {
+			err = ledgerService.Export(q.filter(), func(r ledger.ExportRow) error {
+				return w.Write(ledgerExportRecord(r))
+			})
+		}
+		w.Flush()
+		if err == nil {
+			err = w.Error()
+		}
+		if err != nil {
+			logger.Error("Failed to export ledger", err, logger.Fields{"currency": q.Currency})
+		}
+	})
+}
+
+func ledgerExportRecord(r ledger.ExportRow) []string {
+	amount := func(cents int) string {
+		if cents == 0 {
+			return ""
+		}
+		return money.Money{Amount: cents, Currency: r.Currency}.Decimal()
+	}
+
+	return []string{
+		r.EntryID,
+		strconv.FormatInt(r.Seq, 10),
+		r.PostedAt.UTC().Format(time.RFC3339),
+		r.OrgID,
+		r.Currency,
+		r.Type,
+		r.ReferenceType,
+		r.ReferenceID,
+		r.Account,
+		amount(r.DebitCents),
+		amount(r.CreditCents),
+		r.Description,
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type UnlockRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// registerUnlockRoute handles the link emailed when an account is locked
+func registerUnlockRoute(public *gin.RouterGroup, userService *users.UserService) {
+	public.POST("/unlock", func(c *gin.Context) {
+		var req UnlockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		if err := userService.UnlockWithToken(req.Token); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_UNLOCK_TOKEN",
+				Message:    "Invalid or expired unlock link",
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Account unlocked"}, nil))
+	})
+}
+
+// registerAdminUnlockRoute lets platform admins unlock an account. An
+// account can belong to several organizations, so organization admins can't.
+func registerAdminUnlockRoute(protected *gin.RouterGroup, userService *users.UserService) {
+	admin := protected.Group("/admin/users/:userID")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.POST("/unlock", func(c *gin.Context) {
+		err := userService.UnlockByAdmin(c.Param("userID"), middleware.AuditActor(c))
+		if errors.Is(err, users.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "USER_NOT_FOUND",
+				Message:    "User not found",
+				StatusCode: http.StatusNotFound,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "UNLOCK_ERROR",
+				Message:    "Failed to unlock account",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Account unlocked"}, nil))
+	})
+}
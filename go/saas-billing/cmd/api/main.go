@@ -1,17 +1,28 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/linkmeAman/saas-billing/internal/apikeys"
+	"github.com/linkmeAman/saas-billing/internal/audit"
 	"github.com/linkmeAman/saas-billing/internal/billing"
 	"github.com/linkmeAman/saas-billing/internal/db"
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+	"github.com/linkmeAman/saas-billing/internal/mailer"
+	"github.com/linkmeAman/saas-billing/internal/metrics"
 	"github.com/linkmeAman/saas-billing/internal/middleware"
 	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/sso"
+	"github.com/linkmeAman/saas-billing/internal/tax"
 	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/usage"
 	"github.com/linkmeAman/saas-billing/internal/users"
 )
 
@@ -31,7 +42,11 @@ type CreateOrgRequest struct {
 
 type AddMemberRequest struct {
 	UserID string `json:"user_id" binding:"required"`
-	Role   string `json:"role" binding:"required,oneof=admin member"`
+	Role   string `json:"role" binding:"required,max=50"`
+}
+
+type SecurityPolicyRequest struct {
+	RequireMFAForAdmins *bool `json:"require_mfa_for_admins" binding:"required"`
 }
 
 func main() {
@@ -46,11 +61,27 @@ func main() {
 	defer database.Close()
 
 	// Initialize services
-	userService := users.NewUserService(database)
-	orgService := orgs.NewOrganizationService(database)
-	billingService := billing.NewBillingService(database)
+	auditService := audit.NewAuditService(database)
+	mailService := mailer.New()
+	userService := users.NewUserService(database, auditService, mailService)
+	orgService := orgs.NewOrganizationService(database, auditService)
+	invitationService := orgs.NewInvitationService(database, auditService, mailService)
+	domainService := orgs.NewDomainService(database, auditService, nil)
+	taxCalculator := tax.NewRateCalculator(database, os.Getenv("TAX_ORIGIN_COUNTRY"))
+	billingService := billing.NewBillingService(database, auditService, taxCalculator, tax.NewVIESValidator(), nil)
+	ledgerService := ledger.NewLedgerService(database)
+	metricsService := metrics.NewMetricsService(database)
+	apiKeyService := apikeys.NewAPIKeyService(database, auditService)
+	usageService := usage.NewUsageService(database)
+	ssoService := sso.NewSSOService(database, auditService, userService)
+
+	startPurgeJob(orgService)
+	startRecognitionJob(billingService)
+	startRenewalJob(billingService)
+	startRefundReconcileJob(billingService)
 
 	r := gin.Default()
+	r.Use(middleware.RequestID())
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -81,7 +112,21 @@ func main() {
 					return
 				}
 
-				if err := userService.Register(req.Email, req.Password); err != nil {
+				if !requirePasswordAllowed(c, domainService, req.Email) {
+					return
+				}
+
+				err := userService.Register(req.Email, req.Password)
+				if errors.Is(err, users.ErrWeakPassword) {
+					c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+						Code:       "WEAK_PASSWORD",
+						Message:    "Password does not meet the password policy",
+						Details:    err.Error(),
+						StatusCode: http.StatusBadRequest,
+					}))
+					return
+				}
+				if err != nil {
 					c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
 						Code:       "REGISTRATION_ERROR",
 						Message:    "Failed to register user",
@@ -105,29 +150,70 @@ func main() {
 					return
 				}
 
-				token, err := userService.Login(req.Email, req.Password)
-				if err != nil {
+				if !requirePasswordAllowed(c, domainService, req.Email) {
+					return
+				}
+
+				result, err := userService.Login(req.Email, req.Password, c.ClientIP())
+				var throttled *users.ThrottleError
+				if errors.As(err, &throttled) {
+					c.Header("Retry-After", fmt.Sprintf("%.0f", throttled.RetryAfter.Seconds()))
+					c.JSON(http.StatusTooManyRequests, types.NewErrorResponse(&types.ErrorInfo{
+						Code:       "TOO_MANY_ATTEMPTS",
+						Message:    "Too many failed login attempts",
+						StatusCode: http.StatusTooManyRequests,
+					}))
+					return
+				}
+				if errors.Is(err, users.ErrInvalidCredentials) {
 					c.JSON(http.StatusUnauthorized, types.NewErrorResponse(&types.ErrorInfo{
 						Code:       "INVALID_CREDENTIALS",
 						Message:    "Invalid credentials",
-						Details:    err.Error(),
 						StatusCode: http.StatusUnauthorized,
 					}))
 					return
 				}
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+						Code:       "LOGIN_ERROR",
+						Message:    "Failed to log in",
+						Details:    err.Error(),
+						StatusCode: http.StatusInternalServerError,
+					}))
+					return
+				}
 
-				c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"token": token}, nil))
+				c.JSON(http.StatusOK, types.NewSuccessResponse(result, nil))
 			})
+
+			registerSSORoutes(auth, ssoService)
+			registerUnlockRoute(auth, userService)
 		}
 
+		registerInvitationResponseRoutes(v1, invitationService)
+
 		// Protected routes
 		protected := v1.Group("")
-		protected.Use(middleware.AuthRequired())
+		protected.Use(middleware.AuthRequired(apiKeyService))
 		{
-			orgs := protected.Group("/organizations")
+			registerMFARoutes(auth, protected, userService)
+			registerEmailVerificationRoutes(auth, protected, userService, domainService)
+			registerUserRoutes(protected, userService, domainService)
+			registerTaxRateRoutes(protected, userService, taxCalculator)
+			registerPlanAdminRoutes(protected, userService, billingService)
+			registerCreditAdminRoutes(protected, userService, billingService)
+			registerRefundAdminRoutes(protected, userService, billingService)
+			registerLedgerRoutes(protected, userService, ledgerService)
+			registerRevenueScheduleRoutes(protected, userService, billingService)
+			registerMetricsRoutes(protected, userService, metricsService)
+			registerSubscriptionAdminRoutes(protected, userService, billingService)
+			registerCouponAdminRoutes(protected, userService, billingService)
+			registerAdminUnlockRoute(protected, userService)
+
+			orgGroup := protected.Group("/organizations")
 			{
 				// Create organization
-				orgs.POST("", func(c *gin.Context) {
+				orgGroup.POST("", middleware.RequireUser(), func(c *gin.Context) {
 					var req CreateOrgRequest
 					if err := c.ShouldBindJSON(&req); err != nil {
 						c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
@@ -154,9 +240,9 @@ func main() {
 				})
 
 				// List user's organizations
-				orgs.GET("", func(c *gin.Context) {
+				orgGroup.GET("", middleware.RequireUser(), func(c *gin.Context) {
 					userID := c.GetString("userID")
-					orgs, err := orgService.GetUserOrgs(userID)
+					userOrgs, err := orgService.GetUserOrgs(userID)
 					if err != nil {
 						c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
 							Code:       "ORGANIZATION_FETCH_ERROR",
@@ -167,14 +253,16 @@ func main() {
 						return
 					}
 
-					c.JSON(http.StatusOK, types.NewSuccessResponse(orgs, nil))
+					c.JSON(http.StatusOK, types.NewSuccessResponse(userOrgs, nil))
 				})
 
+				registerDeletionRoutes(orgGroup, orgService)
+
 				// Organization-specific routes
-				org := orgs.Group("/:orgID")
+				org := orgGroup.Group("/:orgID")
 				{
-					// Add member to organization (admin only)
-					org.POST("/members", middleware.RequireRole(orgService, "owner", "admin"), func(c *gin.Context) {
+					// Add member to organization
+					org.POST("/members", middleware.RequirePermission(orgService, orgs.PermMembersManage), func(c *gin.Context) {
 						var req AddMemberRequest
 						if err := c.ShouldBindJSON(&req); err != nil {
 							c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
@@ -186,7 +274,21 @@ func main() {
 						}
 
 						orgID := c.Param("orgID")
-						if err := orgService.AddMember(orgID, req.UserID, req.Role); err != nil {
+						if err := orgService.CanAssignRole(orgID, c.GetString("userRole"), req.Role); err != nil {
+							respondMemberError(c, err)
+							return
+						}
+
+						err := orgService.AddMember(orgID, req.UserID, req.Role, middleware.AuditActor(c))
+						if errors.Is(err, orgs.ErrSeatLimitReached) {
+							c.JSON(http.StatusPaymentRequired, types.NewErrorResponse(&types.ErrorInfo{
+								Code:       "SEAT_LIMIT_REACHED",
+								Message:    err.Error(),
+								StatusCode: http.StatusPaymentRequired,
+							}))
+							return
+						}
+						if err != nil {
 							c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
 								Code:       "MEMBER_ADD_ERROR",
 								Message:    "Failed to add member",
@@ -195,16 +297,59 @@ func main() {
 							}))
 							return
 						}
-						
+
 						c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Member added successfully"}, nil))
 					})
 
+					// Update security policy
+					org.PUT("/security", middleware.RequirePermission(orgService, orgs.PermOrgManage), func(c *gin.Context) {
+						var req SecurityPolicyRequest
+						if err := c.ShouldBindJSON(&req); err != nil {
+							c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+								Code:       "INVALID_REQUEST",
+								Message:    err.Error(),
+								StatusCode: http.StatusBadRequest,
+							}))
+							return
+						}
+
+						orgID := c.Param("orgID")
+						if err := orgService.SetRequireMFAForAdmins(orgID, *req.RequireMFAForAdmins, middleware.AuditActor(c)); err != nil {
+							c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+								Code:       "SECURITY_POLICY_UPDATE_ERROR",
+								Message:    "Failed to update security policy",
+								Details:    err.Error(),
+								StatusCode: http.StatusInternalServerError,
+							}))
+							return
+						}
+
+						c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"require_mfa_for_admins": *req.RequireMFAForAdmins}, nil))
+					})
+
+					registerMemberRoutes(org, orgService)
+					registerRoleRoutes(org, orgService)
+					registerTeamRoutes(org, orgService)
+					registerOwnershipRoutes(org, orgService)
+					registerHierarchyRoutes(org, orgService, billingService)
+					registerBillingProfileRoutes(org, orgService, billingService)
+					registerCreditRoutes(org, orgService, billingService)
+					registerRefundRoutes(org, orgService, billingService)
+					registerSubscriptionHistoryRoutes(org, orgService, billingService)
+					registerScheduleRoutes(org, orgService, billingService)
+					registerPauseRoutes(org, orgService, billingService)
+					registerInvitationRoutes(org, orgService, invitationService)
+					registerAPIKeyRoutes(org, orgService, apiKeyService)
+					registerUsageRoutes(org, orgService, usageService)
+					registerSSOConfigRoutes(org, orgService, ssoService)
+					registerDomainRoutes(org, orgService, domainService, ssoService)
+					registerAuditRoutes(org, orgService, auditService)
+
 					// Billing routes
 					billing := org.Group("/billing")
-					billing.Use(middleware.RequireRole(orgService, "owner", "admin"))
 					{
 						// Get available plans
-						billing.GET("/plans", func(c *gin.Context) {
+						billing.GET("/plans", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
 							plans, err := billingService.GetPlans()
 							if err != nil {
 								c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
@@ -220,26 +365,31 @@ func main() {
 						})
 
 						// Subscribe to plan
-						billing.POST("/subscribe/:planID", func(c *gin.Context) {
+						billing.POST("/subscribe/:planID", middleware.RequireScope("billing:write"), middleware.RequirePermission(orgService, orgs.PermBillingManage), func(c *gin.Context) {
 							orgID := c.Param("orgID")
 							planID := c.Param("planID")
 
-							sub, err := billingService.CreateSubscription(orgID, planID)
-							if err != nil {
-								c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
-									Code:       "SUBSCRIPTION_CREATE_ERROR",
-									Message:    "Failed to create subscription",
-									Details:    err.Error(),
-									StatusCode: http.StatusInternalServerError,
+							var req SubscribeRequest
+							if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+								c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+									Code:       "INVALID_REQUEST",
+									Message:    err.Error(),
+									StatusCode: http.StatusBadRequest,
 								}))
 								return
 							}
 
+							sub, err := billingService.CreateSubscription(orgID, planID, req.Currency, middleware.AuditActor(c))
+							if err != nil {
+								respondBillingError(c, err, "SUBSCRIPTION_CREATE_ERROR")
+								return
+							}
+
 							c.JSON(http.StatusCreated, types.NewSuccessResponse(sub, nil))
 						})
 
 						// Get current subscription
-						billing.GET("/subscription", func(c *gin.Context) {
+						billing.GET("/subscription", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
 							orgID := c.Param("orgID")
 							sub, err := billingService.GetOrgSubscription(orgID)
 							if err != nil {
@@ -265,7 +415,7 @@ func main() {
 						})
 
 						// Get invoices
-						billing.GET("/invoices", func(c *gin.Context) {
+						billing.GET("/invoices", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
 							orgID := c.Param("orgID")
 							invoices, err := billingService.GetInvoices(orgID)
 							if err != nil {
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type ListMembersQuery struct {
+	Page     int `form:"page" binding:"omitempty,min=1"`
+	PageSize int `form:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,max=50"`
+}
+
+// registerMemberRoutes adds listing, role changes, removal and leaving
+func registerMemberRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService) {
+	org.GET("/members", middleware.RequirePermission(orgService, orgs.PermMembersRead), func(c *gin.Context) {
+		var q ListMembersQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if q.Page == 0 {
+			q.Page = 1
+		}
+		if q.PageSize == 0 {
+			q.PageSize = 20
+		}
+
+		members, total, err := orgService.ListMembers(c.Param("orgID"), q.Page, q.PageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "MEMBER_FETCH_ERROR",
+				Message:    "Failed to fetch members",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewPaginatedResponse(members, q.Page, q.PageSize, total))
+	})
+
+	// Leave the organization. Registered before /:userID so "me" isn't
+	// taken for a user ID.
+	org.DELETE("/members/me", middleware.RequireMember(orgService), func(c *gin.Context) {
+		if err := orgService.Leave(c.Param("orgID"), middleware.AuditActor(c)); err != nil {
+			respondMemberError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Left organization"}, nil))
+	})
+
+	org.PATCH("/members/:userID", middleware.RequirePermission(orgService, orgs.PermMembersManage), func(c *gin.Context) {
+		var req UpdateMemberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		orgID := c.Param("orgID")
+		if err := orgService.CanAssignRole(orgID, c.GetString("userRole"), req.Role); err != nil {
+			respondMemberError(c, err)
+			return
+		}
+
+		err := orgService.UpdateMemberRole(orgID, c.Param("userID"), req.Role, c.GetString("userRole"), middleware.AuditActor(c))
+		if err != nil {
+			respondMemberError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Member role updated"}, nil))
+	})
+
+	org.DELETE("/members/:userID", middleware.RequirePermission(orgService, orgs.PermMembersManage), func(c *gin.Context) {
+		if err := orgService.RemoveMember(c.Param("orgID"), c.Param("userID"), c.GetString("userRole"), middleware.AuditActor(c)); err != nil {
+			respondMemberError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Member removed"}, nil))
+	})
+}
+
+func respondMemberError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "MEMBER_UPDATE_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrMemberNotFound):
+		status, code = http.StatusNotFound, "MEMBER_NOT_FOUND"
+	case errors.Is(err, orgs.ErrLastOwner):
+		status, code = http.StatusConflict, "LAST_OWNER"
+	case errors.Is(err, orgs.ErrOwnerRoleProtected):
+		status, code = http.StatusForbidden, "OWNER_ROLE_PROTECTED"
+	case errors.Is(err, orgs.ErrPermissionEscalation):
+		status, code = http.StatusForbidden, "PERMISSION_ESCALATION"
+	case errors.Is(err, orgs.ErrRoleNotFound):
+		status, code = http.StatusBadRequest, "INVALID_ROLE"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
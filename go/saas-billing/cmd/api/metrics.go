@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/metrics"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// MetricsQuery picks the months and currency of a metrics report. Times are
+// RFC 3339; the default is the last 12 months up to now.
+type MetricsQuery struct {
+	Currency string    `form:"currency"`
+	Since    time.Time `form:"since"`
+	Until    time.Time `form:"until"`
+	Format   string    `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+var mrrCSVHeader = []string{
+	"period_start", "currency", "starting_mrr", "new", "expansion", "contraction", "churn", "reactivation",
+	"ending_mrr", "arr", "starting_accounts", "new_accounts", "churned_accounts", "ending_accounts",
+	"logo_churn_rate", "revenue_churn_rate", "net_revenue_churn_rate", "arpa",
+}
+
+var cohortCSVHeader = []string{
+	"cohort", "currency", "accounts", "starting_mrr", "months_since", "retained_accounts", "logo_retention",
+	"mrr", "revenue_retention",
+}
+
+// registerMetricsRoutes adds the platform-wide SaaS metrics. Only platform
+// admins can see them.
+func registerMetricsRoutes(protected *gin.RouterGroup, userService *users.UserService, metricsService *metrics.MetricsService) {
+	admin := protected.Group("/admin/metrics")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("/mrr", func(c *gin.Context) {
+		q, r, ok := bindMetricsQuery(c)
+		if !ok {
+			return
+		}
+
+		periods, err := metricsService.MRRReport(r)
+		if err != nil {
+			respondMetricsError(c, err)
+			return
+		}
+
+		if q.Format != "csv" {
+			c.JSON(http.StatusOK, types.NewSuccessResponse(periods, nil))
+			return
+		}
+
+		records := [][]string{}
+		for _, p := range periods {
+			records = append(records, []string{
+				p.PeriodStart.Format("2006-01-02"),
+				r.Currency,
+				amountCSV(p.StartingMRRCents, r.Currency),
+				amountCSV(p.NewCents, r.Currency),
+				amountCSV(p.ExpansionCents, r.Currency),
+				amountCSV(p.ContractionCents, r.Currency),
+				amountCSV(p.ChurnCents, r.Currency),
+				amountCSV(p.ReactivationCents, r.Currency),
+				amountCSV(p.EndingMRRCents, r.Currency),
+				amountCSV(p.ARRCents, r.Currency),
+				strconv.Itoa(p.StartingAccounts),
+				strconv.Itoa(p.NewAccounts),
+				strconv.Itoa(p.ChurnedAccounts),
+				strconv.Itoa(p.EndingAccounts),
+				rateCSV(p.LogoChurnRate),
+				rateCSV(p.RevenueChurnRate),
+				rateCSV(p.NetRevenueChurnRate),
+				amountCSV(p.ARPACents, r.Currency),
+			})
+		}
+		writeMetricsCSV(c, "mrr", mrrCSVHeader, records)
+	})
+
+	admin.GET("/cohorts", func(c *gin.Context) {
+		q, r, ok := bindMetricsQuery(c)
+		if !ok {
+			return
+		}
+
+		list, err := metricsService.Cohorts(r)
+		if err != nil {
+			respondMetricsError(c, err)
+			return
+		}
+
+		if q.Format != "csv" {
+			c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+			return
+		}
+
+		// One row per cohort and month
+		records := [][]string{}
+		for _, cohort := range list {
+			for _, p := range cohort.Retention {
+				records = append(records, []string{
+					cohort.Month.Format("2006-01"),
+					r.Currency,
+					strconv.Itoa(cohort.Accounts),
+					amountCSV(cohort.StartingMRRCents, r.Currency),
+					strconv.Itoa(p.MonthsSince),
+					strconv.Itoa(p.Accounts),
+					rateCSV(p.LogoRetention),
+					amountCSV(p.MRRCents, r.Currency),
+					rateCSV(p.RevenueRetention),
+				})
+			}
+		}
+		writeMetricsCSV(c, "cohorts", cohortCSVHeader, records)
+	})
+}
+
+func bindMetricsQuery(c *gin.Context) (MetricsQuery, metrics.Range, bool) {
+	var q MetricsQuery
+	err := c.ShouldBindQuery(&q)
+	if err == nil && q.Currency == "" {
+		q.Currency = billing.DefaultCurrency
+	}
+	var currency string
+	if err == nil {
+		currency, err = money.NormalizeCurrency(q.Currency)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+			Code:       "INVALID_REQUEST",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+		}))
+		return q, metrics.Range{}, false
+	}
+
+	r := metrics.Range{Currency: currency, Since: q.Since, Until: q.Until}
+	if r.Until.IsZero() {
+		r.Until = time.Now()
+	}
+	if r.Since.IsZero() {
+		r.Since = r.Until.AddDate(0, -11, 0)
+	}
+	return q, r, true
+}
+
+// writeMetricsCSV sends a computed report as a CSV download
+func writeMetricsCSV(c *gin.Context, name string, header []string, records [][]string) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, name, time.Now().UTC().Format("20060102")))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	w.WriteAll(records)
+	if err := w.Error(); err != nil {
+		logger.Error("Failed to write metrics report", err, logger.Fields{"report": name})
+	}
+}
+
+func amountCSV(cents int, currency string) string {
+	return money.Money{Amount: cents, Currency: currency}.Decimal()
+}
+
+func rateCSV(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', 4, 64)
+}
+
+func respondMetricsError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "METRICS_ERROR"
+
+	switch {
+	case errors.Is(err, metrics.ErrInvalidRange):
+		status, code = http.StatusBadRequest, "INVALID_RANGE"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// registerMFARoutes adds the second login step to the public auth group and
+// enrollment management to the protected group
+func registerMFARoutes(public, protected *gin.RouterGroup, userService *users.UserService) {
+	public.POST("/login/mfa", func(c *gin.Context) {
+		var req MFALoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		token, err := userService.VerifyMFALogin(req.MFAToken, req.Code, req.RecoveryCode, c.ClientIP())
+		var throttled *users.ThrottleError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", fmt.Sprintf("%.0f", throttled.RetryAfter.Seconds()))
+			c.JSON(http.StatusTooManyRequests, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "TOO_MANY_ATTEMPTS",
+				Message:    "Too many failed login attempts",
+				StatusCode: http.StatusTooManyRequests,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_MFA_CODE",
+				Message:    "Invalid or expired MFA challenge",
+				Details:    err.Error(),
+				StatusCode: http.StatusUnauthorized,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"token": token}, nil))
+	})
+
+	mfa := protected.Group("/auth/mfa", middleware.RequireUser())
+	{
+		// Start enrollment: returns secret, otpauth URI and QR code
+		mfa.POST("/enroll", func(c *gin.Context) {
+			enrollment, err := userService.EnrollMFA(c.GetString("userID"))
+			if err != nil {
+				respondMFAError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(enrollment, nil))
+		})
+
+		// Confirm enrollment with a code from the authenticator app
+		mfa.POST("/confirm", func(c *gin.Context) {
+			var req MFACodeRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "INVALID_REQUEST",
+					Message:    err.Error(),
+					StatusCode: http.StatusBadRequest,
+				}))
+				return
+			}
+
+			codes, err := userService.ConfirmMFA(c.GetString("userID"), req.Code)
+			if err != nil {
+				respondMFAError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"recovery_codes": codes}, nil))
+		})
+
+		mfa.POST("/recovery-codes", func(c *gin.Context) {
+			var req MFACodeRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "INVALID_REQUEST",
+					Message:    err.Error(),
+					StatusCode: http.StatusBadRequest,
+				}))
+				return
+			}
+
+			codes, err := userService.RegenerateRecoveryCodes(c.GetString("userID"), req.Code)
+			if err != nil {
+				respondMFAError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"recovery_codes": codes}, nil))
+		})
+
+		mfa.POST("/disable", func(c *gin.Context) {
+			var req MFACodeRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+					Code:       "INVALID_REQUEST",
+					Message:    err.Error(),
+					StatusCode: http.StatusBadRequest,
+				}))
+				return
+			}
+
+			if err := userService.DisableMFA(c.GetString("userID"), req.Code); err != nil {
+				respondMFAError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "MFA disabled"}, nil))
+		})
+	}
+}
+
+func respondMFAError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "MFA_ERROR"
+
+	switch {
+	case errors.Is(err, users.ErrInvalidMFACode):
+		status, code = http.StatusUnauthorized, "INVALID_MFA_CODE"
+	case errors.Is(err, users.ErrMFAAlreadyEnabled):
+		status, code = http.StatusConflict, "MFA_ALREADY_ENABLED"
+	case errors.Is(err, users.ErrMFANotEnrolled), errors.Is(err, users.ErrMFANotEnabled):
+		status, code = http.StatusBadRequest, "MFA_NOT_ENABLED"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
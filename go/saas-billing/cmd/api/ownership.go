@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type OwnershipTransferRequest struct {
+	UserID             string `json:"user_id" binding:"required"`
+	MoveBillingContact bool   `json:"move_billing_contact"`
+}
+
+// registerOwnershipRoutes adds the two-step ownership handover
+func registerOwnershipRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService) {
+	transfers := org.Group("/ownership-transfer")
+
+	transfers.POST("", middleware.RequireRole(orgService, "owner"), func(c *gin.Context) {
+		var req OwnershipTransferRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		transfer, err := orgService.InitiateOwnershipTransfer(c.Param("orgID"), middleware.AuditActor(c), req.UserID, req.MoveBillingContact)
+		if err != nil {
+			respondTransferError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(transfer, nil))
+	})
+
+	transfers.GET("", middleware.RequireMember(orgService), func(c *gin.Context) {
+		transfer, err := orgService.GetPendingOwnershipTransfer(c.Param("orgID"))
+		if err != nil {
+			respondTransferError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(transfer, nil))
+	})
+
+	transfers.POST("/:transferID/accept", middleware.RequireMember(orgService), func(c *gin.Context) {
+		if err := orgService.AcceptOwnershipTransfer(c.Param("orgID"), c.Param("transferID"), middleware.AuditActor(c)); err != nil {
+			respondTransferError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Ownership transferred"}, nil))
+	})
+
+	transfers.DELETE("/:transferID", middleware.RequireMember(orgService), func(c *gin.Context) {
+		if err := orgService.CancelOwnershipTransfer(c.Param("orgID"), c.Param("transferID"), middleware.AuditActor(c)); err != nil {
+			respondTransferError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Ownership transfer canceled"}, nil))
+	})
+}
+
+func respondTransferError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "OWNERSHIP_TRANSFER_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrTransferNotFound):
+		status, code = http.StatusNotFound, "TRANSFER_NOT_FOUND"
+	case errors.Is(err, orgs.ErrTransferExpired):
+		status, code = http.StatusGone, "TRANSFER_EXPIRED"
+	case errors.Is(err, orgs.ErrInvalidTransferee):
+		status, code = http.StatusBadRequest, "INVALID_TRANSFEREE"
+	case errors.Is(err, orgs.ErrNotTransferParty):
+		status, code = http.StatusForbidden, "NOT_TRANSFER_PARTY"
+	case errors.Is(err, orgs.ErrInitiatorNotOwner):
+		status, code = http.StatusConflict, "INITIATOR_NOT_OWNER"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+// Behavior defaults to none. Without resume_at the subscription stays
+// paused until it's resumed.
+type PauseSubscriptionRequest struct {
+	Behavior string     `json:"behavior" binding:"omitempty,oneof=none void"`
+	ResumeAt *time.Time `json:"resume_at"`
+}
+
+// registerPauseRoutes lets organizations pause and resume their
+// subscription, and see what it currently entitles them to
+func registerPauseRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	read := org.Group("/billing", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead))
+	write := org.Group("/billing/subscription", middleware.RequireScope("billing:write"), middleware.RequirePermission(orgService, orgs.PermBillingManage))
+
+	read.GET("/entitlements", func(c *gin.Context) {
+		entitlements, err := billingService.GetEntitlements(c.Param("orgID"))
+		if err != nil {
+			respondBillingError(c, err, "ENTITLEMENTS_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(entitlements, nil))
+	})
+
+	write.POST("/pause", func(c *gin.Context) {
+		var req PauseSubscriptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		sub, err := billingService.PauseSubscription(c.Param("orgID"), req.Behavior, req.ResumeAt, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "SUBSCRIPTION_PAUSE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(sub, nil))
+	})
+
+	write.POST("/resume", func(c *gin.Context) {
+		sub, err := billingService.ResumeSubscription(c.Param("orgID"), middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "SUBSCRIPTION_RESUME_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(sub, nil))
+	})
+}
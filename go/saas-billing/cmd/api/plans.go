@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// The amount is a decimal string in major units, e.g. "49.99"
+type PlanPriceRequest struct {
+	Amount string `json:"amount" binding:"required"`
+}
+
+// registerPlanAdminRoutes adds the per-currency price points of plans and
+// what they entitle organizations to. Only platform admins can change them.
+func registerPlanAdminRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/plans")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.PUT("/:planID/prices/:currency", func(c *gin.Context) {
+		var req PlanPriceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		price, err := money.Parse(req.Amount, c.Param("currency"))
+		if err != nil {
+			respondBillingError(c, err, "PLAN_PRICE_ERROR")
+			return
+		}
+
+		saved, err := billingService.SetPlanPrice(c.Param("planID"), price)
+		if err != nil {
+			respondBillingError(c, err, "PLAN_PRICE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(saved, nil))
+	})
+
+	admin.PUT("/:planID/entitlements", func(c *gin.Context) {
+		var req billing.PlanEntitlements
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		saved, err := billingService.SetPlanEntitlements(c.Param("planID"), req)
+		if err != nil {
+			respondBillingError(c, err, "PLAN_ENTITLEMENTS_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(saved, nil))
+	})
+}
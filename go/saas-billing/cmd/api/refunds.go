@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// refundReconcileInterval is how often refunds left pending, because the
+// provider's answer was lost, are checked again
+const refundReconcileInterval = 5 * time.Minute
+
+type RecordPaymentRequest struct {
+	AmountCents       int    `json:"amount_cents" binding:"required,min=1"`
+	ProviderPaymentID string `json:"provider_payment_id"`
+}
+
+// A consolidated invoice is always paid in full
+type PayConsolidatedInvoiceRequest struct {
+	ProviderPaymentID string `json:"provider_payment_id"`
+}
+
+// Omit amount_cents to refund as much as possible
+type CreateRefundRequest struct {
+	AmountCents int    `json:"amount_cents" binding:"omitempty,min=1"`
+	PaymentID   string `json:"payment_id"`
+	Reason      string `json:"reason" binding:"required"`
+}
+
+// registerRefundRoutes adds the organization's view of its refunds
+func registerRefundRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	org.GET("/billing/refunds", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
+		refunds, err := billingService.ListRefunds(c.Param("orgID"), c.Query("invoice_id"))
+		if err != nil {
+			respondBillingError(c, err, "REFUNDS_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(refunds, nil))
+	})
+}
+
+// registerRefundAdminRoutes lets platform admins record captured payments,
+// including for consolidated invoices, and refund them
+func registerRefundAdminRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/organizations/:orgID/invoices/:invoiceID")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.POST("/payments", func(c *gin.Context) {
+		var req RecordPaymentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		payment, err := billingService.RecordPayment(c.Param("orgID"), c.Param("invoiceID"), req.AmountCents,
+			req.ProviderPaymentID, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "PAYMENT_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(payment, nil))
+	})
+
+	admin.POST("/refunds", func(c *gin.Context) {
+		var req CreateRefundRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		refund, err := billingService.CreateRefund(c.Param("orgID"), c.Param("invoiceID"), req.PaymentID,
+			req.AmountCents, req.Reason, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "REFUND_ERROR")
+			return
+		}
+
+		// The provider didn't give a clear answer; reconciliation settles it
+		if refund.Status == "pending" {
+			c.JSON(http.StatusAccepted, types.NewSuccessResponse(refund, nil))
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(refund, nil))
+	})
+	consolidated := protected.Group("/admin/organizations/:orgID/consolidated-invoices/:invoiceID")
+	consolidated.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	consolidated.POST("/payments", func(c *gin.Context) {
+		var req PayConsolidatedInvoiceRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		payments, err := billingService.PayConsolidatedInvoice(c.Param("orgID"), c.Param("invoiceID"),
+			req.ProviderPaymentID, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "PAYMENT_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(gin.H{"payments": payments}, nil))
+	})
+}
+
+// startRefundReconcileJob settles pending refunds in the background until
+// the process exits
+func startRefundReconcileJob(billingService *billing.BillingService) {
+	go func() {
+		ticker := time.NewTicker(refundReconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			settled, err := billingService.ReconcileRefunds(time.Now())
+			if err != nil {
+				logger.Error("Failed to reconcile refunds", err, nil)
+			} else if settled > 0 {
+				logger.Info("Reconciled refunds", logger.Fields{"refunds": settled})
+			}
+			<-ticker.C
+		}
+	}()
+}
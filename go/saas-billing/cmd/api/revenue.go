@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// recognitionInterval is how often the recognition job looks for revenue
+// earned since its last run. Revenue is recognized by the day, so running
+// more often only catches up sooner after downtime.
+const recognitionInterval = time.Hour
+
+// registerRevenueScheduleRoutes lets platform admins see how an invoice's
+// revenue is being recognized
+func registerRevenueScheduleRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/organizations/:orgID/invoices/:invoiceID")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("/revenue-schedules", func(c *gin.Context) {
+		schedules, err := billingService.ListRevenueSchedules(c.Param("orgID"), c.Param("invoiceID"))
+		if err != nil {
+			respondBillingError(c, err, "REVENUE_SCHEDULES_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(schedules, nil))
+	})
+}
+
+// startRecognitionJob recognizes deferred revenue in the background until
+// the process exits
+func startRecognitionJob(billingService *billing.BillingService) {
+	go func() {
+		ticker := time.NewTicker(recognitionInterval)
+		defer ticker.Stop()
+
+		for {
+			recognized, err := billingService.RecognizeRevenue(time.Now())
+			if err != nil {
+				logger.Error("Failed to recognize revenue", err, logger.Fields{"schedules": recognized})
+			} else if recognized > 0 {
+				logger.Info("Recognized revenue", logger.Fields{"schedules": recognized})
+			}
+			<-ticker.C
+		}
+	}()
+}
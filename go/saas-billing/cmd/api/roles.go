@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// registerRoleRoutes exposes built-in roles and manages custom ones
+func registerRoleRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService) {
+	roles := org.Group("/roles")
+
+	roles.GET("", middleware.RequirePermission(orgService, orgs.PermMembersRead), func(c *gin.Context) {
+		list, err := orgService.ListRoles(c.Param("orgID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "ROLE_FETCH_ERROR",
+				Message:    "Failed to fetch roles",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"roles": list, "permissions": orgs.AllPermissions}, nil))
+	})
+
+	roles.POST("", middleware.RequirePermission(orgService, orgs.PermRolesManage), func(c *gin.Context) {
+		var req CreateRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		role, err := orgService.CreateRole(c.Param("orgID"), c.GetString("userRole"), req.Name, req.Description, req.Permissions, middleware.AuditActor(c))
+		if err != nil {
+			respondRoleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(role, nil))
+	})
+
+	roles.PUT("/:roleID", middleware.RequirePermission(orgService, orgs.PermRolesManage), func(c *gin.Context) {
+		var req UpdateRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		role, err := orgService.UpdateRole(c.Param("orgID"), c.GetString("userRole"), c.Param("roleID"), req.Description, req.Permissions, middleware.AuditActor(c))
+		if err != nil {
+			respondRoleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(role, nil))
+	})
+
+	roles.DELETE("/:roleID", middleware.RequirePermission(orgService, orgs.PermRolesManage), func(c *gin.Context) {
+		if err := orgService.DeleteRole(c.Param("orgID"), c.Param("roleID"), middleware.AuditActor(c)); err != nil {
+			respondRoleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Role deleted"}, nil))
+	})
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "ROLE_UPDATE_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrRoleNotFound):
+		status, code = http.StatusNotFound, "ROLE_NOT_FOUND"
+	case errors.Is(err, orgs.ErrRoleExists):
+		status, code = http.StatusConflict, "ROLE_EXISTS"
+	case errors.Is(err, orgs.ErrRoleInUse):
+		status, code = http.StatusConflict, "ROLE_IN_USE"
+	case errors.Is(err, orgs.ErrInvalidPermission):
+		status, code = http.StatusBadRequest, "INVALID_PERMISSION"
+	case errors.Is(err, orgs.ErrPermissionEscalation):
+		status, code = http.StatusForbidden, "PERMISSION_ESCALATION"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
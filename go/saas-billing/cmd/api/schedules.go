@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// renewalInterval is how often the renewal job looks for periods that have
+// ended and scheduled phases that have started. A change takes effect at
+// its own time however late the job runs; only the invoice comes later.
+const renewalInterval = time.Hour
+
+// Phases replace those of the current schedule that haven't started
+type SaveScheduleRequest struct {
+	Phases []billing.PhaseInput `json:"phases" binding:"required,min=1,dive"`
+}
+
+type CreateCouponRequest struct {
+	Code       string `json:"code" binding:"required"`
+	Name       string `json:"name" binding:"required"`
+	PercentOff int    `json:"percent_off" binding:"required,min=1,max=100"`
+}
+
+// registerScheduleRoutes adds the organization's subscription schedule
+func registerScheduleRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	read := org.Group("/billing/subscription", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead))
+	write := org.Group("/billing/subscription", middleware.RequireScope("billing:write"), middleware.RequirePermission(orgService, orgs.PermBillingManage))
+
+	read.GET("/schedule", func(c *gin.Context) {
+		schedule, err := billingService.GetSchedule(c.Param("orgID"))
+		if err != nil {
+			respondBillingError(c, err, "SCHEDULE_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(schedule, nil))
+	})
+
+	write.PUT("/schedule", func(c *gin.Context) {
+		var req SaveScheduleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		schedule, err := billingService.SaveSchedule(c.Param("orgID"), req.Phases, middleware.AuditActor(c))
+		if err != nil {
+			respondBillingError(c, err, "SCHEDULE_UPDATE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(schedule, nil))
+	})
+
+	write.DELETE("/schedule", func(c *gin.Context) {
+		if err := billingService.CancelSchedule(c.Param("orgID"), middleware.AuditActor(c)); err != nil {
+			respondBillingError(c, err, "SCHEDULE_CANCEL_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Schedule canceled"}, nil))
+	})
+}
+
+// registerCouponAdminRoutes lets platform admins manage the coupons
+// schedules can apply
+func registerCouponAdminRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/coupons")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("", func(c *gin.Context) {
+		coupons, err := billingService.ListCoupons()
+		if err != nil {
+			respondBillingError(c, err, "COUPONS_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(coupons, nil))
+	})
+
+	admin.POST("", func(c *gin.Context) {
+		var req CreateCouponRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		coupon, err := billingService.CreateCoupon(req.Code, req.Name, req.PercentOff)
+		if err != nil {
+			respondBillingError(c, err, "COUPON_CREATE_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(coupon, nil))
+	})
+}
+
+// startRenewalJob renews subscriptions and applies their schedules in the
+// background until the process exits
+func startRenewalJob(billingService *billing.BillingService) {
+	go func() {
+		ticker := time.NewTicker(renewalInterval)
+		defer ticker.Stop()
+
+		for {
+			renewed, err := billingService.RenewSubscriptions(time.Now())
+			if err != nil {
+				logger.Error("Failed to renew subscriptions", err, logger.Fields{"steps": renewed})
+			} else if renewed > 0 {
+				logger.Info("Renewed subscriptions", logger.Fields{"steps": renewed})
+			}
+			<-ticker.C
+		}
+	}()
+}
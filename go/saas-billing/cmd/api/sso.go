@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/sso"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type SSOConnectionRequest struct {
+	Issuer         string   `json:"issuer" binding:"required,url"`
+	ClientID       string   `json:"client_id" binding:"required"`
+	ClientSecret   string   `json:"client_secret" binding:"required"`
+	AllowedDomains []string `json:"allowed_domains" binding:"required,min=1"`
+	DefaultRole    string   `json:"default_role" binding:"omitempty,oneof=admin member"`
+	Enabled        *bool    `json:"enabled"`
+}
+
+// registerSSORoutes adds the browser login flow to the public auth group
+func registerSSORoutes(public *gin.RouterGroup, ssoService *sso.SSOService) {
+	// Redirects the browser to the organization's identity provider
+	public.GET("/sso/:orgID/login", func(c *gin.Context) {
+		authURL, err := ssoService.BeginLogin(c.Param("orgID"))
+		if errors.Is(err, sso.ErrConnectionNotFound) {
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_NOT_CONFIGURED",
+				Message:    "SSO is not configured for this organization",
+				StatusCode: http.StatusNotFound,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_ERROR",
+				Message:    "Failed to start SSO login",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.Redirect(http.StatusFound, authURL)
+	})
+
+	public.GET("/sso/callback", func(c *gin.Context) {
+		if idpErr := c.Query("error"); idpErr != "" {
+			c.JSON(http.StatusUnauthorized, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_LOGIN_FAILED",
+				Message:    "Identity provider rejected the login",
+				Details:    idpErr,
+				StatusCode: http.StatusUnauthorized,
+			}))
+			return
+		}
+
+		result, err := ssoService.CompleteLogin(c.Query("state"), c.Query("code"), c.ClientIP())
+		var throttled *users.ThrottleError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", fmt.Sprintf("%.0f", throttled.RetryAfter.Seconds()))
+			c.JSON(http.StatusTooManyRequests, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "TOO_MANY_ATTEMPTS",
+				Message:    "Too many failed login attempts",
+				StatusCode: http.StatusTooManyRequests,
+			}))
+			return
+		}
+		if errors.Is(err, orgs.ErrSeatLimitReached) {
+			c.JSON(http.StatusPaymentRequired, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SEAT_LIMIT_REACHED",
+				Message:    "Organization has no seats left on its plan",
+				StatusCode: http.StatusPaymentRequired,
+			}))
+			return
+		}
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, sso.ErrDomainNotAllowed) || errors.Is(err, sso.ErrEmailNotVerified) ||
+				errors.Is(err, sso.ErrAccountNotLinked) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_LOGIN_FAILED",
+				Message:    "SSO login failed",
+				Details:    err.Error(),
+				StatusCode: status,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(result, nil))
+	})
+}
+
+// registerSSOConfigRoutes lets owners manage the organization's IdP
+func registerSSOConfigRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, ssoService *sso.SSOService) {
+	org.GET("/sso", middleware.RequirePermission(orgService, orgs.PermOrgManage), func(c *gin.Context) {
+		conn, err := ssoService.EffectiveConnection(c.Param("orgID"))
+		if errors.Is(err, sso.ErrConnectionNotFound) {
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_NOT_CONFIGURED",
+				Message:    "SSO is not configured for this organization",
+				StatusCode: http.StatusNotFound,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_FETCH_ERROR",
+				Message:    "Failed to fetch SSO configuration",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(conn, nil))
+	})
+
+	org.PUT("/sso", middleware.RequirePermission(orgService, orgs.PermOrgManage), func(c *gin.Context) {
+		var req SSOConnectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		conn := &sso.Connection{
+			OrgID:          c.Param("orgID"),
+			Issuer:         req.Issuer,
+			ClientID:       req.ClientID,
+			ClientSecret:   req.ClientSecret,
+			AllowedDomains: req.AllowedDomains,
+			DefaultRole:    req.DefaultRole,
+			Enabled:        req.Enabled == nil || *req.Enabled,
+		}
+		if conn.DefaultRole == "" {
+			conn.DefaultRole = "member"
+		}
+
+		saved, err := ssoService.SaveConnection(conn, middleware.AuditActor(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SSO_UPDATE_ERROR",
+				Message:    "Failed to save SSO configuration",
+				Details:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(saved, nil))
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+// registerSubscriptionHistoryRoutes adds the organization's subscription
+// history
+func registerSubscriptionHistoryRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	org.GET("/billing/subscription/history", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
+		var q PageQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if q.Page == 0 {
+			q.Page = 1
+		}
+		if q.PageSize == 0 {
+			q.PageSize = 20
+		}
+
+		versions, total, err := billingService.ListSubscriptionHistory(c.Param("orgID"), q.Page, q.PageSize)
+		if err != nil {
+			respondBillingError(c, err, "SUBSCRIPTION_HISTORY_FETCH_ERROR")
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewPaginatedResponse(versions, q.Page, q.PageSize, total))
+	})
+}
+
+// registerSubscriptionAdminRoutes lets platform admins look up what an
+// organization was subscribed to at a point in time, e.g. to settle a
+// dispute
+func registerSubscriptionAdminRoutes(protected *gin.RouterGroup, userService *users.UserService, billingService *billing.BillingService) {
+	admin := protected.Group("/admin/organizations/:orgID/subscription")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("/as-of", func(c *gin.Context) {
+		var q struct {
+			At time.Time `form:"at" binding:"required"`
+		}
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		version, err := billingService.SubscriptionAsOf(c.Param("orgID"), q.At)
+		if err != nil {
+			respondBillingError(c, err, "SUBSCRIPTION_FETCH_ERROR")
+			return
+		}
+		if version == nil {
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "SUBSCRIPTION_NOT_FOUND",
+				Message:    "No subscription at that time",
+				StatusCode: http.StatusNotFound,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(version, nil))
+	})
+}
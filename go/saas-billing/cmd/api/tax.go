@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/billing"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/tax"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type BillingProfileRequest struct {
+	LegalName  string `json:"legal_name" binding:"required"`
+	Line1      string `json:"line1" binding:"required"`
+	Line2      string `json:"line2"`
+	City       string `json:"city" binding:"required"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country" binding:"required"`
+	TaxID      string `json:"tax_id"`
+}
+
+type CreateTaxRateRequest struct {
+	Country string `json:"country" binding:"required"`
+	Region  string `json:"region"`
+	Name    string `json:"name" binding:"required"`
+	RateBps int    `json:"rate_bps" binding:"min=0,max=10000"`
+}
+
+// registerBillingProfileRoutes adds the organization's billing address and
+// tax ID, which decide the tax on its invoices
+func registerBillingProfileRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, billingService *billing.BillingService) {
+	org.GET("/billing/profile", middleware.RequireScope("billing:read"), middleware.RequirePermission(orgService, orgs.PermBillingRead), func(c *gin.Context) {
+		profile, err := billingService.GetBillingProfile(c.Param("orgID"))
+		if err != nil {
+			respondTaxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(profile, nil))
+	})
+
+	org.PUT("/billing/profile", middleware.RequireScope("billing:write"), middleware.RequirePermission(orgService, orgs.PermBillingManage), func(c *gin.Context) {
+		var req BillingProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		profile, err := billingService.SaveBillingProfile(billing.BillingProfile{
+			OrgID:      c.Param("orgID"),
+			LegalName:  req.LegalName,
+			Line1:      req.Line1,
+			Line2:      req.Line2,
+			City:       req.City,
+			Region:     req.Region,
+			PostalCode: req.PostalCode,
+			Country:    req.Country,
+			TaxID:      req.TaxID,
+		}, middleware.AuditActor(c))
+		if err != nil {
+			respondTaxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(profile, nil))
+	})
+}
+
+// registerTaxRateRoutes adds the platform-wide tax rate table. Only platform
+// admins can change it.
+func registerTaxRateRoutes(protected *gin.RouterGroup, userService *users.UserService, rates *tax.RateCalculator) {
+	admin := protected.Group("/admin/tax-rates")
+	admin.Use(middleware.RequireUser(), middleware.RequirePlatformAdmin(userService))
+
+	admin.GET("", func(c *gin.Context) {
+		list, err := rates.ListRates()
+		if err != nil {
+			respondTaxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	admin.POST("", func(c *gin.Context) {
+		var req CreateTaxRateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		rate, err := rates.CreateRate(tax.Rate{
+			Country: req.Country,
+			Region:  req.Region,
+			Name:    req.Name,
+			RateBps: req.RateBps,
+		})
+		if err != nil {
+			respondTaxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(rate, nil))
+	})
+
+	admin.DELETE("/:rateID", func(c *gin.Context) {
+		if err := rates.DeactivateRate(c.Param("rateID")); err != nil {
+			respondTaxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Tax rate deactivated"}, nil))
+	})
+}
+
+func respondTaxError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "TAX_ERROR"
+
+	switch {
+	case errors.Is(err, billing.ErrBillingProfileNotFound):
+		status, code = http.StatusNotFound, "BILLING_PROFILE_NOT_FOUND"
+	case errors.Is(err, tax.ErrInvalidCountry), errors.Is(err, tax.ErrInvalidTaxID), errors.Is(err, tax.ErrInvalidRate):
+		status, code = http.StatusBadRequest, "INVALID_TAX_DETAILS"
+	case errors.Is(err, tax.ErrTaxIDCheckUnavailable):
+		status, code = http.StatusServiceUnavailable, "TAX_ID_CHECK_UNAVAILABLE"
+	case errors.Is(err, tax.ErrRateExists):
+		status, code = http.StatusConflict, "TAX_RATE_EXISTS"
+	case errors.Is(err, tax.ErrRateNotFound):
+		status, code = http.StatusNotFound, "TAX_RATE_NOT_FOUND"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+)
+
+type CreateTeamRequest struct {
+	Name        string `json:"name" binding:"required,max=255"`
+	Description string `json:"description"`
+}
+
+type SetTeamMemberRequest struct {
+	Role string `json:"role" binding:"omitempty,oneof=lead member"`
+}
+
+// registerTeamRoutes adds teams and team membership
+func registerTeamRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService) {
+	teams := org.Group("/teams")
+
+	teams.GET("", middleware.RequirePermission(orgService, orgs.PermMembersRead), func(c *gin.Context) {
+		list, err := orgService.ListTeams(c.Param("orgID"))
+		if err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(list, nil))
+	})
+
+	teams.POST("", middleware.RequirePermission(orgService, orgs.PermTeamsManage), func(c *gin.Context) {
+		var req CreateTeamRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		team, err := orgService.CreateTeam(c.Param("orgID"), req.Name, req.Description)
+		if err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.NewSuccessResponse(team, nil))
+	})
+
+	teams.DELETE("/:teamID", middleware.RequirePermission(orgService, orgs.PermTeamsManage), func(c *gin.Context) {
+		if err := orgService.DeleteTeam(c.Param("orgID"), c.Param("teamID")); err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Team deleted"}, nil))
+	})
+
+	teams.GET("/:teamID/members", middleware.RequirePermission(orgService, orgs.PermMembersRead), func(c *gin.Context) {
+		members, err := orgService.ListTeamMembers(c.Param("orgID"), c.Param("teamID"))
+		if err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(members, nil))
+	})
+
+	// Adding, re-roling and removing team members is open to team leads too
+	teams.PUT("/:teamID/members/:userID", middleware.RequireMember(orgService), requireTeamManager(orgService), func(c *gin.Context) {
+		var req SetTeamMemberRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if req.Role == "" {
+			req.Role = orgs.TeamRoleMember
+		}
+
+		if err := orgService.SetTeamMember(c.Param("orgID"), c.Param("teamID"), c.Param("userID"), req.Role); err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Team member saved"}, nil))
+	})
+
+	teams.DELETE("/:teamID/members/:userID", middleware.RequireMember(orgService), requireTeamManager(orgService), func(c *gin.Context) {
+		if err := orgService.RemoveTeamMember(c.Param("orgID"), c.Param("teamID"), c.Param("userID")); err != nil {
+			respondTeamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Team member removed"}, nil))
+	})
+}
+
+// requireTeamManager runs after RequireMember, which sets userRole
+func requireTeamManager(orgService *orgs.OrganizationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := orgService.CanManageTeam(c.Param("orgID"), c.Param("teamID"), c.GetString("userID"), c.GetString("userRole"))
+		if err != nil {
+			respondTeamError(c, err)
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func respondTeamError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "TEAM_ERROR"
+
+	switch {
+	case errors.Is(err, orgs.ErrTeamNotFound):
+		status, code = http.StatusNotFound, "TEAM_NOT_FOUND"
+	case errors.Is(err, orgs.ErrTeamExists):
+		status, code = http.StatusConflict, "TEAM_EXISTS"
+	case errors.Is(err, orgs.ErrTeamMemberNotFound):
+		status, code = http.StatusNotFound, "TEAM_MEMBER_NOT_FOUND"
+	case errors.Is(err, orgs.ErrMemberNotFound):
+		status, code = http.StatusBadRequest, "MEMBER_NOT_FOUND"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
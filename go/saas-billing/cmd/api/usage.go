@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/usage"
+)
+
+type RecordUsageRequest struct {
+	TeamID    string    `json:"team_id"`
+	Metric    string    `json:"metric" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type UsageReportQuery struct {
+	StartDate time.Time `form:"start_date" time_format:"2006-01-02"`
+	EndDate   time.Time `form:"end_date" time_format:"2006-01-02"`
+	Metric    string    `form:"metric"`
+	TeamID    string    `form:"team_id"`
+}
+
+func registerUsageRoutes(org *gin.RouterGroup, orgService *orgs.OrganizationService, usageService *usage.UsageService) {
+	org.POST("/usage", middleware.RequireScope("usage:write"), middleware.RequirePermission(orgService, orgs.PermUsageWrite), func(c *gin.Context) {
+		var req RecordUsageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		rec, err := usageService.Record(c.Param("orgID"), req.TeamID, req.Metric, req.Quantity, req.Timestamp)
+		if errors.Is(err, usage.ErrUnknownTeam) {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_TEAM",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "USAGE_RECORD_ERROR",
+				Message:    "Failed to record usage",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(rec, nil))
+	})
+
+	org.GET("/usage", middleware.RequireScope("usage:read"), middleware.RequirePermission(orgService, orgs.PermUsageRead), func(c *gin.Context) {
+		var q UsageReportQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		// Default to the current month; end_date is inclusive
+		now := time.Now().UTC()
+		start := q.StartDate
+		if start.IsZero() {
+			start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+		end := now
+		if !q.EndDate.IsZero() {
+			end = q.EndDate.AddDate(0, 0, 1)
+		}
+
+		report, err := usageService.GetReport(c.Param("orgID"), start, end, q.Metric, q.TeamID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "USAGE_FETCH_ERROR",
+				Message:    "Failed to fetch usage report",
+				Details:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(report, nil))
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/middleware"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/types"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+type UpdateProfileRequest struct {
+	Name      *string `json:"name"`
+	AvatarURL *string `json:"avatar_url"`
+	Locale    *string `json:"locale"`
+	Timezone  *string `json:"timezone"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	Email           string `json:"email" binding:"required,email"`
+}
+
+// registerUserRoutes adds the signed-in user's profile and account settings
+func registerUserRoutes(protected *gin.RouterGroup, userService *users.UserService, domainService *orgs.DomainService) {
+	me := protected.Group("/users/me", middleware.RequireUser())
+
+	me.GET("", func(c *gin.Context) {
+		user, err := userService.GetProfile(c.GetString("userID"))
+		if err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(user, nil))
+	})
+
+	me.PATCH("", func(c *gin.Context) {
+		var req UpdateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		user, err := userService.UpdateProfile(c.GetString("userID"), users.ProfileUpdate{
+			Name:      req.Name,
+			AvatarURL: req.AvatarURL,
+			Locale:    req.Locale,
+			Timezone:  req.Timezone,
+		}, middleware.AuditActor(c))
+		if err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(user, nil))
+	})
+
+	me.POST("/password", func(c *gin.Context) {
+		var req ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		err := userService.ChangePassword(c.GetString("userID"), req.CurrentPassword, req.NewPassword, middleware.AuditActor(c))
+		if err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponse(gin.H{"message": "Password changed"}, nil))
+	})
+
+	me.POST("/email", func(c *gin.Context) {
+		var req ChangeEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(&types.ErrorInfo{
+				Code:       "INVALID_REQUEST",
+				Message:    err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}))
+			return
+		}
+
+		// The account would be unable to sign in with its password afterwards
+		if !requirePasswordAllowed(c, domainService, req.Email) {
+			return
+		}
+
+		err := userService.ChangeEmail(c.GetString("userID"), req.CurrentPassword, req.Email, middleware.AuditActor(c))
+		if err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, types.NewSuccessResponse(gin.H{
+			"message":       "Verification email sent to the new address",
+			"pending_email": req.Email,
+		}, nil))
+	})
+}
+
+func respondUserError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "USER_ERROR"
+
+	switch {
+	case errors.Is(err, users.ErrInvalidProfile):
+		status, code = http.StatusBadRequest, "INVALID_PROFILE"
+	case errors.Is(err, users.ErrIncorrectPassword):
+		status, code = http.StatusForbidden, "INCORRECT_PASSWORD"
+	case errors.Is(err, users.ErrWeakPassword):
+		status, code = http.StatusBadRequest, "WEAK_PASSWORD"
+	case errors.Is(err, users.ErrSameEmail):
+		status, code = http.StatusBadRequest, "SAME_EMAIL"
+	case errors.Is(err, users.ErrEmailTaken):
+		status, code = http.StatusConflict, "EMAIL_TAKEN"
+	}
+
+	c.JSON(status, types.NewErrorResponse(&types.ErrorInfo{
+		Code:       code,
+		Message:    err.Error(),
+		StatusCode: status,
+	}))
+}
@@ -0,0 +1,135 @@
+package apikeys
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureArg matches any argument and keeps it
+type captureArg struct{ value *string }
+
+func (a captureArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.value = s
+	return ok
+}
+
+func newMockService(t *testing.T) (*APIKeyService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewAPIKeyService(db, audit.NewAuditService(db)), mock
+}
+
+var keyColumns = []string{"id", "org_id", "name", "key_prefix", "scopes", "created_by",
+	"expires_at", "last_used_at", "revoked_at", "created_at"}
+
+func TestCreateStoresOnlyTheHash(t *testing.T) {
+	s, mock := newMockService(t)
+
+	var prefix, hash string
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WithArgs("org-1", "CI", captureArg{&prefix}, captureArg{&hash}, sqlmock.AnyArg(), "user-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "name", "key_prefix", "scopes", "created_by", "expires_at", "created_at"}).
+			AddRow("key-1", "org-1", "CI", "sbk_12345678", "{usage:write}", "user-1", nil, "2025-01-01"))
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	key, raw, err := s.Create("org-1", "CI", []string{"usage:write"}, nil, audit.Actor{UserID: "user-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"usage:write"}, key.Scopes)
+	assert.True(t, strings.HasPrefix(raw, KeyPrefix))
+	assert.Equal(t, raw[:len(KeyPrefix)+8], prefix)
+	assert.Equal(t, hashKey(raw), hash)
+	assert.NotContains(t, hash, raw[len(KeyPrefix):])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateRejectsUnknownScopes(t *testing.T) {
+	s, mock := newMockService(t)
+
+	_, _, err := s.Create("org-1", "CI", []string{"usage:read", "billing:everything"}, nil, audit.Actor{})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthenticate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt interface{}
+		revokedAt interface{}
+		err       error
+	}{
+		{"active key", nil, nil, nil},
+		{"not expired yet", future, nil, nil},
+		{"expired", past, nil, ErrInvalidKey},
+		{"revoked", nil, past, ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newMockService(t)
+			mock.ExpectQuery(`SELECT .* FROM api_keys`).WithArgs(hashKey("sbk_secret")).
+				WillReturnRows(sqlmock.NewRows(keyColumns).AddRow(
+					"key-1", "org-1", "CI", "sbk_secr", "{billing:read}", "user-1", tt.expiresAt, nil, tt.revokedAt, "2025-01-01"))
+			if tt.err == nil {
+				mock.ExpectExec(`UPDATE api_keys SET last_used_at`).WithArgs("key-1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			key, err := s.Authenticate("sbk_secret")
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err == nil {
+				assert.Equal(t, "org-1", key.OrgID)
+				assert.True(t, key.HasScope("billing:read"))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthenticateUnknownKeys(t *testing.T) {
+	s, mock := newMockService(t)
+
+	// Bearer tokens without the prefix never reach the database
+	_, err := s.Authenticate("eyJhbGciOi")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	mock.ExpectQuery(`SELECT .* FROM api_keys`).WithArgs(hashKey("sbk_unknown")).
+		WillReturnRows(sqlmock.NewRows(keyColumns))
+	_, err = s.Authenticate("sbk_unknown")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevoke(t *testing.T) {
+	s, mock := newMockService(t)
+
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at`).WithArgs("key-1", "org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.Revoke("org-1", "key-1", audit.Actor{UserID: "user-1"}))
+
+	// Already revoked, or another organization's key
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at`).WithArgs("key-1", "org-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.Revoke("org-2", "key-1", audit.Actor{}), ErrKeyNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHasScope(t *testing.T) {
+	key := &APIKey{Scopes: []string{"usage:read", "usage:write"}}
+	assert.True(t, key.HasScope("usage:write"))
+	assert.False(t, key.HasScope("billing:read"))
+	assert.False(t, (&APIKey{}).HasScope("usage:read"))
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/linkmeAman/saas-billing/internal/logger"
+)
+
+// Actor is who performed an action and the request it came in on. UserID or
+// APIKeyID is set for authenticated requests; both are empty for system
+// actions such as background jobs.
+type Actor struct {
+	UserID    string
+	APIKeyID  string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// Event is a security- or billing-relevant action. Before and After hold
+// the fields the action changed.
+type Event struct {
+	OrgID      string
+	Actor      Actor
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     map[string]interface{}
+	After      map[string]interface{}
+	Metadata   map[string]interface{}
+}
+
+type AuditService struct {
+	db *sql.DB
+}
+
+func NewAuditService(db *sql.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record appends the event to the audit log. Entries are never updated or
+// deleted; the table rejects both.
+func (s *AuditService) Record(e Event) error {
+	metadata, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return err
+	}
+	before, err := marshalState(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalState(e.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO audit_events
+			(org_id, actor_user_id, actor_api_key_id, action, target_type, target_id,
+			 before, after, ip, user_agent, request_id, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, nullString(e.OrgID), nullString(e.Actor.UserID), nullString(e.Actor.APIKeyID), e.Action,
+		nullString(e.TargetType), nullString(e.TargetID), before, after,
+		nullString(e.Actor.IP), nullString(e.Actor.UserAgent), nullString(e.Actor.RequestID), metadata)
+
+	return err
+}
+
+// Emit records an event for an action that has already happened. Failures
+// are logged but never fail the request that triggered them.
+func (s *AuditService) Emit(e Event) {
+	if err := s.Record(e); err != nil {
+		logger.Error("Failed to record audit event", err, logger.Fields{"action": e.Action})
+	}
+}
+
+// marshalState keeps absent states NULL rather than an empty object
+func marshalState(state map[string]interface{}) ([]byte, error) {
+	if state == nil {
+		return nil, nil
+	}
+	return json.Marshal(state)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
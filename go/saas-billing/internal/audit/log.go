@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Entry is a stored audit event as returned by List and Export
+type Entry struct {
+	ID            string          `json:"id"`
+	OrgID         string          `json:"org_id,omitempty"`
+	ActorType     string          `json:"actor_type"` // user, api_key or system
+	ActorUserID   string          `json:"actor_user_id,omitempty"`
+	ActorAPIKeyID string          `json:"actor_api_key_id,omitempty"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type,omitempty"`
+	TargetID      string          `json:"target_id,omitempty"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	Metadata      json.RawMessage `json:"metadata,omitempty"`
+	IP            string          `json:"ip,omitempty"`
+	UserAgent     string          `json:"user_agent,omitempty"`
+	RequestID     string          `json:"request_id,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// Filter narrows List and Export. Empty fields match everything. An Action
+// ending in "." matches every action with that prefix, e.g. "member.".
+type Filter struct {
+	Action      string
+	ActorUserID string
+	APIKeyID    string
+	TargetType  string
+	TargetID    string
+	Since       time.Time
+	Until       time.Time
+}
+
+const entryColumns = `
+	id, COALESCE(org_id::text, ''), COALESCE(actor_user_id::text, ''), COALESCE(actor_api_key_id::text, ''),
+	action, COALESCE(target_type, ''), COALESCE(target_id, ''), before, after, metadata,
+	COALESCE(ip, ''), COALESCE(user_agent, ''), COALESCE(request_id, ''), created_at`
+
+// List returns one page of the organization's audit log, newest first, and
+// the number of matching entries
+func (s *AuditService) List(orgID string, f Filter, page, pageSize int) ([]Entry, int, error) {
+	where, args := f.where(orgID)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM audit_events WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT %s FROM audit_events
+		WHERE %s
+		ORDER BY created_at DESC, id
+		LIMIT $%d OFFSET $%d
+	`, entryColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// Export calls fn for every matching entry, oldest first, without loading
+// the whole log into memory. It stops at the first error fn returns.
+func (s *AuditService) Export(orgID string, f Filter, fn func(Entry) error) error {
+	where, args := f.where(orgID)
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT %s FROM audit_events
+		WHERE %s
+		ORDER BY created_at, id
+	`, entryColumns, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// where builds the SQL condition for the organization and filter
+func (f Filter) where(orgID string) (string, []interface{}) {
+	conds := []string{"org_id = $1"}
+	args := []interface{}{orgID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if strings.HasSuffix(f.Action, ".") {
+		add("starts_with(action, $%d)", f.Action)
+	} else if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.ActorUserID != "" {
+		add("actor_user_id::text = $%d", f.ActorUserID)
+	}
+	if f.APIKeyID != "" {
+		add("actor_api_key_id::text = $%d", f.APIKeyID)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(row rowScanner) (Entry, error) {
+	var e Entry
+	var before, after, metadata []byte
+	err := row.Scan(&e.ID, &e.OrgID, &e.ActorUserID, &e.ActorAPIKeyID, &e.Action,
+		&e.TargetType, &e.TargetID, &before, &after, &metadata,
+		&e.IP, &e.UserAgent, &e.RequestID, &e.CreatedAt)
+	if err != nil {
+		return e, err
+	}
+
+	e.Before, e.After = before, after
+	if string(metadata) != "null" && string(metadata) != "{}" {
+		e.Metadata = metadata
+	}
+
+	switch {
+	case e.ActorAPIKeyID != "":
+		e.ActorType = "api_key"
+	case e.ActorUserID != "":
+		e.ActorType = "user"
+	default:
+		e.ActorType = "system"
+	}
+
+	return e, nil
+}
@@ -0,0 +1,24 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterWhere(t *testing.T) {
+	where, args := Filter{}.where("org-1")
+	assert.Equal(t, "org_id = $1", where)
+	assert.Equal(t, []interface{}{"org-1"}, args)
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	where, args = Filter{Action: "member.removed", ActorUserID: "user-1", Since: since}.where("org-1")
+	assert.Equal(t, "org_id = $1 AND action = $2 AND actor_user_id::text = $3 AND created_at >= $4", where)
+	assert.Equal(t, []interface{}{"org-1", "member.removed", "user-1", since}, args)
+
+	// A trailing dot matches the whole family of actions
+	where, args = Filter{Action: "member.", TargetType: "user"}.where("org-1")
+	assert.Equal(t, "org_id = $1 AND starts_with(action, $2) AND target_type = $3", where)
+	assert.Equal(t, []interface{}{"org-1", "member.", "user"}, args)
+}
@@ -6,50 +6,120 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
+)
+
+// Token scopes restrict a token to a single step of a flow. Access tokens
+// carry no scope.
+const (
+	ScopeMFAChallenge  = "mfa_challenge"
+	ScopeAccountUnlock = "account_unlock"
+	ScopeInvitation    = "invitation"
+	ScopeEmailVerify   = "email_verification"
 )
 
 type Claims struct {
 	UserID string `json:"user_id"`
+	MFA    bool   `json:"mfa,omitempty"`
+	Scope  string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// HashPassword creates a bcrypt hash of the password
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// GenerateToken creates a new JWT token
+func GenerateToken(userID string) (string, error) {
+	return signToken(&Claims{UserID: userID}, 15*time.Minute)
+}
+
+// GenerateMFAVerifiedToken creates an access token for a user who completed
+// a second factor during login
+func GenerateMFAVerifiedToken(userID string) (string, error) {
+	return signToken(&Claims{UserID: userID, MFA: true}, 15*time.Minute)
+}
+
+// GenerateScopedToken creates a short-lived token that is only accepted by
+// ValidateScopedToken with the same scope
+func GenerateScopedToken(userID, scope string, ttl time.Duration) (string, error) {
+	if scope == "" {
+		return "", errors.New("scope required")
+	}
+	return signToken(&Claims{UserID: userID, Scope: scope}, ttl)
+}
+
+// GenerateUnlockToken signs an account unlock link. The nonce stored for the
+// lock is carried in the subject claim so the link works once.
+func GenerateUnlockToken(userID, nonce string, ttl time.Duration) (string, error) {
+	claims := &Claims{UserID: userID, Scope: ScopeAccountUnlock}
+	claims.Subject = nonce
+	return signToken(claims, ttl)
+}
+
+// GenerateMFAChallengeToken signs a pending second-factor challenge. The
+// challenge ID is carried in the subject claim so the server can spend it.
+func GenerateMFAChallengeToken(userID, challengeID string, ttl time.Duration) (string, error) {
+	claims := &Claims{UserID: userID, Scope: ScopeMFAChallenge}
+	claims.Subject = challengeID
+	return signToken(claims, ttl)
+}
+
+// GenerateInvitationToken signs an organization invitation ID. The ID is
+// carried in the standard subject claim since there is no user yet.
+func GenerateInvitationToken(invitationID string, ttl time.Duration) (string, error) {
+	claims := &Claims{Scope: ScopeInvitation}
+	claims.Subject = invitationID
+	return signToken(claims, ttl)
+}
+
+// GenerateEmailVerificationToken signs a proof-of-ownership link for email.
+// The address is carried in the subject claim so the token stops working if
+// the user's email changes before it is used.
+func GenerateEmailVerificationToken(userID, email string, ttl time.Duration) (string, error) {
+	claims := &Claims{UserID: userID, Scope: ScopeEmailVerify}
+	claims.Subject = email
+	return signToken(claims, ttl)
+}
+
+// ValidateToken checks if the token is valid
+func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(bytes), nil
+
+	// Scoped tokens are never valid as access tokens
+	if claims.Scope != "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
 }
 
-// CheckPasswordHash compares a password with a hash
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// ValidateScopedToken checks the token is valid and was issued for scope
+func ValidateScopedToken(tokenString, scope string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Scope == "" || claims.Scope != scope {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
 }
 
-// GenerateToken creates a new JWT token
-func GenerateToken(userID string) (string, error) {
+func signToken(claims *Claims, ttl time.Duration) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		return "", errors.New("JWT_SECRET not set")
 	}
 
-	claims := &Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
 }
 
-// ValidateToken checks if the token is valid
-func ValidateToken(tokenString string) (*Claims, error) {
+func parseToken(tokenString string) (*Claims, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		return nil, errors.New("JWT_SECRET not set")
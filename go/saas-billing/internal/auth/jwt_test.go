@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopedTokens(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	access, err := GenerateToken("user-1")
+	assert.NoError(t, err)
+	claims, err := ValidateToken(access)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.False(t, claims.MFA)
+
+	// A challenge token must never work as an access token
+	challenge, err := GenerateMFAChallengeToken("user-1", "challenge-1", time.Minute)
+	assert.NoError(t, err)
+	_, err = ValidateToken(challenge)
+	assert.Error(t, err)
+
+	claims, err = ValidateScopedToken(challenge, ScopeMFAChallenge)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "challenge-1", claims.Subject)
+
+	// Nor can an access token be used as a challenge
+	_, err = ValidateScopedToken(access, ScopeMFAChallenge)
+	assert.Error(t, err)
+
+	verified, err := GenerateMFAVerifiedToken("user-1")
+	assert.NoError(t, err)
+	claims, err = ValidateToken(verified)
+	assert.NoError(t, err)
+	assert.True(t, claims.MFA)
+}
+
+func TestInvitationToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	token, err := GenerateInvitationToken("invitation-1", time.Hour)
+	assert.NoError(t, err)
+
+	claims, err := ValidateScopedToken(token, ScopeInvitation)
+	assert.NoError(t, err)
+	assert.Equal(t, "invitation-1", claims.Subject)
+	assert.Empty(t, claims.UserID)
+
+	_, err = ValidateToken(token)
+	assert.Error(t, err)
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	token, err := GenerateEmailVerificationToken("user-1", "jane@example.com", time.Hour)
+	assert.NoError(t, err)
+
+	claims, err := ValidateScopedToken(token, ScopeEmailVerify)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "jane@example.com", claims.Subject)
+
+	_, err = ValidateScopedToken(token, ScopeInvitation)
+	assert.Error(t, err)
+}
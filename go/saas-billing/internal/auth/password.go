@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// HashConfig selects the algorithm and cost used for new password hashes.
+// Existing hashes keep working with whatever parameters they were stored
+// with; NeedsRehash reports when they differ from the current config.
+type HashConfig struct {
+	Algorithm string
+
+	BcryptCost int
+
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  int
+	Argon2KeyLength   uint32
+}
+
+// DefaultHashConfig follows the OWASP argon2id recommendation and takes tens
+// of milliseconds rather than bcrypt cost 14's ~1s
+var DefaultHashConfig = HashConfig{
+	Algorithm:         AlgorithmArgon2id,
+	BcryptCost:        12,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 4,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+// HashConfigFromEnv reads PASSWORD_HASH_ALGORITHM, BCRYPT_COST, ARGON2_MEMORY_KB,
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM on top of DefaultHashConfig
+func HashConfigFromEnv() HashConfig {
+	cfg := DefaultHashConfig
+
+	if alg := os.Getenv("PASSWORD_HASH_ALGORITHM"); alg == AlgorithmBcrypt || alg == AlgorithmArgon2id {
+		cfg.Algorithm = alg
+	}
+	if v, err := strconv.Atoi(os.Getenv("BCRYPT_COST")); err == nil && v >= bcrypt.MinCost && v <= bcrypt.MaxCost {
+		cfg.BcryptCost = v
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KB"), 10, 32); err == nil && v > 0 {
+		cfg.Argon2Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil && v > 0 {
+		cfg.Argon2Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil && v > 0 {
+		cfg.Argon2Parallelism = uint8(v)
+	}
+
+	return cfg
+}
+
+// HashPassword hashes the password with the configured algorithm
+func HashPassword(password string) (string, error) {
+	return HashPasswordWith(HashConfigFromEnv(), password)
+}
+
+func HashPasswordWith(cfg HashConfig, password string) (string, error) {
+	if cfg.Algorithm == AlgorithmBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes), nil
+	}
+
+	salt := make([]byte, cfg.Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, cfg.Argon2Iterations, cfg.Argon2Memory, cfg.Argon2Parallelism, cfg.Argon2KeyLength)
+
+	// PHC string format, as produced by the reference implementation
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash compares a password with a hash of either algorithm
+func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NeedsRehash reports whether a stored hash was made with a different
+// algorithm or parameters than the current config
+func NeedsRehash(hash string) bool {
+	return needsRehash(HashConfigFromEnv(), hash)
+}
+
+func needsRehash(cfg HashConfig, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if cfg.Algorithm != AlgorithmArgon2id {
+			return true
+		}
+		params, _, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		return params.Argon2Memory != cfg.Argon2Memory ||
+			params.Argon2Iterations != cfg.Argon2Iterations ||
+			params.Argon2Parallelism != cfg.Argon2Parallelism ||
+			uint32(len(key)) != cfg.Argon2KeyLength
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		// Not a hash we can verify, so there is nothing to upgrade
+		return false
+	}
+	return cfg.Algorithm != AlgorithmBcrypt || cost != cfg.BcryptCost
+}
+
+func decodeArgon2id(hash string) (HashConfig, []byte, []byte, error) {
+	var cfg HashConfig
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return cfg, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return cfg, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &cfg.Argon2Memory, &cfg.Argon2Iterations, &cfg.Argon2Parallelism); err != nil {
+		return cfg, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return cfg, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return cfg, nil, nil, err
+	}
+
+	cfg.Algorithm = AlgorithmArgon2id
+	cfg.Argon2SaltLength = len(salt)
+	cfg.Argon2KeyLength = uint32(len(key))
+	return cfg, salt, key, nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPasswordTooShort      = errors.New("password is too short")
+	ErrPasswordTooLong       = errors.New("password is too long")
+	ErrPasswordContainsEmail = errors.New("password must not contain your email address")
+	ErrPasswordBreached      = errors.New("password appears in a list of breached passwords")
+)
+
+// bcrypt ignores everything after 72 bytes, so longer passwords would give a
+// false sense of security if the algorithm is switched back
+const maxPasswordLength = 72
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]bool
+)
+
+// ValidatePassword applies the password policy: a minimum length
+// (PASSWORD_MIN_LENGTH, default 8), no email address, and not a known
+// breached password. When PASSWORD_BREACH_CHECK=hibp the Have I Been Pwned
+// range API is consulted as well.
+func ValidatePassword(password, email string) error {
+	minLength := 8
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && v > minLength {
+		minLength = v
+	}
+
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("%w: minimum is %d characters", ErrPasswordTooShort, minLength)
+	}
+	if len(password) > maxPasswordLength {
+		return ErrPasswordTooLong
+	}
+
+	lower := strings.ToLower(password)
+	if local := strings.ToLower(strings.SplitN(email, "@", 2)[0]); len(local) >= 4 && strings.Contains(lower, local) {
+		return ErrPasswordContainsEmail
+	}
+
+	if isCommonPassword(lower) {
+		return ErrPasswordBreached
+	}
+
+	if os.Getenv("PASSWORD_BREACH_CHECK") == "hibp" {
+		breached, err := checkHIBP(password)
+		if err != nil {
+			// Don't block registration when the API is unreachable
+			return nil
+		}
+		if breached {
+			return ErrPasswordBreached
+		}
+	}
+
+	return nil
+}
+
+func isCommonPassword(lower string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = map[string]bool{}
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				commonPasswords[line] = true
+			}
+		}
+	})
+	return commonPasswords[lower]
+}
+
+var hibpClient = &http.Client{Timeout: 3 * time.Second}
+
+// checkHIBP uses the k-anonymity range API: only the first five characters
+// of the SHA-1 hash leave this process
+func checkHIBP(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	resp, err := hibpClient.Get("https://api.pwnedpasswords.com/range/" + hash[:5])
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("hibp: unexpected status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(scanner.Text(), ":")
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
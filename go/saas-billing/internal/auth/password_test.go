@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Cheap parameters so the tests stay fast
+var testArgon2 = HashConfig{
+	Algorithm:         AlgorithmArgon2id,
+	Argon2Memory:      1024,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := HashPasswordWith(testArgon2, "correct horse battery")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	assert.True(t, CheckPasswordHash("correct horse battery", hash))
+	assert.False(t, CheckPasswordHash("wrong horse battery", hash))
+
+	// Salts are random
+	other, _ := HashPasswordWith(testArgon2, "correct horse battery")
+	assert.NotEqual(t, hash, other)
+}
+
+func TestBcryptHashStillVerifies(t *testing.T) {
+	cfg := HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: 4}
+	hash, err := HashPasswordWith(cfg, "correct horse battery")
+	assert.NoError(t, err)
+	assert.True(t, CheckPasswordHash("correct horse battery", hash))
+	assert.False(t, CheckPasswordHash("wrong horse battery", hash))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptCfg := HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: 4}
+	bcryptHash, _ := HashPasswordWith(bcryptCfg, "correct horse battery")
+	argonHash, _ := HashPasswordWith(testArgon2, "correct horse battery")
+
+	assert.False(t, needsRehash(bcryptCfg, bcryptHash))
+	assert.True(t, needsRehash(HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: 5}, bcryptHash))
+	assert.True(t, needsRehash(testArgon2, bcryptHash))
+
+	assert.False(t, needsRehash(testArgon2, argonHash))
+	stronger := testArgon2
+	stronger.Argon2Iterations = 2
+	assert.True(t, needsRehash(stronger, argonHash))
+	assert.True(t, needsRehash(bcryptCfg, argonHash))
+
+	// Unusable hashes (e.g. SSO-only accounts) are left alone
+	assert.False(t, needsRehash(testArgon2, ""))
+}
+
+func TestValidatePassword(t *testing.T) {
+	os.Unsetenv("PASSWORD_BREACH_CHECK")
+
+	assert.NoError(t, ValidatePassword("tangerine-lamp-47", "jane@example.com"))
+	assert.ErrorIs(t, ValidatePassword("short", "jane@example.com"), ErrPasswordTooShort)
+	assert.ErrorIs(t, ValidatePassword(strings.Repeat("a", 73), "jane@example.com"), ErrPasswordTooLong)
+	assert.ErrorIs(t, ValidatePassword("Password123", "jane@example.com"), ErrPasswordBreached)
+	assert.ErrorIs(t, ValidatePassword("janedoe-2024!", "janedoe@example.com"), ErrPasswordContainsEmail)
+
+	os.Setenv("PASSWORD_MIN_LENGTH", "20")
+	defer os.Unsetenv("PASSWORD_MIN_LENGTH")
+	assert.ErrorIs(t, ValidatePassword("tangerine-lamp-47", "jane@example.com"), ErrPasswordTooShort)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	enrollment, err := GenerateTOTP("user@example.com")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+	assert.Contains(t, enrollment.URI, "user@example.com")
+
+	_, err = png.Decode(bytes.NewReader(enrollment.QRCode))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, err := totp.GenerateCode(enrollment.Secret, now)
+	assert.NoError(t, err)
+	step, ok := TOTPStep(code, enrollment.Secret, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+	_, ok = TOTPStep("000000x", enrollment.Secret, now)
+	assert.False(t, ok)
+
+	// The previous code is still accepted, and reports its own step
+	previous, err := totp.GenerateCode(enrollment.Secret, now.Add(-30*time.Second))
+	assert.NoError(t, err)
+	step, ok = TOTPStep(previous, enrollment.Secret, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30-1, step)
+
+	_, ok = TOTPStep(code, enrollment.Secret, now.Add(2*time.Minute))
+	assert.False(t, ok)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, "-", code[5:6])
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	// Hashing ignores formatting differences in user input
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" "))
+	assert.NotEqual(t, HashRecoveryCode(codes[0]), HashRecoveryCode(codes[1]))
+}
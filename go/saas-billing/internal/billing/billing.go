@@ -3,67 +3,140 @@ package billing
 import (
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/linkmeAman/saas-billing/internal/payments"
+	"github.com/linkmeAman/saas-billing/internal/tax"
 )
 
 type Plan struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	PriceCents  int    `json:"price_cents"`
-	Interval    string `json:"interval"`
-	CreatedAt   string `json:"created_at"`
+	// One price per currency the plan is sold in
+	Prices   []money.Money `json:"prices"`
+	Interval string        `json:"interval"`
+	MaxSeats *int          `json:"max_seats"`
+	Features []string      `json:"features"`
+	// What an organization keeps while its subscription is paused. A nil
+	// PausedMaxSeats keeps MaxSeats.
+	PausedFeatures []string `json:"paused_features"`
+	PausedMaxSeats *int     `json:"paused_max_seats"`
+	// Whether the prices already include tax
+	TaxInclusive bool   `json:"tax_inclusive"`
+	CreatedAt    string `json:"created_at"`
 }
 
 type Subscription struct {
 	ID                string    `json:"id"`
 	OrgID            string    `json:"org_id"`
 	PlanID           string    `json:"plan_id"`
+	Quantity         int       `json:"quantity"`
+	CouponCode       *string   `json:"coupon_code,omitempty"`
 	Status           string    `json:"status"`
 	CurrentPeriodEnd time.Time `json:"current_period_end"`
-	CreatedAt        string    `json:"created_at"`
+	// Set while the subscription is paused
+	PausedAt      *time.Time `json:"paused_at,omitempty"`
+	ResumeAt      *time.Time `json:"resume_at,omitempty"`
+	PauseBehavior *string    `json:"pause_behavior,omitempty"`
+	CreatedAt     string     `json:"created_at"`
 }
 
 type Invoice struct {
 	ID             string     `json:"id"`
 	SubscriptionID string     `json:"subscription_id"`
-	AmountCents    int        `json:"amount_cents"`
+	Currency       string     `json:"currency"` // Amounts are in its minor unit
+	SubtotalCents  int        `json:"subtotal_cents"`
+	TaxCents       int        `json:"tax_cents"`
+	AmountCents    int        `json:"amount_cents"` // Invoice total, tax included
 	Status         string     `json:"status"`
 	DueDate        time.Time  `json:"due_date"`
 	PaidAt         *time.Time `json:"paid_at,omitempty"`
-	CreatedAt      string     `json:"created_at"`
+	// Customer credit used when the invoice was created and credit notes
+	// issued since, RefundedCents of which was paid back; AmountDueCents is
+	// what's left to collect
+	CreditAppliedCents int `json:"credit_applied_cents"`
+	CreditedCents      int `json:"credited_cents"`
+	RefundedCents      int `json:"refunded_cents"`
+	AmountDueCents     int `json:"amount_due_cents"`
+	// The customer accounts for VAT; CustomerTaxID is printed on the invoice
+	ReverseCharge bool             `json:"reverse_charge"`
+	CustomerTaxID string           `json:"customer_tax_id,omitempty"`
+	Lines         []InvoiceLine    `json:"lines"`
+	TaxLines      []InvoiceTaxLine `json:"tax_lines"`
+	// Set once the invoice is billed through the parent organization
+	ConsolidatedInvoiceID *string `json:"consolidated_invoice_id,omitempty"`
+	CreatedAt             string  `json:"created_at"`
 }
 
 type BillingService struct {
-	db *sql.DB
+	db       *sql.DB
+	audit    *audit.AuditService
+	tax      tax.TaxCalculator
+	taxIDs   tax.TaxIDValidator
+	provider payments.Provider
 }
 
-func NewBillingService(db *sql.DB) *BillingService {
-	return &BillingService{db: db}
+// NewBillingService never reverse charges when taxIDs is nil, since nothing
+// confirms the tax IDs, and treats payments as taken by hand when provider
+// is nil
+func NewBillingService(db *sql.DB, auditService *audit.AuditService, calculator tax.TaxCalculator, taxIDs tax.TaxIDValidator, provider payments.Provider) *BillingService {
+	if taxIDs == nil {
+		taxIDs = tax.UnverifiedValidator{}
+	}
+	if provider == nil {
+		provider = payments.ManualProvider{}
+	}
+	return &BillingService{db: db, audit: auditService, tax: calculator, taxIDs: taxIDs, provider: provider}
 }
 
-func (s *BillingService) CreatePlan(name, description string, priceCents int, interval string) (*Plan, error) {
+func (s *BillingService) CreatePlan(name, description, interval string, prices []money.Money) (*Plan, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var plan Plan
-	err := s.db.QueryRow(`
-		INSERT INTO plans (name, description, price_cents, interval)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, name, description, price_cents, interval, created_at
-	`, name, description, priceCents, interval).Scan(
+	err = tx.QueryRow(`
+		INSERT INTO plans (name, description, interval)
+		VALUES ($1, $2, $3)
+		RETURNING id, name, description, interval, max_seats, features, paused_features, paused_max_seats,
+			tax_inclusive, created_at
+	`, name, description, interval).Scan(
 		&plan.ID, &plan.Name, &plan.Description,
-		&plan.PriceCents, &plan.Interval, &plan.CreatedAt,
+		&plan.Interval, &plan.MaxSeats, pq.Array(&plan.Features), pq.Array(&plan.PausedFeatures), &plan.PausedMaxSeats,
+		&plan.TaxInclusive, &plan.CreatedAt,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	plan.Prices = []money.Money{}
+	for _, price := range prices {
+		saved, err := setPlanPrice(tx, plan.ID, price)
+		if err != nil {
+			return nil, err
+		}
+		plan.Prices = append(plan.Prices, saved)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return &plan, nil
 }
 
 func (s *BillingService) GetPlans() ([]Plan, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, description, price_cents, interval, created_at
+		SELECT id, name, description, interval, max_seats, features, paused_features, paused_max_seats,
+			tax_inclusive, created_at
 		FROM plans
-		ORDER BY price_cents ASC
+		ORDER BY created_at ASC
 	`)
 
 	if err != nil {
@@ -76,49 +149,101 @@ func (s *BillingService) GetPlans() ([]Plan, error) {
 		var plan Plan
 		if err := rows.Scan(
 			&plan.ID, &plan.Name, &plan.Description,
-			&plan.PriceCents, &plan.Interval, &plan.CreatedAt,
+			&plan.Interval, &plan.MaxSeats, pq.Array(&plan.Features), pq.Array(&plan.PausedFeatures), &plan.PausedMaxSeats,
+			&plan.TaxInclusive, &plan.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
 		plans = append(plans, plan)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadPlanPrices(plans); err != nil {
+		return nil, err
+	}
 
 	return plans, nil
 }
 
-func (s *BillingService) CreateSubscription(orgID, planID string) (*Subscription, error) {
+// CreateSubscription bills the plan in the organization's currency. The
+// first subscription locks that currency: it's the one requested, or
+// DefaultCurrency if none is. Later subscriptions can't ask for another.
+func (s *BillingService) CreateSubscription(orgID, planID, currency string, actor audit.Actor) (*Subscription, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	// Get plan details
-	var interval string
-	var priceCents int
+	var locked sql.NullString
 	err = tx.QueryRow(`
-		SELECT interval, price_cents FROM plans WHERE id = $1
-	`, planID).Scan(&interval, &priceCents)
+		SELECT billing_currency FROM organizations WHERE id = $1 FOR UPDATE
+	`, orgID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
 
+	currency, err = subscriptionCurrency(locked.String, currency)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate period end based on interval
-	periodEnd := time.Now()
-	if interval == "month" {
-		periodEnd = periodEnd.AddDate(0, 1, 0)
-	} else {
-		periodEnd = periodEnd.AddDate(1, 0, 0)
+	// Get plan details
+	charge, err := chargeFor(tx, planID, currency, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked.Valid {
+		_, err = tx.Exec(`
+			UPDATE organizations SET billing_currency = $2 WHERE id = $1
+		`, orgID, currency)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	// The new subscription replaces the current one, so its history and
+	// any changes scheduled for it end here. Time already paid for is
+	// credited; a paused subscription's was when it paused.
+	previous, previousStart, err := lockCurrentSubscription(tx, orgID)
+	if err != nil && err != ErrNoActiveSubscription {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.Status == "active" {
+			err = creditUnusedPeriod(tx, orgID, previous.ID, previousStart, previous.CurrentPeriodEnd, time.Now())
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.Exec(`
+			UPDATE subscriptions SET status = 'canceled', canceled_at = NOW() WHERE id = $1
+		`, previous.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec(`
+		UPDATE subscription_schedules SET status = 'canceled', updated_at = NOW()
+		WHERE org_id = $1 AND status = 'active'
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate period end based on interval
+	periodEnd := nextPeriodEnd(time.Now(), charge.Interval)
+
 	var sub Subscription
 	err = tx.QueryRow(`
 		INSERT INTO subscriptions (org_id, plan_id, status, current_period_start, current_period_end)
 		VALUES ($1, $2, 'active', NOW(), $3)
-		RETURNING id, org_id, plan_id, status, current_period_end, created_at
+		RETURNING id, org_id, plan_id, quantity, coupon_code, status, current_period_end, created_at
 	`, orgID, planID, periodEnd).Scan(
-		&sub.ID, &sub.OrgID, &sub.PlanID,
+		&sub.ID, &sub.OrgID, &sub.PlanID, &sub.Quantity, &sub.CouponCode,
 		&sub.Status, &sub.CurrentPeriodEnd, &sub.CreatedAt,
 	)
 
@@ -127,10 +252,8 @@ func (s *BillingService) CreateSubscription(orgID, planID string) (*Subscription
 	}
 
 	// Create first invoice
-	_, err = tx.Exec(`
-		INSERT INTO invoices (subscription_id, amount_cents, status, due_date)
-		VALUES ($1, $2, 'unpaid', NOW())
-	`, sub.ID, priceCents)
+	_, err = s.createInvoice(tx, orgID, sub.ID, currency, []tax.LineInput{charge.Line},
+		servicePeriod{Start: time.Now(), End: periodEnd}, time.Now())
 
 	if err != nil {
 		return nil, err
@@ -140,18 +263,32 @@ func (s *BillingService) CreateSubscription(orgID, planID string) (*Subscription
 		return nil, err
 	}
 
+	e := audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "subscription.created",
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		After:      map[string]interface{}{"plan_id": sub.PlanID, "status": sub.Status, "current_period_end": sub.CurrentPeriodEnd, "currency": currency},
+	}
+	if previous != nil {
+		e.Before = map[string]interface{}{"plan_id": previous.PlanID, "status": previous.Status}
+	}
+	s.audit.Emit(e)
+
 	return &sub, nil
 }
 
 func (s *BillingService) GetOrgSubscription(orgID string) (*Subscription, error) {
 	var sub Subscription
 	err := s.db.QueryRow(`
-		SELECT id, org_id, plan_id, status, current_period_end, created_at
+		SELECT id, org_id, plan_id, quantity, coupon_code, status, current_period_end,
+			paused_at, resume_at, pause_behavior, created_at
 		FROM subscriptions
-		WHERE org_id = $1 AND status = 'active'
+		WHERE org_id = $1 AND status IN ('active', 'paused')
 	`, orgID).Scan(
-		&sub.ID, &sub.OrgID, &sub.PlanID,
-		&sub.Status, &sub.CurrentPeriodEnd, &sub.CreatedAt,
+		&sub.ID, &sub.OrgID, &sub.PlanID, &sub.Quantity, &sub.CouponCode,
+		&sub.Status, &sub.CurrentPeriodEnd, &sub.PausedAt, &sub.ResumeAt, &sub.PauseBehavior, &sub.CreatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -167,8 +304,10 @@ func (s *BillingService) GetOrgSubscription(orgID string) (*Subscription, error)
 
 func (s *BillingService) GetInvoices(orgID string) ([]Invoice, error) {
 	rows, err := s.db.Query(`
-		SELECT i.id, i.subscription_id, i.amount_cents, i.status,
-			   i.due_date, i.paid_at, i.created_at
+		SELECT i.id, i.subscription_id, i.currency, i.amount_cents, i.status,
+			   i.due_date, i.paid_at, i.consolidated_invoice_id, i.created_at,
+			   i.subtotal_cents, i.tax_cents, i.reverse_charge, COALESCE(i.customer_tax_id, ''),
+			   i.credit_applied_cents, i.credited_cents, i.refunded_cents, i.amount_due_cents
 		FROM invoices i
 		JOIN subscriptions s ON s.id = i.subscription_id
 		WHERE s.org_id = $1
@@ -184,13 +323,22 @@ func (s *BillingService) GetInvoices(orgID string) ([]Invoice, error) {
 	for rows.Next() {
 		var inv Invoice
 		if err := rows.Scan(
-			&inv.ID, &inv.SubscriptionID, &inv.AmountCents,
-			&inv.Status, &inv.DueDate, &inv.PaidAt, &inv.CreatedAt,
+			&inv.ID, &inv.SubscriptionID, &inv.Currency, &inv.AmountCents,
+			&inv.Status, &inv.DueDate, &inv.PaidAt, &inv.ConsolidatedInvoiceID, &inv.CreatedAt,
+			&inv.SubtotalCents, &inv.TaxCents, &inv.ReverseCharge, &inv.CustomerTaxID,
+			&inv.CreditAppliedCents, &inv.CreditedCents, &inv.RefundedCents, &inv.AmountDueCents,
 		); err != nil {
 			return nil, err
 		}
 		invoices = append(invoices, inv)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadInvoiceDetails(invoices); err != nil {
+		return nil, err
+	}
 
 	return invoices, nil
 }
@@ -0,0 +1,83 @@
+package billing
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+var errStop = errors.New("stop")
+
+// cents matches an amount prorated up to now, which is a cent short once
+// any time has passed
+type cents int
+
+func (c cents) Match(v driver.Value) bool {
+	n, ok := v.(int64)
+	return ok && (n == int64(c) || n == int64(c)-1)
+}
+
+// expectCurrentSubscription expects CreateSubscription to price the plan
+// and lock the organization's current subscription
+func expectCurrentSubscription(mock sqlmock.Sqlmock, status string, periodStart, periodEnd time.Time) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT billing_currency FROM organizations`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"billing_currency"}).AddRow("USD"))
+	mock.ExpectQuery(`SELECT name, interval, tax_inclusive FROM plans`).WithArgs("plan-2").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "interval", "tax_inclusive"}).AddRow("Pro", "month", false))
+	mock.ExpectQuery(`SELECT amount FROM plan_prices`).WithArgs("plan-2", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(5000))
+	mock.ExpectQuery(`FROM subscriptions\s+WHERE org_id = \$1 AND status IN \('active', 'paused'\)\s+FOR UPDATE`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "plan_id", "quantity", "coupon_code", "status", "current_period_start",
+			"current_period_end", "paused_at", "resume_at", "pause_behavior", "created_at"}).
+			AddRow("sub-1", "org-1", "plan-1", 1, nil, status, periodStart, periodEnd, nil, nil, nil, periodStart))
+}
+
+func TestCreateSubscriptionCreditsReplacedSubscription(t *testing.T) {
+	s, mock := newBillingMock(t)
+	now := time.Now()
+
+	expectCurrentSubscription(mock, "active", now.AddDate(0, 0, -10), now.AddDate(0, 0, 20))
+	// Two thirds of the month paid for are left
+	mock.ExpectQuery(`SELECT id, status, amount_cents, credited_cents FROM invoices`).WithArgs("sub-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "amount_cents", "credited_cents"}).AddRow("inv-1", "paid", 3000, 0))
+	mock.ExpectQuery(`FROM refunds`).WithArgs("inv-1").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery(`FROM invoices i\s+JOIN subscriptions s`).WithArgs("inv-1", "org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "status", "amount_cents", "tax_cents", "credited_cents", "amount_due_cents"}).
+			AddRow("USD", "paid", 3000, 0, 0, 0))
+	mock.ExpectQuery(`FROM refunds`).WithArgs("inv-1").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery(`INSERT INTO credit_notes`).
+		WithArgs("inv-1", "org-1", "USD", cents(2000), 0, 0, cents(2000), 0, "order_change", sqlmock.AnyArg(), "").
+		WillReturnError(errStop)
+	mock.ExpectRollback()
+
+	_, err := s.CreateSubscription("org-1", "plan-2", "", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, errStop)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateSubscriptionCancelsReplacedSubscription(t *testing.T) {
+	s, mock := newBillingMock(t)
+	now := time.Now()
+
+	// A paused subscription's unused time was credited when it paused
+	expectCurrentSubscription(mock, "paused", now.AddDate(0, 0, -10), now.AddDate(0, 0, 20))
+	mock.ExpectExec(`UPDATE subscriptions SET status = 'canceled', canceled_at = NOW\(\) WHERE id = \$1`).WithArgs("sub-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE subscription_schedules SET status = 'canceled'`).WithArgs("org-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO subscriptions`).WithArgs("org-1", "plan-2", sqlmock.AnyArg()).
+		WillReturnError(errStop)
+	mock.ExpectRollback()
+
+	_, err := s.CreateSubscription("org-1", "plan-2", "", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, errStop)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
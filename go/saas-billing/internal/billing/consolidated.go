@@ -0,0 +1,339 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+var (
+	ErrChildOrganization           = errors.New("child organizations are billed through their parent")
+	ErrNothingToConsolidate        = errors.New("no unpaid invoices or usage in this period")
+	ErrConsolidatedInvoiceNotFound = errors.New("consolidated invoice not found")
+	ErrConsolidatedInvoicePaid     = errors.New("consolidated invoice is already paid")
+)
+
+type ConsolidatedInvoiceLine struct {
+	OrgID       string `json:"org_id"`
+	Description string `json:"description"`
+	InvoiceID   string `json:"invoice_id,omitempty"`
+	Metric      string `json:"metric,omitempty"`
+	Quantity    int64  `json:"quantity,omitempty"`
+	Currency    string `json:"currency"`
+	AmountCents int    `json:"amount_cents"`
+}
+
+// ConsolidatedInvoice is a single bill at a parent organization covering the
+// unpaid subscription invoices of the parent and its children. Usage is
+// listed for reference and doesn't add to the amount.
+type ConsolidatedInvoice struct {
+	ID          string                    `json:"id"`
+	ParentOrgID string                    `json:"parent_org_id"`
+	PeriodStart time.Time                 `json:"period_start"`
+	PeriodEnd   time.Time                 `json:"period_end"`
+	Currency    string                    `json:"currency"`
+	AmountCents int                       `json:"amount_cents"`
+	Status      string                    `json:"status"`
+	Lines       []ConsolidatedInvoiceLine `json:"lines"`
+	CreatedAt   string                    `json:"created_at"`
+}
+
+// CreateConsolidatedInvoice rolls up invoices created in [start, end) that
+// haven't been consolidated yet. Rolled-up invoices point at the new
+// consolidated invoice so they aren't billed twice. Only invoices in the
+// parent's billing currency are rolled up; children billed in another
+// currency pay their invoices directly.
+func (s *BillingService) CreateConsolidatedInvoice(parentOrgID string, start, end time.Time, actor audit.Actor) (*ConsolidatedInvoice, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var parentOfParent sql.NullString
+	var currency string
+	err = tx.QueryRow(`
+		SELECT parent_id, COALESCE(billing_currency, $2) FROM organizations WHERE id = $1 FOR UPDATE
+	`, parentOrgID, DefaultCurrency).Scan(&parentOfParent, &currency)
+	if err != nil {
+		return nil, err
+	}
+	if parentOfParent.Valid {
+		return nil, ErrChildOrganization
+	}
+
+	inv := &ConsolidatedInvoice{ParentOrgID: parentOrgID, PeriodStart: start, PeriodEnd: end, Currency: currency, Lines: []ConsolidatedInvoiceLine{}}
+
+	rows, err := tx.Query(`
+		SELECT i.id, o.id, o.name, i.amount_due_cents
+		FROM invoices i
+		JOIN subscriptions s ON s.id = i.subscription_id
+		JOIN organizations o ON o.id = s.org_id
+		WHERE (o.id = $1 OR o.parent_id = $1)
+		  AND i.created_at >= $2 AND i.created_at < $3
+		  AND i.status = 'unpaid' AND i.consolidated_invoice_id IS NULL
+		  AND i.currency = $4
+		ORDER BY o.name, i.created_at
+		FOR UPDATE OF i
+	`, parentOrgID, start, end, currency)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var line ConsolidatedInvoiceLine
+		var orgName string
+		if err := rows.Scan(&line.InvoiceID, &line.OrgID, &orgName, &line.AmountCents); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		line.Description = fmt.Sprintf("Subscription: %s", orgName)
+		line.Currency = currency
+		inv.AmountCents += line.AmountCents
+		inv.Lines = append(inv.Lines, line)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT o.id, o.name, u.metric, SUM(u.quantity)
+		FROM usage_records u
+		JOIN organizations o ON o.id = u.org_id
+		WHERE (o.id = $1 OR o.parent_id = $1)
+		  AND u.recorded_at >= $2 AND u.recorded_at < $3
+		GROUP BY o.id, o.name, u.metric
+		ORDER BY o.name, u.metric
+	`, parentOrgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var line ConsolidatedInvoiceLine
+		var orgName string
+		if err := rows.Scan(&line.OrgID, &orgName, &line.Metric, &line.Quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		line.Description = fmt.Sprintf("Usage: %s", orgName)
+		line.Currency = currency
+		inv.Lines = append(inv.Lines, line)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(inv.Lines) == 0 {
+		return nil, ErrNothingToConsolidate
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO consolidated_invoices (parent_org_id, period_start, period_end, currency, amount_cents)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, created_at
+	`, parentOrgID, start, end, currency, inv.AmountCents).Scan(&inv.ID, &inv.Status, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range inv.Lines {
+		_, err = tx.Exec(`
+			INSERT INTO consolidated_invoice_lines
+				(consolidated_invoice_id, org_id, description, invoice_id, metric, quantity, amount_cents)
+			VALUES ($1, $2, $3, NULLIF($4, '')::uuid, NULLIF($5, ''), NULLIF($6, 0), $7)
+		`, inv.ID, line.OrgID, line.Description, line.InvoiceID, line.Metric, line.Quantity, line.AmountCents)
+		if err != nil {
+			return nil, err
+		}
+
+		if line.InvoiceID != "" {
+			_, err = tx.Exec(`
+				UPDATE invoices SET consolidated_invoice_id = $1 WHERE id = $2
+			`, inv.ID, line.InvoiceID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      parentOrgID,
+		Actor:      actor,
+		Action:     "consolidated_invoice.created",
+		TargetType: "consolidated_invoice",
+		TargetID:   inv.ID,
+		After:      map[string]interface{}{"amount_cents": inv.AmountCents, "currency": inv.Currency, "lines": len(inv.Lines)},
+		Metadata:   map[string]interface{}{"period_start": start, "period_end": end},
+	})
+	return inv, nil
+}
+
+// PayConsolidatedInvoice records payment in full of a consolidated invoice,
+// e.g. from the provider's webhook or a bank transfer. Each rolled-up
+// invoice still unpaid is paid off with a payment of its own, so every
+// organization's invoices and ledger show what was settled for them.
+func (s *BillingService) PayConsolidatedInvoice(parentOrgID, consolidatedID, providerPaymentID string, actor audit.Actor) ([]Payment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, parentOrgID); err != nil {
+		return nil, err
+	}
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT status FROM consolidated_invoices
+		WHERE id::text = $1 AND parent_org_id = $2
+		FOR UPDATE
+	`, consolidatedID, parentOrgID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, ErrConsolidatedInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "unpaid" {
+		return nil, ErrConsolidatedInvoicePaid
+	}
+
+	type due struct {
+		orgID, invoiceID, currency string
+		amountCents                int
+	}
+	var dues []due
+	rows, err := tx.Query(`
+		SELECT s.org_id, i.id, i.currency, i.amount_due_cents
+		FROM invoices i
+		JOIN subscriptions s ON s.id = i.subscription_id
+		WHERE i.consolidated_invoice_id = $1 AND i.status = 'unpaid' AND i.amount_due_cents > 0
+		ORDER BY i.id
+		FOR UPDATE OF i
+	`, consolidatedID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.orgID, &d.invoiceID, &d.currency, &d.amountCents); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dues = append(dues, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	payments := []Payment{}
+	for _, d := range dues {
+		p, err := recordPayment(tx, d.orgID, d.invoiceID, d.currency, d.amountCents, providerPaymentID, actor.UserID)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+
+	_, err = tx.Exec(`UPDATE consolidated_invoices SET status = 'paid' WHERE id = $1`, consolidatedID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	paidCents := 0
+	for i, p := range payments {
+		paidCents += p.AmountCents
+		s.audit.Emit(audit.Event{
+			OrgID:      dues[i].orgID,
+			Actor:      actor,
+			Action:     "invoice.payment_recorded",
+			TargetType: "invoice",
+			TargetID:   p.InvoiceID,
+			After:      map[string]interface{}{"payment_id": p.ID, "amount_cents": p.AmountCents, "currency": p.Currency},
+			Metadata:   map[string]interface{}{"consolidated_invoice_id": consolidatedID},
+		})
+	}
+	s.audit.Emit(audit.Event{
+		OrgID:      parentOrgID,
+		Actor:      actor,
+		Action:     "consolidated_invoice.paid",
+		TargetType: "consolidated_invoice",
+		TargetID:   consolidatedID,
+		Before:     map[string]interface{}{"status": status},
+		After:      map[string]interface{}{"status": "paid", "paid_cents": paidCents, "invoices_paid": len(payments)},
+	})
+	return payments, nil
+}
+
+// GetConsolidatedInvoices lists the parent organization's consolidated
+// invoices, newest first, with their lines
+func (s *BillingService) GetConsolidatedInvoices(parentOrgID string) ([]ConsolidatedInvoice, error) {
+	rows, err := s.db.Query(`
+		SELECT id, parent_org_id, period_start, period_end, currency, amount_cents, status, created_at
+		FROM consolidated_invoices
+		WHERE parent_org_id = $1
+		ORDER BY created_at DESC
+	`, parentOrgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []ConsolidatedInvoice{}
+	index := map[string]int{}
+	for rows.Next() {
+		var inv ConsolidatedInvoice
+		if err := rows.Scan(&inv.ID, &inv.ParentOrgID, &inv.PeriodStart, &inv.PeriodEnd,
+			&inv.Currency, &inv.AmountCents, &inv.Status, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		inv.Lines = []ConsolidatedInvoiceLine{}
+		index[inv.ID] = len(invoices)
+		invoices = append(invoices, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := s.db.Query(`
+		SELECT l.consolidated_invoice_id, l.org_id, l.description, COALESCE(l.invoice_id::text, ''),
+			COALESCE(l.metric, ''), COALESCE(l.quantity, 0), l.amount_cents
+		FROM consolidated_invoice_lines l
+		JOIN consolidated_invoices c ON c.id = l.consolidated_invoice_id
+		WHERE c.parent_org_id = $1
+		ORDER BY l.description, l.metric
+	`, parentOrgID)
+	if err != nil {
+		return nil, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
+		var id string
+		var line ConsolidatedInvoiceLine
+		if err := lines.Scan(&id, &line.OrgID, &line.Description, &line.InvoiceID,
+			&line.Metric, &line.Quantity, &line.AmountCents); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			line.Currency = invoices[i].Currency
+			invoices[i].Lines = append(invoices[i].Lines, line)
+		}
+	}
+
+	return invoices, lines.Err()
+}
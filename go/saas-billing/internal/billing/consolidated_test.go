@@ -0,0 +1,128 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBillingMock(t *testing.T) (*BillingService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewBillingService(db, audit.NewAuditService(db), nil, nil, nil), mock
+}
+
+func expectConsolidationParent(mock sqlmock.Sqlmock, parentID interface{}) {
+	mock.ExpectQuery(`SELECT parent_id, COALESCE\(billing_currency, \$2\) FROM organizations`).
+		WithArgs("parent", DefaultCurrency).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "currency"}).AddRow(parentID, "USD"))
+}
+
+func TestCreateConsolidatedInvoice(t *testing.T) {
+	s, mock := newBillingMock(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	mock.ExpectBegin()
+	expectConsolidationParent(mock, nil)
+	mock.ExpectQuery(`FROM invoices i\s+JOIN subscriptions s`).WithArgs("parent", start, end, "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "name", "amount_due_cents"}).
+			AddRow("inv-1", "child", "Acme EU", 4000).
+			AddRow("inv-2", "parent", "Acme", 6000))
+	mock.ExpectQuery(`FROM usage_records u`).WithArgs("parent", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "name", "metric", "sum"}).
+			AddRow("child", "Acme EU", "api_calls", 1200))
+	mock.ExpectQuery(`INSERT INTO consolidated_invoices`).WithArgs("parent", start, end, "USD", 10000).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}).AddRow("ci-1", "unpaid", "2025-02-01"))
+	// Each invoice line marks its invoice so it isn't consolidated twice
+	mock.ExpectExec(`INSERT INTO consolidated_invoice_lines`).WithArgs("ci-1", "child", sqlmock.AnyArg(), "inv-1", "", int64(0), 4000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE invoices SET consolidated_invoice_id`).WithArgs("ci-1", "inv-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO consolidated_invoice_lines`).WithArgs("ci-1", "parent", sqlmock.AnyArg(), "inv-2", "", int64(0), 6000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE invoices SET consolidated_invoice_id`).WithArgs("ci-1", "inv-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO consolidated_invoice_lines`).WithArgs("ci-1", "child", sqlmock.AnyArg(), "", "api_calls", int64(1200), 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	inv, err := s.CreateConsolidatedInvoice("parent", start, end, audit.Actor{UserID: "user-1"})
+	require.NoError(t, err)
+	// Usage is listed for reference only
+	assert.Equal(t, 10000, inv.AmountCents)
+	assert.Len(t, inv.Lines, 3)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateConsolidatedInvoiceRejected(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	s, mock := newBillingMock(t)
+	mock.ExpectBegin()
+	expectConsolidationParent(mock, "grandparent")
+	mock.ExpectRollback()
+	_, err := s.CreateConsolidatedInvoice("parent", start, end, audit.Actor{})
+	assert.ErrorIs(t, err, ErrChildOrganization)
+
+	mock.ExpectBegin()
+	expectConsolidationParent(mock, nil)
+	mock.ExpectQuery(`FROM invoices i`).WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "name", "amount_due_cents"}))
+	mock.ExpectQuery(`FROM usage_records u`).WillReturnRows(sqlmock.NewRows([]string{"org_id", "name", "metric", "sum"}))
+	mock.ExpectRollback()
+	_, err = s.CreateConsolidatedInvoice("parent", start, end, audit.Actor{})
+	assert.ErrorIs(t, err, ErrNothingToConsolidate)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPayConsolidatedInvoice(t *testing.T) {
+	s, mock := newBillingMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT 1 FROM organizations WHERE id = \$1 FOR UPDATE`).WithArgs("parent").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT status FROM consolidated_invoices`).WithArgs("ci-1", "parent").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("unpaid"))
+	mock.ExpectQuery(`WHERE i.consolidated_invoice_id = \$1 AND i.status = 'unpaid'`).WithArgs("ci-1").
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "id", "currency", "amount_due_cents"}).
+			AddRow("child", "inv-1", "USD", 4000))
+	// The child's invoice is paid with a payment of its own
+	mock.ExpectQuery(`INSERT INTO invoice_payments`).WithArgs("inv-1", "USD", 4000, "pi_123", "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("pay-1", time.Now()))
+	mock.ExpectExec(`UPDATE invoices SET\s+amount_due_cents`).WithArgs("inv-1", 4000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ledger_entries`).WithArgs("child", "USD", "payment.received", "payment", "pay-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("entry-1"))
+	for range paymentLines(4000) {
+		mock.ExpectExec(`INSERT INTO ledger_lines`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`UPDATE consolidated_invoices SET status = 'paid'`).WithArgs("ci-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	payments, err := s.PayConsolidatedInvoice("parent", "ci-1", "pi_123", audit.Actor{UserID: "user-1"})
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, "inv-1", payments[0].InvoiceID)
+
+	// Paying again is refused
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT 1 FROM organizations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT status FROM consolidated_invoices`).WithArgs("ci-1", "parent").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("paid"))
+	mock.ExpectRollback()
+	_, err = s.PayConsolidatedInvoice("parent", "ci-1", "pi_123", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrConsolidatedInvoicePaid)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrCouponNotFound = errors.New("coupon not found")
+	ErrCouponExists   = errors.New("a coupon with this code already exists")
+	ErrInvalidCoupon  = errors.New("coupon code is required and percent_off must be between 1 and 100")
+)
+
+// Coupon takes PercentOff percent off the invoices of the subscriptions
+// carrying it
+type Coupon struct {
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	PercentOff int       `json:"percent_off"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateCoupon adds a coupon. Codes are upper-cased.
+func (s *BillingService) CreateCoupon(code, name string, percentOff int) (*Coupon, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || percentOff < 1 || percentOff > 100 {
+		return nil, ErrInvalidCoupon
+	}
+
+	c := Coupon{Code: code, Name: name, PercentOff: percentOff}
+	err := s.db.QueryRow(`
+		INSERT INTO coupons (code, name, percent_off) VALUES ($1, $2, $3)
+		RETURNING created_at
+	`, code, name, percentOff).Scan(&c.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrCouponExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *BillingService) ListCoupons() ([]Coupon, error) {
+	rows, err := s.db.Query(`
+		SELECT code, name, percent_off, created_at FROM coupons ORDER BY code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupons := []Coupon{}
+	for rows.Next() {
+		var c Coupon
+		if err := rows.Scan(&c.Code, &c.Name, &c.PercentOff, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, c)
+	}
+	return coupons, rows.Err()
+}
+
+// couponPercentOff looks up a coupon's discount; no coupon is 0% off
+func couponPercentOff(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, code *string) (int, error) {
+	if code == nil {
+		return 0, nil
+	}
+
+	var percentOff int
+	err := q.QueryRow(`SELECT percent_off FROM coupons WHERE code = $1`, *code).Scan(&percentOff)
+	if err == sql.ErrNoRows {
+		return 0, ErrCouponNotFound
+	}
+	return percentOff, err
+}
+
+// discounted takes percentOff percent off amountCents, rounding the
+// discount down to a whole minor unit
+func discounted(amountCents, percentOff int) int {
+	return amountCents - amountCents*percentOff/100
+}
@@ -0,0 +1,492 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+)
+
+var (
+	ErrInvoiceNotFound      = errors.New("invoice not found")
+	ErrInvoiceNotFinalized  = errors.New("credit notes can only be issued against finalized invoices")
+	ErrCreditExceedsInvoice = errors.New("amount exceeds what is left to credit on the invoice")
+	ErrInvalidCreditAmount  = errors.New("credit amount must be positive")
+	ErrInvalidCreditReason  = errors.New("invalid credit note reason")
+	ErrInsufficientCredit   = errors.New("credit balance can't go below zero")
+	ErrNoBillingCurrency    = errors.New("organization has no billing currency yet")
+)
+
+// CreditNoteReasons are the accepted reasons for issuing a credit note
+var CreditNoteReasons = []string{"duplicate", "fraudulent", "order_change", "product_unsatisfactory", "service_issue", "other"}
+
+// CreditNote reduces a finalized invoice. InvoiceCents came off the amount
+// still due, BalanceCents went to the customer's credit balance and
+// RefundCents was paid back by a refund.
+type CreditNote struct {
+	ID           string    `json:"id"`
+	InvoiceID    string    `json:"invoice_id"`
+	OrgID        string    `json:"org_id"`
+	Currency     string    `json:"currency"`
+	AmountCents  int       `json:"amount_cents"`
+	TaxCents     int       `json:"tax_cents"`
+	InvoiceCents int       `json:"invoice_cents"`
+	BalanceCents int       `json:"balance_cents"`
+	RefundCents  int       `json:"refund_cents"`
+	Reason       string    `json:"reason"`
+	Memo         string    `json:"memo,omitempty"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreditBalance is what the organization has to spend on its next invoices
+type CreditBalance struct {
+	Currency     string `json:"currency"`
+	BalanceCents int    `json:"balance_cents"`
+}
+
+// CreditTransaction is one change to the credit balance. BalanceCents is
+// the balance right after it.
+type CreditTransaction struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Currency     string    `json:"currency"`
+	AmountCents  int       `json:"amount_cents"`
+	BalanceCents int       `json:"balance_cents"`
+	Description  string    `json:"description,omitempty"`
+	InvoiceID    *string   `json:"invoice_id,omitempty"`
+	CreditNoteID *string   `json:"credit_note_id,omitempty"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// creditTransaction is a balance change to record
+type creditTransaction struct {
+	OrgID        string
+	Currency     string
+	Type         string
+	AmountCents  int
+	Description  string
+	InvoiceID    string
+	CreditNoteID string
+	CreatedBy    string
+}
+
+// CreateCreditNote credits amountCents of the invoice, or everything left to
+// credit when amountCents is 0. The credit first reduces what's still due;
+// the rest, all of it for a paid invoice, goes to the credit balance. An
+// unpaid invoice credited down to nothing is voided.
+func (s *BillingService) CreateCreditNote(orgID, invoiceID string, amountCents int, reason, memo string, actor audit.Actor) (*CreditNote, error) {
+	if !validCreditReason(reason) {
+		return nil, ErrInvalidCreditReason
+	}
+	if amountCents < 0 {
+		return nil, ErrInvalidCreditAmount
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization first, as invoice creation does, so the balance
+	// can't change underneath us
+	if _, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return nil, err
+	}
+
+	note, err := issueCreditNote(tx, orgID, invoiceID, amountCents, reason, memo, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "credit_note.created",
+		TargetType: "invoice",
+		TargetID:   invoiceID,
+		After: map[string]interface{}{
+			"credit_note_id": note.ID,
+			"amount_cents":   note.AmountCents,
+			"currency":       note.Currency,
+			"reason":         note.Reason,
+		},
+	})
+	return note, nil
+}
+
+// issueCreditNote credits the invoice within tx, which must hold the
+// organization's lock. An amountCents of 0 credits everything left.
+func issueCreditNote(tx *sql.Tx, orgID, invoiceID string, amountCents int, reason, memo, createdBy string) (*CreditNote, error) {
+	var currency, status string
+	var total, taxCents, credited, due int
+	err := tx.QueryRow(`
+		SELECT i.currency, i.status, i.amount_cents, i.tax_cents, i.credited_cents, i.amount_due_cents
+		FROM invoices i
+		JOIN subscriptions s ON s.id = i.subscription_id
+		WHERE i.id::text = $1 AND s.org_id = $2
+		FOR UPDATE OF i
+	`, invoiceID, orgID).Scan(&currency, &status, &total, &taxCents, &credited, &due)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "unpaid" && status != "paid" {
+		return nil, ErrInvoiceNotFinalized
+	}
+
+	pending, err := pendingRefunds(tx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := total - credited - pending
+	if amountCents == 0 {
+		amountCents = remaining
+	}
+	if amountCents == 0 || amountCents > remaining {
+		return nil, ErrCreditExceedsInvoice
+	}
+
+	note := CreditNote{
+		InvoiceID:   invoiceID,
+		OrgID:       orgID,
+		Currency:    currency,
+		AmountCents: amountCents,
+		TaxCents:    proportionalTax(amountCents, total, taxCents),
+		Reason:      reason,
+		Memo:        memo,
+	}
+	note.InvoiceCents, note.BalanceCents = splitCredit(amountCents, due)
+
+	if err := insertCreditNote(tx, &note, createdBy); err != nil {
+		return nil, err
+	}
+
+	if note.BalanceCents > 0 {
+		_, err = addCreditTransaction(tx, creditTransaction{
+			OrgID:        orgID,
+			Currency:     currency,
+			Type:         "credit_note",
+			AmountCents:  note.BalanceCents,
+			InvoiceID:    invoiceID,
+			CreditNoteID: note.ID,
+			CreatedBy:    createdBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &note, nil
+}
+
+// ListCreditNotes returns the organization's credit notes, newest first,
+// optionally only those for one invoice
+func (s *BillingService) ListCreditNotes(orgID, invoiceID string) ([]CreditNote, error) {
+	rows, err := s.db.Query(`
+		SELECT id, invoice_id, org_id, currency, amount_cents, tax_cents, invoice_cents, balance_cents,
+			refund_cents, reason, memo, created_by, created_at
+		FROM credit_notes
+		WHERE org_id = $1 AND ($2 = '' OR invoice_id::text = $2)
+		ORDER BY created_at DESC
+	`, orgID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []CreditNote{}
+	for rows.Next() {
+		var n CreditNote
+		if err := rows.Scan(&n.ID, &n.InvoiceID, &n.OrgID, &n.Currency, &n.AmountCents, &n.TaxCents,
+			&n.InvoiceCents, &n.BalanceCents, &n.RefundCents, &n.Reason, &n.Memo, &n.CreatedBy, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+
+	return notes, rows.Err()
+}
+
+// GetCreditBalance returns the balance in the organization's billing
+// currency
+func (s *BillingService) GetCreditBalance(orgID string) (*CreditBalance, error) {
+	var b CreditBalance
+	err := s.db.QueryRow(`
+		SELECT COALESCE(billing_currency, $2) FROM organizations WHERE id = $1
+	`, orgID, DefaultCurrency).Scan(&b.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	b.BalanceCents, err = creditBalance(s.db, orgID, b.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// AdjustCreditBalance grants credit, e.g. to compensate for an outage, or
+// takes it back when amountCents is negative. The organization must already
+// be billed in a currency.
+func (s *BillingService) AdjustCreditBalance(orgID string, amountCents int, description string, actor audit.Actor) (*CreditTransaction, error) {
+	if amountCents == 0 {
+		return nil, ErrInvalidCreditAmount
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var currency sql.NullString
+	err = tx.QueryRow(`
+		SELECT billing_currency FROM organizations WHERE id = $1 FOR UPDATE
+	`, orgID).Scan(&currency)
+	if err != nil {
+		return nil, err
+	}
+	if !currency.Valid {
+		return nil, ErrNoBillingCurrency
+	}
+
+	t, err := addCreditTransaction(tx, creditTransaction{
+		OrgID:       orgID,
+		Currency:    currency.String,
+		Type:        "adjustment",
+		AmountCents: amountCents,
+		Description: description,
+		CreatedBy:   actor.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ledger.Post(tx, ledger.Entry{
+		OrgID:         orgID,
+		Currency:      t.Currency,
+		Type:          "credit.adjusted",
+		ReferenceType: "credit_balance_transaction",
+		ReferenceID:   t.ID,
+		Description:   t.Description,
+		Lines:         adjustmentLines(amountCents),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "credit_balance.adjusted",
+		TargetType: "organization",
+		TargetID:   orgID,
+		Before:     map[string]interface{}{"balance_cents": t.BalanceCents - t.AmountCents},
+		After:      map[string]interface{}{"balance_cents": t.BalanceCents},
+		Metadata:   map[string]interface{}{"currency": t.Currency, "description": description},
+	})
+	return t, nil
+}
+
+// ListCreditTransactions returns the balance history, newest first
+func (s *BillingService) ListCreditTransactions(orgID string, page, pageSize int) ([]CreditTransaction, int, error) {
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM credit_balance_transactions WHERE org_id = $1
+	`, orgID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+creditTransactionColumns+`
+		FROM credit_balance_transactions
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	transactions := []CreditTransaction{}
+	for rows.Next() {
+		t, err := scanCreditTransaction(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		transactions = append(transactions, *t)
+	}
+
+	return transactions, total, rows.Err()
+}
+
+// insertCreditNote stores the note and takes it off the invoice. The caller
+// must hold the invoice's row lock.
+func insertCreditNote(tx *sql.Tx, note *CreditNote, createdBy string) error {
+	err := tx.QueryRow(`
+		INSERT INTO credit_notes
+			(invoice_id, org_id, currency, amount_cents, tax_cents, invoice_cents, balance_cents, refund_cents,
+			 reason, memo, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, '')::uuid)
+		RETURNING id, created_by, created_at
+	`, note.InvoiceID, note.OrgID, note.Currency, note.AmountCents, note.TaxCents, note.InvoiceCents,
+		note.BalanceCents, note.RefundCents, note.Reason, note.Memo, createdBy).Scan(&note.ID, &note.CreatedBy, &note.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE invoices SET
+			credited_cents = credited_cents + $2,
+			amount_due_cents = amount_due_cents - $3,
+			refunded_cents = refunded_cents + $4,
+			status = CASE WHEN status = 'unpaid' AND amount_due_cents = $3 THEN 'void' ELSE status END
+		WHERE id = $1
+	`, note.InvoiceID, note.AmountCents, note.InvoiceCents, note.RefundCents)
+	if err != nil {
+		return err
+	}
+
+	deferred, err := releaseDeferredRevenue(tx, note.InvoiceID, note.AmountCents-note.TaxCents)
+	if err != nil {
+		return err
+	}
+
+	return ledger.Post(tx, ledger.Entry{
+		OrgID:         note.OrgID,
+		Currency:      note.Currency,
+		Type:          "credit_note.issued",
+		ReferenceType: "credit_note",
+		ReferenceID:   note.ID,
+		Description:   fmt.Sprintf("Credit note for invoice %s", note.InvoiceID),
+		Lines:         creditNoteLines(*note, deferred),
+	})
+}
+
+// lockCreditBalance locks the organization so that concurrent invoices and
+// credit notes see each other's balance changes, then returns the balance
+func lockCreditBalance(tx *sql.Tx, orgID, currency string) (int, error) {
+	if _, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return 0, err
+	}
+	return creditBalance(tx, orgID, currency)
+}
+
+func creditBalance(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, orgID, currency string) (int, error) {
+	var balance int
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(amount_cents), 0)
+		FROM credit_balance_transactions
+		WHERE org_id = $1 AND currency = $2
+	`, orgID, currency).Scan(&balance)
+	return balance, err
+}
+
+// addCreditTransaction records a balance change. The caller must hold the
+// organization's row lock.
+func addCreditTransaction(tx *sql.Tx, t creditTransaction) (*CreditTransaction, error) {
+	balance, err := creditBalance(tx, t.OrgID, t.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if balance+t.AmountCents < 0 {
+		return nil, ErrInsufficientCredit
+	}
+
+	if t.Description == "" {
+		t.Description = defaultCreditDescription(t)
+	}
+
+	return scanCreditTransaction(tx.QueryRow(`
+		INSERT INTO credit_balance_transactions
+			(org_id, currency, type, amount_cents, balance_cents, description, invoice_id, credit_note_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid, NULLIF($8, '')::uuid, NULLIF($9, '')::uuid)
+		RETURNING `+creditTransactionColumns,
+		t.OrgID, t.Currency, t.Type, t.AmountCents, balance+t.AmountCents, t.Description,
+		t.InvoiceID, t.CreditNoteID, t.CreatedBy))
+}
+
+func defaultCreditDescription(t creditTransaction) string {
+	switch t.Type {
+	case "credit_note":
+		return fmt.Sprintf("Credit note for invoice %s", t.InvoiceID)
+	case "applied_to_invoice":
+		return fmt.Sprintf("Applied to invoice %s", t.InvoiceID)
+	}
+	return ""
+}
+
+const creditTransactionColumns = `id, type, currency, amount_cents, balance_cents, description,
+	invoice_id, credit_note_id, created_by, created_at`
+
+func scanCreditTransaction(row interface{ Scan(...interface{}) error }) (*CreditTransaction, error) {
+	var t CreditTransaction
+	err := row.Scan(&t.ID, &t.Type, &t.Currency, &t.AmountCents, &t.BalanceCents, &t.Description,
+		&t.InvoiceID, &t.CreditNoteID, &t.CreatedBy, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// creditToApply is how much of the balance goes towards an invoice total
+func creditToApply(balance, total int) int {
+	if balance <= 0 || total <= 0 {
+		return 0
+	}
+	if balance < total {
+		return balance
+	}
+	return total
+}
+
+// splitCredit divides a credit between the amount still due on the invoice
+// and the credit balance
+func splitCredit(amount, due int) (invoice, balance int) {
+	if due > amount {
+		due = amount
+	}
+	if due < 0 {
+		due = 0
+	}
+	return due, amount - due
+}
+
+// proportionalTax is the share of the invoice's tax in a credit of amount,
+// rounded half up
+func proportionalTax(amount, total, taxCents int) int {
+	if total <= 0 {
+		return 0
+	}
+	return (2*amount*taxCents + total) / (2 * total)
+}
+
+func validCreditReason(reason string) bool {
+	for _, r := range CreditNoteReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreditToApply(t *testing.T) {
+	assert.Equal(t, 0, creditToApply(0, 4999))
+	assert.Equal(t, 1000, creditToApply(1000, 4999))
+	assert.Equal(t, 4999, creditToApply(6000, 4999))
+	assert.Equal(t, 0, creditToApply(6000, 0), "nothing to pay")
+}
+
+func TestSplitCredit(t *testing.T) {
+	tests := []struct {
+		name                 string
+		amount, due          int
+		wantInvoice, wantBal int
+	}{
+		{"unpaid, partial credit", 1000, 5949, 1000, 0},
+		{"unpaid, full credit", 5949, 5949, 5949, 0},
+		{"paid", 1000, 0, 0, 1000},
+		{"partly covered by credit balance", 5949, 949, 949, 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice, balance := splitCredit(tt.amount, tt.due)
+			assert.Equal(t, tt.wantInvoice, invoice)
+			assert.Equal(t, tt.wantBal, balance)
+		})
+	}
+}
+
+func TestProportionalTax(t *testing.T) {
+	// 5949 total with 950 tax: a full credit reverses all of the tax
+	assert.Equal(t, 950, proportionalTax(5949, 5949, 950))
+	assert.Equal(t, 475, proportionalTax(2975, 5949, 950))
+	assert.Equal(t, 0, proportionalTax(1000, 1000, 0))
+	assert.Equal(t, 0, proportionalTax(0, 0, 0))
+}
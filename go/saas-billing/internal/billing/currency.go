@@ -0,0 +1,121 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/money"
+)
+
+// DefaultCurrency is used for an organization's first subscription when it
+// doesn't ask for a currency
+const DefaultCurrency = "USD"
+
+var (
+	ErrPlanNotFound      = errors.New("plan not found")
+	ErrPriceNotAvailable = errors.New("plan is not available in this currency")
+	ErrCurrencyLocked    = errors.New("organization is already billed in another currency")
+	ErrInvalidPlanPrice  = errors.New("plan price can't be negative")
+)
+
+// SetPlanPrice adds or replaces the plan's price in one currency. Invoices
+// already issued keep the price they were created with.
+func (s *BillingService) SetPlanPrice(planID string, price money.Money) (*money.Money, error) {
+	saved, err := setPlanPrice(s.db, planID, price)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func setPlanPrice(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, planID string, price money.Money) (money.Money, error) {
+	currency, err := money.NormalizeCurrency(price.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if price.Amount < 0 {
+		return money.Money{}, ErrInvalidPlanPrice
+	}
+
+	saved := money.Money{Currency: currency}
+	err = q.QueryRow(`
+		INSERT INTO plan_prices (plan_id, currency, amount)
+		SELECT id, $2, $3 FROM plans WHERE id::text = $1
+		ON CONFLICT (plan_id, currency) DO UPDATE SET
+			amount = EXCLUDED.amount,
+			updated_at = NOW()
+		RETURNING amount
+	`, planID, currency, price.Amount).Scan(&saved.Amount)
+	if err == sql.ErrNoRows {
+		return money.Money{}, ErrPlanNotFound
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return saved, nil
+}
+
+// loadPlanPrices fills in the price points of the plans
+func (s *BillingService) loadPlanPrices(plans []Plan) error {
+	if len(plans) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(plans))
+	index := map[string]int{}
+	for i := range plans {
+		ids[i] = plans[i].ID
+		index[plans[i].ID] = i
+		plans[i].Prices = []money.Money{}
+	}
+
+	rows, err := s.db.Query(`
+		SELECT plan_id, currency, amount
+		FROM plan_prices
+		WHERE plan_id = ANY($1::uuid[])
+		ORDER BY currency
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var planID string
+		var price money.Money
+		if err := rows.Scan(&planID, &price.Currency, &price.Amount); err != nil {
+			return err
+		}
+		plan := &plans[index[planID]]
+		plan.Prices = append(plan.Prices, price)
+	}
+
+	return rows.Err()
+}
+
+// subscriptionCurrency picks the currency for a new subscription given the
+// organization's locked currency, if any, and the one requested, if any
+func subscriptionCurrency(locked, requested string) (string, error) {
+	if requested != "" {
+		code, err := money.NormalizeCurrency(requested)
+		if err != nil {
+			return "", err
+		}
+		requested = code
+	}
+
+	switch {
+	case locked != "" && requested != "" && requested != locked:
+		return "", ErrCurrencyLocked
+	case locked != "":
+		return locked, nil
+	case requested != "":
+		return requested, nil
+	default:
+		return DefaultCurrency, nil
+	}
+}
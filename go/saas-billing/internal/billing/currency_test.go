@@ -0,0 +1,33 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/linkmeAman/saas-billing/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionCurrency(t *testing.T) {
+	tests := []struct {
+		name      string
+		locked    string
+		requested string
+		want      string
+		err       error
+	}{
+		{"first subscription, default", "", "", DefaultCurrency, nil},
+		{"first subscription, requested", "", "eur", "EUR", nil},
+		{"locked, nothing requested", "JPY", "", "JPY", nil},
+		{"locked, same requested", "JPY", "jpy", "JPY", nil},
+		{"locked, other requested", "JPY", "USD", "", ErrCurrencyLocked},
+		{"unsupported", "", "ABC", "", money.ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := subscriptionCurrency(tt.locked, tt.requested)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
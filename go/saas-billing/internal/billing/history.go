@@ -0,0 +1,104 @@
+package billing
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SubscriptionVersion is a subscription as it was from EffectiveFrom until
+// EffectiveTo, or until now if EffectiveTo is nil. Change says what made
+// it differ from the previous version: created, plan_changed,
+// quantity_changed, coupon_changed, renewed, paused, resumed, canceled,
+// status_changed or updated. PriceCents is the plan's price in Currency at the time, if it
+// had one, for a single seat and before PercentOff.
+type SubscriptionVersion struct {
+	ID                 string     `json:"id"`
+	SubscriptionID     string     `json:"subscription_id"`
+	Version            int        `json:"version"`
+	Change             string     `json:"change"`
+	PlanID             string     `json:"plan_id"`
+	PlanName           string     `json:"plan_name"`
+	Status             string     `json:"status"`
+	Currency           *string    `json:"currency,omitempty"`
+	PriceCents         *int       `json:"price_cents,omitempty"`
+	Interval           *string    `json:"interval,omitempty"`
+	Quantity           int        `json:"quantity"`
+	CouponCode         *string    `json:"coupon_code,omitempty"`
+	PercentOff         *int       `json:"percent_off,omitempty"`
+	CurrentPeriodStart time.Time  `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time  `json:"current_period_end"`
+	CanceledAt         *time.Time `json:"canceled_at,omitempty"`
+	EffectiveFrom      time.Time  `json:"effective_from"`
+	EffectiveTo        *time.Time `json:"effective_to,omitempty"`
+}
+
+const subscriptionVersionColumns = `v.id, v.subscription_id, v.version, v.change, v.plan_id, COALESCE(p.name, ''), v.status,
+	v.currency, v.price_cents, v.interval, v.quantity, v.coupon_code, v.percent_off, v.current_period_start, v.current_period_end, v.canceled_at,
+	v.effective_from, v.effective_to`
+
+// ListSubscriptionHistory returns every version of the organization's
+// subscriptions, newest first, and the total count
+func (s *BillingService) ListSubscriptionHistory(orgID string, page, pageSize int) ([]SubscriptionVersion, int, error) {
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM subscription_versions WHERE org_id = $1
+	`, orgID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+subscriptionVersionColumns+`
+		FROM subscription_versions v
+		LEFT JOIN plans p ON p.id = v.plan_id
+		WHERE v.org_id = $1
+		ORDER BY v.effective_from DESC, v.version DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	versions := []SubscriptionVersion{}
+	for rows.Next() {
+		v, err := scanSubscriptionVersion(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, total, rows.Err()
+}
+
+// SubscriptionAsOf returns the organization's subscription as it was at t:
+// the version of its latest subscription started by then. Canceled
+// subscriptions are returned too, so callers can tell "had canceled" from
+// "never subscribed". It returns nil if the organization had no
+// subscription yet.
+func (s *BillingService) SubscriptionAsOf(orgID string, t time.Time) (*SubscriptionVersion, error) {
+	v, err := scanSubscriptionVersion(s.db.QueryRow(`
+		SELECT `+subscriptionVersionColumns+`
+		FROM subscription_versions v
+		JOIN subscriptions s ON s.id = v.subscription_id
+		LEFT JOIN plans p ON p.id = v.plan_id
+		WHERE v.org_id = $1 AND v.effective_from <= $2 AND (v.effective_to IS NULL OR v.effective_to > $2)
+		ORDER BY s.created_at DESC
+		LIMIT 1
+	`, orgID, t))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+func scanSubscriptionVersion(row interface{ Scan(...interface{}) error }) (*SubscriptionVersion, error) {
+	var v SubscriptionVersion
+	err := row.Scan(&v.ID, &v.SubscriptionID, &v.Version, &v.Change, &v.PlanID, &v.PlanName, &v.Status,
+		&v.Currency, &v.PriceCents, &v.Interval, &v.Quantity, &v.CouponCode, &v.PercentOff, &v.CurrentPeriodStart, &v.CurrentPeriodEnd, &v.CanceledAt,
+		&v.EffectiveFrom, &v.EffectiveTo)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
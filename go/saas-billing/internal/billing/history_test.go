@@ -0,0 +1,71 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func versionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "subscription_id", "version", "change", "plan_id", "plan_name", "status",
+		"currency", "price_cents", "interval", "quantity", "coupon_code", "percent_off", "current_period_start",
+		"current_period_end", "canceled_at", "effective_from", "effective_to"})
+}
+
+func TestListSubscriptionHistory(t *testing.T) {
+	s, mock := newBillingMock(t)
+	created := date(2025, 1, 1)
+	replaced := date(2025, 1, 15)
+
+	// The replaced subscription's last version is its cancellation, and the
+	// version before it ends when it was canceled
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM subscription_versions`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`FROM subscription_versions v\s+LEFT JOIN plans p`).WithArgs("org-1", 2, 0).
+		WillReturnRows(versionRows().
+			AddRow("v-3", "sub-2", 1, "created", "plan-2", "Pro", "active", "USD", 5000, "month", 1, nil, nil,
+				replaced, replaced.AddDate(0, 1, 0), nil, replaced, nil).
+			AddRow("v-2", "sub-1", 2, "canceled", "plan-1", "Basic", "canceled", "USD", 1000, "month", 1, nil, nil,
+				created, created.AddDate(0, 1, 0), replaced, replaced, nil))
+
+	versions, total, err := s.ListSubscriptionHistory("org-1", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "created", versions[0].Change)
+	assert.Nil(t, versions[0].EffectiveTo)
+	assert.Equal(t, "canceled", versions[1].Change)
+	assert.Equal(t, replaced, *versions[1].CanceledAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSubscriptionAsOf(t *testing.T) {
+	s, mock := newBillingMock(t)
+	start := date(2025, 1, 1)
+	at := date(2025, 2, 10)
+	renewed := date(2025, 2, 1)
+
+	mock.ExpectQuery(`WHERE v.org_id = \$1 AND v.effective_from <= \$2 AND \(v.effective_to IS NULL OR v.effective_to > \$2\)`).
+		WithArgs("org-1", at).
+		WillReturnRows(versionRows().
+			AddRow("v-2", "sub-1", 2, "renewed", "plan-1", "Basic", "active", "USD", 1000, "month", 2, "LAUNCH", 10,
+				renewed, renewed.AddDate(0, 1, 0), nil, renewed, nil))
+	v, err := s.SubscriptionAsOf("org-1", at)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, 2, v.Version)
+	assert.Equal(t, 2, v.Quantity)
+	assert.Equal(t, 10, *v.PercentOff)
+
+	// Before the first subscription there's nothing to return
+	mock.ExpectQuery(`FROM subscription_versions v`).WithArgs("org-1", start.Add(-time.Hour)).
+		WillReturnRows(versionRows())
+	v, err = s.SubscriptionAsOf("org-1", start.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,229 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+	"github.com/linkmeAman/saas-billing/internal/tax"
+)
+
+// InvoiceLine is one charge on an invoice. AmountCents excludes tax. Lines
+// with a service period are recognized as revenue over it.
+type InvoiceLine struct {
+	ID                 string     `json:"id"`
+	Description        string     `json:"description"`
+	Currency           string     `json:"currency"`
+	AmountCents        int        `json:"amount_cents"`
+	TaxCents           int        `json:"tax_cents"`
+	ServicePeriodStart *time.Time `json:"service_period_start,omitempty"`
+	ServicePeriodEnd   *time.Time `json:"service_period_end,omitempty"`
+}
+
+// InvoiceTaxLine is the tax charged on an invoice at one rate
+type InvoiceTaxLine struct {
+	tax.TaxLine
+	Currency string `json:"currency"`
+}
+
+// createInvoice taxes the lines for the organization's billing profile and
+// stores the invoice with its lines and tax lines. Line amounts are in minor
+// units of currency. Revenue for the lines is deferred over period, if set.
+// It returns the invoice ID.
+func (s *BillingService) createInvoice(tx *sql.Tx, orgID, subscriptionID, currency string, lines []tax.LineInput, period servicePeriod, dueDate time.Time) (string, error) {
+	return s.writeInvoice(tx, orgID, subscriptionID, currency, lines, period, dueDate, false)
+}
+
+// createVoidInvoice stores the invoice createInvoice would, marked void: it
+// shows what would have been charged, but nothing is due, booked or taken
+// from the credit balance
+func (s *BillingService) createVoidInvoice(tx *sql.Tx, orgID, subscriptionID, currency string, lines []tax.LineInput, period servicePeriod, dueDate time.Time) (string, error) {
+	return s.writeInvoice(tx, orgID, subscriptionID, currency, lines, period, dueDate, true)
+}
+
+func (s *BillingService) writeInvoice(tx *sql.Tx, orgID, subscriptionID, currency string, lines []tax.LineInput, period servicePeriod, dueDate time.Time, void bool) (string, error) {
+	customer, err := taxCustomer(tx, orgID)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.tax.Calculate(context.Background(), tax.Request{Customer: customer, Lines: lines})
+	if err != nil {
+		return "", err
+	}
+
+	// Use up the customer's credit balance before asking them to pay
+	balance, err := lockCreditBalance(tx, orgID, currency)
+	if err != nil {
+		return "", err
+	}
+	applied := creditToApply(balance, result.TotalCents)
+	status := "unpaid"
+	var paidAt *time.Time
+	if applied > 0 && applied == result.TotalCents {
+		now := time.Now()
+		status, paidAt = "paid", &now
+	}
+	due := result.TotalCents - applied
+	if void {
+		applied, due, status, paidAt = 0, 0, "void", nil
+	}
+
+	var invoiceID string
+	err = tx.QueryRow(`
+		INSERT INTO invoices
+			(subscription_id, currency, subtotal_cents, tax_cents, amount_cents, reverse_charge, customer_tax_id,
+			 credit_applied_cents, amount_due_cents, status, due_date, paid_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $12)
+		RETURNING id
+	`, subscriptionID, currency, result.SubtotalCents, result.TaxCents, result.TotalCents,
+		result.ReverseCharge, customer.TaxID, applied, due, status, dueDate, paidAt).Scan(&invoiceID)
+	if err != nil {
+		return "", err
+	}
+
+	start, end, deferred := period.days()
+	var periodStart, periodEnd interface{}
+	if deferred {
+		periodStart, periodEnd = dateParam(start), dateParam(end)
+	}
+
+	deferredCents := 0
+	for _, line := range result.Lines {
+		var lineID string
+		err = tx.QueryRow(`
+			INSERT INTO invoice_lines
+				(invoice_id, description, currency, amount_cents, tax_cents, service_period_start, service_period_end)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, invoiceID, line.Description, currency, line.AmountCents, line.TaxCents, periodStart, periodEnd).Scan(&lineID)
+		if err != nil {
+			return "", err
+		}
+
+		if deferred && line.AmountCents > 0 && !void {
+			err = createRevenueSchedule(tx, orgID, invoiceID, lineID, currency, line.AmountCents, start, end)
+			if err != nil {
+				return "", err
+			}
+			deferredCents += line.AmountCents
+		}
+	}
+
+	if !void {
+		err = ledger.Post(tx, ledger.Entry{
+			OrgID:         orgID,
+			Currency:      currency,
+			Type:          "invoice.finalized",
+			ReferenceType: "invoice",
+			ReferenceID:   invoiceID,
+			Lines:         invoiceLines(result.SubtotalCents, deferredCents, result.TaxCents),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if applied > 0 {
+		err = ledger.Post(tx, ledger.Entry{
+			OrgID:         orgID,
+			Currency:      currency,
+			Type:          "credit.applied",
+			ReferenceType: "invoice",
+			ReferenceID:   invoiceID,
+			Lines:         creditAppliedLines(applied),
+		})
+		if err != nil {
+			return "", err
+		}
+
+		_, err = addCreditTransaction(tx, creditTransaction{
+			OrgID:       orgID,
+			Currency:    currency,
+			Type:        "applied_to_invoice",
+			AmountCents: -applied,
+			InvoiceID:   invoiceID,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, t := range result.TaxLines {
+		_, err = tx.Exec(`
+			INSERT INTO invoice_tax_lines (invoice_id, name, jurisdiction, rate_bps, taxable_cents, amount_cents, currency)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, invoiceID, t.Name, t.Jurisdiction, t.RateBps, t.TaxableCents, t.AmountCents, currency)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return invoiceID, nil
+}
+
+// loadInvoiceDetails fills in the lines and tax lines of the invoices
+func (s *BillingService) loadInvoiceDetails(invoices []Invoice) error {
+	if len(invoices) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(invoices))
+	index := map[string]int{}
+	for i := range invoices {
+		ids[i] = invoices[i].ID
+		index[invoices[i].ID] = i
+		invoices[i].Lines = []InvoiceLine{}
+		invoices[i].TaxLines = []InvoiceTaxLine{}
+	}
+
+	rows, err := s.db.Query(`
+		SELECT invoice_id, id, description, currency, amount_cents, tax_cents, service_period_start, service_period_end
+		FROM invoice_lines
+		WHERE invoice_id = ANY($1::uuid[])
+		ORDER BY created_at, id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var invoiceID string
+		var line InvoiceLine
+		if err := rows.Scan(&invoiceID, &line.ID, &line.Description, &line.Currency, &line.AmountCents, &line.TaxCents,
+			&line.ServicePeriodStart, &line.ServicePeriodEnd); err != nil {
+			rows.Close()
+			return err
+		}
+		inv := &invoices[index[invoiceID]]
+		inv.Lines = append(inv.Lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT invoice_id, name, jurisdiction, rate_bps, taxable_cents, amount_cents, currency
+		FROM invoice_tax_lines
+		WHERE invoice_id = ANY($1::uuid[])
+		ORDER BY jurisdiction, name
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var invoiceID string
+		var t InvoiceTaxLine
+		if err := rows.Scan(&invoiceID, &t.Name, &t.Jurisdiction, &t.RateBps, &t.TaxableCents, &t.AmountCents, &t.Currency); err != nil {
+			return err
+		}
+		inv := &invoices[index[invoiceID]]
+		inv.TaxLines = append(inv.TaxLines, t)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,307 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/tax"
+)
+
+var (
+	ErrSubscriptionPaused    = errors.New("subscription is already paused")
+	ErrSubscriptionNotPaused = errors.New("subscription is not paused")
+	ErrInvalidPauseBehavior  = errors.New("pause behavior must be none or void")
+	ErrInvalidResumeDate     = errors.New("resume date must be in the future")
+	ErrScheduleActive        = errors.New("cancel the subscription's schedule before pausing it")
+)
+
+// PauseBehaviors are what happens to invoices while a subscription is
+// paused: none are issued, or they're issued void so the customer still
+// sees each period
+var PauseBehaviors = []string{"none", "void"}
+
+// Entitlements is what an organization's subscription gives it now. While
+// the subscription is paused they're the plan's paused ones. A nil MaxSeats
+// is unlimited.
+type Entitlements struct {
+	SubscriptionID string   `json:"subscription_id"`
+	PlanID         string   `json:"plan_id"`
+	Status         string   `json:"status"`
+	MaxSeats       *int     `json:"max_seats"`
+	Features       []string `json:"features"`
+}
+
+// PlanEntitlements is what a plan gives while active and while paused. A nil
+// PausedMaxSeats keeps the plan's seat limit while paused.
+type PlanEntitlements struct {
+	Features       []string `json:"features"`
+	PausedFeatures []string `json:"paused_features"`
+	PausedMaxSeats *int     `json:"paused_max_seats" binding:"omitempty,min=0"`
+}
+
+// SetPlanEntitlements replaces the plan's features and what a paused
+// subscription to it keeps
+func (s *BillingService) SetPlanEntitlements(planID string, e PlanEntitlements) (*PlanEntitlements, error) {
+	if e.Features == nil {
+		e.Features = []string{}
+	}
+	if e.PausedFeatures == nil {
+		e.PausedFeatures = []string{}
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE plans SET features = $2, paused_features = $3, paused_max_seats = $4
+		WHERE id::text = $1
+	`, planID, pq.Array(e.Features), pq.Array(e.PausedFeatures), e.PausedMaxSeats)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrPlanNotFound
+	}
+	return &e, nil
+}
+
+// GetEntitlements returns what the organization's subscription gives it
+func (s *BillingService) GetEntitlements(orgID string) (*Entitlements, error) {
+	var e Entitlements
+	var maxSeats, pausedMaxSeats sql.NullInt64
+	var features, pausedFeatures []string
+	err := s.db.QueryRow(`
+		SELECT s.id, s.plan_id, s.status, p.max_seats, p.features, p.paused_max_seats, p.paused_features
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.org_id = $1 AND s.status IN ('active', 'paused')
+	`, orgID).Scan(&e.SubscriptionID, &e.PlanID, &e.Status, &maxSeats, pq.Array(&features),
+		&pausedMaxSeats, pq.Array(&pausedFeatures))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoActiveSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.Features = features
+	if e.Status == "paused" {
+		e.Features = pausedFeatures
+		if pausedMaxSeats.Valid {
+			maxSeats = pausedMaxSeats
+		}
+	}
+	if e.Features == nil {
+		e.Features = []string{}
+	}
+	if maxSeats.Valid {
+		seats := int(maxSeats.Int64)
+		e.MaxSeats = &seats
+	}
+	return &e, nil
+}
+
+// PauseSubscription pauses the organization's subscription now. The unused
+// part of the current period is credited. With behavior none no invoices
+// are issued until it's resumed; with void each period is still invoiced,
+// marked void. A resumeAt resumes it then.
+func (s *BillingService) PauseSubscription(orgID, behavior string, resumeAt *time.Time, actor audit.Actor) (*Subscription, error) {
+	if behavior == "" {
+		behavior = "none"
+	}
+	if !validPauseBehavior(behavior) {
+		return nil, ErrInvalidPauseBehavior
+	}
+	now := time.Now()
+	if resumeAt != nil && !resumeAt.After(now) {
+		return nil, ErrInvalidResumeDate
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization first, as the renewal worker does
+	if _, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return nil, err
+	}
+
+	sub, periodStart, err := lockCurrentSubscription(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status == "paused" {
+		return nil, ErrSubscriptionPaused
+	}
+
+	schedule, err := activeSchedule(tx, sub.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	if schedule != nil {
+		return nil, ErrScheduleActive
+	}
+
+	if err := creditUnusedPeriod(tx, orgID, sub.ID, periodStart, sub.CurrentPeriodEnd, now); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE subscriptions SET status = 'paused', paused_at = $2, resume_at = $3, pause_behavior = $4
+		WHERE id = $1
+	`, sub.ID, now, resumeAt, behavior)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sub.Status, sub.PausedAt, sub.ResumeAt, sub.PauseBehavior = "paused", &now, resumeAt, &behavior
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "subscription.paused",
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		Before:     map[string]interface{}{"status": "active"},
+		After:      map[string]interface{}{"status": "paused", "resume_at": resumeAt, "pause_behavior": behavior},
+	})
+	return sub, nil
+}
+
+// ResumeSubscription resumes the organization's paused subscription now.
+// A new billing period starts and is invoiced right away.
+func (s *BillingService) ResumeSubscription(orgID string, actor audit.Actor) (*Subscription, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var currency string
+	err = tx.QueryRow(`
+		SELECT billing_currency FROM organizations WHERE id = $1 FOR UPDATE
+	`, orgID).Scan(&currency)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _, err := lockCurrentSubscription(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status != "paused" {
+		return nil, ErrSubscriptionNotPaused
+	}
+
+	now := time.Now()
+	periodEnd, err := s.resume(tx, sub, currency, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "subscription.resumed",
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		Before:     map[string]interface{}{"status": "paused", "paused_at": sub.PausedAt},
+		After:      map[string]interface{}{"status": "active", "current_period_end": periodEnd},
+	})
+
+	sub.Status, sub.CurrentPeriodEnd = "active", periodEnd
+	sub.PausedAt, sub.ResumeAt, sub.PauseBehavior = nil, nil, nil
+	return sub, nil
+}
+
+// resume reactivates a paused subscription with a new period starting at,
+// so it's billed from the day it resumed rather than its old cycle
+func (s *BillingService) resume(tx *sql.Tx, sub *Subscription, currency string, at, now time.Time) (time.Time, error) {
+	_, err := tx.Exec(`
+		UPDATE subscriptions SET paused_at = NULL, resume_at = NULL, pause_behavior = NULL WHERE id = $1
+	`, sub.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.startPeriod(tx, sub.OrgID, sub.ID, currency, sub.PlanID, sub.Quantity, sub.CouponCode, at, now)
+}
+
+// voidPeriod moves a paused subscription on to its next period, issuing
+// the period's invoice void. Like startPeriod it skips periods that ended
+// by now.
+func (s *BillingService) voidPeriod(tx *sql.Tx, sub *Subscription, currency string, start, now time.Time) (time.Time, error) {
+	charge, err := chargeFor(tx, sub.PlanID, currency, sub.Quantity, sub.CouponCode)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	start = catchUpStart(start, charge.Interval, now)
+	end := nextPeriodEnd(start, charge.Interval)
+	_, err = tx.Exec(`
+		UPDATE subscriptions SET current_period_start = $2, current_period_end = $3 WHERE id = $1
+	`, sub.ID, start, end)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = s.createVoidInvoice(tx, sub.OrgID, sub.ID, currency, []tax.LineInput{charge.Line},
+		servicePeriod{Start: start, End: end}, start)
+	return end, err
+}
+
+// pausedStep picks what's due by now on a paused subscription: resuming it,
+// or issuing a void invoice for the period that started. Resuming wins a
+// tie, since it starts a period of its own.
+func pausedStep(periodEnd time.Time, resumeAt *time.Time, behavior string, now time.Time) (renewalStep, bool) {
+	resumeDue := resumeAt != nil && !resumeAt.After(now)
+	voidDue := behavior == "void" && !periodEnd.After(now)
+	switch {
+	case resumeDue && (!voidDue || !resumeAt.After(periodEnd)):
+		return renewalStep{Kind: "resume", At: *resumeAt}, true
+	case voidDue:
+		return renewalStep{Kind: "void", At: periodEnd}, true
+	}
+	return renewalStep{}, false
+}
+
+// lockCurrentSubscription locks the organization's active or paused
+// subscription and returns it with the start of its period
+func lockCurrentSubscription(tx *sql.Tx, orgID string) (*Subscription, time.Time, error) {
+	var sub Subscription
+	var periodStart time.Time
+	err := tx.QueryRow(`
+		SELECT id, org_id, plan_id, quantity, coupon_code, status, current_period_start, current_period_end,
+			paused_at, resume_at, pause_behavior, created_at
+		FROM subscriptions
+		WHERE org_id = $1 AND status IN ('active', 'paused')
+		FOR UPDATE
+	`, orgID).Scan(&sub.ID, &sub.OrgID, &sub.PlanID, &sub.Quantity, &sub.CouponCode, &sub.Status, &periodStart,
+		&sub.CurrentPeriodEnd, &sub.PausedAt, &sub.ResumeAt, &sub.PauseBehavior, &sub.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, ErrNoActiveSubscription
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &sub, periodStart, nil
+}
+
+func validPauseBehavior(behavior string) bool {
+	for _, b := range PauseBehaviors {
+		if b == behavior {
+			return true
+		}
+	}
+	return false
+}
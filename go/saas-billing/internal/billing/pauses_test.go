@@ -0,0 +1,48 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPausedStep(t *testing.T) {
+	periodEnd := date(2025, 3, 1)
+	resumeAt := date(2025, 5, 10)
+
+	t.Run("nothing due", func(t *testing.T) {
+		_, ok := pausedStep(periodEnd, &resumeAt, "void", date(2025, 2, 20))
+		assert.False(t, ok)
+	})
+
+	t.Run("no invoices without void", func(t *testing.T) {
+		_, ok := pausedStep(periodEnd, nil, "none", date(2025, 4, 1))
+		assert.False(t, ok)
+	})
+
+	t.Run("void invoice for the period", func(t *testing.T) {
+		step, ok := pausedStep(periodEnd, &resumeAt, "void", date(2025, 3, 2))
+		assert.True(t, ok)
+		assert.Equal(t, "void", step.Kind)
+		assert.Equal(t, periodEnd, step.At)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		step, ok := pausedStep(periodEnd, &resumeAt, "none", date(2025, 5, 10))
+		assert.True(t, ok)
+		assert.Equal(t, "resume", step.Kind)
+		assert.Equal(t, resumeAt, step.At)
+	})
+
+	t.Run("void periods before the resume date come first", func(t *testing.T) {
+		step, ok := pausedStep(periodEnd, &resumeAt, "void", date(2025, 6, 1))
+		assert.True(t, ok)
+		assert.Equal(t, "void", step.Kind)
+	})
+
+	t.Run("resume wins a tie", func(t *testing.T) {
+		step, ok := pausedStep(periodEnd, &periodEnd, "void", date(2025, 3, 2))
+		assert.True(t, ok)
+		assert.Equal(t, "resume", step.Kind)
+	})
+}
@@ -0,0 +1,72 @@
+package billing
+
+import (
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+)
+
+// The ledger lines for each billing event. Every set balances.
+
+// invoiceLines books a finalized invoice: the customer owes the total, made
+// up of revenue and the tax collected on the authorities' behalf. The part
+// of the subtotal paying for service not yet delivered is deferred.
+func invoiceLines(subtotalCents, deferredCents, taxCents int) []ledger.Line {
+	return []ledger.Line{
+		ledger.Debit(ledger.AccountsReceivable, subtotalCents+taxCents),
+		ledger.Credit(ledger.Revenue, subtotalCents-deferredCents),
+		ledger.Credit(ledger.DeferredRevenue, deferredCents),
+		ledger.Credit(ledger.TaxPayable, taxCents),
+	}
+}
+
+// recognitionLines moves revenue earned over time out of deferred revenue
+func recognitionLines(amountCents int) []ledger.Line {
+	return []ledger.Line{
+		ledger.Debit(ledger.DeferredRevenue, amountCents),
+		ledger.Credit(ledger.Revenue, amountCents),
+	}
+}
+
+// creditAppliedLines settles part of an invoice from the credit balance
+func creditAppliedLines(appliedCents int) []ledger.Line {
+	return []ledger.Line{
+		ledger.Debit(ledger.CustomerCredit, appliedCents),
+		ledger.Credit(ledger.AccountsReceivable, appliedCents),
+	}
+}
+
+// paymentLines books money received for an invoice
+func paymentLines(amountCents int) []ledger.Line {
+	return []ledger.Line{
+		ledger.Debit(ledger.Cash, amountCents),
+		ledger.Credit(ledger.AccountsReceivable, amountCents),
+	}
+}
+
+// creditNoteLines reverses the revenue and tax of the credited amount, and
+// takes it off what's owed, adds it to the credit balance or pays it back.
+// deferredCents of the revenue hadn't been recognized yet.
+func creditNoteLines(note CreditNote, deferredCents int) []ledger.Line {
+	return []ledger.Line{
+		ledger.Debit(ledger.Revenue, note.AmountCents-note.TaxCents-deferredCents),
+		ledger.Debit(ledger.DeferredRevenue, deferredCents),
+		ledger.Debit(ledger.TaxPayable, note.TaxCents),
+		ledger.Credit(ledger.AccountsReceivable, note.InvoiceCents),
+		ledger.Credit(ledger.CustomerCredit, note.BalanceCents),
+		ledger.Credit(ledger.Cash, note.RefundCents),
+	}
+}
+
+// adjustmentLines books credit granted as a reduction of revenue, or taken
+// back when negative
+func adjustmentLines(amountCents int) []ledger.Line {
+	if amountCents < 0 {
+		return []ledger.Line{
+			ledger.Debit(ledger.CustomerCredit, -amountCents),
+			ledger.Credit(ledger.Revenue, -amountCents),
+		}
+	}
+	return []ledger.Line{
+		ledger.Debit(ledger.Revenue, amountCents),
+		ledger.Credit(ledger.CustomerCredit, amountCents),
+	}
+}
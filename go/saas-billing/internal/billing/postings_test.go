@@ -0,0 +1,35 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostingsBalance(t *testing.T) {
+	sets := map[string][]ledger.Line{
+		"invoice":           invoiceLines(10000, 0, 1900),
+		"deferred invoice":  invoiceLines(10000, 8000, 1900),
+		"recognition":       recognitionLines(300),
+		"credit applied":    creditAppliedLines(2500),
+		"payment":           paymentLines(9400),
+		"credit note":       creditNoteLines(CreditNote{AmountCents: 5950, TaxCents: 950, InvoiceCents: 2000, BalanceCents: 950, RefundCents: 3000}, 4000),
+		"credit granted":    adjustmentLines(1500),
+		"credit taken back": adjustmentLines(-1500),
+	}
+
+	for name, lines := range sets {
+		t.Run(name, func(t *testing.T) {
+			debits, credits := 0, 0
+			for _, l := range lines {
+				assert.GreaterOrEqual(t, l.DebitCents, 0)
+				assert.GreaterOrEqual(t, l.CreditCents, 0)
+				debits += l.DebitCents
+				credits += l.CreditCents
+			}
+			assert.Equal(t, debits, credits)
+			assert.NotZero(t, debits)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/tax"
+)
+
+var ErrBillingProfileNotFound = errors.New("billing profile not set")
+
+// BillingProfile is the organization's billing address and tax ID. The
+// country and region decide which tax rates apply.
+type BillingProfile struct {
+	OrgID      string    `json:"org_id"`
+	LegalName  string    `json:"legal_name"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2,omitempty"`
+	City       string    `json:"city"`
+	Region     string    `json:"region,omitempty"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	TaxID      string    `json:"tax_id,omitempty"`
+	TaxIDValid bool      `json:"tax_id_valid"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (s *BillingService) GetBillingProfile(orgID string) (*BillingProfile, error) {
+	p, err := scanBillingProfile(s.db.QueryRow(`
+		SELECT `+billingProfileColumns+` FROM billing_profiles WHERE org_id = $1
+	`, orgID))
+	if err == sql.ErrNoRows {
+		return nil, ErrBillingProfileNotFound
+	}
+	return p, err
+}
+
+// SaveBillingProfile creates or replaces the profile. A malformed EU VAT
+// number, or one the TaxIDValidator finds isn't registered, is rejected.
+// Other tax IDs are kept but don't qualify for the reverse charge.
+func (s *BillingService) SaveBillingProfile(p BillingProfile, actor audit.Actor) (*BillingProfile, error) {
+	country, err := tax.NormalizeCountry(p.Country)
+	if err != nil {
+		return nil, err
+	}
+	p.Country = country
+	p.Region = strings.ToUpper(strings.TrimSpace(p.Region))
+	p.TaxID = tax.NormalizeTaxID(p.TaxID)
+
+	p.TaxIDValid = false
+	if p.TaxID != "" {
+		if err := tax.CheckFormat(p.Country, p.TaxID); err != nil {
+			return nil, err
+		}
+		p.TaxIDValid, err = s.taxIDs.ValidateTaxID(context.Background(), p.Country, p.TaxID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	before, err := s.GetBillingProfile(p.OrgID)
+	if err != nil && err != ErrBillingProfileNotFound {
+		return nil, err
+	}
+
+	saved, err := scanBillingProfile(s.db.QueryRow(`
+		INSERT INTO billing_profiles
+			(org_id, legal_name, line1, line2, city, region, postal_code, country, tax_id, tax_id_valid)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+		ON CONFLICT (org_id) DO UPDATE SET
+			legal_name = EXCLUDED.legal_name,
+			line1 = EXCLUDED.line1,
+			line2 = EXCLUDED.line2,
+			city = EXCLUDED.city,
+			region = EXCLUDED.region,
+			postal_code = EXCLUDED.postal_code,
+			country = EXCLUDED.country,
+			tax_id = EXCLUDED.tax_id,
+			tax_id_valid = EXCLUDED.tax_id_valid,
+			updated_at = NOW()
+		RETURNING `+billingProfileColumns,
+		p.OrgID, p.LegalName, p.Line1, p.Line2, p.City, p.Region, p.PostalCode, p.Country, p.TaxID, p.TaxIDValid))
+	if err != nil {
+		return nil, err
+	}
+
+	e := audit.Event{
+		OrgID:      p.OrgID,
+		Actor:      actor,
+		Action:     "billing_profile.updated",
+		TargetType: "organization",
+		TargetID:   p.OrgID,
+		After:      billingProfileState(saved),
+	}
+	if before != nil {
+		e.Before = billingProfileState(before)
+	}
+	s.audit.Emit(e)
+
+	return saved, nil
+}
+
+// taxCustomer is the organization as the tax calculator sees it. Without a
+// billing profile no tax is charged.
+func taxCustomer(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, orgID string) (tax.Customer, error) {
+	var c tax.Customer
+	err := q.QueryRow(`
+		SELECT country, region, COALESCE(tax_id, ''), tax_id_valid
+		FROM billing_profiles WHERE org_id = $1
+	`, orgID).Scan(&c.Country, &c.Region, &c.TaxID, &c.TaxIDValid)
+	if err == sql.ErrNoRows {
+		return tax.Customer{}, nil
+	}
+	return c, err
+}
+
+const billingProfileColumns = `org_id, legal_name, line1, line2, city, region, postal_code, country,
+	COALESCE(tax_id, ''), tax_id_valid, updated_at`
+
+func scanBillingProfile(row *sql.Row) (*BillingProfile, error) {
+	var p BillingProfile
+	err := row.Scan(&p.OrgID, &p.LegalName, &p.Line1, &p.Line2, &p.City, &p.Region, &p.PostalCode,
+		&p.Country, &p.TaxID, &p.TaxIDValid, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func billingProfileState(p *BillingProfile) map[string]interface{} {
+	return map[string]interface{}{
+		"legal_name":  p.LegalName,
+		"line1":       p.Line1,
+		"line2":       p.Line2,
+		"city":        p.City,
+		"region":      p.Region,
+		"postal_code": p.PostalCode,
+		"country":     p.Country,
+		"tax_id":      p.TaxID,
+	}
+}
@@ -0,0 +1,549 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/payments"
+)
+
+var (
+	ErrInvoiceNotPaid        = errors.New("only paid invoices can be refunded")
+	ErrPaymentNotFound       = errors.New("payment not found")
+	ErrInvalidPayment        = errors.New("payment must be positive and no more than the amount due")
+	ErrRefundExceedsCaptured = errors.New("amount exceeds what can still be refunded")
+	ErrRefundFailed          = errors.New("payment provider declined the refund")
+
+	// errRefundSettled means someone else completed or failed the refund
+	// first
+	errRefundSettled = errors.New("refund is no longer pending")
+)
+
+// refundReconcileAfter is how long a refund stays pending before
+// ReconcileRefunds asks the provider again. It leaves CreateRefund time to
+// finish on its own.
+const refundReconcileAfter = 10 * time.Minute
+
+type refundOutcome int
+
+const (
+	refundSucceeded refundOutcome = iota
+	refundDeclined
+	refundUnknown
+)
+
+// Payment is money captured against an invoice
+type Payment struct {
+	ID                string    `json:"id"`
+	InvoiceID         string    `json:"invoice_id"`
+	Currency          string    `json:"currency"`
+	AmountCents       int       `json:"amount_cents"`
+	ProviderPaymentID string    `json:"provider_payment_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Refund returns part of a payment. Once it succeeds, CreditNoteID is the
+// credit note taking it off the invoice.
+type Refund struct {
+	ID               string    `json:"id"`
+	InvoiceID        string    `json:"invoice_id"`
+	PaymentID        string    `json:"payment_id"`
+	OrgID            string    `json:"org_id"`
+	Currency         string    `json:"currency"`
+	AmountCents      int       `json:"amount_cents"`
+	Reason           string    `json:"reason"`
+	Status           string    `json:"status"`
+	FailureReason    string    `json:"failure_reason,omitempty"`
+	ProviderRefundID string    `json:"provider_refund_id,omitempty"`
+	CreditNoteID     *string   `json:"credit_note_id,omitempty"`
+	CreatedBy        *string   `json:"created_by,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// paymentBalance is a payment with the refunds already taken from it,
+// pending ones included
+type paymentBalance struct {
+	ID                string
+	ProviderPaymentID string
+	CapturedCents     int
+	RefundedCents     int
+}
+
+// RecordPayment records money captured for an unpaid invoice, e.g. from the
+// provider's webhook or a bank transfer. The invoice is paid once nothing
+// is left due.
+func (s *BillingService) RecordPayment(orgID, invoiceID string, amountCents int, providerPaymentID string, actor audit.Actor) (*Payment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var currency, status string
+	var due int
+	err = tx.QueryRow(`
+		SELECT i.currency, i.status, i.amount_due_cents
+		FROM invoices i
+		JOIN subscriptions s ON s.id = i.subscription_id
+		WHERE i.id::text = $1 AND s.org_id = $2
+		FOR UPDATE OF i
+	`, invoiceID, orgID).Scan(&currency, &status, &due)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "unpaid" || amountCents <= 0 || amountCents > due {
+		return nil, ErrInvalidPayment
+	}
+
+	payment, err := recordPayment(tx, orgID, invoiceID, currency, amountCents, providerPaymentID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "invoice.payment_recorded",
+		TargetType: "invoice",
+		TargetID:   invoiceID,
+		After:      map[string]interface{}{"payment_id": payment.ID, "amount_cents": payment.AmountCents, "currency": payment.Currency},
+	})
+	return payment, nil
+}
+
+// recordPayment stores a payment against an invoice locked by the caller
+// and reduces what's due, marking the invoice paid once nothing is left
+func recordPayment(tx *sql.Tx, orgID, invoiceID, currency string, amountCents int, providerPaymentID, createdBy string) (*Payment, error) {
+	p := Payment{InvoiceID: invoiceID, Currency: currency, AmountCents: amountCents, ProviderPaymentID: providerPaymentID}
+	err := tx.QueryRow(`
+		INSERT INTO invoice_payments (invoice_id, currency, amount_cents, provider_payment_id, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+		RETURNING id, created_at
+	`, invoiceID, currency, amountCents, providerPaymentID, createdBy).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE invoices SET
+			amount_due_cents = amount_due_cents - $2,
+			status = CASE WHEN amount_due_cents = $2 THEN 'paid' ELSE status END,
+			paid_at = CASE WHEN amount_due_cents = $2 THEN NOW() ELSE paid_at END
+		WHERE id = $1
+	`, invoiceID, amountCents)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ledger.Post(tx, ledger.Entry{
+		OrgID:         orgID,
+		Currency:      currency,
+		Type:          "payment.received",
+		ReferenceType: "payment",
+		ReferenceID:   p.ID,
+		Description:   fmt.Sprintf("Payment for invoice %s", invoiceID),
+		Lines:         paymentLines(amountCents),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// CreateRefund refunds amountCents of a paid invoice, or as much as can
+// still be refunded when amountCents is 0. paymentID picks the payment to
+// refund; if empty, the most recent payment that covers the amount is used.
+//
+// The refund is saved as pending before the provider is called, under the
+// invoice's row lock, so concurrent refunds can't exceed the captured
+// amount. On success a credit note for the refund is issued. When the
+// provider's answer is lost, e.g. to a timeout, the refund is returned still
+// pending and ReconcileRefunds settles it later.
+func (s *BillingService) CreateRefund(orgID, invoiceID, paymentID string, amountCents int, reason string, actor audit.Actor) (*Refund, error) {
+	if !validCreditReason(reason) {
+		return nil, ErrInvalidCreditReason
+	}
+	if amountCents < 0 {
+		return nil, ErrInvalidCreditAmount
+	}
+
+	refund, payment, err := s.reserveRefund(orgID, invoiceID, paymentID, amountCents, reason, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome, result, providerErr := requestRefund(s.provider, refund, payment.ProviderPaymentID)
+	switch outcome {
+	case refundDeclined:
+		if err := s.failRefund(refund, providerErr.Error()); err != nil {
+			return nil, err
+		}
+		s.auditRefund(refund, actor)
+		return nil, fmt.Errorf("%w: %v", ErrRefundFailed, providerErr)
+	case refundUnknown:
+		logger.Warn("Refund outcome unknown, leaving it pending", logger.Fields{"refund_id": refund.ID, "error": providerErr.Error()})
+		return refund, nil
+	}
+
+	if err := s.completeRefund(refund, result.ProviderRefundID, actor); err != nil {
+		// The money has gone back; ReconcileRefunds records it
+		logger.Error("Failed to record completed refund", err, logger.Fields{"refund_id": refund.ID})
+		return refund, nil
+	}
+
+	s.auditRefund(refund, actor)
+	return refund, nil
+}
+
+// ReconcileRefunds settles refunds pending for longer than
+// refundReconcileAfter, whose provider call timed out or whose success
+// wasn't recorded. It asks the provider again with the refund's original
+// idempotency key, so nothing is refunded twice, and returns how many it
+// settled. A refund that can't be settled is logged and left for the next
+// run.
+func (s *BillingService) ReconcileRefunds(now time.Time) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.invoice_id, r.payment_id, r.org_id, r.currency, r.amount_cents, r.reason, r.status,
+			r.created_by, r.created_at, r.updated_at, COALESCE(p.provider_payment_id, '')
+		FROM refunds r
+		JOIN invoice_payments p ON p.id = r.payment_id
+		WHERE r.status = 'pending' AND r.updated_at <= $1
+		ORDER BY r.updated_at
+		LIMIT 100
+	`, now.Add(-refundReconcileAfter))
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []Refund
+	var providerPaymentIDs []string
+	for rows.Next() {
+		var r Refund
+		var providerPaymentID string
+		if err := rows.Scan(&r.ID, &r.InvoiceID, &r.PaymentID, &r.OrgID, &r.Currency, &r.AmountCents, &r.Reason,
+			&r.Status, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt, &providerPaymentID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, r)
+		providerPaymentIDs = append(providerPaymentIDs, providerPaymentID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for i := range pending {
+		refund := &pending[i]
+		outcome, result, providerErr := requestRefund(s.provider, refund, providerPaymentIDs[i])
+		switch outcome {
+		case refundSucceeded:
+			err = s.completeRefund(refund, result.ProviderRefundID, audit.Actor{})
+		case refundDeclined:
+			err = s.failRefund(refund, providerErr.Error())
+		default:
+			err = providerErr
+		}
+		if errors.Is(err, errRefundSettled) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to reconcile refund", err, logger.Fields{"refund_id": refund.ID})
+			continue
+		}
+
+		s.auditRefund(refund, audit.Actor{})
+		settled++
+	}
+
+	return settled, nil
+}
+
+// requestRefund asks the provider to carry out the refund. The refund's ID
+// is the idempotency key, so asking again for the same refund is safe.
+func requestRefund(provider payments.Provider, refund *Refund, providerPaymentID string) (refundOutcome, *payments.RefundResult, error) {
+	result, err := provider.Refund(context.Background(), payments.RefundRequest{
+		ProviderPaymentID: providerPaymentID,
+		AmountCents:       refund.AmountCents,
+		Currency:          refund.Currency,
+		Reason:            refund.Reason,
+		IdempotencyKey:    refund.ID,
+	})
+	switch {
+	case err == nil:
+		return refundSucceeded, result, nil
+	case errors.Is(err, payments.ErrDeclined):
+		return refundDeclined, nil, err
+	default:
+		return refundUnknown, nil, err
+	}
+}
+
+// auditRefund records a refund that succeeded or failed
+func (s *BillingService) auditRefund(refund *Refund, actor audit.Actor) {
+	e := audit.Event{
+		OrgID:      refund.OrgID,
+		Actor:      actor,
+		Action:     "refund.succeeded",
+		TargetType: "invoice",
+		TargetID:   refund.InvoiceID,
+		After: map[string]interface{}{
+			"refund_id":      refund.ID,
+			"amount_cents":   refund.AmountCents,
+			"currency":       refund.Currency,
+			"reason":         refund.Reason,
+			"credit_note_id": refund.CreditNoteID,
+		},
+	}
+	if refund.Status == "failed" {
+		e.Action = "refund.failed"
+		e.After = map[string]interface{}{"refund_id": refund.ID, "amount_cents": refund.AmountCents, "failure_reason": refund.FailureReason}
+	}
+	s.audit.Emit(e)
+}
+
+// reserveRefund checks the amount against the payment and the invoice and
+// saves the refund as pending
+func (s *BillingService) reserveRefund(orgID, invoiceID, paymentID string, amountCents int, reason string, actor audit.Actor) (*Refund, *paymentBalance, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	refund := Refund{InvoiceID: invoiceID, OrgID: orgID, Reason: reason}
+	var status string
+	var total, credited int
+	err = tx.QueryRow(`
+		SELECT i.currency, i.status, i.amount_cents, i.credited_cents
+		FROM invoices i
+		JOIN subscriptions s ON s.id = i.subscription_id
+		WHERE i.id::text = $1 AND s.org_id = $2
+		FOR UPDATE OF i
+	`, invoiceID, orgID).Scan(&refund.Currency, &status, &total, &credited)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if status != "paid" {
+		return nil, nil, ErrInvoiceNotPaid
+	}
+
+	pending, err := pendingRefunds(tx, invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	balances, err := paymentBalances(tx, invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Credit notes already issued count too, so the invoice is never
+	// credited for more than its total
+	payment, amount, err := pickPayment(balances, paymentID, amountCents, total-credited-pending)
+	if err != nil {
+		return nil, nil, err
+	}
+	refund.PaymentID = payment.ID
+	refund.AmountCents = amount
+
+	err = tx.QueryRow(`
+		INSERT INTO refunds (invoice_id, payment_id, org_id, currency, amount_cents, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)
+		RETURNING id, status, created_by, created_at, updated_at
+	`, invoiceID, payment.ID, orgID, refund.Currency, amount, reason, actor.UserID).Scan(
+		&refund.ID, &refund.Status, &refund.CreatedBy, &refund.CreatedAt, &refund.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return &refund, &payment, nil
+}
+
+// completeRefund marks the refund succeeded and issues its credit note
+func (s *BillingService) completeRefund(refund *Refund, providerRefundID string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var total, taxCents int
+	err = tx.QueryRow(`
+		SELECT amount_cents, tax_cents FROM invoices WHERE id = $1 FOR UPDATE
+	`, refund.InvoiceID).Scan(&total, &taxCents)
+	if err != nil {
+		return err
+	}
+
+	// Reconciliation may be settling the same refund
+	var status string
+	err = tx.QueryRow(`SELECT status FROM refunds WHERE id = $1 FOR UPDATE`, refund.ID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != "pending" {
+		return errRefundSettled
+	}
+
+	note := CreditNote{
+		InvoiceID:   refund.InvoiceID,
+		OrgID:       refund.OrgID,
+		Currency:    refund.Currency,
+		AmountCents: refund.AmountCents,
+		TaxCents:    proportionalTax(refund.AmountCents, total, taxCents),
+		RefundCents: refund.AmountCents,
+		Reason:      refund.Reason,
+		Memo:        fmt.Sprintf("Refund %s", refund.ID),
+	}
+	if err := insertCreditNote(tx, &note, actor.UserID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE refunds SET status = 'succeeded', provider_refund_id = $2, credit_note_id = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING status, provider_refund_id, credit_note_id, updated_at
+	`, refund.ID, providerRefundID, note.ID).Scan(&refund.Status, &refund.ProviderRefundID, &refund.CreditNoteID, &refund.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// failRefund releases the amount held by a declined refund
+func (s *BillingService) failRefund(refund *Refund, reason string) error {
+	err := s.db.QueryRow(`
+		UPDATE refunds SET status = 'failed', failure_reason = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING status, failure_reason, updated_at
+	`, refund.ID, reason).Scan(&refund.Status, &refund.FailureReason, &refund.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return errRefundSettled
+	}
+	if err != nil {
+		logger.Error("Failed to mark refund as failed", err, logger.Fields{"refund_id": refund.ID})
+	}
+	return err
+}
+
+// ListRefunds returns the organization's refunds, newest first, optionally
+// only those for one invoice
+func (s *BillingService) ListRefunds(orgID, invoiceID string) ([]Refund, error) {
+	rows, err := s.db.Query(`
+		SELECT id, invoice_id, payment_id, org_id, currency, amount_cents, reason, status, failure_reason,
+			provider_refund_id, credit_note_id, created_by, created_at, updated_at
+		FROM refunds
+		WHERE org_id = $1 AND ($2 = '' OR invoice_id::text = $2)
+		ORDER BY created_at DESC
+	`, orgID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		var r Refund
+		if err := rows.Scan(&r.ID, &r.InvoiceID, &r.PaymentID, &r.OrgID, &r.Currency, &r.AmountCents, &r.Reason,
+			&r.Status, &r.FailureReason, &r.ProviderRefundID, &r.CreditNoteID, &r.CreatedBy,
+			&r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, r)
+	}
+
+	return refunds, rows.Err()
+}
+
+// pendingRefunds is the amount held by refunds still waiting on the
+// provider
+func pendingRefunds(tx *sql.Tx, invoiceID string) (int, error) {
+	var pending int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount_cents), 0) FROM refunds
+		WHERE invoice_id = $1 AND status = 'pending'
+	`, invoiceID).Scan(&pending)
+	return pending, err
+}
+
+func paymentBalances(tx *sql.Tx, invoiceID string) ([]paymentBalance, error) {
+	rows, err := tx.Query(`
+		SELECT p.id, p.provider_payment_id, p.amount_cents,
+			COALESCE((SELECT SUM(r.amount_cents) FROM refunds r WHERE r.payment_id = p.id AND r.status <> 'failed'), 0)
+		FROM invoice_payments p
+		WHERE p.invoice_id = $1
+		ORDER BY p.created_at DESC
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []paymentBalance
+	for rows.Next() {
+		var b paymentBalance
+		if err := rows.Scan(&b.ID, &b.ProviderPaymentID, &b.CapturedCents, &b.RefundedCents); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+
+	return balances, rows.Err()
+}
+
+// pickPayment chooses the payment to refund and the amount. Nothing can be
+// refunded beyond what a payment captured or beyond creditable, what's left
+// of the invoice after credit notes and pending refunds. A zero amount
+// means as much as possible.
+func pickPayment(balances []paymentBalance, paymentID string, amount, creditable int) (paymentBalance, int, error) {
+	found := false
+	for _, p := range balances {
+		if paymentID != "" && p.ID != paymentID {
+			continue
+		}
+		found = true
+
+		available := p.CapturedCents - p.RefundedCents
+		if creditable < available {
+			available = creditable
+		}
+		if available <= 0 {
+			continue
+		}
+		if amount == 0 {
+			return p, available, nil
+		}
+		if amount <= available {
+			return p, amount, nil
+		}
+	}
+
+	if paymentID != "" && !found {
+		return paymentBalance{}, 0, ErrPaymentNotFound
+	}
+	return paymentBalance{}, 0, ErrRefundExceedsCaptured
+}
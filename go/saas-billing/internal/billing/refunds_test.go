@@ -0,0 +1,86 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/linkmeAman/saas-billing/internal/payments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickPayment(t *testing.T) {
+	// Newest first, as paymentBalances returns them
+	balances := []paymentBalance{
+		{ID: "pay-2", CapturedCents: 3000, RefundedCents: 2500},
+		{ID: "pay-1", CapturedCents: 5000},
+	}
+
+	tests := []struct {
+		name       string
+		paymentID  string
+		amount     int
+		creditable int
+		wantID     string
+		want       int
+		err        error
+	}{
+		{"full refund takes the newest payment with money left", "", 0, 8000, "pay-2", 500, nil},
+		{"partial refund skips payments that can't cover it", "", 1000, 8000, "pay-1", 1000, nil},
+		{"chosen payment", "pay-1", 0, 8000, "pay-1", 5000, nil},
+		{"capped by what's left to credit", "pay-1", 0, 1200, "pay-1", 1200, nil},
+		{"more than captured", "pay-1", 5001, 8000, "", 0, ErrRefundExceedsCaptured},
+		{"more than left to credit", "", 1000, 600, "", 0, ErrRefundExceedsCaptured},
+		{"unknown payment", "pay-3", 0, 8000, "", 0, ErrPaymentNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment, amount, err := pickPayment(balances, tt.paymentID, tt.amount, tt.creditable)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.wantID, payment.ID)
+			assert.Equal(t, tt.want, amount)
+		})
+	}
+
+	_, _, err := pickPayment(nil, "", 0, 8000)
+	assert.ErrorIs(t, err, ErrRefundExceedsCaptured, "paid with credit only")
+}
+
+// fakeProvider answers with err and remembers each request's idempotency key
+type fakeProvider struct {
+	err  error
+	keys []string
+}
+
+func (p *fakeProvider) Refund(ctx context.Context, req payments.RefundRequest) (*payments.RefundResult, error) {
+	p.keys = append(p.keys, req.IdempotencyKey)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &payments.RefundResult{ProviderRefundID: "re_1"}, nil
+}
+
+func TestRequestRefund(t *testing.T) {
+	refund := &Refund{ID: "refund-1", AmountCents: 500, Currency: "USD"}
+
+	// Only a definite decline fails the refund
+	provider := &fakeProvider{err: fmt.Errorf("card expired: %w", payments.ErrDeclined)}
+	outcome, _, err := requestRefund(provider, refund, "pay_1")
+	assert.Equal(t, refundDeclined, outcome)
+	assert.ErrorIs(t, err, payments.ErrDeclined)
+
+	// A timeout may have refunded already, so the refund stays pending and
+	// reconciliation asks again under the same key
+	provider = &fakeProvider{err: context.DeadlineExceeded}
+	outcome, _, err = requestRefund(provider, refund, "pay_1")
+	assert.Equal(t, refundUnknown, outcome)
+	assert.Error(t, err)
+
+	provider.err = nil
+	outcome, result, err := requestRefund(provider, refund, "pay_1")
+	assert.NoError(t, err)
+	assert.Equal(t, refundSucceeded, outcome)
+	assert.Equal(t, "re_1", result.ProviderRefundID)
+	assert.Equal(t, []string{"refund-1", "refund-1"}, provider.keys)
+}
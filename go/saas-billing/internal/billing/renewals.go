@@ -0,0 +1,399 @@
+package billing
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/tax"
+)
+
+// planCharge is what a subscription is invoiced each period
+type planCharge struct {
+	Line     tax.LineInput
+	Interval string
+}
+
+// chargeFor prices quantity seats of a plan in currency, less the coupon's
+// discount
+func chargeFor(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, planID, currency string, quantity int, couponCode *string) (planCharge, error) {
+	var name, interval string
+	var taxInclusive bool
+	err := q.QueryRow(`
+		SELECT name, interval, tax_inclusive FROM plans WHERE id::text = $1
+	`, planID).Scan(&name, &interval, &taxInclusive)
+	if err == sql.ErrNoRows {
+		return planCharge{}, ErrPlanNotFound
+	}
+	if err != nil {
+		return planCharge{}, err
+	}
+
+	var price int
+	err = q.QueryRow(`
+		SELECT amount FROM plan_prices WHERE plan_id = $1 AND currency = $2
+	`, planID, currency).Scan(&price)
+	if err == sql.ErrNoRows {
+		return planCharge{}, ErrPriceNotAvailable
+	}
+	if err != nil {
+		return planCharge{}, err
+	}
+
+	percentOff, err := couponPercentOff(q, couponCode)
+	if err != nil {
+		return planCharge{}, err
+	}
+
+	description := name + " plan"
+	if quantity > 1 {
+		description += fmt.Sprintf(" × %d", quantity)
+	}
+	if percentOff > 0 {
+		description += fmt.Sprintf(" (%s, %d%% off)", *couponCode, percentOff)
+	}
+
+	return planCharge{
+		Line: tax.LineInput{
+			Description: description,
+			AmountCents: discounted(price*quantity, percentOff),
+			Inclusive:   taxInclusive,
+		},
+		Interval: interval,
+	}, nil
+}
+
+// nextPeriodEnd is where a billing period starting at start ends
+func nextPeriodEnd(start time.Time, interval string) time.Time {
+	if interval == "month" {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(1, 0, 0)
+}
+
+// catchUpStart moves a period due to start at start forward by whole
+// periods to the one still running at now. A subscription the job reaches
+// late, for instance on its first run or after an outage, is invoiced once
+// for the current period instead of once for every period it missed.
+func catchUpStart(start time.Time, interval string, now time.Time) time.Time {
+	for end := nextPeriodEnd(start, interval); !end.After(now); end = nextPeriodEnd(start, interval) {
+		start = end
+	}
+	return start
+}
+
+// renewalStep is the next thing due on a subscription: ending it where its
+// schedule ends, starting a scheduled phase, or renewing it for another
+// period
+type renewalStep struct {
+	Kind  string // cancel, phase or renew
+	At    time.Time
+	Phase *SchedulePhase
+}
+
+// nextStep picks the earliest step due by now. When several fall at the
+// same time the schedule's end wins over a phase, and a phase over a plain
+// renewal, since each of them starts the period afresh.
+func nextStep(periodEnd time.Time, phases []SchedulePhase, now time.Time) (renewalStep, bool) {
+	candidates := []renewalStep{}
+	if n := len(phases); n > 0 && phases[n-1].AppliedAt != nil && phases[n-1].EndAt != nil {
+		candidates = append(candidates, renewalStep{Kind: "cancel", At: *phases[n-1].EndAt})
+	}
+	for i := range phases {
+		if phases[i].AppliedAt == nil {
+			candidates = append(candidates, renewalStep{Kind: "phase", At: phases[i].StartAt, Phase: &phases[i]})
+			break
+		}
+	}
+	candidates = append(candidates, renewalStep{Kind: "renew", At: periodEnd})
+
+	var next renewalStep
+	found := false
+	for _, c := range candidates {
+		if c.At.After(now) {
+			continue
+		}
+		if !found || c.At.Before(next.At) {
+			next, found = c, true
+		}
+	}
+	return next, found
+}
+
+// prorationCredit is the part of amountCents paying for the time between
+// at and the end of the period
+func prorationCredit(amountCents int, start, end, at time.Time) int {
+	if !end.After(start) || !at.Before(end) {
+		return 0
+	}
+	if at.Before(start) {
+		at = start
+	}
+	return int(int64(amountCents) * int64(end.Sub(at)/time.Second) / int64(end.Sub(start)/time.Second))
+}
+
+// RenewSubscriptions bills every subscription whose period has ended,
+// applies the scheduled changes due by now and resumes paused
+// subscriptions whose resume date has come, returning how many steps it
+// took. A subscription that fails is logged and skipped until the next run
+// so it can't hold up the others. It is safe to run from several instances
+// at once.
+func (s *BillingService) RenewSubscriptions(now time.Time) (int, error) {
+	renewed, failed := 0, 0
+	skip := []string{}
+	for {
+		subscriptionID, ok, err := s.renewNext(now, skip)
+		if err != nil && subscriptionID == "" {
+			return renewed, err
+		}
+		if err != nil {
+			logger.Error("Failed to renew subscription", err, logger.Fields{"subscription_id": subscriptionID})
+			skip = append(skip, subscriptionID)
+			failed++
+			continue
+		}
+		if !ok && subscriptionID == "" {
+			break
+		}
+		if !ok {
+			// It looked due but wasn't, so the others still get their turn
+			skip = append(skip, subscriptionID)
+			continue
+		}
+		renewed++
+	}
+
+	if failed > 0 {
+		return renewed, fmt.Errorf("%d subscriptions could not be renewed", failed)
+	}
+	return renewed, nil
+}
+
+// renewNext takes the next due step on one subscription other than those in
+// skip, reporting false when nothing is due. It returns the ID of the
+// subscription it looked at, if any, with any error about it.
+func (s *BillingService) renewNext(now time.Time, skip []string) (string, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization with the subscription, as invoicing it takes
+	// the organization's credit balance
+	var sub Subscription
+	var periodStart time.Time
+	var currency string
+	err = tx.QueryRow(`
+		SELECT s.id, s.org_id, s.plan_id, s.quantity, s.coupon_code, s.status, s.current_period_start,
+			s.current_period_end, s.paused_at, s.resume_at, s.pause_behavior, o.billing_currency
+		FROM subscriptions s
+		JOIN organizations o ON o.id = s.org_id
+		WHERE NOT (s.id::text = ANY($2)) AND ((s.status = 'paused' AND (
+			s.resume_at <= $1 OR (s.pause_behavior = 'void' AND s.current_period_end <= $1)
+		)) OR (s.status = 'active' AND (
+			s.current_period_end <= $1
+			OR EXISTS (
+				SELECT 1 FROM subscription_schedules sc
+				JOIN subscription_schedule_phases p ON p.schedule_id = sc.id
+				WHERE sc.subscription_id = s.id AND sc.status = 'active'
+				  AND (
+					(p.applied_at IS NULL AND p.start_at <= $1)
+					OR (p.end_at <= $1 AND p.applied_at IS NOT NULL AND NOT EXISTS (
+						SELECT 1 FROM subscription_schedule_phases n
+						WHERE n.schedule_id = p.schedule_id AND n.start_at > p.start_at
+					))
+				  )
+			)
+		)))
+		ORDER BY s.current_period_end
+		LIMIT 1
+		FOR UPDATE OF o, s SKIP LOCKED
+	`, now, pq.Array(skip)).Scan(&sub.ID, &sub.OrgID, &sub.PlanID, &sub.Quantity, &sub.CouponCode, &sub.Status, &periodStart,
+		&sub.CurrentPeriodEnd, &sub.PausedAt, &sub.ResumeAt, &sub.PauseBehavior, &currency)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	var schedule *SubscriptionSchedule
+	var step renewalStep
+	var ok bool
+	if sub.Status == "paused" {
+		behavior := ""
+		if sub.PauseBehavior != nil {
+			behavior = *sub.PauseBehavior
+		}
+		step, ok = pausedStep(sub.CurrentPeriodEnd, sub.ResumeAt, behavior, now)
+	} else {
+		schedule, err = activeSchedule(tx, sub.ID, true)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		var phases []SchedulePhase
+		if schedule != nil {
+			phases = schedule.Phases
+		}
+		step, ok = nextStep(sub.CurrentPeriodEnd, phases, now)
+	}
+	if !ok {
+		return sub.ID, false, nil
+	}
+
+	before := map[string]interface{}{
+		"plan_id":            sub.PlanID,
+		"quantity":           sub.Quantity,
+		"coupon_code":        sub.CouponCode,
+		"current_period_end": sub.CurrentPeriodEnd,
+	}
+	e := audit.Event{OrgID: sub.OrgID, TargetType: "subscription", TargetID: sub.ID, Before: before}
+
+	switch step.Kind {
+	case "cancel":
+		if err := creditUnusedPeriod(tx, sub.OrgID, sub.ID, periodStart, sub.CurrentPeriodEnd, step.At); err != nil {
+			return sub.ID, false, err
+		}
+		_, err = tx.Exec(`
+			UPDATE subscriptions SET status = 'canceled', canceled_at = $2 WHERE id = $1
+		`, sub.ID, step.At)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		if err := completeSchedule(tx, schedule.ID); err != nil {
+			return sub.ID, false, err
+		}
+		e.Action = "subscription.canceled"
+		e.After = map[string]interface{}{"status": "canceled", "canceled_at": step.At, "schedule_id": schedule.ID}
+
+	case "phase":
+		if err := creditUnusedPeriod(tx, sub.OrgID, sub.ID, periodStart, sub.CurrentPeriodEnd, step.At); err != nil {
+			return sub.ID, false, err
+		}
+		p := step.Phase
+		periodEnd, err := s.startPeriod(tx, sub.OrgID, sub.ID, currency, p.PlanID, p.Quantity, p.CouponCode, step.At, now)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		_, err = tx.Exec(`UPDATE subscription_schedule_phases SET applied_at = NOW() WHERE id = $1`, p.ID)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		if last := schedule.Phases[len(schedule.Phases)-1]; last.ID == p.ID && last.EndAt == nil {
+			if err := completeSchedule(tx, schedule.ID); err != nil {
+				return sub.ID, false, err
+			}
+		}
+		e.Action = "subscription.phase_applied"
+		e.After = map[string]interface{}{
+			"plan_id":            p.PlanID,
+			"quantity":           p.Quantity,
+			"coupon_code":        p.CouponCode,
+			"current_period_end": periodEnd,
+			"schedule_id":        schedule.ID,
+			"phase_id":           p.ID,
+		}
+
+	case "resume":
+		periodEnd, err := s.resume(tx, &sub, currency, step.At, now)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		e.Action = "subscription.resumed"
+		e.Before["status"] = "paused"
+		e.After = map[string]interface{}{"status": "active", "current_period_end": periodEnd}
+
+	case "void":
+		periodEnd, err := s.voidPeriod(tx, &sub, currency, step.At, now)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		e.Action = "subscription.renewed"
+		e.After = map[string]interface{}{"current_period_end": periodEnd, "invoice_status": "void"}
+
+	default:
+		periodEnd, err := s.startPeriod(tx, sub.OrgID, sub.ID, currency, sub.PlanID, sub.Quantity, sub.CouponCode, step.At, now)
+		if err != nil {
+			return sub.ID, false, err
+		}
+		e.Action = "subscription.renewed"
+		e.After = map[string]interface{}{"current_period_end": periodEnd}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return sub.ID, false, err
+	}
+
+	s.audit.Emit(e)
+	return sub.ID, true, nil
+}
+
+// startPeriod puts the subscription on the given terms for a new period
+// from start, active, and invoices it, returning where the period ends.
+// Periods that already ended by now are skipped; see catchUpStart.
+func (s *BillingService) startPeriod(tx *sql.Tx, orgID, subscriptionID, currency, planID string, quantity int, couponCode *string, start, now time.Time) (time.Time, error) {
+	charge, err := chargeFor(tx, planID, currency, quantity, couponCode)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	start = catchUpStart(start, charge.Interval, now)
+	end := nextPeriodEnd(start, charge.Interval)
+	_, err = tx.Exec(`
+		UPDATE subscriptions SET status = 'active', plan_id = $2, quantity = $3, coupon_code = $4,
+			current_period_start = $5, current_period_end = $6
+		WHERE id = $1
+	`, subscriptionID, planID, quantity, couponCode, start, end)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = s.createInvoice(tx, orgID, subscriptionID, currency, []tax.LineInput{charge.Line},
+		servicePeriod{Start: start, End: end}, start)
+	return end, err
+}
+
+// creditUnusedPeriod credits the part of the subscription's latest invoice
+// paying for the time after at, up to what's left to credit on it
+func creditUnusedPeriod(tx *sql.Tx, orgID, subscriptionID string, start, end, at time.Time) error {
+	var invoiceID, status string
+	var total, credited int
+	err := tx.QueryRow(`
+		SELECT id, status, amount_cents, credited_cents FROM invoices
+		WHERE subscription_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, subscriptionID).Scan(&invoiceID, &status, &total, &credited)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != "unpaid" && status != "paid" {
+		return nil
+	}
+
+	pending, err := pendingRefunds(tx, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	amount := prorationCredit(total, start, end, at)
+	if remaining := total - credited - pending; amount > remaining {
+		amount = remaining
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	memo := fmt.Sprintf("Unused time from %s", at.UTC().Format(time.RFC3339))
+	_, err = issueCreditNote(tx, orgID, invoiceID, amount, "order_change", memo, "")
+	return err
+}
@@ -0,0 +1,151 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextPeriodEnd(t *testing.T) {
+	assert.Equal(t, date(2025, 2, 15), nextPeriodEnd(date(2025, 1, 15), "month"))
+	assert.Equal(t, date(2026, 1, 15), nextPeriodEnd(date(2025, 1, 15), "year"))
+}
+
+func TestCatchUpStart(t *testing.T) {
+	now := date(2025, 6, 20)
+
+	// On time, or within the period that started, nothing moves
+	assert.Equal(t, date(2025, 6, 15), catchUpStart(date(2025, 6, 15), "month", now))
+	assert.Equal(t, date(2025, 6, 20), catchUpStart(now, "month", now))
+
+	// Missed periods are skipped, keeping the day of the month
+	assert.Equal(t, date(2025, 6, 15), catchUpStart(date(2025, 1, 15), "month", now))
+	assert.Equal(t, date(2025, 3, 1), catchUpStart(date(2023, 3, 1), "year", now))
+
+	// A period ending exactly now has ended
+	assert.Equal(t, now, catchUpStart(date(2025, 5, 20), "month", now))
+}
+
+func TestDiscounted(t *testing.T) {
+	assert.Equal(t, 1000, discounted(1000, 0))
+	assert.Equal(t, 750, discounted(1000, 25))
+	// The discount rounds down, so the customer never pays less than shown
+	assert.Equal(t, 670, discounted(999, 33))
+	assert.Equal(t, 0, discounted(1000, 100))
+}
+
+func TestProrationCredit(t *testing.T) {
+	start, end := date(2025, 4, 1), date(2025, 5, 1)
+
+	assert.Equal(t, 2000, prorationCredit(3000, start, end, date(2025, 4, 11)))
+	assert.Equal(t, 3000, prorationCredit(3000, start, end, start))
+	assert.Equal(t, 0, prorationCredit(3000, start, end, end))
+	assert.Equal(t, 0, prorationCredit(3000, start, end, date(2025, 6, 1)))
+}
+
+func TestRenewSubscriptionsSkipsWhatIsNotDue(t *testing.T) {
+	s, mock := newBillingMock(t)
+	now := date(2025, 3, 10)
+	resumeAt := date(2025, 4, 1)
+	columns := []string{"id", "org_id", "plan_id", "quantity", "coupon_code", "status", "current_period_start",
+		"current_period_end", "paused_at", "resume_at", "pause_behavior", "billing_currency"}
+
+	// sub-1 is picked but has nothing due, which doesn't end the run before
+	// sub-2 is looked at
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM subscriptions s\s+JOIN organizations o`).WithArgs(now, "{}").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sub-1", "org-1", "plan-1", 1, nil, "paused", date(2025, 2, 1), date(2025, 3, 1), date(2025, 2, 15), resumeAt, "none", "USD"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM subscriptions s\s+JOIN organizations o`).WithArgs(now, `{"sub-1"}`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("sub-2", "org-2", "plan-1", 1, nil, "paused", date(2025, 2, 1), date(2025, 3, 1), date(2025, 2, 15), resumeAt, "none", "USD"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM subscriptions s\s+JOIN organizations o`).WithArgs(now, `{"sub-1","sub-2"}`).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+
+	renewed, err := s.RenewSubscriptions(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, renewed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNextStep(t *testing.T) {
+	applied := date(2025, 1, 1)
+	end := date(2025, 6, 1)
+	phases := []SchedulePhase{
+		{ID: "p1", StartAt: date(2025, 1, 1), AppliedAt: &applied},
+		{ID: "p2", StartAt: date(2025, 3, 15)},
+		{ID: "p3", StartAt: date(2025, 4, 1), EndAt: &end},
+	}
+
+	t.Run("nothing due", func(t *testing.T) {
+		_, ok := nextStep(date(2025, 3, 1), phases, date(2025, 2, 20))
+		assert.False(t, ok)
+	})
+
+	t.Run("renewal before the next phase", func(t *testing.T) {
+		step, ok := nextStep(date(2025, 3, 1), phases, date(2025, 3, 20))
+		assert.True(t, ok)
+		assert.Equal(t, "renew", step.Kind)
+		assert.Equal(t, date(2025, 3, 1), step.At)
+	})
+
+	t.Run("phase mid-period", func(t *testing.T) {
+		step, ok := nextStep(date(2025, 4, 1), phases, date(2025, 3, 20))
+		assert.True(t, ok)
+		assert.Equal(t, "phase", step.Kind)
+		assert.Equal(t, "p2", step.Phase.ID)
+	})
+
+	t.Run("phase wins a tie with renewal", func(t *testing.T) {
+		p2 := date(2025, 3, 15)
+		done := []SchedulePhase{phases[0], {ID: "p2", StartAt: p2, AppliedAt: &p2}, phases[2]}
+		step, ok := nextStep(date(2025, 4, 1), done, date(2025, 4, 1))
+		assert.True(t, ok)
+		assert.Equal(t, "phase", step.Kind)
+		assert.Equal(t, "p3", step.Phase.ID)
+	})
+
+	t.Run("schedule end cancels", func(t *testing.T) {
+		p3 := date(2025, 4, 1)
+		done := []SchedulePhase{phases[0], phases[1], {ID: "p3", StartAt: p3, EndAt: &end, AppliedAt: &p3}}
+		done[1].AppliedAt = &p3
+		step, ok := nextStep(date(2025, 6, 1), done, date(2025, 6, 2))
+		assert.True(t, ok)
+		assert.Equal(t, "cancel", step.Kind)
+		assert.Equal(t, end, step.At)
+	})
+}
+
+func TestValidatePhases(t *testing.T) {
+	now := date(2025, 1, 1)
+	at := func(m time.Month) *time.Time {
+		t := date(2025, m, 1)
+		return &t
+	}
+
+	assert.NoError(t, validatePhases([]PhaseInput{
+		{StartAt: *at(2)},
+		{StartAt: *at(3), EndAt: at(6)},
+	}, now))
+	assert.NoError(t, validatePhases([]PhaseInput{
+		{StartAt: *at(2), EndAt: at(3)},
+		{StartAt: *at(3)},
+	}, now))
+
+	assert.ErrorIs(t, validatePhases(nil, now), ErrInvalidSchedule)
+	assert.ErrorIs(t, validatePhases([]PhaseInput{{StartAt: now}}, now), ErrPhaseInPast)
+	// Out of order
+	assert.ErrorIs(t, validatePhases([]PhaseInput{{StartAt: *at(3)}, {StartAt: *at(2)}}, now), ErrInvalidSchedule)
+	// A gap between phases
+	assert.ErrorIs(t, validatePhases([]PhaseInput{{StartAt: *at(2), EndAt: at(3)}, {StartAt: *at(4)}}, now), ErrInvalidSchedule)
+	// Ending before it starts
+	assert.ErrorIs(t, validatePhases([]PhaseInput{{StartAt: *at(3), EndAt: at(2)}}, now), ErrInvalidSchedule)
+}
@@ -0,0 +1,368 @@
+package billing
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/ledger"
+)
+
+const day = 24 * time.Hour
+
+// servicePeriod is what an invoice pays for. The zero value means the
+// charge is earned when invoiced.
+type servicePeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// days returns the period as whole UTC days, or false if it's shorter than
+// a day
+func (p servicePeriod) days() (time.Time, time.Time, bool) {
+	start, end := utcDay(p.Start), utcDay(p.End)
+	return start, end, end.After(start)
+}
+
+// RevenueSchedule spreads one invoice line over its service period.
+// RecognizedThrough is the day recognition has run up to, exclusive.
+type RevenueSchedule struct {
+	ID                string                `json:"id"`
+	InvoiceID         string                `json:"invoice_id"`
+	InvoiceLineID     string                `json:"invoice_line_id"`
+	Currency          string                `json:"currency"`
+	AmountCents       int                   `json:"amount_cents"`
+	PeriodStart       time.Time             `json:"period_start"`
+	PeriodEnd         time.Time             `json:"period_end"`
+	RecognizedThrough time.Time             `json:"recognized_through"`
+	Items             []RevenueScheduleItem `json:"items"`
+}
+
+// RevenueScheduleItem is the part of a schedule falling in one calendar
+// month
+type RevenueScheduleItem struct {
+	ID              string    `json:"id"`
+	PeriodStart     time.Time `json:"period_start"`
+	PeriodEnd       time.Time `json:"period_end"`
+	AmountCents     int       `json:"amount_cents"`
+	RecognizedCents int       `json:"recognized_cents"`
+}
+
+// ListRevenueSchedules returns the recognition schedules of an invoice
+func (s *BillingService) ListRevenueSchedules(orgID, invoiceID string) ([]RevenueSchedule, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM invoices i
+			JOIN subscriptions s ON s.id = i.subscription_id
+			WHERE i.id::text = $1 AND s.org_id = $2
+		)
+	`, invoiceID, orgID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrInvoiceNotFound
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, invoice_id, invoice_line_id, currency, amount_cents, period_start, period_end, recognized_through
+		FROM revenue_schedules
+		WHERE invoice_id = $1
+		ORDER BY created_at, id
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []RevenueSchedule{}
+	for rows.Next() {
+		var rs RevenueSchedule
+		if err := rows.Scan(&rs.ID, &rs.InvoiceID, &rs.InvoiceLineID, &rs.Currency, &rs.AmountCents,
+			&rs.PeriodStart, &rs.PeriodEnd, &rs.RecognizedThrough); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, rs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range schedules {
+		items, err := scheduleItemsOf(s.db, schedules[i].ID, false)
+		if err != nil {
+			return nil, err
+		}
+		schedules[i].Items = items
+	}
+	return schedules, nil
+}
+
+// RecognizeRevenue posts the revenue earned up to asOf on every schedule
+// and returns how many schedules it moved forward. It is safe to run from
+// several instances at once.
+func (s *BillingService) RecognizeRevenue(asOf time.Time) (int, error) {
+	recognized := 0
+	for {
+		ok, err := s.recognizeNext(utcDay(asOf))
+		if err != nil {
+			return recognized, err
+		}
+		if !ok {
+			return recognized, nil
+		}
+		recognized++
+	}
+}
+
+// recognizeNext recognizes one schedule that is behind, reporting false
+// when none is left
+func (s *BillingService) recognizeNext(through time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var rs RevenueSchedule
+	var orgID string
+	err = tx.QueryRow(`
+		SELECT id, org_id, invoice_id, currency, period_end
+		FROM revenue_schedules
+		WHERE recognized_through < period_end AND recognized_through < $1
+		ORDER BY recognized_through
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, dateParam(through)).Scan(&rs.ID, &orgID, &rs.InvoiceID, &rs.Currency, &rs.PeriodEnd)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	items, err := scheduleItemsOf(tx, rs.ID, true)
+	if err != nil {
+		return false, err
+	}
+
+	total := 0
+	for _, item := range items {
+		due := recognizedBy(item, through) - item.RecognizedCents
+		if due <= 0 {
+			continue
+		}
+		_, err = tx.Exec(`
+			UPDATE revenue_schedule_items SET recognized_cents = recognized_cents + $2 WHERE id = $1
+		`, item.ID, due)
+		if err != nil {
+			return false, err
+		}
+		total += due
+	}
+
+	err = ledger.Post(tx, ledger.Entry{
+		OrgID:         orgID,
+		Currency:      rs.Currency,
+		Type:          "revenue.recognized",
+		ReferenceType: "revenue_schedule",
+		ReferenceID:   rs.ID,
+		Description:   fmt.Sprintf("Revenue for invoice %s through %s", rs.InvoiceID, dateParam(through)),
+		Lines:         recognitionLines(total),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if through.After(rs.PeriodEnd) {
+		through = rs.PeriodEnd
+	}
+	_, err = tx.Exec(`
+		UPDATE revenue_schedules SET recognized_through = $2 WHERE id = $1
+	`, rs.ID, dateParam(through))
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// createRevenueSchedule defers an invoice line's amount over its service
+// period
+func createRevenueSchedule(tx *sql.Tx, orgID, invoiceID, lineID, currency string, amountCents int, start, end time.Time) error {
+	var scheduleID string
+	err := tx.QueryRow(`
+		INSERT INTO revenue_schedules
+			(org_id, invoice_id, invoice_line_id, currency, amount_cents, period_start, period_end, recognized_through)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $6)
+		RETURNING id
+	`, orgID, invoiceID, lineID, currency, amountCents, dateParam(start), dateParam(end)).Scan(&scheduleID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range scheduleItems(amountCents, start, end) {
+		_, err = tx.Exec(`
+			INSERT INTO revenue_schedule_items (schedule_id, period_start, period_end, amount_cents)
+			VALUES ($1, $2, $3, $4)
+		`, scheduleID, dateParam(item.PeriodStart), dateParam(item.PeriodEnd), item.AmountCents)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseDeferredRevenue takes up to amountCents of a credited invoice out
+// of its revenue not yet recognized, latest months first, and returns how
+// much it took. The rest of the credit comes out of recognized revenue.
+func releaseDeferredRevenue(tx *sql.Tx, invoiceID string, amountCents int) (int, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM revenue_schedules WHERE invoice_id = $1 ORDER BY id FOR UPDATE
+	`, invoiceID)
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	items := []RevenueScheduleItem{}
+	for _, id := range ids {
+		scheduled, err := scheduleItemsOf(tx, id, true)
+		if err != nil {
+			return 0, err
+		}
+		items = append(items, scheduled...)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].PeriodStart.Before(items[j].PeriodStart) })
+
+	released := releaseItems(items, amountCents)
+	for _, r := range released {
+		_, err = tx.Exec(`
+			UPDATE revenue_schedule_items SET amount_cents = amount_cents - $2 WHERE id = $1
+		`, r.ID, r.AmountCents)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	for _, r := range released {
+		total += r.AmountCents
+	}
+	return total, nil
+}
+
+func scheduleItemsOf(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, scheduleID string, lock bool) ([]RevenueScheduleItem, error) {
+	query := `
+		SELECT id, period_start, period_end, amount_cents, recognized_cents
+		FROM revenue_schedule_items
+		WHERE schedule_id = $1
+		ORDER BY period_start`
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	rows, err := q.Query(query, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []RevenueScheduleItem{}
+	for rows.Next() {
+		var item RevenueScheduleItem
+		if err := rows.Scan(&item.ID, &item.PeriodStart, &item.PeriodEnd, &item.AmountCents, &item.RecognizedCents); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// scheduleItems splits amountCents over the calendar months from start to
+// end by the number of days in each. Rounding leftovers go to the last
+// month.
+func scheduleItems(amountCents int, start, end time.Time) []RevenueScheduleItem {
+	totalDays := daysBetween(start, end)
+	items := []RevenueScheduleItem{}
+	allocated := 0
+	for from := start; from.Before(end); {
+		to := time.Date(from.Year(), from.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if to.After(end) {
+			to = end
+		}
+		cumulative := amountCents * daysBetween(start, to) / totalDays
+		items = append(items, RevenueScheduleItem{
+			PeriodStart: from,
+			PeriodEnd:   to,
+			AmountCents: cumulative - allocated,
+		})
+		allocated = cumulative
+		from = to
+	}
+	return items
+}
+
+// recognizedBy is how much of the item has been earned by the start of the
+// day through: a share for each day that has passed
+func recognizedBy(item RevenueScheduleItem, through time.Time) int {
+	days := daysBetween(item.PeriodStart, item.PeriodEnd)
+	elapsed := daysBetween(item.PeriodStart, through)
+	switch {
+	case elapsed <= 0:
+		return 0
+	case elapsed >= days:
+		return item.AmountCents
+	}
+	return item.AmountCents * elapsed / days
+}
+
+// releaseItems picks what to take off each item for a credit of
+// amountCents, latest months first, never touching what's recognized. The
+// returned items carry the amount to take off.
+func releaseItems(items []RevenueScheduleItem, amountCents int) []RevenueScheduleItem {
+	released := []RevenueScheduleItem{}
+	for i := len(items) - 1; i >= 0 && amountCents > 0; i-- {
+		take := items[i].AmountCents - items[i].RecognizedCents
+		if take > amountCents {
+			take = amountCents
+		}
+		if take <= 0 {
+			continue
+		}
+		released = append(released, RevenueScheduleItem{ID: items[i].ID, AmountCents: take})
+		amountCents -= take
+	}
+	return released
+}
+
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// dateParam formats a day for a DATE column, so the session time zone
+// can't shift it
+func dateParam(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from) / day)
+}
@@ -0,0 +1,63 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestScheduleItems(t *testing.T) {
+	// An annual plan starting mid-January: 17 + 28 + ... + 14 days
+	items := scheduleItems(36500, date(2025, 1, 15), date(2026, 1, 15))
+
+	assert.Len(t, items, 13)
+	assert.Equal(t, date(2025, 1, 15), items[0].PeriodStart)
+	assert.Equal(t, date(2025, 2, 1), items[0].PeriodEnd)
+	assert.Equal(t, 1700, items[0].AmountCents)
+	assert.Equal(t, 2800, items[1].AmountCents)
+	assert.Equal(t, date(2026, 1, 1), items[12].PeriodStart)
+	assert.Equal(t, 1400, items[12].AmountCents)
+
+	total := 0
+	for _, item := range items {
+		total += item.AmountCents
+	}
+	assert.Equal(t, 36500, total)
+}
+
+func TestScheduleItemsRounding(t *testing.T) {
+	items := scheduleItems(1000, date(2025, 3, 1), date(2025, 6, 1))
+
+	assert.Equal(t, []int{336, 327, 337}, []int{items[0].AmountCents, items[1].AmountCents, items[2].AmountCents})
+}
+
+func TestRecognizedBy(t *testing.T) {
+	item := RevenueScheduleItem{PeriodStart: date(2025, 4, 1), PeriodEnd: date(2025, 5, 1), AmountCents: 3000}
+
+	assert.Equal(t, 0, recognizedBy(item, date(2025, 3, 20)))
+	assert.Equal(t, 0, recognizedBy(item, date(2025, 4, 1)))
+	assert.Equal(t, 100, recognizedBy(item, date(2025, 4, 2)))
+	assert.Equal(t, 1500, recognizedBy(item, date(2025, 4, 16)))
+	assert.Equal(t, 3000, recognizedBy(item, date(2025, 5, 1)))
+	assert.Equal(t, 3000, recognizedBy(item, date(2025, 9, 1)))
+}
+
+func TestReleaseItems(t *testing.T) {
+	items := []RevenueScheduleItem{
+		{ID: "jan", AmountCents: 1000, RecognizedCents: 1000},
+		{ID: "feb", AmountCents: 1000, RecognizedCents: 400},
+		{ID: "mar", AmountCents: 1000},
+	}
+
+	released := releaseItems(items, 1500)
+	assert.Equal(t, []RevenueScheduleItem{{ID: "mar", AmountCents: 1000}, {ID: "feb", AmountCents: 500}}, released)
+
+	// Never more than what's left to recognize
+	released = releaseItems(items, 5000)
+	assert.Equal(t, []RevenueScheduleItem{{ID: "mar", AmountCents: 1000}, {ID: "feb", AmountCents: 600}}, released)
+}
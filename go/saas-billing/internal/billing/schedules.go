@@ -0,0 +1,319 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+var (
+	ErrNoActiveSubscription = errors.New("organization has no active subscription")
+	ErrScheduleNotFound     = errors.New("subscription schedule not found")
+	ErrInvalidSchedule      = errors.New("phases must be in order, each ending where the next starts; only the last may end the subscription")
+	ErrPhaseInPast          = errors.New("phases not yet started must start in the future")
+	ErrInvalidQuantity      = errors.New("quantity must be at least 1")
+	ErrScheduleWhilePaused  = errors.New("resume the subscription before scheduling changes to it")
+)
+
+// SubscriptionSchedule is a sequence of changes to a subscription. The
+// renewal worker starts each phase at its StartAt; until then it can be
+// replaced. A schedule is completed once its last phase has started, or
+// ended if it has an end.
+type SubscriptionSchedule struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Status         string          `json:"status"`
+	Phases         []SchedulePhase `json:"phases"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// SchedulePhase puts the subscription on a plan, quantity and coupon from
+// StartAt. Only the last phase's EndAt is set by the caller: the
+// subscription is canceled then.
+type SchedulePhase struct {
+	ID         string     `json:"id"`
+	PlanID     string     `json:"plan_id"`
+	Quantity   int        `json:"quantity"`
+	CouponCode *string    `json:"coupon_code,omitempty"`
+	StartAt    time.Time  `json:"start_at"`
+	EndAt      *time.Time `json:"end_at,omitempty"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+}
+
+// PhaseInput is a phase to schedule. Quantity defaults to 1; EndAt may only
+// be set on the last phase, or to where the next one starts.
+type PhaseInput struct {
+	PlanID     string     `json:"plan_id" binding:"required"`
+	Quantity   int        `json:"quantity"`
+	CouponCode string     `json:"coupon_code"`
+	StartAt    time.Time  `json:"start_at" binding:"required"`
+	EndAt      *time.Time `json:"end_at"`
+}
+
+// GetSchedule returns the organization's active schedule, with the phases
+// already applied
+func (s *BillingService) GetSchedule(orgID string) (*SubscriptionSchedule, error) {
+	var subscriptionID string
+	err := s.db.QueryRow(`
+		SELECT id FROM subscriptions WHERE org_id = $1 AND status IN ('active', 'paused')
+	`, orgID).Scan(&subscriptionID)
+	if err == sql.ErrNoRows {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := activeSchedule(s.db, subscriptionID, false)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, ErrScheduleNotFound
+	}
+	return schedule, nil
+}
+
+// SaveSchedule replaces the phases of the organization's schedule that
+// haven't started with phases, creating the schedule if there is none.
+// Every phase must start in the future.
+func (s *BillingService) SaveSchedule(orgID string, phases []PhaseInput, actor audit.Actor) (*SubscriptionSchedule, error) {
+	for i := range phases {
+		if phases[i].Quantity == 0 {
+			phases[i].Quantity = 1
+		}
+		if phases[i].Quantity < 0 {
+			return nil, ErrInvalidQuantity
+		}
+		phases[i].CouponCode = strings.ToUpper(strings.TrimSpace(phases[i].CouponCode))
+	}
+	if err := validatePhases(phases, time.Now()); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization first, as the renewal worker does
+	var currency sql.NullString
+	err = tx.QueryRow(`
+		SELECT billing_currency FROM organizations WHERE id = $1 FOR UPDATE
+	`, orgID).Scan(&currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// The renewal worker doesn't apply phases while the subscription is
+	// paused
+	var subscriptionID, status string
+	err = tx.QueryRow(`
+		SELECT id, status FROM subscriptions WHERE org_id = $1 AND status IN ('active', 'paused') FOR UPDATE
+	`, orgID).Scan(&subscriptionID, &status)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoActiveSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == "paused" {
+		return nil, ErrScheduleWhilePaused
+	}
+
+	// Check every phase can be billed now rather than when it starts
+	for _, p := range phases {
+		if _, err := chargeFor(tx, p.PlanID, currency.String, p.Quantity, couponParam(p.CouponCode)); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := activeSchedule(tx, subscriptionID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var before map[string]interface{}
+	scheduleID := ""
+	if existing != nil {
+		before = map[string]interface{}{"phases": existing.Phases}
+		scheduleID = existing.ID
+		_, err = tx.Exec(`
+			DELETE FROM subscription_schedule_phases WHERE schedule_id = $1 AND applied_at IS NULL
+		`, scheduleID)
+		if err != nil {
+			return nil, err
+		}
+		// The phase in progress now runs until the first new one
+		_, err = tx.Exec(`
+			UPDATE subscription_schedule_phases SET end_at = $2
+			WHERE schedule_id = $1 AND start_at = (
+				SELECT MAX(start_at) FROM subscription_schedule_phases WHERE schedule_id = $1
+			)
+		`, scheduleID, phases[0].StartAt)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE subscription_schedules SET updated_at = NOW() WHERE id = $1`, scheduleID)
+	} else {
+		err = tx.QueryRow(`
+			INSERT INTO subscription_schedules (org_id, subscription_id, created_by)
+			VALUES ($1, $2, NULLIF($3, '')::uuid)
+			RETURNING id
+		`, orgID, subscriptionID, actor.UserID).Scan(&scheduleID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range phases {
+		endAt := p.EndAt
+		if i+1 < len(phases) {
+			endAt = &phases[i+1].StartAt
+		}
+		_, err = tx.Exec(`
+			INSERT INTO subscription_schedule_phases (schedule_id, plan_id, quantity, coupon_code, start_at, end_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, scheduleID, p.PlanID, p.Quantity, couponParam(p.CouponCode), p.StartAt, endAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	schedule, err := activeSchedule(tx, subscriptionID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "subscription_schedule.updated",
+		TargetType: "subscription_schedule",
+		TargetID:   schedule.ID,
+		Before:     before,
+		After:      map[string]interface{}{"phases": schedule.Phases},
+	})
+	return schedule, nil
+}
+
+// CancelSchedule drops the phases of the organization's schedule that
+// haven't started. The subscription stays as it is and renews as usual.
+func (s *BillingService) CancelSchedule(orgID string, actor audit.Actor) error {
+	var scheduleID string
+	err := s.db.QueryRow(`
+		UPDATE subscription_schedules SET status = 'canceled', updated_at = NOW()
+		WHERE org_id = $1 AND status = 'active'
+		RETURNING id
+	`, orgID).Scan(&scheduleID)
+	if err == sql.ErrNoRows {
+		return ErrScheduleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "subscription_schedule.canceled",
+		TargetType: "subscription_schedule",
+		TargetID:   scheduleID,
+	})
+	return nil
+}
+
+// validatePhases checks that phases start after notBefore, in order, and
+// that only the last one ends the subscription
+func validatePhases(phases []PhaseInput, notBefore time.Time) error {
+	if len(phases) == 0 {
+		return ErrInvalidSchedule
+	}
+	if !phases[0].StartAt.After(notBefore) {
+		return ErrPhaseInPast
+	}
+	for i, p := range phases {
+		last := i == len(phases)-1
+		if !last && !phases[i+1].StartAt.After(p.StartAt) {
+			return ErrInvalidSchedule
+		}
+		if p.EndAt == nil {
+			continue
+		}
+		if !last && !p.EndAt.Equal(phases[i+1].StartAt) {
+			return ErrInvalidSchedule
+		}
+		if !p.EndAt.After(p.StartAt) {
+			return ErrInvalidSchedule
+		}
+	}
+	return nil
+}
+
+// activeSchedule returns the subscription's active schedule with its phases
+// in order, or nil if it has none
+func activeSchedule(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, subscriptionID string, lock bool) (*SubscriptionSchedule, error) {
+	query := `
+		SELECT id, subscription_id, status, created_at, updated_at
+		FROM subscription_schedules
+		WHERE subscription_id = $1 AND status = 'active'`
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	var sc SubscriptionSchedule
+	err := q.QueryRow(query, subscriptionID).Scan(&sc.ID, &sc.SubscriptionID, &sc.Status, &sc.CreatedAt, &sc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT id, plan_id, quantity, coupon_code, start_at, end_at, applied_at
+		FROM subscription_schedule_phases
+		WHERE schedule_id = $1
+		ORDER BY start_at
+	`, sc.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sc.Phases = []SchedulePhase{}
+	for rows.Next() {
+		var p SchedulePhase
+		if err := rows.Scan(&p.ID, &p.PlanID, &p.Quantity, &p.CouponCode, &p.StartAt, &p.EndAt, &p.AppliedAt); err != nil {
+			return nil, err
+		}
+		sc.Phases = append(sc.Phases, p)
+	}
+	return &sc, rows.Err()
+}
+
+func completeSchedule(tx *sql.Tx, scheduleID string) error {
+	_, err := tx.Exec(`
+		UPDATE subscription_schedules SET status = 'completed', updated_at = NOW() WHERE id = $1
+	`, scheduleID)
+	return err
+}
+
+func couponParam(code string) *string {
+	if code == "" {
+		return nil
+	}
+	return &code
+}
@@ -0,0 +1,47 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetScheduleOfPausedSubscription(t *testing.T) {
+	s, mock := newBillingMock(t)
+	start := time.Now().AddDate(0, 1, 0)
+
+	mock.ExpectQuery(`SELECT id FROM subscriptions WHERE org_id = \$1 AND status IN \('active', 'paused'\)`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("sub-1"))
+	mock.ExpectQuery(`FROM subscription_schedules`).WithArgs("sub-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "status", "created_at", "updated_at"}).
+			AddRow("schedule-1", "sub-1", "active", time.Now(), time.Now()))
+	mock.ExpectQuery(`FROM subscription_schedule_phases`).WithArgs("schedule-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "plan_id", "quantity", "coupon_code", "start_at", "end_at", "applied_at"}).
+			AddRow("phase-1", "plan-2", 1, nil, start, nil, nil))
+
+	schedule, err := s.GetSchedule("org-1")
+	require.NoError(t, err)
+	require.Len(t, schedule.Phases, 1)
+	assert.Equal(t, "plan-2", schedule.Phases[0].PlanID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveScheduleWhilePaused(t *testing.T) {
+	s, mock := newBillingMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT billing_currency FROM organizations`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"billing_currency"}).AddRow("USD"))
+	mock.ExpectQuery(`SELECT id, status FROM subscriptions WHERE org_id = \$1 AND status IN \('active', 'paused'\) FOR UPDATE`).
+		WithArgs("org-1").WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow("sub-1", "paused"))
+	mock.ExpectRollback()
+
+	phases := []PhaseInput{{PlanID: "plan-2", StartAt: time.Now().AddDate(0, 1, 0)}}
+	_, err := s.SaveSchedule("org-1", phases, audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrScheduleWhilePaused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
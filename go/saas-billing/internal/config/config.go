@@ -0,0 +1,13 @@
+// Package config holds settings read from the environment by more than one
+// package
+package config
+
+import "os"
+
+// AppURL is the base URL of the web app, used to build links sent by email
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
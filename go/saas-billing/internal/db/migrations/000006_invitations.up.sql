@@ -0,0 +1,18 @@
+-- Seats included in a plan; NULL means unlimited
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS max_seats INTEGER;
+
+-- Pending and answered invitations to join an organization
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL,
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- At most one open invitation per address and organization
+CREATE UNIQUE INDEX idx_invitations_org_id_email_pending ON invitations(org_id, lower(email)) WHERE status = 'pending';
@@ -0,0 +1,25 @@
+-- Who receives billing email for the organization; defaults to the creator
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS billing_contact_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+UPDATE organizations o SET billing_contact_id = (
+    SELECT m.user_id FROM memberships m
+    WHERE m.org_id = o.id AND m.role = 'owner'
+    ORDER BY m.created_at
+    LIMIT 1
+) WHERE billing_contact_id IS NULL;
+
+-- Two-step ownership handover: an owner proposes, the target accepts
+CREATE TABLE IF NOT EXISTS ownership_transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    from_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    move_billing_contact BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_ownership_transfers_org_id_pending ON ownership_transfers(org_id) WHERE status = 'pending';
@@ -0,0 +1,34 @@
+-- Subsidiaries point at the organization that pays their bill
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES organizations(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_organizations_parent_id ON organizations(parent_id);
+
+-- One bill at the parent covering its own and its children's invoices
+CREATE TABLE IF NOT EXISTS consolidated_invoices (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    parent_org_id UUID NOT NULL REFERENCES organizations(id),
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    amount_cents INTEGER NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'unpaid',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_consolidated_invoices_parent_org_id ON consolidated_invoices(parent_org_id);
+
+-- Subscription lines point at the rolled-up invoice; usage lines carry a
+-- metric and quantity for reference
+CREATE TABLE IF NOT EXISTS consolidated_invoice_lines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    consolidated_invoice_id UUID NOT NULL REFERENCES consolidated_invoices(id) ON DELETE CASCADE,
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    description TEXT NOT NULL,
+    invoice_id UUID REFERENCES invoices(id),
+    metric VARCHAR(255),
+    quantity BIGINT,
+    amount_cents INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS consolidated_invoice_id UUID REFERENCES consolidated_invoices(id);
@@ -0,0 +1,15 @@
+-- Deleted organizations stay restorable until purge_after. Purging removes
+-- members, keys, usage and settings but keeps the organization row, its
+-- subscriptions and its invoices for accounting, so none of the cascades
+-- from organizations ever fire.
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS purged_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_organizations_purge_after
+    ON organizations(purge_after) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
+
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS canceled_at TIMESTAMP WITH TIME ZONE;
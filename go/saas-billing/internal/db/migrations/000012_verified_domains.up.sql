@@ -0,0 +1,36 @@
+-- Registered emails are unverified until the user follows the emailed link.
+-- Invitations and SSO prove ownership on their own.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS org_domains (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    domain VARCHAR(253) NOT NULL,
+    verification_token TEXT NOT NULL,
+    verified_at TIMESTAMP WITH TIME ZONE,
+    join_policy VARCHAR(20) NOT NULL DEFAULT 'off' CHECK (join_policy IN ('off', 'auto', 'request')),
+    default_role VARCHAR(50) NOT NULL DEFAULT 'member',
+    enforce_sso BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (org_id, domain)
+);
+
+-- Any number of organizations can try to verify a domain; only one can hold it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_domains_verified
+    ON org_domains(domain) WHERE verified_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS join_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    responded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_join_requests_pending
+    ON join_requests(org_id, user_id) WHERE status = 'pending';
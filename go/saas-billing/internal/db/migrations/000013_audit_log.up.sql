@@ -0,0 +1,25 @@
+-- Audit entries outlive the users, keys and organizations they mention, so
+-- the references are plain IDs rather than foreign keys
+ALTER TABLE audit_events
+    DROP CONSTRAINT IF EXISTS audit_events_org_id_fkey,
+    DROP CONSTRAINT IF EXISTS audit_events_actor_user_id_fkey,
+    ADD COLUMN IF NOT EXISTS actor_api_key_id UUID,
+    ADD COLUMN IF NOT EXISTS before JSONB,
+    ADD COLUMN IF NOT EXISTS after JSONB,
+    ADD COLUMN IF NOT EXISTS user_agent TEXT,
+    ADD COLUMN IF NOT EXISTS request_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_org_id_action ON audit_events(org_id, action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_org_id_actor ON audit_events(org_id, actor_user_id);
+
+-- The log is append-only
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,73 @@
+-- Platform operators manage global settings such as tax rates
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS is_platform_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Where an organization is billed, which decides the tax it pays
+CREATE TABLE IF NOT EXISTS billing_profiles (
+    org_id UUID PRIMARY KEY REFERENCES organizations(id),
+    legal_name TEXT NOT NULL DEFAULT '',
+    line1 TEXT NOT NULL DEFAULT '',
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    region TEXT NOT NULL DEFAULT '',
+    postal_code TEXT NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL,
+    tax_id TEXT,
+    tax_id_valid BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Rates without a region apply to the whole country
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    country CHAR(2) NOT NULL,
+    region TEXT,
+    name TEXT NOT NULL,
+    rate_bps INTEGER NOT NULL CHECK (rate_bps BETWEEN 0 AND 10000),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    deactivated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_rates_active
+    ON tax_rates(country, COALESCE(region, ''), name) WHERE active;
+
+-- Whether plan prices already include tax
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- amount_cents stays the total due
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS subtotal_cents INTEGER,
+    ADD COLUMN IF NOT EXISTS tax_cents INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS customer_tax_id TEXT;
+
+UPDATE invoices SET subtotal_cents = amount_cents WHERE subtotal_cents IS NULL;
+ALTER TABLE invoices ALTER COLUMN subtotal_cents SET NOT NULL;
+
+-- Line amounts exclude tax
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    amount_cents INTEGER NOT NULL,
+    tax_cents INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice_id ON invoice_lines(invoice_id);
+
+-- Tax charged per rate, copied so later rate changes don't alter issued invoices
+CREATE TABLE IF NOT EXISTS invoice_tax_lines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    jurisdiction TEXT NOT NULL,
+    rate_bps INTEGER NOT NULL,
+    taxable_cents INTEGER NOT NULL,
+    amount_cents INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_tax_lines_invoice_id ON invoice_tax_lines(invoice_id);
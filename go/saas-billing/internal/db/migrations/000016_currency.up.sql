@@ -0,0 +1,42 @@
+-- Plans are priced separately in each currency they're sold in. Amounts are
+-- in the currency's minor unit (cents, yen, fils).
+CREATE TABLE IF NOT EXISTS plan_prices (
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE CASCADE,
+    currency CHAR(3) NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (plan_id, currency)
+);
+
+-- Existing prices were in US dollars
+INSERT INTO plan_prices (plan_id, currency, amount)
+SELECT id, 'USD', price_cents FROM plans
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE plans DROP COLUMN IF EXISTS price_cents;
+
+-- Set by the organization's first subscription and never changed after
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS billing_currency CHAR(3);
+
+UPDATE organizations o SET billing_currency = 'USD'
+WHERE billing_currency IS NULL
+  AND EXISTS (SELECT 1 FROM subscriptions s WHERE s.org_id = o.id);
+
+-- The *_cents columns hold minor units of this currency
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE invoices ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE invoice_lines
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE invoice_lines ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE invoice_tax_lines
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE invoice_tax_lines ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE consolidated_invoices
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE consolidated_invoices ALTER COLUMN currency DROP DEFAULT;
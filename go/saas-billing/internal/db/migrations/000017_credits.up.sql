@@ -0,0 +1,50 @@
+-- What's left to collect on an invoice after customer credit and credit
+-- notes. amount_cents stays the invoice total.
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS credit_applied_cents INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS credited_cents INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS amount_due_cents INTEGER;
+
+UPDATE invoices SET amount_due_cents = CASE WHEN status = 'paid' THEN 0 ELSE amount_cents END
+WHERE amount_due_cents IS NULL;
+ALTER TABLE invoices ALTER COLUMN amount_due_cents SET NOT NULL;
+
+-- Credit notes reduce a finalized invoice. Whatever isn't needed to cover
+-- the amount still due is credited to the customer's balance.
+CREATE TABLE IF NOT EXISTS credit_notes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_id UUID NOT NULL REFERENCES invoices(id),
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    currency CHAR(3) NOT NULL,
+    amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+    tax_cents INTEGER NOT NULL DEFAULT 0,
+    -- Split of amount_cents between the invoice and the credit balance
+    invoice_cents INTEGER NOT NULL DEFAULT 0,
+    balance_cents INTEGER NOT NULL DEFAULT 0,
+    reason VARCHAR(50) NOT NULL CHECK (reason IN ('duplicate', 'fraudulent', 'order_change', 'product_unsatisfactory', 'service_issue', 'other')),
+    memo TEXT NOT NULL DEFAULT '',
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_notes_invoice_id ON credit_notes(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_credit_notes_org_id ON credit_notes(org_id, created_at);
+
+-- Append-only history of the customer credit balance. Positive amounts add
+-- credit, negative ones use it up; balance_cents is the balance afterwards.
+CREATE TABLE IF NOT EXISTS credit_balance_transactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    currency CHAR(3) NOT NULL,
+    type VARCHAR(50) NOT NULL CHECK (type IN ('adjustment', 'credit_note', 'applied_to_invoice')),
+    amount_cents INTEGER NOT NULL,
+    balance_cents INTEGER NOT NULL CHECK (balance_cents >= 0),
+    description TEXT NOT NULL DEFAULT '',
+    invoice_id UUID REFERENCES invoices(id),
+    credit_note_id UUID REFERENCES credit_notes(id),
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_balance_transactions_org_id
+    ON credit_balance_transactions(org_id, created_at);
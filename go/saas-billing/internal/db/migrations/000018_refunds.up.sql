@@ -0,0 +1,42 @@
+-- Money captured against an invoice. Refunds can't exceed it.
+CREATE TABLE IF NOT EXISTS invoice_payments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_id UUID NOT NULL REFERENCES invoices(id),
+    currency CHAR(3) NOT NULL,
+    amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+    provider_payment_id TEXT NOT NULL DEFAULT '',
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_payments_invoice_id ON invoice_payments(invoice_id);
+
+-- Succeeded refunds only; pending ones are in the refunds table
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS refunded_cents INTEGER NOT NULL DEFAULT 0;
+
+-- The part of a credit note paid back to the customer
+ALTER TABLE credit_notes
+    ADD COLUMN IF NOT EXISTS refund_cents INTEGER NOT NULL DEFAULT 0;
+
+-- A refund is pending while the provider is called. Pending refunds hold
+-- their amount so concurrent refunds can't exceed what was captured.
+CREATE TABLE IF NOT EXISTS refunds (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_id UUID NOT NULL REFERENCES invoices(id),
+    payment_id UUID NOT NULL REFERENCES invoice_payments(id),
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    currency CHAR(3) NOT NULL,
+    amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+    reason VARCHAR(50) NOT NULL CHECK (reason IN ('duplicate', 'fraudulent', 'order_change', 'product_unsatisfactory', 'service_issue', 'other')),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    failure_reason TEXT NOT NULL DEFAULT '',
+    provider_refund_id TEXT NOT NULL DEFAULT '',
+    credit_note_id UUID REFERENCES credit_notes(id),
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_org_id ON refunds(org_id, created_at);
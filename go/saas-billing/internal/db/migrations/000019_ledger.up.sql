@@ -0,0 +1,62 @@
+-- Double-entry ledger. Every entry's lines balance: the debits equal the
+-- credits. Amounts are minor units of the entry's currency.
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    seq BIGSERIAL UNIQUE,
+    org_id UUID NOT NULL,
+    currency CHAR(3) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    reference_type VARCHAR(50) NOT NULL,
+    reference_id UUID NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_created_at ON ledger_entries(created_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_reference ON ledger_entries(reference_type, reference_id);
+
+CREATE TABLE IF NOT EXISTS ledger_lines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    entry_id UUID NOT NULL REFERENCES ledger_entries(id),
+    account VARCHAR(50) NOT NULL CHECK (account IN (
+        'accounts_receivable', 'cash', 'revenue', 'deferred_revenue', 'tax_payable', 'customer_credit'
+    )),
+    debit_cents BIGINT NOT NULL DEFAULT 0 CHECK (debit_cents >= 0),
+    credit_cents BIGINT NOT NULL DEFAULT 0 CHECK (credit_cents >= 0),
+    CHECK ((debit_cents = 0) <> (credit_cents = 0))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_lines_entry_id ON ledger_lines(entry_id);
+
+-- Mistakes are corrected with a new entry, never by editing old ones
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON ledger_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION ledger_append_only();
+
+DROP TRIGGER IF EXISTS ledger_lines_append_only ON ledger_lines;
+CREATE TRIGGER ledger_lines_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON ledger_lines
+    FOR EACH STATEMENT EXECUTE FUNCTION ledger_append_only();
+
+-- Checked at commit, once all of an entry's lines are in
+CREATE OR REPLACE FUNCTION ledger_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(debit_cents) - SUM(credit_cents) FROM ledger_lines WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % does not balance', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_lines_balanced ON ledger_lines;
+CREATE CONSTRAINT TRIGGER ledger_lines_balanced
+    AFTER INSERT ON ledger_lines
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_entry_balanced();
@@ -0,0 +1,38 @@
+-- The period an invoice line pays for. Lines without one are recognized as
+-- revenue when invoiced.
+ALTER TABLE invoice_lines
+    ADD COLUMN IF NOT EXISTS service_period_start DATE,
+    ADD COLUMN IF NOT EXISTS service_period_end DATE;
+
+-- Spreads an invoice line's amount, excluding tax, over its service period.
+-- Dates are UTC days; the end is exclusive. recognized_through is the day
+-- recognition has run up to.
+CREATE TABLE IF NOT EXISTS revenue_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    invoice_id UUID NOT NULL REFERENCES invoices(id),
+    invoice_line_id UUID NOT NULL UNIQUE REFERENCES invoice_lines(id),
+    currency CHAR(3) NOT NULL,
+    amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL CHECK (period_end > period_start),
+    recognized_through DATE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revenue_schedules_invoice_id ON revenue_schedules(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_revenue_schedules_pending ON revenue_schedules(recognized_through)
+    WHERE recognized_through < period_end;
+
+-- One calendar month of a schedule, recognized day by day. Credit notes
+-- lower amount_cents of the months not yet recognized.
+CREATE TABLE IF NOT EXISTS revenue_schedule_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    schedule_id UUID NOT NULL REFERENCES revenue_schedules(id),
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL CHECK (period_end > period_start),
+    amount_cents INTEGER NOT NULL CHECK (amount_cents >= 0),
+    recognized_cents INTEGER NOT NULL DEFAULT 0 CHECK (recognized_cents >= 0),
+    CHECK (recognized_cents <= amount_cents),
+    UNIQUE (schedule_id, period_start)
+);
@@ -0,0 +1,101 @@
+-- Every state a subscription has been in. A trigger adds a version
+-- whenever the subscription row is inserted or changed, so no code path
+-- can skip it. effective_to is NULL for the current version.
+CREATE TABLE IF NOT EXISTS subscription_versions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+    org_id UUID NOT NULL,
+    version INTEGER NOT NULL,
+    change VARCHAR(50) NOT NULL,
+    plan_id UUID NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    -- The plan's price in the organization's currency when the version
+    -- took effect
+    currency CHAR(3),
+    price_cents INTEGER,
+    interval VARCHAR(20),
+    current_period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    current_period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    canceled_at TIMESTAMP WITH TIME ZONE,
+    effective_from TIMESTAMP WITH TIME ZONE NOT NULL,
+    effective_to TIMESTAMP WITH TIME ZONE,
+    UNIQUE (subscription_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_versions_org_id ON subscription_versions(org_id, effective_from);
+
+-- A new subscription used to leave the one it replaced active. End those
+-- when their successor started.
+UPDATE subscriptions s SET status = 'canceled', canceled_at = (
+    SELECT MIN(n.created_at) FROM subscriptions n
+    WHERE n.org_id = s.org_id AND n.created_at > s.created_at
+)
+WHERE s.status = 'active'
+  AND EXISTS (SELECT 1 FROM subscriptions n WHERE n.org_id = s.org_id AND n.created_at > s.created_at);
+
+-- Existing subscriptions start with what's known about them: when they
+-- began and, if canceled, when they ended
+INSERT INTO subscription_versions
+    (subscription_id, org_id, version, change, plan_id, status, currency, price_cents, interval,
+     current_period_start, current_period_end, canceled_at, effective_from, effective_to)
+SELECT s.id, s.org_id, 1, 'created', s.plan_id, 'active', o.billing_currency, pp.amount, p.interval,
+    s.current_period_start, s.current_period_end, NULL, s.created_at, s.canceled_at
+FROM subscriptions s
+JOIN organizations o ON o.id = s.org_id
+LEFT JOIN plans p ON p.id = s.plan_id
+LEFT JOIN plan_prices pp ON pp.plan_id = s.plan_id AND pp.currency = o.billing_currency
+WHERE NOT EXISTS (SELECT 1 FROM subscription_versions v WHERE v.subscription_id = s.id);
+
+INSERT INTO subscription_versions
+    (subscription_id, org_id, version, change, plan_id, status, currency, price_cents, interval,
+     current_period_start, current_period_end, canceled_at, effective_from)
+SELECT v.subscription_id, v.org_id, 2, 'canceled', v.plan_id, 'canceled', v.currency, v.price_cents, v.interval,
+    v.current_period_start, v.current_period_end, v.effective_to, v.effective_to
+FROM subscription_versions v
+WHERE v.version = 1 AND v.effective_to IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM subscription_versions w WHERE w.subscription_id = v.subscription_id AND w.version = 2);
+
+CREATE OR REPLACE FUNCTION subscription_versions_record() RETURNS trigger AS $$
+DECLARE
+    next_version INTEGER := 1;
+    change_type VARCHAR(50) := 'created';
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF (NEW.plan_id, NEW.status, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at)
+            IS NOT DISTINCT FROM (OLD.plan_id, OLD.status, OLD.current_period_start, OLD.current_period_end, OLD.canceled_at) THEN
+            RETURN NULL;
+        END IF;
+
+        change_type := CASE
+            WHEN NEW.status = 'canceled' AND OLD.status <> 'canceled' THEN 'canceled'
+            WHEN NEW.status <> OLD.status THEN 'status_changed'
+            WHEN NEW.plan_id <> OLD.plan_id THEN 'plan_changed'
+            WHEN NEW.current_period_end <> OLD.current_period_end THEN 'renewed'
+            ELSE 'updated'
+        END;
+
+        UPDATE subscription_versions SET effective_to = NOW()
+        WHERE subscription_id = NEW.id AND effective_to IS NULL;
+
+        SELECT COALESCE(MAX(version), 0) + 1 INTO next_version
+        FROM subscription_versions WHERE subscription_id = NEW.id;
+    END IF;
+
+    INSERT INTO subscription_versions
+        (subscription_id, org_id, version, change, plan_id, status, currency, price_cents, interval,
+         current_period_start, current_period_end, canceled_at, effective_from)
+    SELECT NEW.id, NEW.org_id, next_version, change_type, NEW.plan_id, NEW.status, o.billing_currency, pp.amount,
+        p.interval, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at, NOW()
+    FROM organizations o
+    LEFT JOIN plans p ON p.id = NEW.plan_id
+    LEFT JOIN plan_prices pp ON pp.plan_id = NEW.plan_id AND pp.currency = o.billing_currency
+    WHERE o.id = NEW.org_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS subscriptions_record_version ON subscriptions;
+CREATE TRIGGER subscriptions_record_version
+    AFTER INSERT OR UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION subscription_versions_record();
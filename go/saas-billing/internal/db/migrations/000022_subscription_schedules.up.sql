@@ -0,0 +1,97 @@
+-- Percentage discounts applied to a subscription's invoices while it
+-- carries the coupon
+CREATE TABLE IF NOT EXISTS coupons (
+    code VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    percent_off INTEGER NOT NULL CHECK (percent_off BETWEEN 1 AND 100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(50) REFERENCES coupons(code);
+
+-- Planned changes to a subscription. Each phase sets the plan, quantity and
+-- coupon from its start; the renewal worker applies it then and sets
+-- applied_at. Phases not yet applied can be replaced.
+CREATE TABLE IF NOT EXISTS subscription_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'completed', 'canceled')),
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscription_schedules_active
+    ON subscription_schedules(subscription_id) WHERE status = 'active';
+
+-- A phase ends where the next one starts. An end on the last phase cancels
+-- the subscription then; without one it carries on.
+CREATE TABLE IF NOT EXISTS subscription_schedule_phases (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    schedule_id UUID NOT NULL REFERENCES subscription_schedules(id),
+    plan_id UUID NOT NULL REFERENCES plans(id),
+    quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    coupon_code VARCHAR(50) REFERENCES coupons(code),
+    start_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_at TIMESTAMP WITH TIME ZONE,
+    applied_at TIMESTAMP WITH TIME ZONE,
+    CHECK (end_at IS NULL OR end_at > start_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_schedule_phases_pending
+    ON subscription_schedule_phases(start_at) WHERE applied_at IS NULL;
+
+-- Versions record the quantity and coupon too, and count changes to them
+ALTER TABLE subscription_versions
+    ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS percent_off INTEGER;
+
+CREATE OR REPLACE FUNCTION subscription_versions_record() RETURNS trigger AS $$
+DECLARE
+    next_version INTEGER := 1;
+    change_type VARCHAR(50) := 'created';
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF (NEW.plan_id, NEW.status, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at,
+            NEW.quantity, NEW.coupon_code)
+            IS NOT DISTINCT FROM (OLD.plan_id, OLD.status, OLD.current_period_start, OLD.current_period_end,
+            OLD.canceled_at, OLD.quantity, OLD.coupon_code) THEN
+            RETURN NULL;
+        END IF;
+
+        change_type := CASE
+            WHEN NEW.status = 'canceled' AND OLD.status <> 'canceled' THEN 'canceled'
+            WHEN NEW.status <> OLD.status THEN 'status_changed'
+            WHEN NEW.plan_id <> OLD.plan_id THEN 'plan_changed'
+            WHEN NEW.quantity <> OLD.quantity THEN 'quantity_changed'
+            WHEN NEW.coupon_code IS DISTINCT FROM OLD.coupon_code THEN 'coupon_changed'
+            WHEN NEW.current_period_end <> OLD.current_period_end THEN 'renewed'
+            ELSE 'updated'
+        END;
+
+        UPDATE subscription_versions SET effective_to = NOW()
+        WHERE subscription_id = NEW.id AND effective_to IS NULL;
+
+        SELECT COALESCE(MAX(version), 0) + 1 INTO next_version
+        FROM subscription_versions WHERE subscription_id = NEW.id;
+    END IF;
+
+    INSERT INTO subscription_versions
+        (subscription_id, org_id, version, change, plan_id, status, currency, price_cents, interval,
+         current_period_start, current_period_end, canceled_at, effective_from, quantity, coupon_code, percent_off)
+    SELECT NEW.id, NEW.org_id, next_version, change_type, NEW.plan_id, NEW.status, o.billing_currency, pp.amount,
+        p.interval, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at, NOW(),
+        NEW.quantity, NEW.coupon_code, c.percent_off
+    FROM organizations o
+    LEFT JOIN plans p ON p.id = NEW.plan_id
+    LEFT JOIN plan_prices pp ON pp.plan_id = NEW.plan_id AND pp.currency = o.billing_currency
+    LEFT JOIN coupons c ON c.code = NEW.coupon_code
+    WHERE o.id = NEW.org_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
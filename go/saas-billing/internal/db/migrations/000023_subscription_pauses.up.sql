@@ -0,0 +1,63 @@
+-- A paused subscription isn't billed, or is billed with void invoices, until
+-- it's resumed by hand or at resume_at
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS paused_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS resume_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS pause_behavior VARCHAR(20) CHECK (pause_behavior IN ('none', 'void'));
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_resume_at ON subscriptions(resume_at) WHERE status = 'paused';
+
+-- What a plan entitles an organization to, and what it keeps while paused.
+-- A NULL paused_max_seats keeps the plan's seat limit.
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS features TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS paused_features TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS paused_max_seats INTEGER CHECK (paused_max_seats >= 0);
+
+CREATE OR REPLACE FUNCTION subscription_versions_record() RETURNS trigger AS $$
+DECLARE
+    next_version INTEGER := 1;
+    change_type VARCHAR(50) := 'created';
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF (NEW.plan_id, NEW.status, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at,
+            NEW.quantity, NEW.coupon_code)
+            IS NOT DISTINCT FROM (OLD.plan_id, OLD.status, OLD.current_period_start, OLD.current_period_end,
+            OLD.canceled_at, OLD.quantity, OLD.coupon_code) THEN
+            RETURN NULL;
+        END IF;
+
+        change_type := CASE
+            WHEN NEW.status = 'canceled' AND OLD.status <> 'canceled' THEN 'canceled'
+            WHEN NEW.status = 'paused' AND OLD.status <> 'paused' THEN 'paused'
+            WHEN NEW.status = 'active' AND OLD.status = 'paused' THEN 'resumed'
+            WHEN NEW.status <> OLD.status THEN 'status_changed'
+            WHEN NEW.plan_id <> OLD.plan_id THEN 'plan_changed'
+            WHEN NEW.quantity <> OLD.quantity THEN 'quantity_changed'
+            WHEN NEW.coupon_code IS DISTINCT FROM OLD.coupon_code THEN 'coupon_changed'
+            WHEN NEW.current_period_end <> OLD.current_period_end THEN 'renewed'
+            ELSE 'updated'
+        END;
+
+        UPDATE subscription_versions SET effective_to = NOW()
+        WHERE subscription_id = NEW.id AND effective_to IS NULL;
+
+        SELECT COALESCE(MAX(version), 0) + 1 INTO next_version
+        FROM subscription_versions WHERE subscription_id = NEW.id;
+    END IF;
+
+    INSERT INTO subscription_versions
+        (subscription_id, org_id, version, change, plan_id, status, currency, price_cents, interval,
+         current_period_start, current_period_end, canceled_at, effective_from, quantity, coupon_code, percent_off)
+    SELECT NEW.id, NEW.org_id, next_version, change_type, NEW.plan_id, NEW.status, o.billing_currency, pp.amount,
+        p.interval, NEW.current_period_start, NEW.current_period_end, NEW.canceled_at, NOW(),
+        NEW.quantity, NEW.coupon_code, c.percent_off
+    FROM organizations o
+    LEFT JOIN plans p ON p.id = NEW.plan_id
+    LEFT JOIN plan_prices pp ON pp.plan_id = NEW.plan_id AND pp.currency = o.billing_currency
+    LEFT JOIN coupons c ON c.code = NEW.coupon_code
+    WHERE o.id = NEW.org_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+-- Unknown emails are throttled by their recent failures across all IPs
+CREATE INDEX IF NOT EXISTS idx_login_failures_email_attempted_at ON login_failures(email, attempted_at);
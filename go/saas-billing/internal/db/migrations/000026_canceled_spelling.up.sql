@@ -0,0 +1,3 @@
+-- Ownership transfers were marked 'cancelled' while subscriptions use
+-- 'canceled'; use the one spelling everywhere
+UPDATE ownership_transfers SET status = 'canceled' WHERE status = 'cancelled';
@@ -0,0 +1,4 @@
+-- Tax IDs were marked valid when they were merely well formed. Only numbers
+-- confirmed by VIES qualify for the reverse charge now; customers re-save
+-- their billing profile to have theirs checked.
+UPDATE billing_profiles SET tax_id_valid = FALSE WHERE tax_id_valid;
@@ -0,0 +1,3 @@
+-- The time step of the last TOTP code accepted for the user. Codes from that
+-- step or earlier are refused, so each code works once.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
@@ -0,0 +1,3 @@
+-- The nonce in the account's current unlock link. Unlocking clears it, so
+-- each emailed link works once.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS unlock_nonce UUID;
@@ -0,0 +1,124 @@
+// Package ledger is the double-entry book of everything billed, collected,
+// credited and refunded. Billing posts entries in the same transaction as
+// the change they record; nothing is ever updated or deleted.
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Accounts
+const (
+	AccountsReceivable = "accounts_receivable"
+	Cash               = "cash"
+	Revenue            = "revenue"
+	DeferredRevenue    = "deferred_revenue"
+	TaxPayable         = "tax_payable"
+	CustomerCredit     = "customer_credit"
+)
+
+// debitNormal are the asset accounts, whose balance is debits minus
+// credits. The rest are liabilities and revenue, the other way round.
+var debitNormal = map[string]bool{AccountsReceivable: true, Cash: true}
+
+var (
+	ErrUnbalanced     = errors.New("ledger entry does not balance")
+	ErrInvalidLine    = errors.New("ledger line must be a positive debit or credit")
+	ErrUnknownAccount = errors.New("unknown ledger account")
+)
+
+// Line moves an amount into or out of one account
+type Line struct {
+	Account     string `json:"account"`
+	DebitCents  int    `json:"debit_cents"`
+	CreditCents int    `json:"credit_cents"`
+}
+
+// Debit and Credit build lines. Zero amounts are dropped when posting.
+func Debit(account string, cents int) Line  { return Line{Account: account, DebitCents: cents} }
+func Credit(account string, cents int) Line { return Line{Account: account, CreditCents: cents} }
+
+// Entry is one business event, such as an invoice or a payment, recorded
+// as balanced lines
+type Entry struct {
+	ID            string    `json:"id"`
+	OrgID         string    `json:"org_id"`
+	Currency      string    `json:"currency"`
+	Type          string    `json:"type"`
+	ReferenceType string    `json:"reference_type"`
+	ReferenceID   string    `json:"reference_id"`
+	Description   string    `json:"description,omitempty"`
+	Lines         []Line    `json:"lines"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Post writes the entry within tx. An entry whose lines are all zero, such
+// as the invoice for a free plan, isn't posted. A database trigger checks
+// the balance again at commit.
+func Post(tx *sql.Tx, e Entry) error {
+	lines, err := validate(e.Lines)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", e.Type, e.ReferenceID, err)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var entryID string
+	err = tx.QueryRow(`
+		INSERT INTO ledger_entries (org_id, currency, type, reference_type, reference_id, description)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, e.OrgID, e.Currency, e.Type, e.ReferenceType, e.ReferenceID, e.Description).Scan(&entryID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		_, err = tx.Exec(`
+			INSERT INTO ledger_lines (entry_id, account, debit_cents, credit_cents)
+			VALUES ($1, $2, $3, $4)
+		`, entryID, l.Account, l.DebitCents, l.CreditCents)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate drops zero lines and checks the rest balance
+func validate(lines []Line) ([]Line, error) {
+	kept := []Line{}
+	debits, credits := 0, 0
+	for _, l := range lines {
+		if l.DebitCents == 0 && l.CreditCents == 0 {
+			continue
+		}
+		if l.DebitCents < 0 || l.CreditCents < 0 || (l.DebitCents > 0 && l.CreditCents > 0) {
+			return nil, ErrInvalidLine
+		}
+		if !validAccount(l.Account) {
+			return nil, ErrUnknownAccount
+		}
+		debits += l.DebitCents
+		credits += l.CreditCents
+		kept = append(kept, l)
+	}
+
+	if debits != credits {
+		return nil, ErrUnbalanced
+	}
+	return kept, nil
+}
+
+func validAccount(account string) bool {
+	switch account {
+	case AccountsReceivable, Cash, Revenue, DeferredRevenue, TaxPayable, CustomerCredit:
+		return true
+	}
+	return false
+}
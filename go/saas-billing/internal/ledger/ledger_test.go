@@ -0,0 +1,70 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []Line
+		kept  int
+		err   error
+	}{
+		{"balanced", []Line{Debit(AccountsReceivable, 1190), Credit(Revenue, 1000), Credit(TaxPayable, 190)}, 3, nil},
+		{"zero lines dropped", []Line{Debit(AccountsReceivable, 1000), Credit(Revenue, 1000), Credit(TaxPayable, 0)}, 2, nil},
+		{"all zero", []Line{Debit(AccountsReceivable, 0), Credit(Revenue, 0)}, 0, nil},
+		{"unbalanced", []Line{Debit(Cash, 1000), Credit(AccountsReceivable, 900)}, 0, ErrUnbalanced},
+		{"negative", []Line{Debit(Cash, -100), Credit(AccountsReceivable, -100)}, 0, ErrInvalidLine},
+		{"both sides", []Line{{Account: Cash, DebitCents: 100, CreditCents: 100}}, 0, ErrInvalidLine},
+		{"unknown account", []Line{Debit("suspense", 100), Credit(Cash, 100)}, 0, ErrUnknownAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, err := validate(tt.lines)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Len(t, kept, tt.kept)
+		})
+	}
+}
+
+func TestTrialBalanceAdd(t *testing.T) {
+	tb := TrialBalance{Currency: "EUR"}
+	tb.add(AccountBalance{Account: AccountsReceivable, DebitCents: 1190, CreditCents: 1000})
+	tb.add(AccountBalance{Account: Revenue, DebitCents: 100, CreditCents: 1000})
+	tb.add(AccountBalance{Account: Cash, DebitCents: 900})
+
+	assert.Equal(t, 190, tb.Accounts[0].BalanceCents)
+	assert.Equal(t, 900, tb.Accounts[1].BalanceCents)
+	assert.Equal(t, 900, tb.Accounts[2].BalanceCents)
+	assert.Equal(t, 2190, tb.TotalDebitCents)
+	assert.Equal(t, 2000, tb.TotalCreditCents)
+}
+
+func TestFilterWhere(t *testing.T) {
+	where, args := Filter{}.where()
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	where, args = Filter{Currency: "eur", Since: since}.where()
+	assert.Equal(t, "TRUE AND e.currency = $1 AND e.created_at >= $2", where)
+	assert.Equal(t, []interface{}{"EUR", since}, args)
+}
+
+func TestRunningBalances(t *testing.T) {
+	periods := []RevenuePeriod{
+		{Currency: "EUR", DeferredCents: 12000, ReleasedCents: 1000},
+		{Currency: "EUR", ReleasedCents: 1000},
+		{Currency: "USD", DeferredCents: 500},
+	}
+	runningBalances(periods, map[string]int{"EUR": 3000})
+
+	assert.Equal(t, 14000, periods[0].DeferredBalanceCents)
+	assert.Equal(t, 13000, periods[1].DeferredBalanceCents)
+	assert.Equal(t, 500, periods[2].DeferredBalanceCents)
+}
@@ -0,0 +1,268 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPeriod = errors.New("period must be day or month")
+
+type LedgerService struct {
+	db *sql.DB
+}
+
+func NewLedgerService(db *sql.DB) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+// AccountBalance is an account's totals. BalanceCents is positive on the
+// account's normal side: debit for assets, credit for the rest.
+type AccountBalance struct {
+	Account      string `json:"account"`
+	DebitCents   int    `json:"debit_cents"`
+	CreditCents  int    `json:"credit_cents"`
+	BalanceCents int    `json:"balance_cents"`
+}
+
+// TrialBalance lists every account in one currency. If the books are
+// sound, total debits equal total credits.
+type TrialBalance struct {
+	Currency         string           `json:"currency"`
+	Accounts         []AccountBalance `json:"accounts"`
+	TotalDebitCents  int              `json:"total_debit_cents"`
+	TotalCreditCents int              `json:"total_credit_cents"`
+	Balanced         bool             `json:"balanced"`
+}
+
+// Filter limits exports and reports. Zero values match everything.
+type Filter struct {
+	Currency string
+	Since    time.Time
+	Until    time.Time
+}
+
+// ExportRow is one ledger line with its entry
+type ExportRow struct {
+	EntryID       string
+	Seq           int64
+	PostedAt      time.Time
+	OrgID         string
+	Currency      string
+	Type          string
+	ReferenceType string
+	ReferenceID   string
+	Description   string
+	Line
+}
+
+// TrialBalances returns a trial balance per currency of everything posted
+// before asOf, or everything if asOf is zero
+func (s *LedgerService) TrialBalances(asOf time.Time) ([]TrialBalance, error) {
+	rows, err := s.db.Query(`
+		SELECT e.currency, l.account, SUM(l.debit_cents), SUM(l.credit_cents)
+		FROM ledger_lines l
+		JOIN ledger_entries e ON e.id = l.entry_id
+		WHERE $1::timestamptz IS NULL OR e.created_at < $1
+		GROUP BY e.currency, l.account
+		ORDER BY e.currency, l.account
+	`, nullTime(asOf))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []TrialBalance{}
+	for rows.Next() {
+		var currency string
+		var a AccountBalance
+		if err := rows.Scan(&currency, &a.Account, &a.DebitCents, &a.CreditCents); err != nil {
+			return nil, err
+		}
+		if len(balances) == 0 || balances[len(balances)-1].Currency != currency {
+			balances = append(balances, TrialBalance{Currency: currency, Accounts: []AccountBalance{}})
+		}
+		tb := &balances[len(balances)-1]
+		tb.add(a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range balances {
+		balances[i].Balanced = balances[i].TotalDebitCents == balances[i].TotalCreditCents
+	}
+	return balances, nil
+}
+
+func (tb *TrialBalance) add(a AccountBalance) {
+	if debitNormal[a.Account] {
+		a.BalanceCents = a.DebitCents - a.CreditCents
+	} else {
+		a.BalanceCents = a.CreditCents - a.DebitCents
+	}
+	tb.Accounts = append(tb.Accounts, a)
+	tb.TotalDebitCents += a.DebitCents
+	tb.TotalCreditCents += a.CreditCents
+}
+
+// RevenuePeriod is the revenue activity in one currency over a day or a
+// month. RecognizedCents is the revenue earned, net of credits.
+// DeferredCents was billed for service not yet delivered and ReleasedCents
+// left deferred revenue, either recognized or credited back.
+// DeferredBalanceCents is what's still deferred at the end of the period.
+type RevenuePeriod struct {
+	Currency             string    `json:"currency"`
+	PeriodStart          time.Time `json:"period_start"`
+	RecognizedCents      int       `json:"recognized_cents"`
+	DeferredCents        int       `json:"deferred_cents"`
+	ReleasedCents        int       `json:"released_cents"`
+	DeferredBalanceCents int       `json:"deferred_balance_cents"`
+}
+
+// RevenueReport returns recognized and deferred revenue per period, "day"
+// or "month" in UTC, oldest first within each currency. Periods without
+// revenue activity are left out.
+func (s *LedgerService) RevenueReport(f Filter, period string) ([]RevenuePeriod, error) {
+	if period != "day" && period != "month" {
+		return nil, ErrInvalidPeriod
+	}
+
+	// What was deferred before the report starts
+	opening := map[string]int{}
+	if !f.Since.IsZero() {
+		where, args := Filter{Currency: f.Currency, Until: f.Since}.where()
+		rows, err := s.db.Query(fmt.Sprintf(`
+			SELECT e.currency, SUM(l.credit_cents - l.debit_cents)
+			FROM ledger_lines l
+			JOIN ledger_entries e ON e.id = l.entry_id
+			WHERE %s AND l.account = '%s'
+			GROUP BY e.currency
+		`, where, DeferredRevenue), args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var currency string
+			var balance int
+			if err := rows.Scan(&currency, &balance); err != nil {
+				return nil, err
+			}
+			opening[currency] = balance
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	where, args := f.where()
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT e.currency, date_trunc('%s', e.created_at AT TIME ZONE 'UTC'),
+			COALESCE(SUM(CASE WHEN l.account = '%[2]s' THEN l.credit_cents - l.debit_cents END), 0),
+			COALESCE(SUM(CASE WHEN l.account = '%[3]s' THEN l.credit_cents END), 0),
+			COALESCE(SUM(CASE WHEN l.account = '%[3]s' THEN l.debit_cents END), 0)
+		FROM ledger_lines l
+		JOIN ledger_entries e ON e.id = l.entry_id
+		WHERE %[4]s AND l.account IN ('%[2]s', '%[3]s')
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, period, Revenue, DeferredRevenue, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := []RevenuePeriod{}
+	for rows.Next() {
+		var p RevenuePeriod
+		if err := rows.Scan(&p.Currency, &p.PeriodStart, &p.RecognizedCents, &p.DeferredCents, &p.ReleasedCents); err != nil {
+			return nil, err
+		}
+		p.PeriodStart = p.PeriodStart.UTC()
+		periods = append(periods, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	runningBalances(periods, opening)
+	return periods, nil
+}
+
+// runningBalances fills in the deferred balance at the end of each period,
+// starting from the opening balance of its currency
+func runningBalances(periods []RevenuePeriod, opening map[string]int) {
+	balance := map[string]int{}
+	for currency, b := range opening {
+		balance[currency] = b
+	}
+	for i := range periods {
+		p := &periods[i]
+		balance[p.Currency] += p.DeferredCents - p.ReleasedCents
+		p.DeferredBalanceCents = balance[p.Currency]
+	}
+}
+
+// Export calls fn for every ledger line matching the filter, in posting
+// order, without loading the whole ledger into memory. It stops at the
+// first error fn returns.
+func (s *LedgerService) Export(f Filter, fn func(ExportRow) error) error {
+	where, args := f.where()
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT e.id, e.seq, e.created_at, e.org_id, e.currency, e.type, e.reference_type, e.reference_id,
+			e.description, l.account, l.debit_cents, l.credit_cents
+		FROM ledger_lines l
+		JOIN ledger_entries e ON e.id = l.entry_id
+		WHERE %s
+		ORDER BY e.seq, l.debit_cents DESC, l.account
+	`, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ExportRow
+		if err := rows.Scan(&r.EntryID, &r.Seq, &r.PostedAt, &r.OrgID, &r.Currency, &r.Type, &r.ReferenceType,
+			&r.ReferenceID, &r.Description, &r.Account, &r.DebitCents, &r.CreditCents); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (f Filter) where() (string, []interface{}) {
+	conds := []string{"TRUE"}
+	args := []interface{}{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Currency != "" {
+		add("e.currency = $%d", strings.ToUpper(f.Currency))
+	}
+	if !f.Since.IsZero() {
+		add("e.created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("e.created_at < $%d", f.Until)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
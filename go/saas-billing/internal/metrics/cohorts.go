@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Cohort is the organizations that started paying in one calendar month,
+// and how many of them, and how much of their MRR, were kept in each month
+// since
+type Cohort struct {
+	Month            time.Time      `json:"month"`
+	Accounts         int            `json:"accounts"`
+	StartingMRRCents int            `json:"starting_mrr_cents"`
+	Retention        []CohortPeriod `json:"retention"`
+}
+
+// CohortPeriod is a cohort measured at the end of the month MonthsSince
+// months after it started; 0 is its first month. Revenue retention counts
+// expansion, so it can exceed 1.
+type CohortPeriod struct {
+	MonthsSince      int     `json:"months_since"`
+	Accounts         int     `json:"accounts"`
+	LogoRetention    float64 `json:"logo_retention"`
+	MRRCents         int     `json:"mrr_cents"`
+	RevenueRetention float64 `json:"revenue_retention"`
+}
+
+// Cohorts returns a cohort for every month in the range, each measured up
+// to the end of the range
+func (s *MetricsService) Cohorts(r Range) ([]Cohort, error) {
+	if !r.Since.Before(r.Until) {
+		return nil, ErrInvalidRange
+	}
+
+	tl, err := s.loadTimeline(r.Currency, r.Until)
+	if err != nil {
+		return nil, err
+	}
+	return cohorts(tl, months(r.Since, r.Until), r.Until), nil
+}
+
+func cohorts(tl timeline, starts []time.Time, until time.Time) []Cohort {
+	byMonth := map[time.Time]*Cohort{}
+	members := map[time.Time][]string{}
+	list := make([]Cohort, len(starts))
+	for i, start := range starts {
+		list[i] = Cohort{Month: start, Retention: []CohortPeriod{}}
+		byMonth[start] = &list[i]
+	}
+
+	for _, orgID := range tl.orgs() {
+		first, ok := tl.firstPaid(orgID)
+		if !ok {
+			continue
+		}
+		start := first.Start.UTC()
+		month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		c, ok := byMonth[month]
+		if !ok {
+			continue
+		}
+		c.Accounts++
+		c.StartingMRRCents += first.MRRCents
+		members[month] = append(members[month], orgID)
+	}
+
+	for i := range list {
+		c := &list[i]
+		for k := 0; ; k++ {
+			end := c.Month.AddDate(0, k+1, 0)
+			if end.After(until) {
+				end = until
+			}
+
+			p := CohortPeriod{MonthsSince: k}
+			for _, orgID := range members[c.Month] {
+				if mrr := tl.mrrAt(orgID, end); mrr > 0 {
+					p.Accounts++
+					p.MRRCents += mrr
+				}
+			}
+			p.LogoRetention = rate(p.Accounts, c.Accounts)
+			p.RevenueRetention = rate(p.MRRCents, c.StartingMRRCents)
+			c.Retention = append(c.Retention, p)
+
+			if !end.Before(until) {
+				break
+			}
+		}
+	}
+	return list
+}
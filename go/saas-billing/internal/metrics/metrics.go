@@ -0,0 +1,168 @@
+// Package metrics computes SaaS metrics such as MRR movements, churn and
+// cohort retention from subscription history, pricing each version of a
+// subscription at its plan's price when it took effect. Every figure is in
+// one currency; organizations never change theirs.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrInvalidRange = errors.New("since must be before until")
+
+type MetricsService struct {
+	db *sql.DB
+}
+
+func NewMetricsService(db *sql.DB) *MetricsService {
+	return &MetricsService{db: db}
+}
+
+// Range is the months a report covers. Since is rounded down to the start
+// of its month (UTC); Until is exclusive.
+type Range struct {
+	Currency string
+	Since    time.Time
+	Until    time.Time
+}
+
+// segment is an organization paying MRRCents from Start until End, or
+// until now if End is zero
+type segment struct {
+	Start    time.Time
+	End      time.Time
+	MRRCents int
+}
+
+// timeline is each organization's segments, oldest first
+type timeline map[string][]segment
+
+// mrrAt is what the organization is paying per month at t
+func (tl timeline) mrrAt(orgID string, t time.Time) int {
+	for _, s := range tl[orgID] {
+		if !t.Before(s.Start) && (s.End.IsZero() || t.Before(s.End)) {
+			return s.MRRCents
+		}
+	}
+	return 0
+}
+
+// paidBefore reports whether the organization paid anything before t
+func (tl timeline) paidBefore(orgID string, t time.Time) bool {
+	for _, s := range tl[orgID] {
+		if s.MRRCents > 0 && s.Start.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstPaid is when the organization started paying, or false if it never
+// has
+func (tl timeline) firstPaid(orgID string) (segment, bool) {
+	for _, s := range tl[orgID] {
+		if s.MRRCents > 0 {
+			return s, true
+		}
+	}
+	return segment{}, false
+}
+
+// orgs returns the organizations in the timeline in a stable order
+func (tl timeline) orgs() []string {
+	ids := make([]string, 0, len(tl))
+	for id := range tl {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// subscriptionRow is one active subscription version and what it's billed
+// each interval, seats and coupon included
+type subscriptionRow struct {
+	OrgID      string
+	Start      time.Time
+	End        *time.Time
+	Interval   string
+	PriceCents int
+}
+
+// loadTimeline reads every active subscription version in the currency
+// that took effect before until
+func (s *MetricsService) loadTimeline(currency string, until time.Time) (timeline, error) {
+	rows, err := s.db.Query(`
+		SELECT org_id, effective_from, effective_to, interval,
+			price_cents * quantity - price_cents * quantity * COALESCE(percent_off, 0) / 100
+		FROM subscription_versions
+		WHERE status = 'active' AND currency = $1 AND price_cents IS NOT NULL AND effective_from < $2
+		ORDER BY org_id, effective_from
+	`, currency, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []subscriptionRow{}
+	for rows.Next() {
+		var r subscriptionRow
+		if err := rows.Scan(&r.OrgID, &r.Start, &r.End, &r.Interval, &r.PriceCents); err != nil {
+			return nil, err
+		}
+		subs = append(subs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildTimeline(subs), nil
+}
+
+// buildTimeline turns subscription versions, ordered by organization and
+// start, into segments. Each segment ends at the next one's start at the
+// latest, as a new subscription replaces the organization's previous one.
+func buildTimeline(subs []subscriptionRow) timeline {
+	tl := timeline{}
+	for _, r := range subs {
+		segs := tl[r.OrgID]
+		if n := len(segs); n > 0 && (segs[n-1].End.IsZero() || segs[n-1].End.After(r.Start)) {
+			segs[n-1].End = r.Start
+		}
+
+		seg := segment{Start: r.Start, MRRCents: monthlyAmount(r.PriceCents, r.Interval)}
+		if r.End != nil {
+			seg.End = *r.End
+		}
+		tl[r.OrgID] = append(segs, seg)
+	}
+	return tl
+}
+
+// monthlyAmount normalizes a plan price to a month, rounding half up
+func monthlyAmount(amountCents int, interval string) int {
+	if interval == "year" {
+		return (amountCents*2 + 12) / 24
+	}
+	return amountCents
+}
+
+// months returns the start of every month from since's up to until
+func months(since, until time.Time) []time.Time {
+	since = since.UTC()
+	list := []time.Time{}
+	for m := time.Date(since.Year(), since.Month(), 1, 0, 0, 0, 0, time.UTC); m.Before(until); m = m.AddDate(0, 1, 0) {
+		list = append(list, m)
+	}
+	return list
+}
+
+// rate divides without failing on an empty denominator
+func rate(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
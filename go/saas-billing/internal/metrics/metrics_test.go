@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func testTimeline() timeline {
+	canceled := day(2025, 2, 20)
+	returned := day(2025, 3, 5)
+	return buildTimeline([]subscriptionRow{
+		// Upgrades in February
+		{OrgID: "a", Start: day(2025, 1, 10), Interval: "month", PriceCents: 1000},
+		{OrgID: "a", Start: day(2025, 2, 15), Interval: "year", PriceCents: 24000},
+		// Churns in February, comes back in March
+		{OrgID: "b", Start: day(2025, 1, 20), End: &canceled, Interval: "month", PriceCents: 3000},
+		{OrgID: "b", Start: returned, Interval: "month", PriceCents: 2000},
+		// Downgrades in March
+		{OrgID: "c", Start: day(2025, 2, 2), Interval: "month", PriceCents: 5000},
+		{OrgID: "c", Start: day(2025, 3, 2), Interval: "month", PriceCents: 4000},
+	})
+}
+
+func TestBuildTimeline(t *testing.T) {
+	tl := testTimeline()
+
+	assert.Equal(t, day(2025, 2, 15), tl["a"][0].End)
+	assert.Equal(t, 2000, tl["a"][1].MRRCents)
+	assert.True(t, tl["a"][1].End.IsZero())
+	assert.Equal(t, 0, tl.mrrAt("b", day(2025, 3, 1)))
+	assert.Equal(t, 2000, tl.mrrAt("b", day(2025, 3, 5)))
+	assert.Equal(t, 0, tl.mrrAt("d", day(2025, 3, 5)))
+}
+
+func TestMonthlyAmount(t *testing.T) {
+	assert.Equal(t, 4999, monthlyAmount(4999, "month"))
+	assert.Equal(t, 1000, monthlyAmount(12000, "year"))
+	assert.Equal(t, 833, monthlyAmount(9999, "year"))
+}
+
+func TestMRRMovements(t *testing.T) {
+	periods := mrrMovements(testTimeline(), months(day(2025, 1, 15), day(2025, 4, 1)), day(2025, 4, 1))
+	assert.Len(t, periods, 3)
+
+	jan := periods[0]
+	assert.Equal(t, 4000, jan.NewCents)
+	assert.Equal(t, 2, jan.NewAccounts)
+	assert.Equal(t, 4000, jan.EndingMRRCents)
+
+	feb := periods[1]
+	assert.Equal(t, 4000, feb.StartingMRRCents)
+	assert.Equal(t, 5000, feb.NewCents)
+	assert.Equal(t, 1000, feb.ExpansionCents)
+	assert.Equal(t, 3000, feb.ChurnCents)
+	assert.Equal(t, 7000, feb.EndingMRRCents)
+	assert.Equal(t, 84000, feb.ARRCents)
+	assert.Equal(t, 0.5, feb.LogoChurnRate)
+	assert.Equal(t, 0.75, feb.RevenueChurnRate)
+	assert.Equal(t, 0.5, feb.NetRevenueChurnRate)
+	assert.Equal(t, 3500, feb.ARPACents)
+
+	mar := periods[2]
+	assert.Equal(t, 2000, mar.ReactivationCents)
+	assert.Equal(t, 0, mar.NewCents)
+	assert.Equal(t, 1000, mar.ContractionCents)
+	assert.Equal(t, 3, mar.EndingAccounts)
+	assert.Equal(t, mar.StartingMRRCents+mar.NewCents+mar.ReactivationCents+mar.ExpansionCents-
+		mar.ContractionCents-mar.ChurnCents, mar.EndingMRRCents)
+}
+
+func TestCohorts(t *testing.T) {
+	list := cohorts(testTimeline(), months(day(2025, 1, 1), day(2025, 3, 15)), day(2025, 3, 15))
+	assert.Len(t, list, 3)
+
+	jan := list[0]
+	assert.Equal(t, 2, jan.Accounts)
+	assert.Equal(t, 4000, jan.StartingMRRCents)
+	assert.Len(t, jan.Retention, 3)
+	assert.Equal(t, 1.0, jan.Retention[0].LogoRetention)
+	// b churned in February
+	assert.Equal(t, 0.5, jan.Retention[1].LogoRetention)
+	assert.Equal(t, 0.5, jan.Retention[1].RevenueRetention)
+	// and came back in March, measured on the 15th
+	assert.Equal(t, 1.0, jan.Retention[2].LogoRetention)
+	assert.Equal(t, 1.0, jan.Retention[2].RevenueRetention)
+
+	feb := list[1]
+	assert.Equal(t, 1, feb.Accounts)
+	assert.Equal(t, 0.8, feb.Retention[1].RevenueRetention)
+
+	assert.Equal(t, 0, list[2].Accounts)
+}
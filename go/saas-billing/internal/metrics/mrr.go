@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"time"
+)
+
+// MRRPeriod is how monthly recurring revenue moved over one calendar
+// month. Each organization's MRR at the start of the month is compared
+// with its MRR at the end:
+//   - new: it had never paid before
+//   - reactivation: it had paid before, but not at the start of the month
+//   - expansion and contraction: it pays more or less than it did
+//   - churn: it stopped paying
+//
+// Amounts are positive; ending MRR is starting MRR plus new, reactivation
+// and expansion, minus contraction and churn.
+type MRRPeriod struct {
+	PeriodStart       time.Time `json:"period_start"`
+	StartingMRRCents  int       `json:"starting_mrr_cents"`
+	NewCents          int       `json:"new_cents"`
+	ExpansionCents    int       `json:"expansion_cents"`
+	ContractionCents  int       `json:"contraction_cents"`
+	ChurnCents        int       `json:"churn_cents"`
+	ReactivationCents int       `json:"reactivation_cents"`
+	EndingMRRCents    int       `json:"ending_mrr_cents"`
+	ARRCents          int       `json:"arr_cents"`
+	StartingAccounts  int       `json:"starting_accounts"`
+	NewAccounts       int       `json:"new_accounts"`
+	ChurnedAccounts   int       `json:"churned_accounts"`
+	EndingAccounts    int       `json:"ending_accounts"`
+	// Churned accounts over starting accounts
+	LogoChurnRate float64 `json:"logo_churn_rate"`
+	// Churn and contraction over starting MRR; the net rate also subtracts
+	// expansion, so it's negative when existing customers grow
+	RevenueChurnRate    float64 `json:"revenue_churn_rate"`
+	NetRevenueChurnRate float64 `json:"net_revenue_churn_rate"`
+	// Average revenue per account: ending MRR over ending accounts
+	ARPACents int `json:"arpa_cents"`
+}
+
+// MRRReport returns the MRR movements for every month in the range
+func (s *MetricsService) MRRReport(r Range) ([]MRRPeriod, error) {
+	if !r.Since.Before(r.Until) {
+		return nil, ErrInvalidRange
+	}
+
+	tl, err := s.loadTimeline(r.Currency, r.Until)
+	if err != nil {
+		return nil, err
+	}
+	return mrrMovements(tl, months(r.Since, r.Until), r.Until), nil
+}
+
+// mrrMovements measures each month from its start to the next month's
+// start, or until if that comes first
+func mrrMovements(tl timeline, starts []time.Time, until time.Time) []MRRPeriod {
+	orgIDs := tl.orgs()
+	periods := make([]MRRPeriod, 0, len(starts))
+	for _, start := range starts {
+		end := start.AddDate(0, 1, 0)
+		if end.After(until) {
+			end = until
+		}
+
+		p := MRRPeriod{PeriodStart: start}
+		for _, orgID := range orgIDs {
+			before, after := tl.mrrAt(orgID, start), tl.mrrAt(orgID, end)
+			if before > 0 {
+				p.StartingAccounts++
+				p.StartingMRRCents += before
+			}
+			if after > 0 {
+				p.EndingAccounts++
+				p.EndingMRRCents += after
+			}
+
+			switch {
+			case before == 0 && after > 0 && tl.paidBefore(orgID, start):
+				p.ReactivationCents += after
+			case before == 0 && after > 0:
+				p.NewCents += after
+				p.NewAccounts++
+			case before > 0 && after == 0:
+				p.ChurnCents += before
+				p.ChurnedAccounts++
+			case after > before:
+				p.ExpansionCents += after - before
+			case after < before:
+				p.ContractionCents += before - after
+			}
+		}
+
+		p.ARRCents = p.EndingMRRCents * 12
+		p.LogoChurnRate = rate(p.ChurnedAccounts, p.StartingAccounts)
+		p.RevenueChurnRate = rate(p.ChurnCents+p.ContractionCents, p.StartingMRRCents)
+		p.NetRevenueChurnRate = rate(p.ChurnCents+p.ContractionCents-p.ExpansionCents, p.StartingMRRCents)
+		if p.EndingAccounts > 0 {
+			p.ARPACents = p.EndingMRRCents / p.EndingAccounts
+		}
+		periods = append(periods, p)
+	}
+	return periods
+}
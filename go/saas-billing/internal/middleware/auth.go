@@ -5,11 +5,21 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/apikeys"
+	"github.com/linkmeAman/saas-billing/internal/audit"
 	"github.com/linkmeAman/saas-billing/internal/auth"
 )
 
-// AuthRequired verifies JWT token and adds claims to context
-func AuthRequired() gin.HandlerFunc {
+// Principal types stored under "principalType" in the context
+const (
+	PrincipalUser   = "user"
+	PrincipalAPIKey = "api_key"
+)
+
+// AuthRequired verifies a JWT or org API key and adds the principal to context
+func AuthRequired(keyService interface {
+	Authenticate(rawKey string) (*apikeys.APIKey, error)
+}) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -25,6 +35,23 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		// API keys resolve to an org, not a user
+		if strings.HasPrefix(bearerToken[1], apikeys.KeyPrefix) {
+			key, err := keyService.Authenticate(bearerToken[1])
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+				c.Abort()
+				return
+			}
+
+			c.Set("principalType", PrincipalAPIKey)
+			c.Set("apiKeyID", key.ID)
+			c.Set("apiKeyOrgID", key.OrgID)
+			c.Set("apiKey", key)
+			c.Next()
+			return
+		}
+
 		claims, err := auth.ValidateToken(bearerToken[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -33,15 +60,138 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		// Add claims to context
+		c.Set("principalType", PrincipalUser)
 		c.Set("userID", claims.UserID)
+		c.Set("mfa", claims.MFA)
+		c.Next()
+	}
+}
+
+// RequireUser rejects API key principals on routes that act on behalf of a user
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("principalType") != PrincipalUser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a user token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuditActor describes the request's principal for the audit log. Unauthenticated
+// requests get an actor with only the client details set.
+func AuditActor(c *gin.Context) audit.Actor {
+	actor := audit.Actor{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString("request_id"),
+	}
+	if c.GetString("principalType") == PrincipalAPIKey {
+		actor.APIKeyID = c.GetString("apiKeyID")
+	} else {
+		actor.UserID = c.GetString("userID")
+	}
+	return actor
+}
+
+// RequireScope lets API keys through to the route when the key belongs to the
+// organization in the path and was granted scope. Users pass through
+// unchanged and are checked by RequireRole.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("principalType") != PrincipalAPIKey {
+			c.Next()
+			return
+		}
+
+		if c.Param("orgID") != c.GetString("apiKeyOrgID") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key does not belong to this organization"})
+			c.Abort()
+			return
+		}
+
+		key, _ := c.MustGet("apiKey").(*apikeys.APIKey)
+		if !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing scope " + scope})
+			c.Abort()
+			return
+		}
+
+		c.Set("apiKeyAuthorized", true)
+		c.Next()
+	}
+}
+
+// RequirePlatformAdmin limits a route to operators of the service itself,
+// such as managing tax rates. It implies RequireUser.
+func RequirePlatformAdmin(userService interface {
+	IsPlatformAdmin(userID string) (bool, error)
+}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("principalType") != PrincipalUser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a user token"})
+			c.Abort()
+			return
+		}
+
+		ok, err := userService.IsPlatformAdmin(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-// RequireRole checks if the user has the required role in the organization
-func RequireRole(orgService interface {
-	CheckUserRole(userID, orgID string) (string, error)
-}, requiredRoles ...string) gin.HandlerFunc {
+// roleChecker is the part of the organization service the membership checks
+// below rely on
+type roleChecker interface {
+	EffectiveRole(userID, orgID string) (string, error)
+	RequiresMFAForAdmins(orgID string) (bool, error)
+	IsAdminRole(orgID, role string) (bool, error)
+}
+
+// RequireRole checks if the user has the required role in the organization.
+// Prefer RequirePermission; this is for checks that really are about the role,
+// such as an owner handing over ownership.
+func RequireRole(orgService roleChecker, requiredRoles ...string) gin.HandlerFunc {
+	return authorizeMember(orgService, func(orgID, role string) (bool, error) {
+		for _, r := range requiredRoles {
+			if role == r {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// RequirePermission checks the user's role in the organization, built-in or
+// custom, grants permission
+func RequirePermission(orgService interface {
+	roleChecker
+	HasPermission(orgID, role, permission string) (bool, error)
+}, permission string) gin.HandlerFunc {
+	return authorizeMember(orgService, func(orgID, role string) (bool, error) {
+		return orgService.HasPermission(orgID, role, permission)
+	})
+}
+
+// RequireMember only checks the user belongs to the organization
+func RequireMember(orgService roleChecker) gin.HandlerFunc {
+	return authorizeMember(orgService, func(orgID, role string) (bool, error) {
+		return true, nil
+	})
+}
+
+func authorizeMember(orgService roleChecker, allowed func(orgID, role string) (bool, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetString("userID")
 		orgID := c.Param("orgID")
@@ -52,28 +202,61 @@ func RequireRole(orgService interface {
 			return
 		}
 
-		role, err := orgService.CheckUserRole(userID, orgID)
+		// API keys are only allowed where RequireScope already authorized them
+		if c.GetString("principalType") == PrincipalAPIKey {
+			if !c.GetBool("apiKeyAuthorized") {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot access this endpoint"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		role, err := orgService.EffectiveRole(userID, orgID)
 		if err != nil {
 			c.JSON(http.StatusForbidden, gin.H{"error": "User is not a member of this organization"})
 			c.Abort()
 			return
 		}
 
-		// Check if user's role is in the required roles
-		hasRole := false
-		for _, r := range requiredRoles {
-			if role == r {
-				hasRole = true
-				break
-			}
+		ok, err := allowed(orgID, role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
 		}
-
-		if !hasRole {
+		if !ok {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 			c.Abort()
 			return
 		}
 
+		// Enforce the org's MFA policy for privileged roles, judged by what
+		// the role can do rather than its name
+		if !c.GetBool("mfa") {
+			admin, err := orgService.IsAdminRole(orgID, role)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				c.Abort()
+				return
+			}
+			required := false
+			if admin {
+				required, err = orgService.RequiresMFAForAdmins(orgID)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check organization policy"})
+				c.Abort()
+				return
+			}
+			if required {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Multi-factor authentication required", "code": "MFA_REQUIRED"})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Set("userRole", role)
 		c.Next()
 	}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linkmeAman/saas-billing/internal/apikeys"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKeyService struct {
+	keys map[string]*apikeys.APIKey
+}
+
+func (f *fakeKeyService) Authenticate(rawKey string) (*apikeys.APIKey, error) {
+	if key, ok := f.keys[rawKey]; ok {
+		return key, nil
+	}
+	return nil, apikeys.ErrInvalidKey
+}
+
+type fakeOrgService struct{}
+
+func (fakeOrgService) EffectiveRole(userID, orgID string) (string, error) {
+	return "", errors.New("user is not a member of this organization")
+}
+
+func (fakeOrgService) RequiresMFAForAdmins(orgID string) (bool, error) {
+	return false, nil
+}
+
+func (fakeOrgService) IsAdminRole(orgID, role string) (bool, error) {
+	return false, nil
+}
+
+func newAPIKeyRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	keys := &fakeKeyService{keys: map[string]*apikeys.APIKey{
+		"sbk_reader": {ID: "key-1", OrgID: "org-1", Scopes: []string{"billing:read"}},
+	}}
+
+	r := gin.New()
+	r.Use(AuthRequired(keys))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/orgs", RequireUser(), ok)
+	r.GET("/orgs/:orgID/invoices", RequireScope("billing:read"), RequireRole(fakeOrgService{}, "owner", "admin"), ok)
+	r.POST("/orgs/:orgID/subscribe", RequireScope("billing:write"), RequireRole(fakeOrgService{}, "owner", "admin"), ok)
+	r.POST("/orgs/:orgID/members", RequireRole(fakeOrgService{}, "owner", "admin"), ok)
+	return r
+}
+
+func TestAuthRequiredWithAPIKey(t *testing.T) {
+	r := newAPIKeyRouter()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		{"scoped route", http.MethodGet, "/orgs/org-1/invoices", "sbk_reader", http.StatusOK},
+		{"other org", http.MethodGet, "/orgs/org-2/invoices", "sbk_reader", http.StatusForbidden},
+		{"missing scope", http.MethodPost, "/orgs/org-1/subscribe", "sbk_reader", http.StatusForbidden},
+		{"role-only route", http.MethodPost, "/orgs/org-1/members", "sbk_reader", http.StatusForbidden},
+		{"user-only route", http.MethodGet, "/orgs", "sbk_reader", http.StatusForbidden},
+		{"unknown key", http.MethodGet, "/orgs/org-1/invoices", "sbk_unknown", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+// fakePermissionService knows one org whose users hold fixed roles
+type fakePermissionService struct {
+	roles       map[string]string
+	permissions map[string][]string
+	requireMFA  bool
+}
+
+func (f fakePermissionService) EffectiveRole(userID, orgID string) (string, error) {
+	if role, ok := f.roles[userID]; ok && orgID == "org-1" {
+		return role, nil
+	}
+	return "", errors.New("user is not a member of this organization")
+}
+
+func (f fakePermissionService) RequiresMFAForAdmins(orgID string) (bool, error) {
+	return f.requireMFA, nil
+}
+
+func (f fakePermissionService) IsAdminRole(orgID, role string) (bool, error) {
+	return f.HasPermission(orgID, role, "billing:manage")
+}
+
+func (f fakePermissionService) HasPermission(orgID, role, permission string) (bool, error) {
+	for _, p := range f.permissions[role] {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orgService := fakePermissionService{
+		roles: map[string]string{"alice": "admin", "fred": "finance", "mia": "member"},
+		permissions: map[string][]string{
+			"admin":   {"billing:read", "billing:manage"},
+			"finance": {"billing:read"},
+		},
+	}
+
+	withUser := func(c *gin.Context) {
+		c.Set("principalType", PrincipalUser)
+		c.Set("userID", c.GetHeader("X-User"))
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+
+	r := gin.New()
+	r.Use(withUser)
+	r.GET("/orgs/:orgID/invoices", RequirePermission(orgService, "billing:read"), ok)
+	r.POST("/orgs/:orgID/subscribe", RequirePermission(orgService, "billing:manage"), ok)
+	r.DELETE("/orgs/:orgID/members/me", RequireMember(orgService), ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		want   int
+	}{
+		{"custom role reads billing", http.MethodGet, "/orgs/org-1/invoices", "fred", http.StatusOK},
+		{"custom role can't manage billing", http.MethodPost, "/orgs/org-1/subscribe", "fred", http.StatusForbidden},
+		{"admin manages billing", http.MethodPost, "/orgs/org-1/subscribe", "alice", http.StatusOK},
+		{"member without permission", http.MethodGet, "/orgs/org-1/invoices", "mia", http.StatusForbidden},
+		{"any member can leave", http.MethodDelete, "/orgs/org-1/members/me", "mia", http.StatusOK},
+		{"non-member", http.MethodGet, "/orgs/org-2/invoices", "alice", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-User", tt.user)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestAdminMFAFollowsPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orgService := fakePermissionService{
+		roles: map[string]string{"bob": "billing-boss", "fred": "finance"},
+		permissions: map[string][]string{
+			"billing-boss": {"billing:read", "billing:manage"},
+			"finance":      {"billing:read"},
+		},
+		requireMFA: true,
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("principalType", PrincipalUser)
+		c.Set("userID", c.GetHeader("X-User"))
+		c.Set("mfa", c.GetHeader("X-MFA") == "true")
+	})
+	r.GET("/orgs/:orgID/invoices", RequirePermission(orgService, "billing:read"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name string
+		user string
+		mfa  string
+		want int
+	}{
+		{"custom admin role without MFA", "bob", "false", http.StatusForbidden},
+		{"custom admin role with MFA", "bob", "true", http.StatusOK},
+		{"non-admin role without MFA", "fred", "false", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/orgs/org-1/invoices", nil)
+			req.Header.Set("X-User", tt.user)
+			req.Header.Set("X-MFA", tt.mfa)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
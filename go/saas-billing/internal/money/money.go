@@ -0,0 +1,126 @@
+// Package money represents amounts as whole minor units of an ISO 4217
+// currency, so they never go through floating point.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unsupported currency")
+	ErrInvalidAmount   = errors.New("invalid amount")
+)
+
+// exponents is the number of minor-unit digits of each supported currency.
+// Anything not listed here can't be priced or invoiced.
+var exponents = map[string]int{
+	// Zero-decimal currencies: amounts are whole units
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "PYG": 0, "UGX": 0, "VND": 0, "XAF": 0, "XOF": 0,
+
+	// Three-decimal currencies
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+
+	"AED": 2, "AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2, "DKK": 2,
+	"EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "ILS": 2, "INR": 2, "MXN": 2, "NOK": 2,
+	"NZD": 2, "PLN": 2, "SAR": 2, "SEK": 2, "SGD": 2, "TRY": 2, "USD": 2, "ZAR": 2,
+}
+
+// Money is an amount in the smallest unit of Currency: cents for USD, yen
+// for JPY, fils for BHD
+type Money struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New checks the currency and returns amount minor units of it
+func New(amount int, currency string) (Money, error) {
+	code, err := NormalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: code}, nil
+}
+
+// Parse converts a decimal amount in major units, such as "49.99", to
+// minor units. Extra digits are rounded half away from zero to the
+// currency's precision, so "1.5" JPY is 2 yen and "0.0005" BHD is 1 fils.
+func Parse(amount, currency string) (Money, error) {
+	code, err := NormalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok || strings.ContainsAny(amount, "/eE") {
+		return Money{}, ErrInvalidAmount
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponents[code])), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+
+	minor := roundHalfAway(r)
+	if !minor.IsInt64() {
+		return Money{}, ErrInvalidAmount
+	}
+	return Money{Amount: int(minor.Int64()), Currency: code}, nil
+}
+
+// String formats the amount in major units with the currency's precision,
+// e.g. "49.99 EUR", "5000 JPY" or "1.250 BHD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Decimal is the amount in major units without the currency, e.g. "49.99"
+func (m Money) Decimal() string {
+	exp := exponents[m.Currency]
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+
+	scale := 1
+	for i := 0; i < exp; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, exp, amount%scale)
+}
+
+// Exponent is the number of digits after the decimal point in the currency
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[currency]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+	return exp, nil
+}
+
+// NormalizeCurrency upper-cases a currency code and checks it's supported
+func NormalizeCurrency(currency string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := exponents[code]; !ok {
+		return "", ErrUnknownCurrency
+	}
+	return code, nil
+}
+
+func roundHalfAway(r *big.Rat) *big.Int {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q
+}
@@ -0,0 +1,50 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     Money
+	}{
+		{"49.99", "usd", Money{Amount: 4999, Currency: "USD"}},
+		{"49.995", "EUR", Money{Amount: 5000, Currency: "EUR"}},
+		{"49.994", "EUR", Money{Amount: 4999, Currency: "EUR"}},
+		{"5000", "JPY", Money{Amount: 5000, Currency: "JPY"}},
+		{"1.5", "JPY", Money{Amount: 2, Currency: "JPY"}},
+		{"1.25", "BHD", Money{Amount: 1250, Currency: "BHD"}},
+		{"0.0005", "KWD", Money{Amount: 1, Currency: "KWD"}},
+		{"-0.005", "USD", Money{Amount: -1, Currency: "USD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.amount, tt.currency)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	_, err := Parse("10", "XYZ")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+
+	for _, amount := range []string{"", "abc", "1/2", "1e3", "99999999999999999999999"} {
+		_, err := Parse(amount, "USD")
+		assert.ErrorIs(t, err, ErrInvalidAmount, amount)
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "49.99 USD", Money{Amount: 4999, Currency: "USD"}.String())
+	assert.Equal(t, "0.05 EUR", Money{Amount: 5, Currency: "EUR"}.String())
+	assert.Equal(t, "-1.50 GBP", Money{Amount: -150, Currency: "GBP"}.String())
+	assert.Equal(t, "5000 JPY", Money{Amount: 5000, Currency: "JPY"}.String())
+	assert.Equal(t, "1.005 BHD", Money{Amount: 1005, Currency: "BHD"}.String())
+}
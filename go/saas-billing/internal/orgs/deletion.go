@@ -0,0 +1,422 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+// defaultRetentionDays is how long a deleted organization can be restored
+// before its data is purged
+const defaultRetentionDays = 30
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrOrganizationDeleted  = errors.New("organization is already deleted")
+	ErrNotDeleted           = errors.New("organization is not deleted or can no longer be restored")
+	ErrHasChildren          = errors.New("remove child organizations before deleting their parent")
+	ErrRestoreNotAllowed    = errors.New("only owners can restore an organization")
+)
+
+// canceledStatuses are the subscription statuses deletion cancels
+var canceledStatuses = []string{"active", "paused"}
+
+// DeletionResult describes how an organization's billing was closed out
+type DeletionResult struct {
+	OrgID                 string    `json:"org_id"`
+	DeletedAt             time.Time `json:"deleted_at"`
+	PurgeAfter            time.Time `json:"purge_after"`
+	CanceledSubscriptions int       `json:"canceled_subscriptions"`
+	// Unpaid invoices stay collectable: they're now due and rolled into a
+	// final invoice per currency
+	OutstandingCents int      `json:"outstanding_cents"`
+	FinalInvoiceIDs  []string `json:"final_invoice_ids"`
+}
+
+// outstandingInvoice is an invoice still owed when its organization is
+// deleted
+type outstandingInvoice struct {
+	ID             string
+	Currency       string
+	AmountDueCents int
+	CreatedAt      time.Time
+}
+
+// finalInvoice is the single bill in one currency an organization is left
+// to settle after deletion
+type finalInvoice struct {
+	Currency    string
+	PeriodStart time.Time
+	AmountCents int
+	Invoices    []outstandingInvoice
+}
+
+type DeletedOrganization struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// Delete soft-deletes the organization. Active and paused subscriptions
+// are canceled, and unpaid invoices become due immediately and are rolled
+// into a final invoice to settle. Pending invitations and ownership
+// transfers are withdrawn. Members lose access straight away but owners can
+// restore the organization until the retention period ends.
+func (s *OrganizationService) Delete(orgID string, actor audit.Actor) (*DeletionResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var name string
+	var deleted, hasChildren bool
+	err = tx.QueryRow(`
+		SELECT name, deleted_at IS NOT NULL,
+			EXISTS (SELECT 1 FROM organizations c WHERE c.parent_id = o.id AND c.deleted_at IS NULL)
+		FROM organizations o
+		WHERE id = $1
+		FOR UPDATE
+	`, orgID).Scan(&name, &deleted, &hasChildren)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrOrganizationDeleted
+	}
+	if hasChildren {
+		return nil, ErrHasChildren
+	}
+
+	result := DeletionResult{OrgID: orgID, FinalInvoiceIDs: []string{}}
+
+	res, err := tx.Exec(`
+		UPDATE subscriptions SET status = 'canceled', canceled_at = NOW()
+		WHERE org_id = $1 AND status = ANY($2)
+	`, orgID, pq.Array(canceledStatuses))
+	if err != nil {
+		return nil, err
+	}
+	canceled, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	result.CanceledSubscriptions = int(canceled)
+
+	outstanding, err := dueOutstandingInvoices(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, final := range finalInvoices(outstanding) {
+		id, err := issueFinalInvoice(tx, orgID, name, final)
+		if err != nil {
+			return nil, err
+		}
+		result.FinalInvoiceIDs = append(result.FinalInvoiceIDs, id)
+		result.OutstandingCents += final.AmountCents
+	}
+
+	_, err = tx.Exec(`
+		UPDATE invitations SET status = 'revoked', responded_at = NOW()
+		WHERE org_id = $1 AND status = 'pending'
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE ownership_transfers SET status = 'canceled', responded_at = NOW()
+		WHERE org_id = $1 AND status = 'pending'
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE organizations
+		SET deleted_at = NOW(), deleted_by = $2, purge_after = NOW() + make_interval(days => $3),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING deleted_at, purge_after
+	`, orgID, actor.UserID, retentionDays()).Scan(&result.DeletedAt, &result.PurgeAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.deleted",
+		TargetType: "organization",
+		TargetID:   orgID,
+		Metadata: map[string]interface{}{
+			"canceled_subscriptions": result.CanceledSubscriptions,
+			"outstanding_cents":      result.OutstandingCents,
+			"final_invoice_ids":      result.FinalInvoiceIDs,
+			"purge_after":            result.PurgeAfter,
+		},
+	})
+	return &result, nil
+}
+
+// dueOutstandingInvoices makes the organization's unpaid invoices due now
+// and returns them, oldest first. Invoices already rolled up into a
+// parent's consolidated invoice are settled there.
+func dueOutstandingInvoices(tx *sql.Tx, orgID string) ([]outstandingInvoice, error) {
+	rows, err := tx.Query(`
+		UPDATE invoices i SET due_date = LEAST(i.due_date, NOW())
+		FROM subscriptions s
+		WHERE s.id = i.subscription_id AND s.org_id = $1
+		  AND i.status = 'unpaid' AND i.consolidated_invoice_id IS NULL
+		RETURNING i.id, i.currency, i.amount_due_cents, i.created_at
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []outstandingInvoice
+	for rows.Next() {
+		var inv outstandingInvoice
+		if err := rows.Scan(&inv.ID, &inv.Currency, &inv.AmountDueCents, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(invoices, func(i, j int) bool { return invoices[i].CreatedAt.Before(invoices[j].CreatedAt) })
+	return invoices, nil
+}
+
+// finalInvoices groups outstanding invoices, oldest first, into one final
+// invoice per currency in the order the currencies first appear
+func finalInvoices(outstanding []outstandingInvoice) []finalInvoice {
+	var finals []finalInvoice
+	index := map[string]int{}
+	for _, inv := range outstanding {
+		i, ok := index[inv.Currency]
+		if !ok {
+			i = len(finals)
+			index[inv.Currency] = i
+			finals = append(finals, finalInvoice{Currency: inv.Currency, PeriodStart: inv.CreatedAt})
+		}
+		finals[i].AmountCents += inv.AmountDueCents
+		finals[i].Invoices = append(finals[i].Invoices, inv)
+	}
+	return finals
+}
+
+// issueFinalInvoice records a final invoice as a consolidated invoice of the
+// organization's own, so it's paid like any other and paying it settles the
+// invoices it covers
+func issueFinalInvoice(tx *sql.Tx, orgID, orgName string, final finalInvoice) (string, error) {
+	var id string
+	err := tx.QueryRow(`
+		INSERT INTO consolidated_invoices (parent_org_id, period_start, period_end, currency, amount_cents)
+		VALUES ($1, $2, NOW(), $3, $4)
+		RETURNING id
+	`, orgID, final.PeriodStart, final.Currency, final.AmountCents).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	for _, inv := range final.Invoices {
+		_, err = tx.Exec(`
+			INSERT INTO consolidated_invoice_lines (consolidated_invoice_id, org_id, description, invoice_id, amount_cents)
+			VALUES ($1, $2, $3, $4, $5)
+		`, id, orgID, fmt.Sprintf("Final invoice: %s", orgName), inv.ID, inv.AmountDueCents)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tx.Exec(`UPDATE invoices SET consolidated_invoice_id = $1 WHERE id = $2`, id, inv.ID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// Restore undoes a deletion within the retention period. Canceled
+// subscriptions stay canceled; the organization subscribes again as usual.
+func (s *OrganizationService) Restore(orgID string, actor audit.Actor) error {
+	result, err := s.db.Exec(`
+		UPDATE organizations o
+		SET deleted_at = NULL, deleted_by = NULL, purge_after = NULL, updated_at = NOW()
+		WHERE o.id = $1 AND o.deleted_at IS NOT NULL AND o.purged_at IS NULL AND o.purge_after > NOW()
+		  AND EXISTS (SELECT 1 FROM memberships m WHERE m.org_id = o.id AND m.user_id = $2 AND m.role = 'owner')
+	`, orgID, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		var owner bool
+		err = s.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM memberships WHERE org_id = $1 AND user_id = $2 AND role = 'owner')
+		`, orgID, actor.UserID).Scan(&owner)
+		if err != nil {
+			return err
+		}
+		if !owner {
+			return ErrRestoreNotAllowed
+		}
+		return ErrNotDeleted
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.restored",
+		TargetType: "organization",
+		TargetID:   orgID,
+	})
+	return nil
+}
+
+// ListDeleted returns the user's deleted organizations that they can still
+// restore
+func (s *OrganizationService) ListDeleted(userID string) ([]DeletedOrganization, error) {
+	rows, err := s.db.Query(`
+		SELECT o.id, o.name, o.deleted_at, o.purge_after
+		FROM organizations o
+		JOIN memberships m ON m.org_id = o.id
+		WHERE m.user_id = $1 AND m.role = 'owner'
+		  AND o.deleted_at IS NOT NULL AND o.purged_at IS NULL AND o.purge_after > NOW()
+		ORDER BY o.deleted_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []DeletedOrganization{}
+	for rows.Next() {
+		var org DeletedOrganization
+		if err := rows.Scan(&org.ID, &org.Name, &org.DeletedAt, &org.PurgeAfter); err != nil {
+			return nil, err
+		}
+		list = append(list, org)
+	}
+
+	return list, rows.Err()
+}
+
+// purgeStatements remove a deleted organization's data. Subscriptions and
+// their history, invoices, payments, refunds, credit notes, revenue
+// schedules, ledger entries, consolidated invoices and audit events are kept
+// for accounting; the credit balance's history goes, as the ledger records
+// it. The billing profile goes too, as invoices keep their own tax lines.
+// team_members go with their teams.
+var purgeStatements = []string{
+	`DELETE FROM subscription_schedule_phases
+	 WHERE schedule_id IN (SELECT id FROM subscription_schedules WHERE org_id = $1)`,
+	`DELETE FROM subscription_schedules WHERE org_id = $1`,
+	`DELETE FROM credit_balance_transactions WHERE org_id = $1`,
+	`DELETE FROM billing_profiles WHERE org_id = $1`,
+	`DELETE FROM sso_login_states WHERE org_id = $1`,
+	`DELETE FROM sso_connections WHERE org_id = $1`,
+	`DELETE FROM api_keys WHERE org_id = $1`,
+	`DELETE FROM usage_records WHERE org_id = $1`,
+	`DELETE FROM teams WHERE org_id = $1`,
+	`DELETE FROM org_roles WHERE org_id = $1`,
+	`DELETE FROM invitations WHERE org_id = $1`,
+	`DELETE FROM join_requests WHERE org_id = $1`,
+	`DELETE FROM org_domains WHERE org_id = $1`,
+	`DELETE FROM ownership_transfers WHERE org_id = $1`,
+	`DELETE FROM memberships WHERE org_id = $1`,
+	`UPDATE organizations
+	 SET name = 'Deleted organization', billing_contact_id = NULL, parent_id = NULL,
+		 deleted_by = NULL, purged_at = NOW(), updated_at = NOW()
+	 WHERE id = $1`,
+}
+
+// PurgeDeleted purges every deleted organization whose retention period has
+// ended and returns how many were purged. It is safe to run from several
+// instances at once.
+func (s *OrganizationService) PurgeDeleted() (int, error) {
+	purged := 0
+	for {
+		ok, err := s.purgeNext()
+		if err != nil {
+			return purged, err
+		}
+		if !ok {
+			return purged, nil
+		}
+		purged++
+	}
+}
+
+// purgeNext purges one due organization, reporting false when none is left
+func (s *OrganizationService) purgeNext() (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var orgID string
+	err = tx.QueryRow(`
+		SELECT id FROM organizations
+		WHERE deleted_at IS NOT NULL AND purged_at IS NULL AND purge_after <= NOW()
+		ORDER BY purge_after
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, stmt := range purgeStatements {
+		if _, err := tx.Exec(stmt, orgID); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Action:     "organization.purged",
+		TargetType: "organization",
+		TargetID:   orgID,
+	})
+	return true, nil
+}
+
+// retentionDays reads ORG_DELETION_RETENTION_DAYS, defaulting to 30
+func retentionDays() int {
+	if v, err := strconv.Atoi(os.Getenv("ORG_DELETION_RETENTION_DAYS")); err == nil && v > 0 {
+		return v
+	}
+	return defaultRetentionDays
+}
@@ -0,0 +1,129 @@
+package orgs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionDays(t *testing.T) {
+	t.Setenv("ORG_DELETION_RETENTION_DAYS", "")
+	assert.Equal(t, defaultRetentionDays, retentionDays())
+
+	t.Setenv("ORG_DELETION_RETENTION_DAYS", "90")
+	assert.Equal(t, 90, retentionDays())
+
+	t.Setenv("ORG_DELETION_RETENTION_DAYS", "-1")
+	assert.Equal(t, defaultRetentionDays, retentionDays())
+}
+
+func TestDelete(t *testing.T) {
+	s, mock := newOrgMock(t)
+	day := func(d int) time.Time { return time.Date(2025, 9, d, 0, 0, 0, 0, time.UTC) }
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, deleted_at IS NOT NULL`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "deleted", "has_children"}).AddRow("Acme", false, false))
+	// Paused subscriptions are canceled along with active ones
+	mock.ExpectExec(`UPDATE subscriptions SET status = 'canceled'`).WithArgs("org-1", `{"active","paused"}`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`UPDATE invoices i SET due_date`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency", "amount_due_cents", "created_at"}).
+			AddRow("inv-2", "EUR", 250, day(3)).
+			AddRow("inv-1", "EUR", 1000, day(1)))
+	mock.ExpectQuery(`INSERT INTO consolidated_invoices`).WithArgs("org-1", day(1), "EUR", 1250).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("final-1"))
+	for _, inv := range []string{"inv-1", "inv-2"} {
+		mock.ExpectExec(`INSERT INTO consolidated_invoice_lines`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE invoices SET consolidated_invoice_id`).WithArgs("final-1", inv).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`UPDATE invitations SET status = 'revoked'`).WithArgs("org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE ownership_transfers SET status = 'canceled'`).WithArgs("org-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SET deleted_at = NOW\(\)`).WithArgs("org-1", "user-1", defaultRetentionDays).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at", "purge_after"}).AddRow(day(10), day(40)))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	t.Setenv("ORG_DELETION_RETENTION_DAYS", "")
+	result, err := s.Delete("org-1", audit.Actor{UserID: "user-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.CanceledSubscriptions)
+	assert.Equal(t, 1250, result.OutstandingCents)
+	assert.Equal(t, []string{"final-1"}, result.FinalInvoiceIDs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteParentWithChildren(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, deleted_at IS NOT NULL`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "deleted", "has_children"}).AddRow("Acme", false, true))
+	mock.ExpectRollback()
+
+	_, err := s.Delete("org-1", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrHasChildren)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFinalInvoices(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 9, d, 0, 0, 0, 0, time.UTC) }
+
+	assert.Empty(t, finalInvoices(nil))
+
+	finals := finalInvoices([]outstandingInvoice{
+		{ID: "inv-1", Currency: "EUR", AmountDueCents: 1000, CreatedAt: day(1)},
+		{ID: "inv-2", Currency: "USD", AmountDueCents: 500, CreatedAt: day(2)},
+		{ID: "inv-3", Currency: "EUR", AmountDueCents: 250, CreatedAt: day(3)},
+	})
+
+	// One per currency, each covering the period since its oldest invoice
+	assert.Len(t, finals, 2)
+	assert.Equal(t, "EUR", finals[0].Currency)
+	assert.Equal(t, 1250, finals[0].AmountCents)
+	assert.Equal(t, day(1), finals[0].PeriodStart)
+	assert.Len(t, finals[0].Invoices, 2)
+	assert.Equal(t, "USD", finals[1].Currency)
+	assert.Equal(t, 500, finals[1].AmountCents)
+	assert.Equal(t, day(2), finals[1].PeriodStart)
+}
+
+func TestPurgeNext(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM organizations`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("org-1"))
+	// Every per-organization table is cleared, children before the rows they
+	// reference. Accounting records aren't touched: any other statement
+	// fails the test.
+	for _, table := range []string{
+		"subscription_schedule_phases", "subscription_schedules", "credit_balance_transactions", "billing_profiles",
+		"sso_login_states", "sso_connections", "api_keys", "usage_records", "teams", "org_roles",
+		"invitations", "join_requests", "org_domains", "ownership_transfers", "memberships",
+	} {
+		mock.ExpectExec(`DELETE FROM ` + table + `\s+WHERE`).WithArgs("org-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	// The organization row itself is anonymized, never deleted
+	mock.ExpectExec(`UPDATE organizations\s+SET name = 'Deleted organization'`).WithArgs("org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM organizations`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	purged, err := s.PurgeDeleted()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
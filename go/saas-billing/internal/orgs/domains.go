@@ -0,0 +1,589 @@
+package orgs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+// Join policies for users who verify an email address at an organization's
+// verified domain
+const (
+	JoinPolicyOff     = "off"
+	JoinPolicyAuto    = "auto"
+	JoinPolicyRequest = "request"
+)
+
+const (
+	// The challenge is published as a TXT record on this subdomain
+	verificationRecordPrefix = "_saas-billing-challenge."
+	verificationValuePrefix  = "saas-billing-verification="
+	dnsLookupTimeout         = 5 * time.Second
+)
+
+var (
+	ErrInvalidDomain       = errors.New("invalid domain name")
+	ErrDomainNotFound      = errors.New("domain not found")
+	ErrDomainExists        = errors.New("domain has already been added to this organization")
+	ErrDomainClaimed       = errors.New("domain is verified by another organization")
+	ErrDomainNotVerified   = errors.New("domain must be verified first")
+	ErrVerificationFailed  = errors.New("verification TXT record not found")
+	ErrJoinRequestNotFound = errors.New("join request not found")
+	ErrSSORequired         = errors.New("this email domain requires single sign-on")
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type Domain struct {
+	ID          string     `json:"id"`
+	OrgID       string     `json:"org_id"`
+	Domain      string     `json:"domain"`
+	RecordName  string     `json:"record_name"`
+	RecordValue string     `json:"record_value"`
+	Verified    bool       `json:"verified"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	JoinPolicy  string     `json:"join_policy"`
+	DefaultRole string     `json:"default_role"`
+	EnforceSSO  bool       `json:"enforce_sso"`
+	CreatedAt   string     `json:"created_at"`
+}
+
+// DomainSettings are the changeable parts of a domain; nil fields are kept
+type DomainSettings struct {
+	JoinPolicy  *string
+	DefaultRole *string
+	EnforceSSO  *bool
+}
+
+type JoinRequest struct {
+	ID        string `json:"id"`
+	OrgID     string `json:"org_id"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// DomainJoin reports what happened to a user whose verified email matched an
+// organization's domain. Status is "joined" or "requested".
+type DomainJoin struct {
+	OrgID  string `json:"org_id"`
+	Role   string `json:"role"`
+	Status string `json:"status"`
+}
+
+type DomainService struct {
+	db       *sql.DB
+	audit    *audit.AuditService
+	resolver TXTResolver
+}
+
+// NewDomainService uses the system resolver when resolver is nil
+func NewDomainService(db *sql.DB, auditService *audit.AuditService, resolver TXTResolver) *DomainService {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &DomainService{db: db, audit: auditService, resolver: resolver}
+}
+
+// AddDomain starts verification of a domain. The organization proves
+// control by publishing the returned TXT record.
+func (s *DomainService) AddDomain(orgID, domain string, actor audit.Actor) (*Domain, error) {
+	domain, err := normalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	d := Domain{OrgID: orgID, Domain: domain, JoinPolicy: JoinPolicyOff, DefaultRole: "member"}
+	err = s.db.QueryRow(`
+		INSERT INTO org_domains (org_id, domain, verification_token)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, orgID, domain, token).Scan(&d.ID, &d.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrDomainExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.RecordName, d.RecordValue = verificationRecord(domain, token)
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "domain.added",
+		TargetType: "domain",
+		TargetID:   d.ID,
+		Metadata:   map[string]interface{}{"domain": domain},
+	})
+	return &d, nil
+}
+
+func (s *DomainService) ListDomains(orgID string) ([]Domain, error) {
+	rows, err := s.db.Query(`
+		SELECT id, org_id, domain, verification_token, verified_at, join_policy, default_role, enforce_sso, created_at
+		FROM org_domains
+		WHERE org_id = $1
+		ORDER BY domain
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []Domain{}
+	for rows.Next() {
+		d, _, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, *d)
+	}
+
+	return domains, rows.Err()
+}
+
+// VerifyDomain checks the TXT record and marks the domain verified. Only one
+// organization can hold a verified domain.
+func (s *DomainService) VerifyDomain(orgID, domainID string, actor audit.Actor) (*Domain, error) {
+	d, token, err := s.getDomain(orgID, domainID)
+	if err != nil {
+		return nil, err
+	}
+	if d.Verified {
+		return d, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	if !hasVerificationRecord(ctx, s.resolver, d.Domain, token) {
+		return nil, ErrVerificationFailed
+	}
+
+	err = s.db.QueryRow(`
+		UPDATE org_domains SET verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+		RETURNING verified_at
+	`, d.ID).Scan(&d.VerifiedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrDomainClaimed
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Verified = true
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "domain.verified",
+		TargetType: "domain",
+		TargetID:   d.ID,
+		Metadata:   map[string]interface{}{"domain": d.Domain},
+	})
+	return d, nil
+}
+
+// UpdateDomainSettings changes the join policy, default role and SSO
+// enforcement. Anything other than the defaults needs a verified domain.
+func (s *DomainService) UpdateDomainSettings(orgID, domainID string, settings DomainSettings, actor audit.Actor) (*Domain, error) {
+	d, _, err := s.getDomain(orgID, domainID)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.JoinPolicy != nil {
+		d.JoinPolicy = *settings.JoinPolicy
+	}
+	if settings.DefaultRole != nil {
+		d.DefaultRole = *settings.DefaultRole
+	}
+	if settings.EnforceSSO != nil {
+		d.EnforceSSO = *settings.EnforceSSO
+	}
+	if !d.Verified && (d.JoinPolicy != JoinPolicyOff || d.EnforceSSO) {
+		return nil, ErrDomainNotVerified
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE org_domains SET join_policy = $1, default_role = $2, enforce_sso = $3, updated_at = NOW()
+		WHERE id = $4
+	`, d.JoinPolicy, d.DefaultRole, d.EnforceSSO, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "domain.updated",
+		TargetType: "domain",
+		TargetID:   d.ID,
+		Metadata: map[string]interface{}{
+			"join_policy":  d.JoinPolicy,
+			"default_role": d.DefaultRole,
+			"enforce_sso":  d.EnforceSSO,
+		},
+	})
+	return d, nil
+}
+
+// GetDomain returns one of the organization's domains
+func (s *DomainService) GetDomain(orgID, domainID string) (*Domain, error) {
+	d, _, err := s.getDomain(orgID, domainID)
+	return d, err
+}
+
+func (s *DomainService) DeleteDomain(orgID, domainID string, actor audit.Actor) error {
+	result, err := s.db.Exec(`
+		DELETE FROM org_domains WHERE id::text = $1 AND org_id = $2
+	`, domainID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrDomainNotFound
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "domain.removed",
+		TargetType: "domain",
+		TargetID:   domainID,
+	})
+	return nil
+}
+
+// JoinByDomain applies the join policy of the organization holding the
+// email's domain, if any. Call it only once the user has proven they own the
+// address. It returns nil when no organization applies. When an auto-join
+// organization is out of seats a join request is filed instead.
+func (s *DomainService) JoinByDomain(userID, email string) (*DomainJoin, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var join DomainJoin
+	var policy string
+	err = tx.QueryRow(`
+		SELECT d.org_id, d.join_policy, d.default_role
+		FROM org_domains d
+		JOIN organizations o ON o.id = d.org_id
+		WHERE d.domain = $1 AND d.verified_at IS NOT NULL AND d.join_policy <> 'off'
+		  AND o.deleted_at IS NULL
+		FOR UPDATE OF o
+	`, strings.ToLower(email[at+1:])).Scan(&join.OrgID, &policy, &join.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var member bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM memberships WHERE org_id = $1 AND user_id = $2)
+	`, join.OrgID, userID).Scan(&member)
+	if err != nil {
+		return nil, err
+	}
+	if member {
+		return nil, nil
+	}
+
+	join.Status = "requested"
+	if policy == JoinPolicyAuto {
+		err = CheckSeats(tx, join.OrgID)
+		if err != nil && !errors.Is(err, ErrSeatLimitReached) {
+			return nil, err
+		}
+		if err == nil {
+			join.Status = "joined"
+		}
+	}
+
+	if join.Status == "joined" {
+		_, err = tx.Exec(`
+			INSERT INTO memberships (user_id, org_id, role)
+			VALUES ($1, $2, $3)
+		`, userID, join.OrgID, join.Role)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO join_requests (org_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (org_id, user_id) WHERE status = 'pending' DO NOTHING
+		`, join.OrgID, userID, join.Role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      join.OrgID,
+		Actor:      audit.Actor{UserID: userID},
+		Action:     "domain.join_" + join.Status,
+		TargetType: "user",
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"role": join.Role},
+	})
+	return &join, nil
+}
+
+func (s *DomainService) ListJoinRequests(orgID string) ([]JoinRequest, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.org_id, r.user_id, u.email, r.role, r.status, r.created_at
+		FROM join_requests r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.org_id = $1 AND r.status = 'pending'
+		ORDER BY r.created_at
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []JoinRequest{}
+	for rows.Next() {
+		var r JoinRequest
+		if err := rows.Scan(&r.ID, &r.OrgID, &r.UserID, &r.Email, &r.Role, &r.Status, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+
+	return requests, rows.Err()
+}
+
+// ApproveJoinRequest adds the requester with role, or with the role recorded
+// on the request when role is empty
+func (s *DomainService) ApproveJoinRequest(orgID, requestID, role string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return err
+	}
+
+	var userID, requestedRole string
+	err = tx.QueryRow(`
+		UPDATE join_requests SET status = 'approved', responded_by = $3, responded_at = NOW()
+		WHERE id::text = $1 AND org_id = $2 AND status = 'pending'
+		RETURNING user_id, role
+	`, requestID, orgID, actor.UserID).Scan(&userID, &requestedRole)
+	if err == sql.ErrNoRows {
+		return ErrJoinRequestNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		role = requestedRole
+	}
+
+	if err = CheckSeats(tx, orgID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO memberships (user_id, org_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, userID, orgID, role)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "join_request.approved",
+		TargetType: "user",
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"role": role},
+	})
+	return nil
+}
+
+func (s *DomainService) RejectJoinRequest(orgID, requestID string, actor audit.Actor) error {
+	var userID string
+	err := s.db.QueryRow(`
+		UPDATE join_requests SET status = 'rejected', responded_by = $3, responded_at = NOW()
+		WHERE id::text = $1 AND org_id = $2 AND status = 'pending'
+		RETURNING user_id
+	`, requestID, orgID, actor.UserID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return ErrJoinRequestNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "join_request.rejected",
+		TargetType: "user",
+		TargetID:   userID,
+	})
+	return nil
+}
+
+// SSORequired returns the organization that enforces SSO for the email's
+// domain, or an empty string when passwords are allowed
+func (s *DomainService) SSORequired(email string) (string, error) {
+	return ssoEnforcingOrg(s.db, email)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func ssoEnforcingOrg(q queryRower, email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", nil
+	}
+
+	var orgID string
+	err := q.QueryRow(`
+		SELECT d.org_id
+		FROM org_domains d
+		JOIN organizations o ON o.id = d.org_id
+		WHERE d.domain = $1 AND d.verified_at IS NOT NULL AND d.enforce_sso
+		  AND o.deleted_at IS NULL
+	`, strings.ToLower(email[at+1:])).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return orgID, err
+}
+
+func (s *DomainService) getDomain(orgID, domainID string) (*Domain, string, error) {
+	row := s.db.QueryRow(`
+		SELECT id, org_id, domain, verification_token, verified_at, join_policy, default_role, enforce_sso, created_at
+		FROM org_domains
+		WHERE id::text = $1 AND org_id = $2
+	`, domainID, orgID)
+	d, token, err := scanDomain(row)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrDomainNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return d, token, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDomain also returns the verification token
+func scanDomain(row rowScanner) (*Domain, string, error) {
+	var d Domain
+	var token string
+	err := row.Scan(&d.ID, &d.OrgID, &d.Domain, &token, &d.VerifiedAt,
+		&d.JoinPolicy, &d.DefaultRole, &d.EnforceSSO, &d.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	d.Verified = d.VerifiedAt != nil
+	d.RecordName, d.RecordValue = verificationRecord(d.Domain, token)
+	return &d, token, nil
+}
+
+func verificationRecord(domain, token string) (string, string) {
+	return verificationRecordPrefix + domain, verificationValuePrefix + token
+}
+
+// hasVerificationRecord reports whether the challenge TXT record is
+// published. Lookup errors count as not found.
+func hasVerificationRecord(ctx context.Context, resolver TXTResolver, domain, token string) bool {
+	name, want := verificationRecord(domain, token)
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range records {
+		if strings.TrimSpace(r) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain lowercases the name and checks it looks like a registrable
+// host name
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) > 253 || !strings.Contains(domain, ".") {
+		return "", ErrInvalidDomain
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", ErrInvalidDomain
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", ErrInvalidDomain
+			}
+		}
+	}
+
+	return domain, nil
+}
+
+func newVerificationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
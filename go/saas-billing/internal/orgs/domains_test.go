@@ -0,0 +1,50 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver serves TXT records from a map instead of DNS
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := f[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func TestHasVerificationRecord(t *testing.T) {
+	resolver := fakeResolver{
+		"_saas-billing-challenge.example.com": {"v=spf1 -all", "saas-billing-verification=abc123"},
+		"_saas-billing-challenge.other.com":   {"saas-billing-verification=wrong"},
+	}
+	ctx := context.Background()
+
+	assert.True(t, hasVerificationRecord(ctx, resolver, "example.com", "abc123"))
+	assert.False(t, hasVerificationRecord(ctx, resolver, "other.com", "abc123"))
+	assert.False(t, hasVerificationRecord(ctx, resolver, "missing.com", "abc123"))
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	for input, want := range map[string]string{
+		"Example.COM":           "example.com",
+		" sub.example.com. ":    "sub.example.com",
+		"my-company.co.uk":      "my-company.co.uk",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+	} {
+		got, err := normalizeDomain(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got)
+	}
+
+	for _, input := range []string{"", "localhost", "exa mple.com", "-bad.com", "a..com", "user@example.com"} {
+		_, err := normalizeDomain(input)
+		assert.ErrorIs(t, err, ErrInvalidDomain, input)
+	}
+}
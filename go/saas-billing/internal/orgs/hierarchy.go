@@ -0,0 +1,188 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+// RoleParentAdmin is the effective role in a child organization of members
+// with an admin role in its parent. It is never stored on a membership.
+const RoleParentAdmin = "parent_admin"
+
+var (
+	ErrInvalidHierarchy = errors.New("a parent can't have a parent and a child can't have children")
+	ErrParentPermission = errors.New("you need org:manage in the parent organization")
+	ErrNoParent         = errors.New("organization has no parent")
+)
+
+// SetParent makes childID a subsidiary of parentID. The hierarchy is one
+// level deep. The actor must hold org:manage in the parent; the route checks
+// the same for the child.
+func (s *OrganizationService) SetParent(childID, parentID string, actor audit.Actor) error {
+	if childID == parentID {
+		return ErrInvalidHierarchy
+	}
+
+	role, err := s.CheckUserRole(actor.UserID, parentID)
+	if err != nil {
+		return ErrParentPermission
+	}
+	allowed, err := s.HasPermission(parentID, role, PermOrgManage)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrParentPermission
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both rows in a fixed order so concurrent changes can't build a
+	// deeper tree between the checks and the update
+	rows, err := tx.Query(`
+		SELECT id, COALESCE(parent_id::text, ''),
+			EXISTS (SELECT 1 FROM organizations c WHERE c.parent_id = o.id)
+		FROM organizations o
+		WHERE id IN ($1, $2)
+		ORDER BY id
+		FOR UPDATE
+	`, childID, parentID)
+	if err != nil {
+		return err
+	}
+	parents := map[string]string{}
+	hasChildren := map[string]bool{}
+	for rows.Next() {
+		var id, parent string
+		var children bool
+		if err := rows.Scan(&id, &parent, &children); err != nil {
+			rows.Close()
+			return err
+		}
+		parents[id] = parent
+		hasChildren[id] = children
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(parents) != 2 {
+		return ErrOrganizationNotFound
+	}
+	if parents[parentID] != "" || hasChildren[childID] {
+		return ErrInvalidHierarchy
+	}
+
+	_, err = tx.Exec(`
+		UPDATE organizations SET parent_id = $1, updated_at = NOW() WHERE id = $2
+	`, parentID, childID)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      childID,
+		Actor:      actor,
+		Action:     "organization.parent_set",
+		TargetType: "organization",
+		TargetID:   parentID,
+	})
+	return nil
+}
+
+// RemoveParent detaches the organization from its parent
+func (s *OrganizationService) RemoveParent(childID string, actor audit.Actor) error {
+	var parentID string
+	err := s.db.QueryRow(`
+		UPDATE organizations o SET parent_id = NULL, updated_at = NOW()
+		FROM organizations old
+		WHERE o.id = $1 AND old.id = o.id AND old.parent_id IS NOT NULL
+		RETURNING old.parent_id
+	`, childID).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		return ErrNoParent
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      childID,
+		Actor:      actor,
+		Action:     "organization.parent_removed",
+		TargetType: "organization",
+		TargetID:   parentID,
+	})
+	return nil
+}
+
+func (s *OrganizationService) ListChildren(parentID string) ([]Organization, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, require_mfa_for_admins, COALESCE(billing_contact_id::text, ''),
+			COALESCE(parent_id::text, ''), created_at
+		FROM organizations
+		WHERE parent_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.RequireMFAForAdmins, &org.BillingContactID,
+			&org.ParentID, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		children = append(children, org)
+	}
+
+	return children, rows.Err()
+}
+
+// EffectiveRole is the role route checks use: the user's own membership, or
+// RoleParentAdmin for members whose role in the parent organization is an
+// admin role, built-in or custom
+func (s *OrganizationService) EffectiveRole(userID, orgID string) (string, error) {
+	role, err := s.CheckUserRole(userID, orgID)
+	if err == nil {
+		return role, nil
+	}
+
+	var parentID, parentRole string
+	perr := s.db.QueryRow(`
+		SELECT o.parent_id, m.role
+		FROM organizations o
+		JOIN memberships m ON m.org_id = o.parent_id
+		WHERE o.id = $1 AND m.user_id = $2 AND o.deleted_at IS NULL
+	`, orgID, userID).Scan(&parentID, &parentRole)
+	if perr == sql.ErrNoRows {
+		return "", err
+	}
+	if perr != nil {
+		return "", perr
+	}
+
+	admin, perr := s.IsAdminRole(parentID, parentRole)
+	if perr != nil {
+		return "", perr
+	}
+	if admin {
+		return RoleParentAdmin, nil
+	}
+
+	return "", err
+}
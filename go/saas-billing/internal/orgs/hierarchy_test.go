@@ -0,0 +1,132 @@
+package orgs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectHierarchy returns each organization's current parent, and whether
+// it has children, as SetParent reads them under lock
+func expectHierarchy(mock sqlmock.Sqlmock, child, parent string, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`FROM organizations o\s+WHERE id IN \(\$1, \$2\)`).WithArgs(child, parent).WillReturnRows(rows)
+}
+
+func hierarchyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "parent_id", "has_children"})
+}
+
+func TestSetParent(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectQuery(`SELECT m.role FROM memberships m`).WithArgs("user-1", "parent").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("owner"))
+	mock.ExpectBegin()
+	expectHierarchy(mock, "child", "parent", hierarchyRows().AddRow("child", "", false).AddRow("parent", "", false))
+	mock.ExpectExec(`UPDATE organizations SET parent_id = \$1`).WithArgs("parent", "child").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, s.SetParent("child", "parent", audit.Actor{UserID: "user-1"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetParentRejected(t *testing.T) {
+	tests := []struct {
+		name          string
+		child, parent string
+		role          string
+		rows          *sqlmock.Rows
+		want          error
+	}{
+		{"own parent", "org-a", "org-a", "", nil, ErrInvalidHierarchy},
+		{"no org:manage in the parent", "org-a", "org-b", "admin", nil, ErrParentPermission},
+		// org-b is org-a's child, so making it org-a's parent would close a loop
+		{"cycle", "org-a", "org-b", "owner",
+			hierarchyRows().AddRow("org-a", "", true).AddRow("org-b", "org-a", false), ErrInvalidHierarchy},
+		{"parent is itself a child", "org-a", "org-b", "owner",
+			hierarchyRows().AddRow("org-a", "", false).AddRow("org-b", "org-c", false), ErrInvalidHierarchy},
+		{"child has children", "org-a", "org-b", "owner",
+			hierarchyRows().AddRow("org-a", "", true).AddRow("org-b", "", false), ErrInvalidHierarchy},
+		{"unknown organization", "org-a", "org-b", "owner",
+			hierarchyRows().AddRow("org-b", "", false), ErrOrganizationNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newOrgMock(t)
+			if tt.role != "" {
+				mock.ExpectQuery(`SELECT m.role FROM memberships m`).WithArgs("user-1", tt.parent).
+					WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(tt.role))
+			}
+			if tt.rows != nil {
+				mock.ExpectBegin()
+				expectHierarchy(mock, tt.child, tt.parent, tt.rows)
+				mock.ExpectRollback()
+			}
+
+			err := s.SetParent(tt.child, tt.parent, audit.Actor{UserID: "user-1"})
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestEffectiveRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		parentRole  string
+		permissions []string
+		want        string
+	}{
+		{"parent owner", "owner", nil, RoleParentAdmin},
+		{"parent admin", "admin", nil, RoleParentAdmin},
+		{"parent member", "member", nil, ""},
+		{"custom admin role in the parent", "finance", []string{PermMembersRead, PermBillingManage}, RoleParentAdmin},
+		{"custom read-only role in the parent", "auditor", []string{PermMembersRead, PermAuditRead}, ""},
+		{"not in the parent", "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newOrgMock(t)
+			mock.ExpectQuery(`SELECT m.role FROM memberships m`).WithArgs("user-1", "child").
+				WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+			rows := sqlmock.NewRows([]string{"parent_id", "role"})
+			if tt.parentRole != "" {
+				rows.AddRow("parent", tt.parentRole)
+			}
+			mock.ExpectQuery(`JOIN memberships m ON m.org_id = o.parent_id`).WithArgs("child", "user-1").WillReturnRows(rows)
+			if tt.permissions != nil {
+				mock.ExpectQuery(`SELECT permissions FROM org_roles`).WithArgs("parent", tt.parentRole).
+					WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{" + strings.Join(tt.permissions, ",") + "}"))
+			}
+
+			role, err := s.EffectiveRole("user-1", "child")
+			assert.Equal(t, tt.want, role)
+			if tt.want == "" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestEffectiveRolePrefersOwnMembership(t *testing.T) {
+	s, mock := newOrgMock(t)
+	mock.ExpectQuery(`SELECT m.role FROM memberships m`).WithArgs("user-1", "child").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("member"))
+
+	role, err := s.EffectiveRole("user-1", "child")
+	require.NoError(t, err)
+	assert.Equal(t, "member", role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
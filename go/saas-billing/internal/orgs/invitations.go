@@ -0,0 +1,391 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/linkmeAman/saas-billing/internal/config"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/mailer"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation has expired")
+	ErrAlreadyInvited     = errors.New("an invitation is already pending for this email")
+	ErrAlreadyMember      = errors.New("user is already a member of this organization")
+	ErrSeatLimitReached   = errors.New("organization has no seats left on its plan")
+	ErrPasswordRequired   = errors.New("password required to create an account")
+)
+
+type Invitation struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by,omitempty"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// AcceptResult carries an access token only when the invitation created the
+// account; existing users still sign in normally so MFA is not bypassed.
+type AcceptResult struct {
+	OrgID          string `json:"org_id"`
+	UserID         string `json:"user_id"`
+	Role           string `json:"role"`
+	AccountCreated bool   `json:"account_created"`
+	Token          string `json:"token,omitempty"`
+}
+
+type InvitationService struct {
+	db     *sql.DB
+	audit  *audit.AuditService
+	mailer mailer.Mailer
+}
+
+func NewInvitationService(db *sql.DB, auditService *audit.AuditService, m mailer.Mailer) *InvitationService {
+	return &InvitationService{db: db, audit: auditService, mailer: m}
+}
+
+// Create invites email to the organization and mails a signed accept link
+func (s *InvitationService) Create(orgID, email, role string, actor audit.Actor) (*Invitation, error) {
+	invitedBy := actor.UserID
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var isMember bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM memberships m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.org_id = $1 AND lower(u.email) = $2
+		)
+	`, orgID, email).Scan(&isMember)
+	if err != nil {
+		return nil, err
+	}
+	if isMember {
+		return nil, ErrAlreadyMember
+	}
+
+	// Clear out a lapsed invitation so the address can be invited again
+	_, err = s.db.Exec(`
+		UPDATE invitations SET status = 'expired'
+		WHERE org_id = $1 AND lower(email) = $2 AND status = 'pending' AND expires_at <= NOW()
+	`, orgID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := Invitation{OrgID: orgID, Email: email, Role: role, InvitedBy: invitedBy}
+	err = s.db.QueryRow(`
+		INSERT INTO invitations (org_id, email, role, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, expires_at, created_at
+	`, orgID, email, role, invitedBy, time.Now().Add(invitationTTL)).Scan(
+		&inv.ID, &inv.Status, &inv.ExpiresAt, &inv.CreatedAt,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrAlreadyInvited
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "invitation.created",
+		TargetType: "invitation",
+		TargetID:   inv.ID,
+		Metadata:   map[string]interface{}{"email": email, "role": role},
+	})
+	s.sendInvitationEmail(&inv)
+
+	return &inv, nil
+}
+
+// ListPending returns the organization's open, unexpired invitations
+func (s *InvitationService) ListPending(orgID string) ([]Invitation, error) {
+	rows, err := s.db.Query(`
+		SELECT id, org_id, email, role, COALESCE(invited_by::text, ''), status, expires_at, created_at
+		FROM invitations
+		WHERE org_id = $1 AND status = 'pending' AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy,
+			&inv.Status, &inv.ExpiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, rows.Err()
+}
+
+// Revoke cancels a pending invitation so its link stops working
+func (s *InvitationService) Revoke(orgID, invitationID string, actor audit.Actor) error {
+	result, err := s.db.Exec(`
+		UPDATE invitations SET status = 'revoked', responded_at = NOW()
+		WHERE id = $1 AND org_id = $2 AND status = 'pending'
+	`, invitationID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvitationNotFound
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "invitation.revoked",
+		TargetType: "invitation",
+		TargetID:   invitationID,
+	})
+	return nil
+}
+
+// Accept joins the invited user to the organization, creating the account
+// with password if no user has the invited email yet
+func (s *InvitationService) Accept(token, password string) (*AcceptResult, error) {
+	claims, err := auth.ValidateScopedToken(token, auth.ScopeInvitation)
+	if err != nil {
+		return nil, ErrInvitationNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := pendingInvitation(tx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serialize accepts per organization so two of them can't both take the
+	// last seat
+	if _, err = tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, inv.OrgID); err != nil {
+		return nil, err
+	}
+	if err = CheckSeats(tx, inv.OrgID); err != nil {
+		return nil, err
+	}
+
+	result := &AcceptResult{OrgID: inv.OrgID, Role: inv.Role}
+	err = tx.QueryRow(`SELECT id FROM users WHERE lower(email) = $1`, inv.Email).Scan(&result.UserID)
+	if err == sql.ErrNoRows {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		if orgID, err := ssoEnforcingOrg(tx, inv.Email); err != nil {
+			return nil, err
+		} else if orgID != "" {
+			return nil, ErrSSORequired
+		}
+		if err := auth.ValidatePassword(password, inv.Email); err != nil {
+			return nil, fmt.Errorf("%w: %w", users.ErrWeakPassword, err)
+		}
+		hashedPassword, err := auth.HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(`
+			INSERT INTO users (email, password_hash, email_verified_at)
+			VALUES ($1, $2, NOW())
+			RETURNING id
+		`, inv.Email, hashedPassword).Scan(&result.UserID)
+		if err != nil {
+			return nil, err
+		}
+		result.AccountCreated = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO memberships (user_id, org_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, result.UserID, inv.OrgID, inv.Role)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if rows == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	if err = respond(tx, inv.ID, "accepted"); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if result.AccountCreated {
+		result.Token, err = auth.GenerateToken(result.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      inv.OrgID,
+		Actor:      audit.Actor{UserID: result.UserID},
+		Action:     "invitation.accepted",
+		TargetType: "invitation",
+		TargetID:   inv.ID,
+		Metadata:   map[string]interface{}{"role": inv.Role, "account_created": result.AccountCreated},
+	})
+
+	return result, nil
+}
+
+// Decline closes the invitation without joining
+func (s *InvitationService) Decline(token string) error {
+	claims, err := auth.ValidateScopedToken(token, auth.ScopeInvitation)
+	if err != nil {
+		return ErrInvitationNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inv, err := pendingInvitation(tx, claims.Subject)
+	if err != nil {
+		return err
+	}
+	if err = respond(tx, inv.ID, "declined"); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      inv.OrgID,
+		Action:     "invitation.declined",
+		TargetType: "invitation",
+		TargetID:   inv.ID,
+	})
+	return nil
+}
+
+// pendingInvitation locks the invitation row and checks it can still be used
+func pendingInvitation(tx *sql.Tx, invitationID string) (*Invitation, error) {
+	var inv Invitation
+	err := tx.QueryRow(`
+		SELECT id, org_id, email, role, status, expires_at
+		FROM invitations
+		WHERE id = $1
+		FOR UPDATE
+	`, invitationID).Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.Status, &inv.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Status != "pending" {
+		return nil, ErrInvitationNotFound
+	}
+	if time.Now().After(inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+
+	return &inv, nil
+}
+
+func respond(tx *sql.Tx, invitationID, status string) error {
+	_, err := tx.Exec(`
+		UPDATE invitations SET status = $1, responded_at = NOW()
+		WHERE id = $2
+	`, status, invitationID)
+	return err
+}
+
+// CheckSeats fails when the active plan's seat limit is already used up.
+// A paused subscription has the plan's paused limit, if it sets one.
+// Organizations without a subscription, or on a plan without a limit, have
+// unlimited seats.
+func CheckSeats(tx *sql.Tx, orgID string) error {
+	var maxSeats sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT CASE WHEN s.status = 'paused' THEN COALESCE(p.paused_max_seats, p.max_seats) ELSE p.max_seats END
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.org_id = $1 AND s.status IN ('active', 'paused')
+	`, orgID).Scan(&maxSeats)
+	if err == sql.ErrNoRows || (err == nil && !maxSeats.Valid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var members int64
+	err = tx.QueryRow(`SELECT COUNT(*) FROM memberships WHERE org_id = $1`, orgID).Scan(&members)
+	if err != nil {
+		return err
+	}
+	if members >= maxSeats.Int64 {
+		return ErrSeatLimitReached
+	}
+
+	return nil
+}
+
+func (s *InvitationService) sendInvitationEmail(inv *Invitation) {
+	token, err := auth.GenerateInvitationToken(inv.ID, time.Until(inv.ExpiresAt))
+	if err != nil {
+		logger.Error("Failed to create invitation token", err, logger.Fields{"invitation_id": inv.ID})
+		return
+	}
+
+	var orgName string
+	if err := s.db.QueryRow(`SELECT name FROM organizations WHERE id = $1`, inv.OrgID).Scan(&orgName); err != nil {
+		orgName = "an organization"
+	}
+
+	body := fmt.Sprintf("You have been invited to join %s as %s.\n\n"+
+		"Accept the invitation:\n%s/invitations/accept?token=%s\n\n"+
+		"The link expires in %d days. If you weren't expecting this, you can ignore this email.",
+		orgName, inv.Role, config.AppURL(), token, int(invitationTTL.Hours()/24))
+
+	if err := s.mailer.Send(inv.Email, "You're invited to join "+orgName, body); err != nil {
+		logger.Error("Failed to send invitation email", err, logger.Fields{"invitation_id": inv.ID})
+	}
+}
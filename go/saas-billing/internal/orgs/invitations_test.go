@@ -0,0 +1,158 @@
+package orgs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInvitationMock(t *testing.T) (*InvitationService, sqlmock.Sqlmock) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	t.Cleanup(func() { os.Unsetenv("JWT_SECRET") })
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewInvitationService(db, audit.NewAuditService(db), nil), mock
+}
+
+func expectInvitation(mock sqlmock.Sqlmock, status string, expiresAt time.Time) {
+	mock.ExpectQuery(`SELECT id, org_id, email, role, status, expires_at\s+FROM invitations`).
+		WithArgs("inv-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "email", "role", "status", "expires_at"}).
+			AddRow("inv-1", "org-1", "jane@acme.test", "member", status, expiresAt))
+}
+
+// expectSeats returns the plan's seat limit and, when there is one, the
+// current member count
+func expectSeats(mock sqlmock.Sqlmock, maxSeats interface{}, members int) {
+	mock.ExpectExec(`SELECT id FROM organizations WHERE id = \$1 FOR UPDATE`).
+		WithArgs("org-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM subscriptions s`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"max_seats"}).AddRow(maxSeats))
+	if maxSeats != nil {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM memberships`).WithArgs("org-1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(members))
+	}
+}
+
+func TestCreateInvitation(t *testing.T) {
+	s, mock := newInvitationMock(t)
+
+	// Addresses are compared case-insensitively, so an existing member can't
+	// be invited again under different casing
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs("org-1", "jane@acme.test").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	_, err := s.Create("org-1", " Jane@Acme.test ", "member", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrAlreadyMember)
+
+	// The unique index on pending invitations reports a duplicate
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs("org-1", "jane@acme.test").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`UPDATE invitations SET status = 'expired'`).WithArgs("org-1", "jane@acme.test").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO invitations`).
+		WithArgs("org-1", "jane@acme.test", "member", "user-1", sqlmock.AnyArg()).
+		WillReturnError(&pq.Error{Code: "23505"})
+	_, err = s.Create("org-1", "jane@acme.test", "member", audit.Actor{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrAlreadyInvited)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcceptInvitationJoinsInvitedAccount(t *testing.T) {
+	s, mock := newInvitationMock(t)
+	token, err := auth.GenerateInvitationToken("inv-1", time.Hour)
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	expectInvitation(mock, "pending", time.Now().Add(time.Hour))
+	expectSeats(mock, 5, 4)
+	// The account is looked up by the invited address, whoever holds the link
+	mock.ExpectQuery(`SELECT id FROM users WHERE lower\(email\) = \$1`).WithArgs("jane@acme.test").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-9"))
+	mock.ExpectExec(`INSERT INTO memberships`).WithArgs("user-9", "org-1", "member").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE invitations SET status = \$1`).WithArgs("accepted", "inv-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := s.Accept(token, "")
+	require.NoError(t, err)
+	assert.Equal(t, "user-9", result.UserID)
+	assert.False(t, result.AccountCreated)
+	// Existing users sign in themselves, so MFA still applies
+	assert.Empty(t, result.Token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcceptInvitationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{"expired", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "pending", time.Now().Add(-time.Minute))
+		}, ErrInvitationExpired},
+		{"already accepted", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "accepted", time.Now().Add(time.Hour))
+		}, ErrInvitationNotFound},
+		{"revoked", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "revoked", time.Now().Add(time.Hour))
+		}, ErrInvitationNotFound},
+		{"no seats left", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "pending", time.Now().Add(time.Hour))
+			expectSeats(mock, 5, 5)
+		}, ErrSeatLimitReached},
+		{"new account without password", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "pending", time.Now().Add(time.Hour))
+			expectSeats(mock, nil, 0)
+			mock.ExpectQuery(`SELECT id FROM users`).WithArgs("jane@acme.test").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, ErrPasswordRequired},
+		{"already a member", func(mock sqlmock.Sqlmock) {
+			expectInvitation(mock, "pending", time.Now().Add(time.Hour))
+			expectSeats(mock, nil, 0)
+			mock.ExpectQuery(`SELECT id FROM users`).WithArgs("jane@acme.test").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-9"))
+			mock.ExpectExec(`INSERT INTO memberships`).WillReturnResult(sqlmock.NewResult(0, 0))
+		}, ErrAlreadyMember},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newInvitationMock(t)
+			token, err := auth.GenerateInvitationToken("inv-1", time.Hour)
+			require.NoError(t, err)
+
+			mock.ExpectBegin()
+			tt.expect(mock)
+			mock.ExpectRollback()
+
+			_, err = s.Accept(token, "")
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAcceptInvitationNeedsInvitationToken(t *testing.T) {
+	s, mock := newInvitationMock(t)
+
+	// A token for another purpose names a user, not an invitation
+	token, err := auth.GenerateScopedToken("inv-1", auth.ScopeAccountUnlock, time.Hour)
+	require.NoError(t, err)
+
+	_, err = s.Accept(token, "password")
+	assert.ErrorIs(t, err, ErrInvitationNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,167 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+var (
+	ErrMemberNotFound     = errors.New("user is not a member of this organization")
+	ErrLastOwner          = errors.New("organization must keep at least one owner")
+	ErrOwnerRoleProtected = errors.New("only owners can grant, change or remove the owner role")
+)
+
+// ListMembers returns one page of members with their emails, oldest first,
+// and the total number of members
+func (s *OrganizationService) ListMembers(orgID string, page, pageSize int) ([]Member, int, error) {
+	var total int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM memberships WHERE org_id = $1`, orgID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT m.user_id, m.org_id, u.email, m.role, m.created_at
+		FROM memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.created_at, m.user_id
+		LIMIT $2 OFFSET $3
+	`, orgID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.OrgID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		members = append(members, m)
+	}
+
+	return members, total, rows.Err()
+}
+
+// UpdateMemberRole changes a member's role on behalf of an actor holding
+// actorRole in the same organization
+func (s *OrganizationService) UpdateMemberRole(orgID, userID, role, actorRole string, actor audit.Actor) error {
+	return s.changeMembership(orgID, userID, role, actorRole, actor)
+}
+
+// RemoveMember removes a member on behalf of an actor holding actorRole
+func (s *OrganizationService) RemoveMember(orgID, userID, actorRole string, actor audit.Actor) error {
+	return s.changeMembership(orgID, userID, "", actorRole, actor)
+}
+
+// Leave removes the user from the organization. The last owner can't leave
+// until someone else is made owner.
+func (s *OrganizationService) Leave(orgID string, actor audit.Actor) error {
+	role, err := s.CheckUserRole(actor.UserID, orgID)
+	if err != nil {
+		return ErrMemberNotFound
+	}
+	return s.changeMembership(orgID, actor.UserID, "", role, actor)
+}
+
+// changeMembership sets the member's role, or removes them when role is empty
+func (s *OrganizationService) changeMembership(orgID, userID, role, actorRole string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the owner rows first so concurrent demotions of two different
+	// owners can't both see the other one as the remaining owner
+	var owners int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT user_id FROM memberships
+			WHERE org_id = $1 AND role = 'owner'
+			ORDER BY user_id
+			FOR UPDATE
+		) o
+	`, orgID).Scan(&owners)
+	if err != nil {
+		return err
+	}
+
+	var current string
+	err = tx.QueryRow(`
+		SELECT role FROM memberships
+		WHERE org_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, orgID, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := checkMembershipChange(actorRole, current, role, owners); err != nil {
+		return err
+	}
+
+	if role == "" {
+		_, err = tx.Exec(`
+			DELETE FROM team_members
+			WHERE user_id = $1 AND team_id IN (SELECT id FROM teams WHERE org_id = $2)
+		`, userID, orgID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			DELETE FROM memberships WHERE org_id = $1 AND user_id = $2
+		`, orgID, userID)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE memberships SET role = $1 WHERE org_id = $2 AND user_id = $3
+		`, role, orgID, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	e := audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "member.role_changed",
+		TargetType: "user",
+		TargetID:   userID,
+		Before:     map[string]interface{}{"role": current},
+		After:      map[string]interface{}{"role": role},
+	}
+	if role == "" {
+		e.Action, e.After = "member.removed", nil
+		if actor.UserID == userID {
+			e.Action = "member.left"
+		}
+	}
+	s.audit.Emit(e)
+	return nil
+}
+
+// checkMembershipChange enforces the membership invariants. newRole is empty
+// for a removal; owners is the current number of owners.
+func checkMembershipChange(actorRole, currentRole, newRole string, owners int) error {
+	touchesOwner := currentRole == "owner" || newRole == "owner"
+	if touchesOwner && actorRole != "owner" {
+		return ErrOwnerRoleProtected
+	}
+
+	if currentRole == "owner" && newRole != "owner" && owners <= 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
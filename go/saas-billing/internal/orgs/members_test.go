@@ -0,0 +1,40 @@
+package orgs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMembershipChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		actorRole string
+		current   string
+		newRole   string
+		owners    int
+		want      error
+	}{
+		{"admin promotes member", "admin", "member", "admin", 1, nil},
+		{"admin removes member", "admin", "member", "", 1, nil},
+		{"admin demotes owner", "admin", "owner", "member", 2, ErrOwnerRoleProtected},
+		{"admin removes owner", "admin", "owner", "", 2, ErrOwnerRoleProtected},
+		{"admin grants owner", "admin", "member", "owner", 1, ErrOwnerRoleProtected},
+		{"owner grants owner", "owner", "admin", "owner", 1, nil},
+		{"owner demotes co-owner", "owner", "owner", "admin", 2, nil},
+		{"last owner demoted", "owner", "owner", "admin", 1, ErrLastOwner},
+		{"last owner leaves", "owner", "owner", "", 1, ErrLastOwner},
+		{"owner keeps owner", "owner", "owner", "owner", 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMembershipChange(tt.actorRole, tt.current, tt.newRole, tt.owners)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+}
@@ -3,27 +3,34 @@ package orgs
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
 )
 
 type Organization struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt string    `json:"created_at"`
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	RequireMFAForAdmins bool   `json:"require_mfa_for_admins"`
+	BillingContactID    string `json:"billing_contact_id,omitempty"`
+	ParentID            string `json:"parent_id,omitempty"`
+	CreatedAt           string `json:"created_at"`
 }
 
 type Member struct {
 	UserID    string `json:"user_id"`
 	OrgID     string `json:"org_id"`
+	Email     string `json:"email,omitempty"`
 	Role      string `json:"role"`
 	CreatedAt string `json:"created_at"`
 }
 
 type OrganizationService struct {
-	db *sql.DB
+	db    *sql.DB
+	audit *audit.AuditService
 }
 
-func NewOrganizationService(db *sql.DB) *OrganizationService {
-	return &OrganizationService{db: db}
+func NewOrganizationService(db *sql.DB, auditService *audit.AuditService) *OrganizationService {
+	return &OrganizationService{db: db, audit: auditService}
 }
 
 func (s *OrganizationService) Create(name string, ownerID string) (*Organization, error) {
@@ -35,10 +42,10 @@ func (s *OrganizationService) Create(name string, ownerID string) (*Organization
 
 	var org Organization
 	err = tx.QueryRow(`
-		INSERT INTO organizations (name)
-		VALUES ($1)
-		RETURNING id, name, created_at
-	`, name).Scan(&org.ID, &org.Name, &org.CreatedAt)
+		INSERT INTO organizations (name, billing_contact_id)
+		VALUES ($1, $2)
+		RETURNING id, name, require_mfa_for_admins, billing_contact_id, created_at
+	`, name, ownerID).Scan(&org.ID, &org.Name, &org.RequireMFAForAdmins, &org.BillingContactID, &org.CreatedAt)
 
 	if err != nil {
 		return nil, err
@@ -63,10 +70,11 @@ func (s *OrganizationService) Create(name string, ownerID string) (*Organization
 
 func (s *OrganizationService) GetUserOrgs(userID string) ([]Organization, error) {
 	rows, err := s.db.Query(`
-		SELECT o.id, o.name, o.created_at
+		SELECT o.id, o.name, o.require_mfa_for_admins, COALESCE(o.billing_contact_id::text, ''),
+			COALESCE(o.parent_id::text, ''), o.created_at
 		FROM organizations o
 		JOIN memberships m ON m.org_id = o.id
-		WHERE m.user_id = $1
+		WHERE m.user_id = $1 AND o.deleted_at IS NULL
 	`, userID)
 
 	if err != nil {
@@ -77,7 +85,8 @@ func (s *OrganizationService) GetUserOrgs(userID string) ([]Organization, error)
 	var orgs []Organization
 	for rows.Next() {
 		var org Organization
-		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+		if err := rows.Scan(&org.ID, &org.Name, &org.RequireMFAForAdmins, &org.BillingContactID,
+			&org.ParentID, &org.CreatedAt); err != nil {
 			return nil, err
 		}
 		orgs = append(orgs, org)
@@ -86,20 +95,53 @@ func (s *OrganizationService) GetUserOrgs(userID string) ([]Organization, error)
 	return orgs, nil
 }
 
-func (s *OrganizationService) AddMember(orgID, userID, role string) error {
-	_, err := s.db.Exec(`
+// AddMember adds an existing user to the organization. Like every other way
+// in, it takes a seat on the organization's plan.
+func (s *OrganizationService) AddMember(orgID, userID, role string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize joins per organization so two of them can't both take the
+	// last seat
+	if _, err = tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return err
+	}
+	if err = CheckSeats(tx, orgID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
 		INSERT INTO memberships (user_id, org_id, role)
 		VALUES ($1, $2, $3)
 	`, userID, orgID, role)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
 
-	return err
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "member.added",
+		TargetType: "user",
+		TargetID:   userID,
+		After:      map[string]interface{}{"role": role},
+	})
+	return nil
 }
 
 func (s *OrganizationService) CheckUserRole(userID, orgID string) (string, error) {
 	var role string
 	err := s.db.QueryRow(`
-		SELECT role FROM memberships
-		WHERE user_id = $1 AND org_id = $2
+		SELECT m.role FROM memberships m
+		JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = $1 AND m.org_id = $2 AND o.deleted_at IS NULL
 	`, userID, orgID).Scan(&role)
 
 	if err == sql.ErrNoRows {
@@ -108,3 +150,46 @@ func (s *OrganizationService) CheckUserRole(userID, orgID string) (string, error
 
 	return role, err
 }
+
+// SetRequireMFAForAdmins toggles whether members with admin roles must have signed
+// in with a second factor to use admin routes
+func (s *OrganizationService) SetRequireMFAForAdmins(orgID string, required bool, actor audit.Actor) error {
+	var previous bool
+	err := s.db.QueryRow(`
+		UPDATE organizations o SET require_mfa_for_admins = $1, updated_at = NOW()
+		FROM organizations old
+		WHERE o.id = $2 AND old.id = o.id
+		RETURNING old.require_mfa_for_admins
+	`, required, orgID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return ErrOrganizationNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.security_policy_updated",
+		TargetType: "organization",
+		TargetID:   orgID,
+		Before:     map[string]interface{}{"require_mfa_for_admins": previous},
+		After:      map[string]interface{}{"require_mfa_for_admins": required},
+	})
+	return nil
+}
+
+// RequiresMFAForAdmins reports the organization's MFA policy. A child
+// organization inherits the policy when its parent turns it on.
+func (s *OrganizationService) RequiresMFAForAdmins(orgID string) (bool, error) {
+	var required bool
+	err := s.db.QueryRow(`
+		SELECT o.require_mfa_for_admins OR COALESCE(p.require_mfa_for_admins, FALSE)
+		FROM organizations o
+		LEFT JOIN organizations p ON p.id = o.parent_id
+		WHERE o.id = $1
+	`, orgID).Scan(&required)
+
+	return required, err
+}
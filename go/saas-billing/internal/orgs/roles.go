@@ -0,0 +1,329 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+// Permissions checked by RequirePermission. Custom roles are any subset.
+const (
+	PermOrgManage     = "org:manage"
+	PermRolesManage   = "roles:manage"
+	PermMembersRead   = "members:read"
+	PermMembersInvite = "members:invite"
+	PermMembersManage = "members:manage"
+	PermTeamsManage   = "teams:manage"
+	PermBillingRead   = "billing:read"
+	PermBillingManage = "billing:manage"
+	PermAPIKeysManage = "api_keys:manage"
+	PermUsageRead     = "usage:read"
+	PermUsageWrite    = "usage:write"
+	PermAuditRead     = "audit:read"
+)
+
+var AllPermissions = []string{
+	PermOrgManage, PermRolesManage,
+	PermMembersRead, PermMembersInvite, PermMembersManage,
+	PermTeamsManage,
+	PermBillingRead, PermBillingManage,
+	PermAPIKeysManage,
+	PermUsageRead, PermUsageWrite,
+	PermAuditRead,
+}
+
+// builtinRoles can't be edited or shadowed by custom roles
+var builtinRoles = map[string][]string{
+	"owner": AllPermissions,
+	"admin": {
+		PermMembersRead, PermMembersInvite, PermMembersManage,
+		PermTeamsManage,
+		PermBillingRead, PermBillingManage,
+		PermAPIKeysManage,
+		PermUsageRead, PermUsageWrite,
+		PermAuditRead,
+	},
+	"member": {PermMembersRead, PermUsageRead, PermUsageWrite},
+	// Read-only access for the parent organization's admins
+	RoleParentAdmin: {PermMembersRead, PermBillingRead, PermUsageRead},
+}
+
+// adminPermissions make a role privileged: holding any of them puts the
+// role under the organization's admin MFA policy
+var adminPermissions = []string{
+	PermOrgManage, PermRolesManage, PermMembersManage, PermBillingManage, PermAPIKeysManage,
+}
+
+var (
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrRoleExists           = errors.New("a role with this name already exists")
+	ErrRoleInUse            = errors.New("role is still assigned to members or pending invitations")
+	ErrInvalidPermission    = errors.New("unknown permission")
+	ErrPermissionEscalation = errors.New("cannot grant a role with permissions you don't have")
+)
+
+type Role struct {
+	ID          string   `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"built_in"`
+}
+
+// RolePermissions resolves a built-in or custom role to its permissions
+func (s *OrganizationService) RolePermissions(orgID, role string) ([]string, error) {
+	if perms, ok := builtinRoles[role]; ok {
+		return perms, nil
+	}
+
+	var perms []string
+	err := s.db.QueryRow(`
+		SELECT permissions FROM org_roles WHERE org_id = $1 AND name = $2
+	`, orgID, role).Scan(pq.Array(&perms))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return perms, nil
+}
+
+// HasPermission reports whether role grants permission in the organization.
+// A membership whose custom role was removed has no permissions.
+func (s *OrganizationService) HasPermission(orgID, role, permission string) (bool, error) {
+	perms, err := s.RolePermissions(orgID, role)
+	if err == ErrRoleNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return containsAll(perms, []string{permission}), nil
+}
+
+// IsAdminRole reports whether role grants any admin permission, whatever
+// it's called
+func (s *OrganizationService) IsAdminRole(orgID, role string) (bool, error) {
+	perms, err := s.RolePermissions(orgID, role)
+	if err == ErrRoleNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range adminPermissions {
+		if containsAll(perms, []string{p}) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CanAssignRole checks role exists and that an actor with actorRole may hand
+// it out: only owners grant owner, and nobody grants more than they have.
+func (s *OrganizationService) CanAssignRole(orgID, actorRole, role string) error {
+	if role == "owner" && actorRole != "owner" {
+		return ErrOwnerRoleProtected
+	}
+	if role == RoleParentAdmin {
+		return ErrRoleNotFound
+	}
+
+	granted, err := s.RolePermissions(orgID, role)
+	if err != nil {
+		return err
+	}
+	return s.checkGrantable(orgID, actorRole, granted)
+}
+
+// checkGrantable fails when permissions include any that actorRole lacks
+func (s *OrganizationService) checkGrantable(orgID, actorRole string, permissions []string) error {
+	held, err := s.RolePermissions(orgID, actorRole)
+	if err != nil {
+		return err
+	}
+
+	if !containsAll(held, permissions) {
+		return ErrPermissionEscalation
+	}
+	return nil
+}
+
+// ListRoles returns the built-in roles followed by the organization's own
+func (s *OrganizationService) ListRoles(orgID string) ([]Role, error) {
+	roles := []Role{}
+	for _, name := range []string{"owner", "admin", "member"} {
+		roles = append(roles, Role{Name: name, Permissions: builtinRoles[name], BuiltIn: true})
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, name, description, permissions
+		FROM org_roles
+		WHERE org_id = $1
+		ORDER BY name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r.ID, &r.Name, &r.Description, pq.Array(&r.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+
+	return roles, rows.Err()
+}
+
+// CreateRole adds a custom role. Like assigning a role, nobody can create
+// one with permissions their own role (actorRole) lacks.
+func (s *OrganizationService) CreateRole(orgID, actorRole, name, description string, permissions []string, actor audit.Actor) (*Role, error) {
+	if _, ok := builtinRoles[name]; ok {
+		return nil, ErrRoleExists
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	if err := s.checkGrantable(orgID, actorRole, permissions); err != nil {
+		return nil, err
+	}
+
+	role := Role{Name: name, Description: description, Permissions: permissions}
+	err := s.db.QueryRow(`
+		INSERT INTO org_roles (org_id, name, description, permissions)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, orgID, name, description, pq.Array(permissions)).Scan(&role.ID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrRoleExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "role.created",
+		TargetType: "role",
+		TargetID:   role.ID,
+		After:      roleState(role.Name, description, permissions),
+	})
+	return &role, nil
+}
+
+// UpdateRole replaces a custom role's description and permissions. Members
+// holding it pick up the change on their next request. As with CreateRole,
+// actorRole must hold every permission given.
+func (s *OrganizationService) UpdateRole(orgID, actorRole, roleID, description string, permissions []string, actor audit.Actor) (*Role, error) {
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	if err := s.checkGrantable(orgID, actorRole, permissions); err != nil {
+		return nil, err
+	}
+
+	role := Role{ID: roleID, Description: description, Permissions: permissions}
+	var oldDescription string
+	var oldPermissions []string
+	err := s.db.QueryRow(`
+		UPDATE org_roles r SET description = $1, permissions = $2, updated_at = NOW()
+		FROM org_roles old
+		WHERE r.id = $3 AND r.org_id = $4 AND old.id = r.id
+		RETURNING r.name, old.description, old.permissions
+	`, description, pq.Array(permissions), roleID, orgID).Scan(&role.Name, &oldDescription, pq.Array(&oldPermissions))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "role.updated",
+		TargetType: "role",
+		TargetID:   roleID,
+		Before:     roleState(role.Name, oldDescription, oldPermissions),
+		After:      roleState(role.Name, description, permissions),
+	})
+	return &role, nil
+}
+
+func (s *OrganizationService) DeleteRole(orgID, roleID string, actor audit.Actor) error {
+	var inUse bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM memberships m JOIN org_roles r ON r.org_id = m.org_id AND r.name = m.role
+			WHERE r.id = $1 AND r.org_id = $2
+		) OR EXISTS (
+			SELECT 1 FROM invitations i JOIN org_roles r ON r.org_id = i.org_id AND r.name = i.role
+			WHERE r.id = $1 AND r.org_id = $2 AND i.status = 'pending'
+		)
+	`, roleID, orgID).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrRoleInUse
+	}
+
+	var name, description string
+	var permissions []string
+	err = s.db.QueryRow(`
+		DELETE FROM org_roles WHERE id = $1 AND org_id = $2
+		RETURNING name, description, permissions
+	`, roleID, orgID).Scan(&name, &description, pq.Array(&permissions))
+	if err == sql.ErrNoRows {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "role.deleted",
+		TargetType: "role",
+		TargetID:   roleID,
+		Before:     roleState(name, description, permissions),
+	})
+	return nil
+}
+
+func roleState(name, description string, permissions []string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "description": description, "permissions": permissions}
+}
+
+func validatePermissions(permissions []string) error {
+	if !containsAll(AllPermissions, permissions) {
+		return ErrInvalidPermission
+	}
+	return nil
+}
+
+// containsAll reports whether every element of want is in have
+func containsAll(have, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, p := range have {
+		set[p] = true
+	}
+	for _, p := range want {
+		if !set[p] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package orgs
+
+import (
+	"testing"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinRolesUseKnownPermissions(t *testing.T) {
+	for name, perms := range builtinRoles {
+		assert.NoError(t, validatePermissions(perms), name)
+	}
+
+	// Each built-in role is a subset of the one above it
+	assert.True(t, containsAll(builtinRoles["owner"], builtinRoles["admin"]))
+	assert.True(t, containsAll(builtinRoles["admin"], builtinRoles["member"]))
+	assert.False(t, containsAll(builtinRoles["admin"], []string{PermOrgManage}))
+
+	// Parent admins can look but not change anything
+	assert.True(t, containsAll(builtinRoles["admin"], builtinRoles[RoleParentAdmin]))
+	assert.False(t, containsAll(builtinRoles[RoleParentAdmin], []string{PermUsageWrite}))
+}
+
+func TestValidatePermissions(t *testing.T) {
+	assert.NoError(t, validatePermissions([]string{PermBillingRead}))
+	assert.NoError(t, validatePermissions(nil))
+	assert.ErrorIs(t, validatePermissions([]string{PermBillingRead, "billing:everything"}), ErrInvalidPermission)
+}
+
+func TestCanAssignBuiltinRoles(t *testing.T) {
+	s := &OrganizationService{}
+
+	assert.NoError(t, s.CanAssignRole("org-1", "owner", "owner"))
+	assert.NoError(t, s.CanAssignRole("org-1", "admin", "member"))
+	assert.NoError(t, s.CanAssignRole("org-1", "admin", "admin"))
+	assert.ErrorIs(t, s.CanAssignRole("org-1", "admin", "owner"), ErrOwnerRoleProtected)
+	assert.ErrorIs(t, s.CanAssignRole("org-1", "member", "admin"), ErrPermissionEscalation)
+	assert.ErrorIs(t, s.CanAssignRole("org-1", "owner", RoleParentAdmin), ErrRoleNotFound)
+}
+
+func TestIsAdminRole(t *testing.T) {
+	s := &OrganizationService{}
+
+	for role, want := range map[string]bool{"owner": true, "admin": true, "member": false, RoleParentAdmin: false} {
+		admin, err := s.IsAdminRole("org-1", role)
+		assert.NoError(t, err)
+		assert.Equal(t, want, admin, role)
+	}
+}
+
+func TestRolesCannotEscalate(t *testing.T) {
+	s := &OrganizationService{}
+
+	_, err := s.CreateRole("org-1", "admin", "org-admin", "", []string{PermOrgManage}, audit.Actor{})
+	assert.ErrorIs(t, err, ErrPermissionEscalation)
+
+	_, err = s.UpdateRole("org-1", "member", "role-1", "", []string{PermMembersRead, PermBillingManage}, audit.Actor{})
+	assert.ErrorIs(t, err, ErrPermissionEscalation)
+}
@@ -0,0 +1,209 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Team-level roles. Leads can add and remove members of their own team.
+const (
+	TeamRoleLead   = "lead"
+	TeamRoleMember = "member"
+)
+
+var (
+	ErrTeamNotFound       = errors.New("team not found")
+	ErrTeamExists         = errors.New("a team with this name already exists")
+	ErrTeamMemberNotFound = errors.New("user is not a member of this team")
+)
+
+type Team struct {
+	ID          string `json:"id"`
+	OrgID       string `json:"org_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MemberCount int    `json:"member_count"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type TeamMember struct {
+	TeamID    string `json:"team_id"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (s *OrganizationService) CreateTeam(orgID, name, description string) (*Team, error) {
+	team := Team{OrgID: orgID, Name: name, Description: description}
+	err := s.db.QueryRow(`
+		INSERT INTO teams (org_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, orgID, name, description).Scan(&team.ID, &team.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrTeamExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &team, nil
+}
+
+func (s *OrganizationService) ListTeams(orgID string) ([]Team, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.org_id, t.name, t.description, COUNT(tm.user_id), t.created_at
+		FROM teams t
+		LEFT JOIN team_members tm ON tm.team_id = t.id
+		WHERE t.org_id = $1
+		GROUP BY t.id
+		ORDER BY t.name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var t Team
+		if err := rows.Scan(&t.ID, &t.OrgID, &t.Name, &t.Description, &t.MemberCount, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+
+	return teams, rows.Err()
+}
+
+// DeleteTeam removes the team. Usage attributed to it is kept but no longer
+// attributed to any team.
+func (s *OrganizationService) DeleteTeam(orgID, teamID string) error {
+	result, err := s.db.Exec(`
+		DELETE FROM teams WHERE id = $1 AND org_id = $2
+	`, teamID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTeamNotFound
+	}
+
+	return nil
+}
+
+func (s *OrganizationService) ListTeamMembers(orgID, teamID string) ([]TeamMember, error) {
+	if err := s.checkTeam(orgID, teamID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT tm.team_id, tm.user_id, u.email, tm.role, tm.created_at
+		FROM team_members tm
+		JOIN users u ON u.id = tm.user_id
+		WHERE tm.team_id = $1
+		ORDER BY tm.created_at, tm.user_id
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []TeamMember{}
+	for rows.Next() {
+		var m TeamMember
+		if err := rows.Scan(&m.TeamID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// SetTeamMember adds an organization member to the team, or changes their
+// team role if they are already on it
+func (s *OrganizationService) SetTeamMember(orgID, teamID, userID, role string) error {
+	if err := s.checkTeam(orgID, teamID); err != nil {
+		return err
+	}
+	if _, err := s.CheckUserRole(userID, orgID); err != nil {
+		return ErrMemberNotFound
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO team_members (team_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, teamID, userID, role)
+
+	return err
+}
+
+func (s *OrganizationService) RemoveTeamMember(orgID, teamID, userID string) error {
+	result, err := s.db.Exec(`
+		DELETE FROM team_members tm
+		USING teams t
+		WHERE t.id = tm.team_id AND tm.team_id = $1 AND t.org_id = $2 AND tm.user_id = $3
+	`, teamID, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTeamMemberNotFound
+	}
+
+	return nil
+}
+
+// CanManageTeam reports whether the user may change the team's membership:
+// holders of teams:manage for any team, leads for their own
+func (s *OrganizationService) CanManageTeam(orgID, teamID, userID, orgRole string) (bool, error) {
+	allowed, err := s.HasPermission(orgID, orgRole, PermTeamsManage)
+	if err != nil || allowed {
+		return allowed, err
+	}
+
+	var teamRole string
+	err = s.db.QueryRow(`
+		SELECT tm.role FROM team_members tm
+		JOIN teams t ON t.id = tm.team_id
+		WHERE tm.team_id = $1 AND t.org_id = $2 AND tm.user_id = $3
+	`, teamID, orgID, userID).Scan(&teamRole)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return teamRole == TeamRoleLead, nil
+}
+
+func (s *OrganizationService) checkTeam(orgID, teamID string) error {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM teams WHERE id::text = $1 AND org_id = $2)
+	`, teamID, orgID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTeamNotFound
+	}
+	return nil
+}
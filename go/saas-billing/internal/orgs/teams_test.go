@@ -0,0 +1,153 @@
+package orgs
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectTeam(mock sqlmock.Sqlmock, exists bool) {
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM teams`).WithArgs("team-1", "org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func TestCreateTeam(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectQuery(`INSERT INTO teams`).WithArgs("org-1", "Platform", "Infra").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("team-1", "2025-01-01"))
+	team, err := s.CreateTeam("org-1", "Platform", "Infra")
+	require.NoError(t, err)
+	assert.Equal(t, "team-1", team.ID)
+
+	// Names are unique within the organization
+	mock.ExpectQuery(`INSERT INTO teams`).WithArgs("org-1", "Platform", "").
+		WillReturnError(&pq.Error{Code: "23505"})
+	_, err = s.CreateTeam("org-1", "Platform", "")
+	assert.ErrorIs(t, err, ErrTeamExists)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListTeams(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectQuery(`FROM teams t\s+LEFT JOIN team_members`).WithArgs("org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "name", "description", "count", "created_at"}).
+			AddRow("team-1", "org-1", "Platform", "", 3, "2025-01-01").
+			AddRow("team-2", "org-1", "Sales", "", 0, "2025-01-02"))
+	teams, err := s.ListTeams("org-1")
+	require.NoError(t, err)
+	require.Len(t, teams, 2)
+	assert.Equal(t, 3, teams[0].MemberCount)
+	assert.Equal(t, 0, teams[1].MemberCount)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTeam(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectExec(`DELETE FROM teams WHERE id = \$1 AND org_id = \$2`).WithArgs("team-1", "org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.DeleteTeam("org-1", "team-1"))
+
+	// Another organization's team is not found
+	mock.ExpectExec(`DELETE FROM teams`).WithArgs("team-1", "org-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.DeleteTeam("org-2", "team-1"), ErrTeamNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetTeamMember(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	// Adding again changes the team role
+	expectTeam(mock, true)
+	expectRole(mock, "user-1", "member")
+	mock.ExpectExec(`INSERT INTO team_members .* ON CONFLICT \(team_id, user_id\) DO UPDATE SET role`).
+		WithArgs("team-1", "user-1", TeamRoleLead).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.SetTeamMember("org-1", "team-1", "user-1", TeamRoleLead))
+
+	// Only members of the organization can join its teams
+	expectTeam(mock, true)
+	expectRole(mock, "outsider", "")
+	assert.ErrorIs(t, s.SetTeamMember("org-1", "team-1", "outsider", TeamRoleMember), ErrMemberNotFound)
+
+	expectTeam(mock, false)
+	assert.ErrorIs(t, s.SetTeamMember("org-1", "team-1", "user-1", TeamRoleMember), ErrTeamNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListTeamMembers(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	expectTeam(mock, true)
+	mock.ExpectQuery(`FROM team_members tm`).WithArgs("team-1").
+		WillReturnRows(sqlmock.NewRows([]string{"team_id", "user_id", "email", "role", "created_at"}).
+			AddRow("team-1", "user-1", "jane@acme.test", TeamRoleLead, "2025-01-01"))
+	members, err := s.ListTeamMembers("org-1", "team-1")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, TeamRoleLead, members[0].Role)
+
+	expectTeam(mock, false)
+	_, err = s.ListTeamMembers("org-1", "team-1")
+	assert.ErrorIs(t, err, ErrTeamNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRemoveTeamMember(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectExec(`DELETE FROM team_members tm\s+USING teams t`).WithArgs("team-1", "org-1", "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.RemoveTeamMember("org-1", "team-1", "user-1"))
+
+	mock.ExpectExec(`DELETE FROM team_members`).WithArgs("team-1", "org-1", "user-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.RemoveTeamMember("org-1", "team-1", "user-2"), ErrTeamMemberNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCanManageTeam(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	// teams:manage covers every team without looking at team roles
+	ok, err := s.CanManageTeam("org-1", "team-1", "user-1", "admin")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	tests := []struct {
+		name     string
+		teamRole string
+		want     bool
+	}{
+		{"lead of the team", TeamRoleLead, true},
+		{"member of the team", TeamRoleMember, false},
+		{"not on the team", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := sqlmock.NewRows([]string{"role"})
+			if tt.teamRole != "" {
+				rows.AddRow(tt.teamRole)
+			}
+			mock.ExpectQuery(`SELECT tm.role FROM team_members tm`).WithArgs("team-1", "org-1", "user-1").
+				WillReturnRows(rows)
+
+			ok, err := s.CanManageTeam("org-1", "team-1", "user-1", "member")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,268 @@
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+)
+
+const ownershipTransferTTL = 7 * 24 * time.Hour
+
+var (
+	ErrTransferNotFound  = errors.New("ownership transfer not found")
+	ErrTransferExpired   = errors.New("ownership transfer has expired")
+	ErrInvalidTransferee = errors.New("ownership can only be transferred to another member who is not already an owner")
+	ErrInitiatorNotOwner = errors.New("only an owner can transfer ownership")
+	ErrNotTransferParty  = errors.New("only the initiator or the target can act on this transfer")
+)
+
+type OwnershipTransfer struct {
+	ID                 string    `json:"id"`
+	OrgID              string    `json:"org_id"`
+	FromUserID         string    `json:"from_user_id"`
+	ToUserID           string    `json:"to_user_id"`
+	MoveBillingContact bool      `json:"move_billing_contact"`
+	Status             string    `json:"status"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	CreatedAt          string    `json:"created_at"`
+}
+
+// InitiateOwnershipTransfer proposes handing the organization from one owner
+// to another member. Any earlier pending proposal is canceled.
+func (s *OrganizationService) InitiateOwnershipTransfer(orgID string, actor audit.Actor, toUserID string, moveBillingContact bool) (*OwnershipTransfer, error) {
+	fromUserID := actor.UserID
+	if fromUserID == toUserID {
+		return nil, ErrInvalidTransferee
+	}
+
+	if role, err := s.CheckUserRole(fromUserID, orgID); err != nil || role != "owner" {
+		return nil, ErrInitiatorNotOwner
+	}
+	role, err := s.CheckUserRole(toUserID, orgID)
+	if err != nil || role == "owner" {
+		return nil, ErrInvalidTransferee
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE ownership_transfers SET status = 'canceled', responded_at = NOW()
+		WHERE org_id = $1 AND status = 'pending'
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	t := OwnershipTransfer{OrgID: orgID, FromUserID: fromUserID, ToUserID: toUserID, MoveBillingContact: moveBillingContact}
+	err = tx.QueryRow(`
+		INSERT INTO ownership_transfers (org_id, from_user_id, to_user_id, move_billing_contact, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, expires_at, created_at
+	`, orgID, fromUserID, toUserID, moveBillingContact, time.Now().Add(ownershipTransferTTL)).Scan(
+		&t.ID, &t.Status, &t.ExpiresAt, &t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.ownership_transfer_initiated",
+		TargetType: "user",
+		TargetID:   toUserID,
+		Metadata:   map[string]interface{}{"transfer_id": t.ID, "move_billing_contact": moveBillingContact},
+	})
+
+	return &t, nil
+}
+
+// GetPendingOwnershipTransfer returns the organization's open proposal
+func (s *OrganizationService) GetPendingOwnershipTransfer(orgID string) (*OwnershipTransfer, error) {
+	var t OwnershipTransfer
+	err := s.db.QueryRow(`
+		SELECT id, org_id, from_user_id, to_user_id, move_billing_contact, status, expires_at, created_at
+		FROM ownership_transfers
+		WHERE org_id = $1 AND status = 'pending' AND expires_at > NOW()
+	`, orgID).Scan(&t.ID, &t.OrgID, &t.FromUserID, &t.ToUserID, &t.MoveBillingContact,
+		&t.Status, &t.ExpiresAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// AcceptOwnershipTransfer is called by the target. The initiator becomes an
+// admin and the target an owner in the same transaction.
+func (s *OrganizationService) AcceptOwnershipTransfer(orgID, transferID string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, err := pendingTransfer(tx, orgID, transferID)
+	if err != nil {
+		return err
+	}
+	if t.ToUserID != actor.UserID {
+		return ErrNotTransferParty
+	}
+
+	// Lock both memberships in a fixed order before checking them
+	rows, err := tx.Query(`
+		SELECT user_id, role FROM memberships
+		WHERE org_id = $1 AND user_id IN ($2, $3)
+		ORDER BY user_id
+		FOR UPDATE
+	`, orgID, t.FromUserID, t.ToUserID)
+	if err != nil {
+		return err
+	}
+	roles := map[string]string{}
+	for rows.Next() {
+		var id, role string
+		if err := rows.Scan(&id, &role); err != nil {
+			rows.Close()
+			return err
+		}
+		roles[id] = role
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if roles[t.FromUserID] != "owner" {
+		return ErrInitiatorNotOwner
+	}
+	if _, ok := roles[t.ToUserID]; !ok {
+		return ErrInvalidTransferee
+	}
+
+	_, err = tx.Exec(`
+		UPDATE memberships
+		SET role = CASE WHEN user_id = $2 THEN 'owner' ELSE 'admin' END
+		WHERE org_id = $1 AND user_id IN ($2, $3)
+	`, orgID, t.ToUserID, t.FromUserID)
+	if err != nil {
+		return err
+	}
+
+	if t.MoveBillingContact {
+		_, err = tx.Exec(`
+			UPDATE organizations SET billing_contact_id = $1, updated_at = NOW()
+			WHERE id = $2
+		`, t.ToUserID, orgID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = respondTransfer(tx, t.ID, "accepted"); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.ownership_transferred",
+		TargetType: "organization",
+		TargetID:   orgID,
+		Metadata: map[string]interface{}{
+			"transfer_id":           t.ID,
+			"from_user_id":          t.FromUserID,
+			"to_user_id":            t.ToUserID,
+			"billing_contact_moved": t.MoveBillingContact,
+		},
+	})
+
+	return nil
+}
+
+// CancelOwnershipTransfer lets the initiator withdraw or the target decline
+func (s *OrganizationService) CancelOwnershipTransfer(orgID, transferID string, actor audit.Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, err := pendingTransfer(tx, orgID, transferID)
+	if err != nil {
+		return err
+	}
+	if actor.UserID != t.FromUserID && actor.UserID != t.ToUserID {
+		return ErrNotTransferParty
+	}
+
+	if err = respondTransfer(tx, t.ID, "canceled"); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		OrgID:      orgID,
+		Actor:      actor,
+		Action:     "organization.ownership_transfer_canceled",
+		TargetType: "organization",
+		TargetID:   orgID,
+		Metadata:   map[string]interface{}{"transfer_id": t.ID},
+	})
+
+	return nil
+}
+
+func pendingTransfer(tx *sql.Tx, orgID, transferID string) (*OwnershipTransfer, error) {
+	var t OwnershipTransfer
+	err := tx.QueryRow(`
+		SELECT id, org_id, from_user_id, to_user_id, move_billing_contact, status, expires_at
+		FROM ownership_transfers
+		WHERE id = $1 AND org_id = $2
+		FOR UPDATE
+	`, transferID, orgID).Scan(&t.ID, &t.OrgID, &t.FromUserID, &t.ToUserID, &t.MoveBillingContact,
+		&t.Status, &t.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Status != "pending" {
+		return nil, ErrTransferNotFound
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrTransferExpired
+	}
+
+	return &t, nil
+}
+
+func respondTransfer(tx *sql.Tx, transferID, status string) error {
+	_, err := tx.Exec(`
+		UPDATE ownership_transfers SET status = $1, responded_at = NOW()
+		WHERE id = $2
+	`, status, transferID)
+	return err
+}
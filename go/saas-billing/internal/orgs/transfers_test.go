@@ -0,0 +1,176 @@
+package orgs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOrgMock(t *testing.T) (*OrganizationService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewOrganizationService(db, audit.NewAuditService(db)), mock
+}
+
+func expectRole(mock sqlmock.Sqlmock, userID, role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	mock.ExpectQuery(`SELECT m.role FROM memberships m`).WithArgs(userID, "org-1").WillReturnRows(rows)
+}
+
+func expectTransfer(mock sqlmock.Sqlmock, status string, expiresAt time.Time) {
+	mock.ExpectQuery(`FROM ownership_transfers\s+WHERE id = \$1 AND org_id = \$2\s+FOR UPDATE`).
+		WithArgs("transfer-1", "org-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_id", "from_user_id", "to_user_id", "move_billing_contact", "status", "expires_at"}).
+			AddRow("transfer-1", "org-1", "owner-1", "admin-1", true, status, expiresAt))
+}
+
+func TestInitiateOwnershipTransfer(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	expectRole(mock, "owner-1", "owner")
+	expectRole(mock, "admin-1", "admin")
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE ownership_transfers SET status = 'canceled'`).WithArgs("org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ownership_transfers`).
+		WithArgs("org-1", "owner-1", "admin-1", true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "expires_at", "created_at"}).
+			AddRow("transfer-1", "pending", time.Now().Add(ownershipTransferTTL), "2025-01-01"))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	transfer, err := s.InitiateOwnershipTransfer("org-1", audit.Actor{UserID: "owner-1"}, "admin-1", true)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", transfer.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInitiateOwnershipTransferRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		actor  string
+		target string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{"admin initiates", "admin-1", "member-1", func(mock sqlmock.Sqlmock) {
+			expectRole(mock, "admin-1", "admin")
+		}, ErrInitiatorNotOwner},
+		{"non-member initiates", "outsider", "member-1", func(mock sqlmock.Sqlmock) {
+			expectRole(mock, "outsider", "")
+		}, ErrInitiatorNotOwner},
+		{"to themselves", "owner-1", "owner-1", func(mock sqlmock.Sqlmock) {}, ErrInvalidTransferee},
+		{"to another owner", "owner-1", "owner-2", func(mock sqlmock.Sqlmock) {
+			expectRole(mock, "owner-1", "owner")
+			expectRole(mock, "owner-2", "owner")
+		}, ErrInvalidTransferee},
+		{"to a non-member", "owner-1", "outsider", func(mock sqlmock.Sqlmock) {
+			expectRole(mock, "owner-1", "owner")
+			expectRole(mock, "outsider", "")
+		}, ErrInvalidTransferee},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newOrgMock(t)
+			tt.expect(mock)
+
+			_, err := s.InitiateOwnershipTransfer("org-1", audit.Actor{UserID: tt.actor}, tt.target, false)
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAcceptOwnershipTransfer(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	mock.ExpectBegin()
+	expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+	mock.ExpectQuery(`SELECT user_id, role FROM memberships`).WithArgs("org-1", "owner-1", "admin-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "role"}).AddRow("admin-1", "admin").AddRow("owner-1", "owner"))
+	// The target becomes an owner and the initiator an admin in one statement
+	mock.ExpectExec(`UPDATE memberships\s+SET role = CASE WHEN user_id = \$2 THEN 'owner' ELSE 'admin' END`).
+		WithArgs("org-1", "admin-1", "owner-1").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE organizations SET billing_contact_id`).WithArgs("admin-1", "org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE ownership_transfers`).WithArgs("accepted", "transfer-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, s.AcceptOwnershipTransfer("org-1", "transfer-1", audit.Actor{UserID: "admin-1"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcceptOwnershipTransferRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		actor  string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{"by the initiator", "owner-1", func(mock sqlmock.Sqlmock) {
+			expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+		}, ErrNotTransferParty},
+		{"expired", "admin-1", func(mock sqlmock.Sqlmock) {
+			expectTransfer(mock, "pending", time.Now().Add(-time.Minute))
+		}, ErrTransferExpired},
+		{"already declined", "admin-1", func(mock sqlmock.Sqlmock) {
+			expectTransfer(mock, "canceled", time.Now().Add(time.Hour))
+		}, ErrTransferNotFound},
+		{"initiator no longer an owner", "admin-1", func(mock sqlmock.Sqlmock) {
+			expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+			mock.ExpectQuery(`SELECT user_id, role FROM memberships`).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "role"}).AddRow("admin-1", "admin").AddRow("owner-1", "admin"))
+		}, ErrInitiatorNotOwner},
+		{"target left", "admin-1", func(mock sqlmock.Sqlmock) {
+			expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+			mock.ExpectQuery(`SELECT user_id, role FROM memberships`).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "role"}).AddRow("owner-1", "owner"))
+		}, ErrInvalidTransferee},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newOrgMock(t)
+			mock.ExpectBegin()
+			tt.expect(mock)
+			mock.ExpectRollback()
+
+			err := s.AcceptOwnershipTransfer("org-1", "transfer-1", audit.Actor{UserID: tt.actor})
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeclineOwnershipTransfer(t *testing.T) {
+	s, mock := newOrgMock(t)
+
+	// The target declines
+	mock.ExpectBegin()
+	expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+	mock.ExpectExec(`UPDATE ownership_transfers`).WithArgs("canceled", "transfer-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.CancelOwnershipTransfer("org-1", "transfer-1", audit.Actor{UserID: "admin-1"}))
+
+	// Other members can't
+	mock.ExpectBegin()
+	expectTransfer(mock, "pending", time.Now().Add(time.Hour))
+	mock.ExpectRollback()
+	err := s.CancelOwnershipTransfer("org-1", "transfer-1", audit.Actor{UserID: "member-1"})
+	assert.ErrorIs(t, err, ErrNotTransferParty)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,47 @@
+// Package payments is how billing talks to whoever moves the money. Billing
+// keeps its own record of payments and refunds; a Provider only carries out
+// the money movement.
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDeclined is returned, possibly wrapped, when the provider refused a
+// refund for good. Any other error leaves the outcome unknown: the refund
+// may have gone through, and only retrying with the same IdempotencyKey
+// finds out.
+var ErrDeclined = errors.New("payment provider declined the request")
+
+// RefundRequest asks the provider to return part or all of a captured
+// payment. IdempotencyKey is our refund ID, so retrying a request can't
+// refund twice.
+type RefundRequest struct {
+	ProviderPaymentID string
+	AmountCents       int
+	Currency          string
+	Reason            string
+	IdempotencyKey    string
+}
+
+// RefundResult identifies the refund at the provider
+type RefundResult struct {
+	ProviderRefundID string
+}
+
+// Provider refunds payments. Refund returns once the provider has accepted
+// the refund, ErrDeclined if it was refused, or another error if the outcome
+// is unknown. Retrying with the same IdempotencyKey returns the original
+// outcome rather than refunding again.
+type Provider interface {
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+}
+
+// ManualProvider is for payments taken outside the system, such as bank
+// transfers. Refunds are recorded as done; the money is returned by hand.
+type ManualProvider struct{}
+
+func (ManualProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{}, nil
+}
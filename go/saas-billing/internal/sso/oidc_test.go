@@ -0,0 +1,175 @@
+package sso
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIdP is a minimal OpenID provider that issues one authorization code
+type stubIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	challenge string
+	nonce     string
+	email     string
+	verified  bool
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &stubIdP{key: key, clientID: "billing-app", email: "jane@acme.test", verified: true}
+	mux := http.NewServeMux()
+	idp.server = httptest.NewTLSServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerConfig{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kid: "test",
+				Kty: "RSA",
+				N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idTokenClaims{
+			Email:         idp.email,
+			EmailVerified: &idp.verified,
+			Nonce:         idp.nonce,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    idp.server.URL,
+				Subject:   "idp-user-1",
+				Audience:  jwt.ClaimStrings{idp.clientID},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		})
+		token.Header["kid"] = "test"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+
+	return idp
+}
+
+// authorize records what the browser would have sent to /authorize
+func (idp *stubIdP) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, idp.clientID, u.Query().Get("client_id"))
+	idp.challenge = u.Query().Get("code_challenge")
+	idp.nonce = u.Query().Get("nonce")
+}
+
+func TestOIDCFlowAgainstStubIdP(t *testing.T) {
+	idp := newStubIdP(t)
+	client := idp.server.Client()
+
+	cfg, err := discover(client, idp.server.URL)
+	require.NoError(t, err)
+
+	verifier, _ := randomString(48)
+	idp.authorize(t, authCodeURL(cfg, "billing-app", "http://localhost/callback", "state", "nonce-1", verifier))
+
+	rawToken, err := exchangeCode(client, cfg, "billing-app", "secret", "http://localhost/callback", "good-code", verifier)
+	require.NoError(t, err)
+
+	claims, err := verifyIDToken(client, cfg, "billing-app", "nonce-1", rawToken)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@acme.test", claims.Email)
+
+	email, err := checkEmail(claims, []string{"acme.test"})
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@acme.test", email)
+
+	_, err = checkEmail(claims, []string{"other.test"})
+	assert.ErrorIs(t, err, ErrDomainNotAllowed)
+
+	// Wrong nonce and wrong audience are rejected
+	_, err = verifyIDToken(client, cfg, "billing-app", "nonce-2", rawToken)
+	assert.Error(t, err)
+	_, err = verifyIDToken(client, cfg, "another-app", "nonce-1", rawToken)
+	assert.Error(t, err)
+
+	// A verifier that doesn't match the challenge can't redeem the code
+	_, err = exchangeCode(client, cfg, "billing-app", "secret", "http://localhost/callback", "good-code", "wrong-verifier")
+	assert.Error(t, err)
+}
+
+func TestCheckEmailRequiresVerification(t *testing.T) {
+	unverified := false
+	_, err := checkEmail(&idTokenClaims{Email: "jane@acme.test", EmailVerified: &unverified}, []string{"acme.test"})
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+}
+
+func TestDiscoverRequiresHTTPS(t *testing.T) {
+	idp := newStubIdP(t)
+
+	_, err := discover(idp.server.Client(), "http"+strings.TrimPrefix(idp.server.URL, "https"))
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
+	_, err = discover(idp.server.Client(), "file:///etc/passwd")
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
+}
+
+func TestProviderErrorsDoNotEchoTheURL(t *testing.T) {
+	idp := newStubIdP(t)
+
+	_, err := discover(idp.server.Client(), idp.server.URL+"/missing")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	assert.NotContains(t, err.Error(), idp.server.URL)
+}
+
+func TestHTTPClientRefusesInternalAddresses(t *testing.T) {
+	idp := newStubIdP(t)
+
+	// The stub listens on loopback, like an internal service would
+	_, err := newHTTPClient().Get(idp.server.URL + "/.well-known/openid-configuration")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errPrivateAddress), err.Error())
+
+	for _, addr := range []string{"127.0.0.1", "10.0.0.5", "172.16.1.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		assert.ErrorIs(t, publicAddressOnly("tcp", net.JoinHostPort(addr, "443"), nil), errPrivateAddress, addr)
+	}
+	for _, addr := range []string{"8.8.8.8", "2606:4700::1111"} {
+		assert.NoError(t, publicAddressOnly("tcp", net.JoinHostPort(addr, "443"), nil), addr)
+	}
+}
@@ -0,0 +1,403 @@
+package sso
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/orgs"
+	"github.com/linkmeAman/saas-billing/internal/users"
+)
+
+const loginStateTTL = 10 * time.Minute
+
+var (
+	ErrConnectionNotFound  = errors.New("sso is not configured for this organization")
+	ErrInvalidState        = errors.New("invalid or expired sso state")
+	ErrDomainNotAllowed    = errors.New("email domain is not allowed for this organization")
+	ErrEmailNotVerified    = errors.New("identity provider did not verify the email address")
+	ErrAccountNotLinked    = errors.New("an account with this email already exists; sign in with its password")
+	ErrInvalidIssuer       = errors.New("issuer must be an https URL")
+	ErrProviderUnavailable = errors.New("identity provider could not be reached")
+)
+
+// Connection is an organization's OIDC identity provider configuration
+type Connection struct {
+	ID             string   `json:"id"`
+	OrgID          string   `json:"org_id"`
+	Issuer         string   `json:"issuer"`
+	ClientID       string   `json:"client_id"`
+	ClientSecret   string   `json:"-"`
+	AllowedDomains []string `json:"allowed_domains"`
+	DefaultRole    string   `json:"default_role"`
+	Enabled        bool     `json:"enabled"`
+	// InheritedFrom is the parent organization whose connection this is
+	InheritedFrom string `json:"inherited_from,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// LoginResult is returned after a successful callback. When the user has
+// MFA enabled, Token is empty and MFAToken must be exchanged via
+// VerifyMFALogin.
+type LoginResult struct {
+	Token       string `json:"token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	UserID      string `json:"user_id"`
+	OrgID       string `json:"org_id"`
+	Provisioned bool   `json:"provisioned"`
+}
+
+type SSOService struct {
+	db          *sql.DB
+	audit       *audit.AuditService
+	users       *users.UserService
+	client      *http.Client
+	redirectURL string
+}
+
+func NewSSOService(db *sql.DB, auditService *audit.AuditService, userService *users.UserService) *SSOService {
+	return &SSOService{
+		db:          db,
+		audit:       auditService,
+		users:       userService,
+		client:      newHTTPClient(),
+		redirectURL: os.Getenv("SSO_REDIRECT_URL"),
+	}
+}
+
+// SaveConnection creates or replaces the org's SSO configuration. The issuer
+// is checked by running discovery before anything is stored.
+func (s *SSOService) SaveConnection(conn *Connection, actor audit.Actor) (*Connection, error) {
+	if _, err := discover(s.client, conn.Issuer); err != nil {
+		return nil, err
+	}
+
+	domains := make([]string, 0, len(conn.AllowedDomains))
+	for _, d := range conn.AllowedDomains {
+		domains = append(domains, strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")))
+	}
+
+	// For the audit log only, so a failed lookup just leaves Before empty
+	previous, _ := s.GetConnection(conn.OrgID)
+
+	var saved Connection
+	err := s.db.QueryRow(`
+		INSERT INTO sso_connections (org_id, issuer, client_id, client_secret, allowed_domains, default_role, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (org_id) DO UPDATE SET
+			issuer = EXCLUDED.issuer,
+			client_id = EXCLUDED.client_id,
+			client_secret = EXCLUDED.client_secret,
+			allowed_domains = EXCLUDED.allowed_domains,
+			default_role = EXCLUDED.default_role,
+			enabled = EXCLUDED.enabled,
+			updated_at = NOW()
+		RETURNING id, org_id, issuer, client_id, client_secret, allowed_domains, default_role, enabled, created_at
+	`, conn.OrgID, conn.Issuer, conn.ClientID, conn.ClientSecret, pq.Array(domains), conn.DefaultRole, conn.Enabled).Scan(
+		&saved.ID, &saved.OrgID, &saved.Issuer, &saved.ClientID, &saved.ClientSecret,
+		pq.Array(&saved.AllowedDomains), &saved.DefaultRole, &saved.Enabled, &saved.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	e := audit.Event{
+		OrgID:      saved.OrgID,
+		Actor:      actor,
+		Action:     "sso.connection_saved",
+		TargetType: "sso_connection",
+		TargetID:   saved.ID,
+		After:      connectionState(&saved),
+	}
+	if previous != nil {
+		e.Before = connectionState(previous)
+	}
+	s.audit.Emit(e)
+
+	return &saved, nil
+}
+
+// connectionState is the audited part of a connection; the client secret is
+// left out
+func connectionState(conn *Connection) map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":          conn.Issuer,
+		"client_id":       conn.ClientID,
+		"allowed_domains": conn.AllowedDomains,
+		"default_role":    conn.DefaultRole,
+		"enabled":         conn.Enabled,
+	}
+}
+
+func (s *SSOService) GetConnection(orgID string) (*Connection, error) {
+	return s.scanConnection(s.db.QueryRow(`
+		SELECT id, org_id, issuer, client_id, client_secret, allowed_domains, default_role, enabled, created_at
+		FROM sso_connections
+		WHERE org_id = $1
+		  AND org_id IN (SELECT id FROM organizations WHERE deleted_at IS NULL)
+	`, orgID))
+}
+
+// EffectiveConnection is the organization's own connection or, failing
+// that, its parent's. Users signing in through an inherited connection join
+// the child organization as members.
+func (s *SSOService) EffectiveConnection(orgID string) (*Connection, error) {
+	conn, err := s.GetConnection(orgID)
+	if err != ErrConnectionNotFound {
+		return conn, err
+	}
+
+	conn, err = s.scanConnection(s.db.QueryRow(`
+		SELECT c.id, c.org_id, c.issuer, c.client_id, c.client_secret, c.allowed_domains,
+			c.default_role, c.enabled, c.created_at
+		FROM organizations o
+		JOIN sso_connections c ON c.org_id = o.parent_id
+		WHERE o.id = $1 AND o.deleted_at IS NULL
+	`, orgID))
+	if err != nil {
+		return nil, err
+	}
+
+	// The parent's default role may be one of its custom roles
+	conn.InheritedFrom = conn.OrgID
+	conn.OrgID = orgID
+	conn.DefaultRole = "member"
+	return conn, nil
+}
+
+func (s *SSOService) scanConnection(row *sql.Row) (*Connection, error) {
+	var conn Connection
+	err := row.Scan(
+		&conn.ID, &conn.OrgID, &conn.Issuer, &conn.ClientID, &conn.ClientSecret,
+		pq.Array(&conn.AllowedDomains), &conn.DefaultRole, &conn.Enabled, &conn.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrConnectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// BeginLogin stores a one-time state with the nonce and PKCE verifier and
+// returns the URL to redirect the browser to
+func (s *SSOService) BeginLogin(orgID string) (string, error) {
+	if s.redirectURL == "" {
+		return "", errors.New("SSO_REDIRECT_URL not set")
+	}
+
+	conn, err := s.EffectiveConnection(orgID)
+	if err != nil {
+		return "", err
+	}
+	if !conn.Enabled {
+		return "", ErrConnectionNotFound
+	}
+
+	cfg, err := discover(s.client, conn.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO sso_login_states (state, org_id, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, state, orgID, nonce, verifier, time.Now().Add(loginStateTTL))
+	if err != nil {
+		return "", err
+	}
+
+	return authCodeURL(cfg, conn.ClientID, s.redirectURL, state, nonce, verifier), nil
+}
+
+// CompleteLogin handles the IdP callback: it redeems the code, verifies the
+// ID token, provisions the user and membership if needed and issues our own
+// access token. Logins refused once the IdP has named the user are audited.
+func (s *SSOService) CompleteLogin(state, code, ip string) (*LoginResult, error) {
+	var orgID, nonce, verifier string
+	var expiresAt time.Time
+	err := s.db.QueryRow(`
+		DELETE FROM sso_login_states WHERE state = $1
+		RETURNING org_id, nonce, code_verifier, expires_at
+	`, state).Scan(&orgID, &nonce, &verifier, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidState
+	}
+
+	conn, err := s.EffectiveConnection(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := discover(s.client, conn.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := exchangeCode(s.client, cfg, conn.ClientID, conn.ClientSecret, s.redirectURL, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyIDToken(s.client, cfg, conn.ClientID, nonce, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := users.LoginAttempt{OrgID: orgID, Email: claims.Email, IP: ip, Method: "sso"}
+	email, err := checkEmail(claims, conn.AllowedDomains)
+	if err != nil {
+		s.users.AuditLogin(attempt, err)
+		return nil, err
+	}
+
+	userID, provisioned, err := s.provision(email, conn)
+	if errors.Is(err, ErrAccountNotLinked) || errors.Is(err, orgs.ErrSeatLimitReached) {
+		s.users.AuditLogin(attempt, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attempt.UserID = userID
+	attempt.Email = email
+	login, err := s.users.ExternalLogin(attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		Token:       login.Token,
+		MFARequired: login.MFARequired,
+		MFAToken:    login.MFAToken,
+		UserID:      userID,
+		OrgID:       orgID,
+		Provisioned: provisioned,
+	}, nil
+}
+
+// provision finds or creates the user and makes sure they are a member of the
+// connection's organization. Any organization can list any domain as
+// allowed, so an existing account is only signed in when it's already a
+// member or the organization has verified the email's domain.
+func (s *SSOService) provision(email string, conn *Connection) (string, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	// Serialize joins per organization so two of them can't both take the
+	// last seat
+	if _, err = tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, conn.OrgID); err != nil {
+		return "", false, err
+	}
+
+	// An inherited connection can rely on the parent's verified domains
+	owners := []string{conn.OrgID}
+	if conn.InheritedFrom != "" {
+		owners = append(owners, conn.InheritedFrom)
+	}
+	var domainVerified bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM org_domains
+			WHERE domain = $1 AND verified_at IS NOT NULL AND org_id::text = ANY($2)
+		)
+	`, email[strings.LastIndex(email, "@")+1:], pq.Array(owners)).Scan(&domainVerified)
+	if err != nil {
+		return "", false, err
+	}
+
+	var userID string
+	var member bool
+	provisioned := false
+	err = tx.QueryRow(`
+		SELECT u.id, EXISTS (SELECT 1 FROM memberships m WHERE m.user_id = u.id AND m.org_id = $2)
+		FROM users u
+		WHERE u.email = $1
+	`, email, conn.OrgID).Scan(&userID, &member)
+	switch {
+	case err == sql.ErrNoRows:
+		// SSO users get an unusable password hash until they set one. The
+		// email only counts as verified on a domain the organization owns.
+		err = tx.QueryRow(`
+			INSERT INTO users (email, password_hash, email_verified_at)
+			VALUES ($1, '', CASE WHEN $2 THEN NOW() END)
+			RETURNING id
+		`, email, domainVerified).Scan(&userID)
+		provisioned = true
+	case err == nil && !member && !domainVerified:
+		return "", false, ErrAccountNotLinked
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if !member {
+		if err = orgs.CheckSeats(tx, conn.OrgID); err != nil {
+			return "", false, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO memberships (user_id, org_id, role)
+			VALUES ($1, $2, $3)
+		`, userID, conn.OrgID, conn.DefaultRole)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", false, err
+	}
+
+	return userID, provisioned, nil
+}
+
+func checkEmail(claims *idTokenClaims, allowedDomains []string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "", errors.New("identity provider did not return an email address")
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return "", ErrEmailNotVerified
+	}
+
+	domain := email[at+1:]
+	for _, d := range allowedDomains {
+		if domain == d {
+			return email, nil
+		}
+	}
+
+	return "", ErrDomainNotAllowed
+}
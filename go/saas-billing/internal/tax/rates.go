@@ -0,0 +1,128 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// RateCalculator is the built-in TaxCalculator. It charges every active rate
+// configured for the customer's country and, if set, region. origin is the
+// seller's country, used for the EU reverse charge.
+type RateCalculator struct {
+	db     *sql.DB
+	origin string
+}
+
+func NewRateCalculator(db *sql.DB, originCountry string) *RateCalculator {
+	return &RateCalculator{db: db, origin: strings.ToUpper(originCountry)}
+}
+
+func (c *RateCalculator) Calculate(ctx context.Context, req Request) (*Result, error) {
+	if req.Customer.Country == "" || reverseCharged(c.origin, req.Customer) {
+		return compute(req, nil, reverseCharged(c.origin, req.Customer)), nil
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT id, country, COALESCE(region, ''), name, rate_bps, created_at
+		FROM tax_rates
+		WHERE active AND country = $1 AND (region IS NULL OR region = $2)
+		ORDER BY region NULLS FIRST, name
+	`, req.Customer.Country, strings.ToUpper(req.Customer.Region))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []Rate{}
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.ID, &r.Country, &r.Region, &r.Name, &r.RateBps, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return compute(req, rates, false), nil
+}
+
+// ListRates returns the active rates, by jurisdiction
+func (c *RateCalculator) ListRates() ([]Rate, error) {
+	rows, err := c.db.Query(`
+		SELECT id, country, COALESCE(region, ''), name, rate_bps, created_at
+		FROM tax_rates
+		WHERE active
+		ORDER BY country, region NULLS FIRST, name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []Rate{}
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.ID, &r.Country, &r.Region, &r.Name, &r.RateBps, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+
+	return rates, rows.Err()
+}
+
+// CreateRate adds a rate. It applies to invoices created from now on.
+func (c *RateCalculator) CreateRate(r Rate) (*Rate, error) {
+	country, err := NormalizeCountry(r.Country)
+	if err != nil {
+		return nil, err
+	}
+	r.Country = country
+	r.Region = strings.ToUpper(strings.TrimSpace(r.Region))
+	if r.RateBps < 0 || r.RateBps > 10000 || strings.TrimSpace(r.Name) == "" {
+		return nil, ErrInvalidRate
+	}
+
+	err = c.db.QueryRow(`
+		INSERT INTO tax_rates (country, region, name, rate_bps)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id, created_at
+	`, r.Country, r.Region, r.Name, r.RateBps).Scan(&r.ID, &r.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrRateExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// DeactivateRate stops charging a rate. Invoices keep the tax lines they
+// were issued with.
+func (c *RateCalculator) DeactivateRate(rateID string) error {
+	result, err := c.db.Exec(`
+		UPDATE tax_rates SET active = FALSE, deactivated_at = NOW()
+		WHERE id::text = $1 AND active
+	`, rateID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRateNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package tax works out the tax on invoices. Billing only talks to the
+// TaxCalculator interface; RateCalculator implements it from the rates
+// configured in the database, and an external provider can replace it.
+package tax
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrRateNotFound   = errors.New("tax rate not found")
+	ErrRateExists     = errors.New("an active tax rate with this name already exists for the jurisdiction")
+	ErrInvalidRate    = errors.New("invalid tax rate")
+	ErrInvalidCountry = errors.New("country must be an ISO 3166-1 alpha-2 code")
+	ErrInvalidTaxID   = errors.New("tax ID is not valid for the billing country")
+	// ErrTaxIDCheckUnavailable means the registry couldn't be asked; the tax
+	// ID may be valid or not
+	ErrTaxIDCheckUnavailable = errors.New("tax ID could not be checked, try again later")
+)
+
+// Customer is who an invoice is addressed to, as far as tax is concerned
+type Customer struct {
+	Country string // ISO 3166-1 alpha-2
+	Region  string // state or province, where rates differ within a country
+	TaxID   string
+	// TaxIDValid is set when the tax ID was checked when it was saved
+	TaxIDValid bool
+}
+
+// LineInput is an amount to tax. Inclusive amounts already contain the tax.
+type LineInput struct {
+	Description string
+	AmountCents int
+	Inclusive   bool
+}
+
+type Request struct {
+	Customer Customer
+	Lines    []LineInput
+}
+
+// Line is a taxed line. AmountCents excludes tax whether or not the input
+// was inclusive.
+type Line struct {
+	Description string `json:"description"`
+	AmountCents int    `json:"amount_cents"`
+	TaxCents    int    `json:"tax_cents"`
+}
+
+// TaxLine is the tax charged at one rate across the whole invoice
+type TaxLine struct {
+	Name         string `json:"name"`
+	Jurisdiction string `json:"jurisdiction"`
+	RateBps      int    `json:"rate_bps"`
+	TaxableCents int    `json:"taxable_cents"`
+	AmountCents  int    `json:"amount_cents"`
+}
+
+type Result struct {
+	Lines         []Line    `json:"lines"`
+	TaxLines      []TaxLine `json:"tax_lines"`
+	SubtotalCents int       `json:"subtotal_cents"`
+	TaxCents      int       `json:"tax_cents"`
+	TotalCents    int       `json:"total_cents"`
+	// ReverseCharge means the customer accounts for the VAT themselves
+	ReverseCharge bool `json:"reverse_charge"`
+}
+
+// TaxCalculator computes the tax for an invoice
+type TaxCalculator interface {
+	Calculate(ctx context.Context, req Request) (*Result, error)
+}
+
+// Rate is a tax charged in a country, or in one region of it when Region is
+// set. RateBps is in basis points: 2000 is 20%.
+type Rate struct {
+	ID        string `json:"id"`
+	Country   string `json:"country"`
+	Region    string `json:"region,omitempty"`
+	Name      string `json:"name"`
+	RateBps   int    `json:"rate_bps"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (r Rate) jurisdiction() string {
+	if r.Region != "" {
+		return r.Country + "-" + r.Region
+	}
+	return r.Country
+}
+
+// compute applies every rate to every line. Tax is rounded per line and
+// rate, half away from zero, so the lines add up to the invoice totals.
+// Inclusive amounts are split into net and tax using the combined rate.
+func compute(req Request, rates []Rate, reverseCharge bool) *Result {
+	result := &Result{Lines: []Line{}, TaxLines: []TaxLine{}, ReverseCharge: reverseCharge}
+	if reverseCharge {
+		rates = nil
+	}
+
+	combined := 0
+	for _, r := range rates {
+		combined += r.RateBps
+	}
+
+	taxLines := make([]TaxLine, len(rates))
+	for i, r := range rates {
+		taxLines[i] = TaxLine{Name: r.Name, Jurisdiction: r.jurisdiction(), RateBps: r.RateBps}
+	}
+
+	for _, in := range req.Lines {
+		net := in.AmountCents
+		if in.Inclusive && combined > 0 {
+			net = divRound(in.AmountCents*10000, 10000+combined)
+		}
+
+		line := Line{Description: in.Description, AmountCents: net}
+		for i, r := range rates {
+			tax := divRound(net*r.RateBps, 10000)
+			// With several inclusive rates the last one absorbs the rounding
+			// so net plus tax is exactly the amount charged
+			if in.Inclusive && i == len(rates)-1 {
+				tax = in.AmountCents - net - line.TaxCents
+			}
+			line.TaxCents += tax
+			taxLines[i].TaxableCents += net
+			taxLines[i].AmountCents += tax
+		}
+
+		result.Lines = append(result.Lines, line)
+		result.SubtotalCents += line.AmountCents
+		result.TaxCents += line.TaxCents
+	}
+
+	result.TaxLines = append(result.TaxLines, taxLines...)
+	result.TotalCents = result.SubtotalCents + result.TaxCents
+	return result
+}
+
+// divRound divides rounding half away from zero
+func divRound(a, b int) int {
+	if (a < 0) != (b < 0) {
+		return (a - b/2) / b
+	}
+	return (a + b/2) / b
+}
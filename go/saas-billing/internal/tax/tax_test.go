@@ -0,0 +1,125 @@
+package tax
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeExclusive(t *testing.T) {
+	rates := []Rate{{Country: "DE", Name: "VAT", RateBps: 1900}}
+	result := compute(Request{Lines: []LineInput{
+		{Description: "Pro plan", AmountCents: 4999},
+		{Description: "Seats", AmountCents: 1001},
+	}}, rates, false)
+
+	// 4999 * 19% = 949.81 and 1001 * 19% = 190.19, rounded per line
+	assert.Equal(t, []Line{
+		{Description: "Pro plan", AmountCents: 4999, TaxCents: 950},
+		{Description: "Seats", AmountCents: 1001, TaxCents: 190},
+	}, result.Lines)
+	assert.Equal(t, []TaxLine{{Name: "VAT", Jurisdiction: "DE", RateBps: 1900, TaxableCents: 6000, AmountCents: 1140}}, result.TaxLines)
+	assert.Equal(t, 6000, result.SubtotalCents)
+	assert.Equal(t, 1140, result.TaxCents)
+	assert.Equal(t, 7140, result.TotalCents)
+}
+
+func TestComputeInclusive(t *testing.T) {
+	// Two rates on one price: the net and both taxes add back up to it
+	rates := []Rate{
+		{Country: "CA", Name: "GST", RateBps: 500},
+		{Country: "CA", Region: "BC", Name: "PST", RateBps: 700},
+	}
+	result := compute(Request{Lines: []LineInput{{Description: "Pro plan", AmountCents: 10000, Inclusive: true}}}, rates, false)
+
+	assert.Equal(t, 8929, result.SubtotalCents)
+	assert.Equal(t, 10000, result.TotalCents)
+	assert.Equal(t, 446, result.TaxLines[0].AmountCents)
+	assert.Equal(t, 625, result.TaxLines[1].AmountCents)
+	assert.Equal(t, "CA-BC", result.TaxLines[1].Jurisdiction)
+}
+
+func TestComputeReverseCharge(t *testing.T) {
+	rates := []Rate{{Country: "FR", Name: "TVA", RateBps: 2000}}
+	result := compute(Request{Lines: []LineInput{{AmountCents: 12000, Inclusive: true}}}, rates, true)
+
+	assert.True(t, result.ReverseCharge)
+	assert.Empty(t, result.TaxLines)
+	assert.Equal(t, 12000, result.SubtotalCents)
+	assert.Equal(t, 0, result.TaxCents)
+}
+
+func TestReverseCharged(t *testing.T) {
+	valid := Customer{Country: "FR", TaxID: "FR12345678901", TaxIDValid: true}
+
+	assert.True(t, reverseCharged("DE", valid))
+	assert.False(t, reverseCharged("FR", valid), "domestic sale")
+	assert.False(t, reverseCharged("", valid), "seller country not configured")
+	assert.False(t, reverseCharged("US", valid), "seller outside the EU")
+	assert.False(t, reverseCharged("DE", Customer{Country: "FR"}), "consumer")
+	assert.False(t, reverseCharged("DE", Customer{Country: "CH", TaxIDValid: true}), "customer outside the EU")
+}
+
+func TestCheckFormat(t *testing.T) {
+	ok := func(country, id string) bool {
+		return CheckFormat(country, id) == nil
+	}
+
+	assert.True(t, ok("DE", "DE123456789"))
+	assert.True(t, ok("NL", "nl 8560.66.124-B01"))
+	assert.True(t, ok("GR", "EL123456789"))
+	assert.False(t, ok("GR", "GR123456789"))
+	assert.False(t, ok("DE", "FR12345678901"))
+	assert.False(t, ok("DE", "DE1"))
+	// Only EU VAT numbers have a known format
+	assert.True(t, ok("US", "US123456789"))
+}
+
+func TestUnverifiedValidatorNeverReverseCharges(t *testing.T) {
+	valid, err := UnverifiedValidator{}.ValidateTaxID(context.Background(), "FR", "FR12345678901")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func TestVIESValidator(t *testing.T) {
+	var path string
+	answer := `{"isValid": true, "userError": "VALID"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(answer))
+	}))
+	defer server.Close()
+	v := &VIESValidator{client: server.Client(), baseURL: server.URL}
+	ctx := context.Background()
+
+	valid, err := v.ValidateTaxID(ctx, "GR", "el 123456789")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, "/ms/EL/vat/123456789", path)
+
+	answer = `{"isValid": false, "userError": "INVALID"}`
+	valid, err = v.ValidateTaxID(ctx, "DE", "DE123456789")
+	assert.ErrorIs(t, err, ErrInvalidTaxID)
+	assert.False(t, valid)
+
+	// An unreachable registry is neither valid nor invalid
+	answer = `{"isValid": false, "userError": "MS_UNAVAILABLE"}`
+	_, err = v.ValidateTaxID(ctx, "DE", "DE123456789")
+	assert.ErrorIs(t, err, ErrTaxIDCheckUnavailable)
+
+	// Nothing outside the EU is looked up
+	path = ""
+	valid, err = v.ValidateTaxID(ctx, "US", "US123456789")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+	assert.Empty(t, path)
+}
+
+func TestDivRound(t *testing.T) {
+	assert.Equal(t, 3, divRound(5, 2))
+	assert.Equal(t, -3, divRound(-5, 2))
+	assert.Equal(t, 2, divRound(7, 3))
+}
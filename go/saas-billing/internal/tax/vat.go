@@ -0,0 +1,157 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// euCountries are the EU member states, where B2B supplies across borders
+// are reverse charged
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true,
+	"EE": true, "ES": true, "FI": true, "FR": true, "GR": true, "HR": true, "HU": true,
+	"IE": true, "IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+var (
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	vatBodyPattern = regexp.MustCompile(`^[0-9A-Z]{2,12}$`)
+)
+
+// TaxIDValidator decides whether a customer's tax ID is valid, which makes
+// their invoices eligible for the reverse charge. taxID is already known to
+// be well formed (see CheckFormat). A number known not to be registered is
+// ErrInvalidTaxID.
+type TaxIDValidator interface {
+	ValidateTaxID(ctx context.Context, country, taxID string) (bool, error)
+}
+
+// UnverifiedValidator confirms nothing: tax IDs are stored but never count
+// as valid, so no invoice is reverse charged. A well-formed number may still
+// not be registered, so the format alone isn't enough.
+type UnverifiedValidator struct{}
+
+func (UnverifiedValidator) ValidateTaxID(ctx context.Context, country, taxID string) (bool, error) {
+	return false, nil
+}
+
+const viesBaseURL = "https://ec.europa.eu/taxation_customs/vies/rest-api"
+
+// VIESValidator asks the European Commission's VIES service whether an EU
+// VAT number is registered. Tax IDs from elsewhere never count as valid.
+type VIESValidator struct {
+	client  *http.Client
+	baseURL string
+}
+
+func NewVIESValidator() *VIESValidator {
+	return &VIESValidator{client: &http.Client{Timeout: 10 * time.Second}, baseURL: viesBaseURL}
+}
+
+type viesResponse struct {
+	IsValid   bool   `json:"isValid"`
+	UserError string `json:"userError"`
+}
+
+// ValidateTaxID returns ErrTaxIDCheckUnavailable when VIES or the member
+// state's registry can't answer, rather than guessing either way
+func (v *VIESValidator) ValidateTaxID(ctx context.Context, country, taxID string) (bool, error) {
+	if !euCountries[country] {
+		return false, nil
+	}
+	prefix := vatPrefix(country)
+	number := strings.TrimPrefix(NormalizeTaxID(taxID), prefix)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		v.baseURL+"/ms/"+prefix+"/vat/"+url.PathEscape(number), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrTaxIDCheckUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%w: unexpected status %d", ErrTaxIDCheckUnavailable, resp.StatusCode)
+	}
+
+	var body viesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrTaxIDCheckUnavailable, err)
+	}
+
+	switch {
+	case body.IsValid:
+		return true, nil
+	case body.UserError == "INVALID":
+		return false, ErrInvalidTaxID
+	default:
+		// MS_UNAVAILABLE, TIMEOUT and the like say nothing about the number
+		return false, fmt.Errorf("%w: %s", ErrTaxIDCheckUnavailable, body.UserError)
+	}
+}
+
+// CheckFormat rejects an EU VAT number that isn't well formed for the
+// country. Tax IDs from outside the EU aren't checked.
+func CheckFormat(country, taxID string) error {
+	if euCountries[country] && !validVATFormat(country, taxID) {
+		return ErrInvalidTaxID
+	}
+	return nil
+}
+
+// InEU reports whether the country is an EU member state
+func InEU(country string) bool {
+	return euCountries[country]
+}
+
+func validVATFormat(country, taxID string) bool {
+	if !euCountries[country] {
+		return false
+	}
+	prefix := vatPrefix(country)
+	id := NormalizeTaxID(taxID)
+	return strings.HasPrefix(id, prefix) && vatBodyPattern.MatchString(id[len(prefix):])
+}
+
+// vatPrefix is the country's prefix on VAT numbers. Greece uses EL rather
+// than its ISO code.
+func vatPrefix(country string) string {
+	if country == "GR" {
+		return "EL"
+	}
+	return country
+}
+
+// NormalizeTaxID drops the separators people tend to type
+func NormalizeTaxID(taxID string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(taxID))
+}
+
+// NormalizeCountry upper-cases a country code and checks its shape
+func NormalizeCountry(country string) (string, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if !countryPattern.MatchString(country) {
+		return "", ErrInvalidCountry
+	}
+	return country, nil
+}
+
+// reverseCharged reports whether a sale from origin to the customer is
+// reverse charged: both in the EU, different countries, and the customer has
+// a valid VAT number
+func reverseCharged(origin string, c Customer) bool {
+	return origin != "" && euCountries[origin] && euCountries[c.Country] &&
+		c.Country != origin && c.TaxIDValid
+}
@@ -0,0 +1,115 @@
+package usage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrUnknownTeam = errors.New("team does not belong to this organization")
+
+type Record struct {
+	ID         string    `json:"usage_id"`
+	OrgID      string    `json:"org_id"`
+	TeamID     string    `json:"team_id,omitempty"`
+	Metric     string    `json:"metric"`
+	Quantity   int       `json:"quantity"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type MetricTotal struct {
+	Total int64 `json:"total"`
+}
+
+type Report struct {
+	Period struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"period"`
+	Metrics map[string]MetricTotal `json:"metrics"`
+	// Teams breaks the totals down by team ID. Usage recorded without a team
+	// only counts towards Metrics.
+	Teams map[string]map[string]MetricTotal `json:"teams,omitempty"`
+}
+
+type UsageService struct {
+	db *sql.DB
+}
+
+func NewUsageService(db *sql.DB) *UsageService {
+	return &UsageService{db: db}
+}
+
+// Record stores usage for the organization, attributed to teamID when it is
+// not empty
+func (s *UsageService) Record(orgID, teamID, metric string, quantity int, recordedAt time.Time) (*Record, error) {
+	if recordedAt.IsZero() {
+		recordedAt = time.Now()
+	}
+
+	if teamID != "" {
+		var exists bool
+		err := s.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM teams WHERE id::text = $1 AND org_id = $2)
+		`, teamID, orgID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrUnknownTeam
+		}
+	}
+
+	rec := Record{TeamID: teamID}
+	err := s.db.QueryRow(`
+		INSERT INTO usage_records (org_id, team_id, metric, quantity, recorded_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+		RETURNING id, org_id, metric, quantity, recorded_at
+	`, orgID, teamID, metric, quantity, recordedAt).Scan(
+		&rec.ID, &rec.OrgID, &rec.Metric, &rec.Quantity, &rec.RecordedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+// GetReport sums usage per metric, and per team and metric, in [start, end).
+// An empty metric includes all metrics; a non-empty teamID limits the report
+// to that team.
+func (s *UsageService) GetReport(orgID string, start, end time.Time, metric, teamID string) (*Report, error) {
+	rows, err := s.db.Query(`
+		SELECT COALESCE(team_id::text, ''), metric, COALESCE(SUM(quantity), 0)
+		FROM usage_records
+		WHERE org_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		  AND ($4 = '' OR metric = $4)
+		  AND ($5 = '' OR team_id::text = $5)
+		GROUP BY team_id, metric
+	`, orgID, start, end, metric, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &Report{Metrics: map[string]MetricTotal{}, Teams: map[string]map[string]MetricTotal{}}
+	report.Period.Start = start
+	report.Period.End = end
+
+	for rows.Next() {
+		var team, name string
+		var total int64
+		if err := rows.Scan(&team, &name, &total); err != nil {
+			return nil, err
+		}
+		report.Metrics[name] = MetricTotal{Total: report.Metrics[name].Total + total}
+		if team != "" {
+			if report.Teams[team] == nil {
+				report.Teams[team] = map[string]MetricTotal{}
+			}
+			report.Teams[team][name] = MetricTotal{Total: total}
+		}
+	}
+
+	return report, rows.Err()
+}
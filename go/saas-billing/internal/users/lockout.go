@@ -0,0 +1,296 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/linkmeAman/saas-billing/internal/config"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+)
+
+// Brute-force protection. After a few failures each further attempt has to
+// wait twice as long as the previous one; after lockoutThreshold failures the
+// account is locked and the owner is emailed an unlock link.
+const (
+	accountDelayThreshold = 3
+	lockoutThreshold      = 10
+	lockoutDuration       = 30 * time.Minute
+
+	ipWindow         = 15 * time.Minute
+	ipDelayThreshold = 10
+	ipMaxFailures    = 50
+
+	maxLoginDelay = time.Minute
+)
+
+var (
+	ErrInvalidUnlockToken = errors.New("invalid or expired unlock link")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// ThrottleError is returned by Login when the caller must wait before trying
+// again. Its message deliberately doesn't say whether the account or the IP
+// tripped it, or whether the account exists; Locked is for callers only.
+type ThrottleError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// progressiveDelay is how long to wait after the latest of n failures
+func progressiveDelay(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-threshold))) * time.Second
+	if delay > maxLoginDelay || delay <= 0 {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+func checkAccountThrottle(now time.Time, failures int, lastFailedAt, lockedUntil sql.NullTime) error {
+	if lockedUntil.Valid && now.Before(lockedUntil.Time) {
+		return &ThrottleError{RetryAfter: lockedUntil.Time.Sub(now), Locked: true}
+	}
+
+	if lastFailedAt.Valid {
+		next := lastFailedAt.Time.Add(progressiveDelay(failures, accountDelayThreshold))
+		if now.Before(next) {
+			return &ThrottleError{RetryAfter: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// checkUnknownEmailThrottle throttles an email with no account the way
+// checkAccountThrottle would if it had one, so the response doesn't reveal
+// which emails are registered
+func (s *UserService) checkUnknownEmailThrottle(email string) error {
+	var failures int
+	var lastFailedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MAX(attempted_at)
+		FROM login_failures
+		WHERE email = $1 AND attempted_at > $2
+	`, email, time.Now().Add(-lockoutDuration)).Scan(&failures, &lastFailedAt)
+	if err != nil {
+		return err
+	}
+
+	return checkAccountThrottle(time.Now(), failures, lastFailedAt, unknownEmailLock(failures, lastFailedAt))
+}
+
+// unknownEmailLock is when an account would stay locked until after these
+// failures: lockoutDuration after the one that reached lockoutThreshold
+func unknownEmailLock(failures int, lastFailedAt sql.NullTime) sql.NullTime {
+	if failures < lockoutThreshold || !lastFailedAt.Valid {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: lastFailedAt.Time.Add(lockoutDuration), Valid: true}
+}
+
+func (s *UserService) checkIPThrottle(ip string) error {
+	var failures int
+	var lastFailedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MAX(attempted_at)
+		FROM login_failures
+		WHERE ip = $1 AND attempted_at > $2
+	`, ip, time.Now().Add(-ipWindow)).Scan(&failures, &lastFailedAt)
+	if err != nil {
+		return err
+	}
+
+	if failures >= ipMaxFailures {
+		return &ThrottleError{RetryAfter: ipWindow}
+	}
+
+	if lastFailedAt.Valid {
+		next := lastFailedAt.Time.Add(progressiveDelay(failures, ipDelayThreshold))
+		if time.Now().Before(next) {
+			return &ThrottleError{RetryAfter: time.Until(next)}
+		}
+	}
+
+	return nil
+}
+
+func (s *UserService) recordIPFailure(ip, email string) error {
+	// Keep the table bounded to the rows the throttles still look at
+	_, err := s.db.Exec(`
+		DELETE FROM login_failures WHERE ip = $1 AND attempted_at <= $2
+	`, ip, time.Now().Add(-max(ipWindow, lockoutDuration)))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO login_failures (ip, email)
+		VALUES ($1, $2)
+	`, ip, email)
+	return err
+}
+
+// recordFailure counts a wrong password against the account and the IP and
+// locks the account once lockoutThreshold is reached
+func (s *UserService) recordFailure(user User, ip string) error {
+	if err := s.recordIPFailure(ip, user.Email); err != nil {
+		return err
+	}
+
+	var failures int
+	err := s.db.QueryRow(`
+		UPDATE users
+		SET failed_login_attempts = failed_login_attempts + 1,
+			last_failed_login_at = NOW()
+		WHERE id = $1
+		RETURNING failed_login_attempts
+	`, user.ID).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if failures < lockoutThreshold {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(lockoutDuration)
+	_, err = s.db.Exec(`
+		UPDATE users SET locked_until = $1 WHERE id = $2
+	`, lockedUntil, user.ID)
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		Action:     "user.locked",
+		TargetType: "user",
+		TargetID:   user.ID,
+		Actor:      audit.Actor{IP: ip},
+		Metadata:   map[string]interface{}{"failed_attempts": failures, "locked_until": lockedUntil},
+	})
+	// Only email on the first lock; later ones happen after a single failure
+	// once the previous lock expired
+	if failures == lockoutThreshold {
+		s.sendUnlockEmail(user)
+	}
+
+	return nil
+}
+
+func (s *UserService) resetFailures(userID string) error {
+	_, err := s.db.Exec(`
+		UPDATE users
+		SET failed_login_attempts = 0, last_failed_login_at = NULL, locked_until = NULL, unlock_nonce = NULL
+		WHERE id = $1
+	`, userID)
+	return err
+}
+
+// UnlockWithToken clears a lockout using the link emailed at lock time. The
+// link is spent with the stored nonce, and any other unlock or a successful
+// login spends it too.
+func (s *UserService) UnlockWithToken(token string) error {
+	claims, err := auth.ValidateScopedToken(token, auth.ScopeAccountUnlock)
+	if err != nil || claims.Subject == "" {
+		return ErrInvalidUnlockToken
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users
+		SET failed_login_attempts = 0, last_failed_login_at = NULL, locked_until = NULL, unlock_nonce = NULL
+		WHERE id = $1 AND unlock_nonce::text = $2
+	`, claims.UserID, claims.Subject)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidUnlockToken
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      audit.Actor{UserID: claims.UserID},
+		Action:     "user.unlocked",
+		TargetType: "user",
+		TargetID:   claims.UserID,
+		Metadata:   map[string]interface{}{"method": "email"},
+	})
+	return nil
+}
+
+// UnlockByAdmin clears a lockout on behalf of a platform admin. Accounts can
+// belong to several organizations, so no single organization's admins may do
+// this.
+func (s *UserService) UnlockByAdmin(userID string, actor audit.Actor) error {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	if err := s.resetFailures(userID); err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      actor,
+		Action:     "user.unlocked",
+		TargetType: "user",
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"method": "admin"},
+	})
+	return nil
+}
+
+func (s *UserService) sendUnlockEmail(user User) {
+	var nonce string
+	err := s.db.QueryRow(`
+		UPDATE users SET unlock_nonce = gen_random_uuid() WHERE id = $1 RETURNING unlock_nonce
+	`, user.ID).Scan(&nonce)
+	if err != nil {
+		logger.Error("Failed to create unlock token", err, logger.Fields{"user_id": user.ID})
+		return
+	}
+
+	token, err := auth.GenerateUnlockToken(user.ID, nonce, lockoutDuration)
+	if err != nil {
+		logger.Error("Failed to create unlock token", err, logger.Fields{"user_id": user.ID})
+		return
+	}
+
+	body := fmt.Sprintf("Your account was locked after too many failed sign-in attempts.\n\n"+
+		"If this was you, unlock it now:\n%s/unlock?token=%s\n\n"+
+		"Otherwise it will unlock automatically in %d minutes.",
+		config.AppURL(), token, int(lockoutDuration.Minutes()))
+
+	if err := s.mailer.Send(user.Email, "Your account has been locked", body); err != nil {
+		logger.Error("Failed to send unlock email", err, logger.Fields{"user_id": user.ID})
+	}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is compared against when the email is unknown
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = auth.HashPassword("not-a-real-password")
+	})
+	return dummyHash
+}
@@ -0,0 +1,97 @@
+package users
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressiveDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), progressiveDelay(2, 3))
+	assert.Equal(t, time.Second, progressiveDelay(3, 3))
+	assert.Equal(t, 2*time.Second, progressiveDelay(4, 3))
+	assert.Equal(t, 32*time.Second, progressiveDelay(8, 3))
+	assert.Equal(t, maxLoginDelay, progressiveDelay(9, 3))
+	assert.Equal(t, maxLoginDelay, progressiveDelay(500, 3))
+}
+
+func TestCheckAccountThrottle(t *testing.T) {
+	now := time.Now()
+	never := sql.NullTime{}
+
+	// Below the delay threshold nothing is throttled
+	assert.NoError(t, checkAccountThrottle(now, 2, sql.NullTime{Time: now, Valid: true}, never))
+
+	// Fifth failure one second ago must wait four seconds in total
+	err := checkAccountThrottle(now, 5, sql.NullTime{Time: now.Add(-time.Second), Valid: true}, never)
+	var throttled *ThrottleError
+	assert.ErrorAs(t, err, &throttled)
+	assert.False(t, throttled.Locked)
+	assert.Equal(t, 3*time.Second, throttled.RetryAfter)
+
+	assert.NoError(t, checkAccountThrottle(now, 5, sql.NullTime{Time: now.Add(-5 * time.Second), Valid: true}, never))
+
+	// Locked accounts report the remaining lock time
+	err = checkAccountThrottle(now, lockoutThreshold, sql.NullTime{Time: now, Valid: true}, sql.NullTime{Time: now.Add(10 * time.Minute), Valid: true})
+	assert.ErrorAs(t, err, &throttled)
+	assert.True(t, throttled.Locked)
+	assert.Equal(t, 10*time.Minute, throttled.RetryAfter)
+
+	// An expired lock falls back to the progressive delay
+	err = checkAccountThrottle(now, lockoutThreshold, sql.NullTime{Time: now.Add(-time.Hour), Valid: true}, sql.NullTime{Time: now.Add(-time.Minute), Valid: true})
+	assert.NoError(t, err)
+}
+
+func TestThrottleErrorDoesNotRevealLock(t *testing.T) {
+	delayed := &ThrottleError{RetryAfter: time.Minute}
+	locked := &ThrottleError{RetryAfter: time.Minute, Locked: true}
+	assert.Equal(t, delayed.Error(), locked.Error())
+}
+
+func TestUnknownEmailLock(t *testing.T) {
+	last := sql.NullTime{Time: time.Now(), Valid: true}
+
+	assert.False(t, unknownEmailLock(lockoutThreshold-1, last).Valid)
+
+	// Locked for as long as a real account reaching the threshold would be
+	lock := unknownEmailLock(lockoutThreshold, last)
+	assert.True(t, lock.Valid)
+	assert.Equal(t, last.Time.Add(lockoutDuration), lock.Time)
+}
+
+func TestUnlockLinkWorksOnce(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := NewUserService(db, audit.NewAuditService(db), nil)
+
+	token, err := auth.GenerateUnlockToken("user-1", "nonce-1", time.Minute)
+	require.NoError(t, err)
+
+	mock.ExpectExec(`UPDATE users .* unlock_nonce = NULL\s+WHERE id = \$1 AND unlock_nonce::text = \$2`).
+		WithArgs("user-1", "nonce-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.UnlockWithToken(token))
+
+	// The nonce was cleared, so the same link matches nothing
+	mock.ExpectExec(`UPDATE users .* unlock_nonce = NULL`).
+		WithArgs("user-1", "nonce-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.UnlockWithToken(token), ErrInvalidUnlockToken)
+
+	// Links without a nonce are refused outright
+	legacy, err := auth.GenerateScopedToken("user-1", auth.ScopeAccountUnlock, time.Minute)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.UnlockWithToken(legacy), ErrInvalidUnlockToken)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
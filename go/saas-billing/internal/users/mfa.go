@@ -0,0 +1,353 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/auth"
+)
+
+const (
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+
+	// mfaMaxAttempts wrong codes spend a challenge, so the user has to sign
+	// in with their password again
+	mfaMaxAttempts = 5
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("mfa is already enabled")
+	ErrMFANotEnrolled    = errors.New("mfa enrollment has not been started")
+	ErrMFANotEnabled     = errors.New("mfa is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+	ErrMFAChallengeSpent = errors.New("mfa challenge is expired or already used")
+)
+
+// EnrollMFA starts TOTP enrollment. MFA stays disabled until the user proves
+// possession of the secret with ConfirmMFA.
+func (s *UserService) EnrollMFA(userID string) (*auth.TOTPEnrollment, error) {
+	var email string
+	var mfaEnabled bool
+	err := s.db.QueryRow(`
+		SELECT email, mfa_enabled FROM users WHERE id = $1
+	`, userID).Scan(&email, &mfaEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	enrollment, err := auth.GenerateTOTP(email)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users SET totp_secret = $1, updated_at = NOW()
+		WHERE id = $2
+	`, enrollment.Secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmMFA enables MFA once the user submits a valid code for the pending
+// secret and returns a fresh set of recovery codes
+func (s *UserService) ConfirmMFA(userID, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var mfaEnabled bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, mfa_enabled FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &mfaEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := auth.TOTPStep(code, secret.String, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET mfa_enabled = TRUE, totp_last_step = $2, updated_at = NOW()
+		WHERE id = $1
+	`, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// startMFAChallenge records a login waiting on its second factor and returns
+// the token that completes it
+func (s *UserService) startMFAChallenge(userID string) (string, error) {
+	var challengeID string
+	err := s.db.QueryRow(`
+		INSERT INTO mfa_challenges (user_id, expires_at)
+		VALUES ($1, $2)
+		RETURNING id
+	`, userID, time.Now().Add(mfaChallengeTTL)).Scan(&challengeID)
+	if err != nil {
+		return "", err
+	}
+
+	return auth.GenerateMFAChallengeToken(userID, challengeID, mfaChallengeTTL)
+}
+
+// VerifyMFALogin completes a login started by Login. Either a TOTP code or an
+// unused recovery code is accepted. Each challenge can be completed once and
+// allows mfaMaxAttempts wrong codes, which also count towards the account
+// lockout.
+func (s *UserService) VerifyMFALogin(mfaToken, code, recoveryCode, ip string) (string, error) {
+	claims, err := auth.ValidateScopedToken(mfaToken, auth.ScopeMFAChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var challengeFailures int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT failed_attempts, expires_at, used_at
+		FROM mfa_challenges
+		WHERE id::text = $1 AND user_id = $2
+		FOR UPDATE
+	`, claims.Subject, claims.UserID).Scan(&challengeFailures, &expiresAt, &usedAt)
+	attempt := LoginAttempt{UserID: claims.UserID, IP: ip, Method: "mfa"}
+	if err == sql.ErrNoRows {
+		s.AuditLogin(attempt, ErrMFAChallengeSpent)
+		return "", ErrMFAChallengeSpent
+	}
+	if err != nil {
+		return "", err
+	}
+	if usedAt.Valid || challengeFailures >= mfaMaxAttempts || !time.Now().Before(expiresAt) {
+		s.AuditLogin(attempt, ErrMFAChallengeSpent)
+		return "", ErrMFAChallengeSpent
+	}
+
+	user := User{ID: claims.UserID}
+	var failedAttempts int
+	var lastFailedAt, lockedUntil sql.NullTime
+	err = tx.QueryRow(`
+		SELECT email, failed_login_attempts, last_failed_login_at, locked_until
+		FROM users WHERE id = $1
+	`, user.ID).Scan(&user.Email, &failedAttempts, &lastFailedAt, &lockedUntil)
+	if err != nil {
+		return "", err
+	}
+	attempt.Email = user.Email
+
+	if err := checkAccountThrottle(time.Now(), failedAttempts, lastFailedAt, lockedUntil); err != nil {
+		s.AuditLogin(attempt, err)
+		return "", err
+	}
+
+	if recoveryCode != "" {
+		err = useRecoveryCode(tx, user.ID, recoveryCode)
+	} else {
+		err = checkTOTP(tx, user.ID, code)
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		// The last allowed attempt spends the challenge
+		_, err = tx.Exec(`
+			UPDATE mfa_challenges
+			SET failed_attempts = failed_attempts + 1,
+				used_at = CASE WHEN failed_attempts + 1 >= $2 THEN NOW() END
+			WHERE id = $1
+		`, claims.Subject, mfaMaxAttempts)
+		if err != nil {
+			return "", err
+		}
+		if err = tx.Commit(); err != nil {
+			return "", err
+		}
+		if err := s.recordFailure(user, ip); err != nil {
+			return "", err
+		}
+		s.AuditLogin(attempt, ErrInvalidMFACode)
+		return "", ErrInvalidMFACode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1`, claims.Subject)
+	if err != nil {
+		return "", err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	if failedAttempts > 0 {
+		if err := s.resetFailures(user.ID); err != nil {
+			return "", err
+		}
+	}
+
+	token, err := auth.GenerateMFAVerifiedToken(user.ID)
+	if err != nil {
+		return "", err
+	}
+	s.AuditLogin(attempt, nil)
+	return token, nil
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes
+func (s *UserService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTOTP(tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableMFA turns MFA off after checking a current code
+func (s *UserService) DisableMFA(userID, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkTOTP(tx, userID, code); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET mfa_enabled = FALSE, totp_secret = NULL, totp_last_step = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkTOTP accepts each code once: the time step of the last code accepted
+// is kept, and codes from that step or earlier are refused, so a code seen
+// by someone else can't be replayed within its validity window
+func checkTOTP(tx *sql.Tx, userID, code string) error {
+	var secret sql.NullString
+	var mfaEnabled bool
+	var lastStep sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT totp_secret, mfa_enabled, totp_last_step FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &mfaEnabled, &lastStep)
+	if err != nil {
+		return err
+	}
+
+	if !mfaEnabled || !secret.Valid {
+		return ErrMFANotEnabled
+	}
+	step, ok := auth.TOTPStep(code, secret.String, time.Now())
+	if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+		return ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(`UPDATE users SET totp_last_step = $2 WHERE id = $1`, userID, step)
+	return err
+}
+
+// useRecoveryCode spends a recovery code as part of tx, so it's only gone
+// once tx commits
+func useRecoveryCode(tx *sql.Tx, userID, code string) error {
+	result, err := tx.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash)
+			VALUES ($1, $2)
+		`, userID, auth.HashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
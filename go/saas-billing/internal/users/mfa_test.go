@@ -0,0 +1,73 @@
+package users
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTOTPAcceptsEachCodeOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	enrollment, err := auth.GenerateTOTP("user@example.com")
+	require.NoError(t, err)
+	now := time.Now()
+	code, err := totp.GenerateCode(enrollment.Secret, now)
+	require.NoError(t, err)
+	step := now.Unix() / 30
+
+	lookup := func(lastStep interface{}) {
+		mock.ExpectQuery(`SELECT totp_secret, mfa_enabled, totp_last_step FROM users`).
+			WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "mfa_enabled", "totp_last_step"}).
+				AddRow(enrollment.Secret, true, lastStep))
+	}
+
+	// First use records the code's step
+	mock.ExpectBegin()
+	lookup(nil)
+	mock.ExpectExec(`UPDATE users SET totp_last_step`).WithArgs("user-1", step).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The same code again is refused without touching the row
+	mock.ExpectBegin()
+	lookup(step)
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	assert.NoError(t, checkTOTP(tx, "user-1", code))
+
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	assert.ErrorIs(t, checkTOTP(tx, "user-1", code), ErrInvalidMFACode)
+	require.NoError(t, tx.Rollback())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecoveryCodeIsKeptWhenTransactionRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at = NOW\(\)`).
+		WithArgs("user-1", auth.HashRecoveryCode("abcde-fghij")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	assert.NoError(t, useRecoveryCode(tx, "user-1", "abcde-fghij"))
+	// Nothing is committed, so the code is still unused
+	require.NoError(t, tx.Rollback())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
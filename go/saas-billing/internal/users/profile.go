@@ -0,0 +1,281 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/linkmeAman/saas-billing/internal/config"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+)
+
+var (
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrInvalidProfile    = errors.New("invalid profile")
+	ErrEmailTaken        = errors.New("email address is already in use")
+	ErrSameEmail         = errors.New("new email address is the same as the current one")
+)
+
+// ProfileUpdate holds the profile fields to change; nil fields are kept
+type ProfileUpdate struct {
+	Name      *string
+	AvatarURL *string
+	Locale    *string
+	Timezone  *string
+}
+
+// localePattern accepts BCP 47 tags such as "en", "pt-BR" or "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// GetProfile returns the user with their profile and account status
+func (s *UserService) GetProfile(userID string) (*User, error) {
+	return scanProfile(s.db.QueryRow(`
+		SELECT `+profileColumns+` FROM users WHERE id = $1
+	`, userID))
+}
+
+// UpdateProfile changes the given profile fields. An empty avatar URL
+// removes the avatar.
+func (s *UserService) UpdateProfile(userID string, update ProfileUpdate, actor audit.Actor) (*User, error) {
+	if err := validateProfile(update); err != nil {
+		return nil, err
+	}
+
+	before, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := scanProfile(s.db.QueryRow(`
+		UPDATE users SET
+			name = COALESCE($2, name),
+			avatar_url = CASE WHEN $3::text IS NULL THEN avatar_url ELSE NULLIF($3, '') END,
+			locale = COALESCE($4, locale),
+			timezone = COALESCE($5, timezone),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+profileColumns,
+		userID, update.Name, update.AvatarURL, update.Locale, update.Timezone))
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      actor,
+		Action:     "user.profile_updated",
+		TargetType: "user",
+		TargetID:   userID,
+		Before:     profileState(before),
+		After:      profileState(user),
+	})
+	return user, nil
+}
+
+// ChangePassword replaces the password after checking the current one
+func (s *UserService) ChangePassword(userID, currentPassword, newPassword string, actor audit.Actor) error {
+	email, err := s.checkCurrentPassword(userID, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.ValidatePassword(newPassword, email); err != nil {
+		return fmt.Errorf("%w: %w", ErrWeakPassword, err)
+	}
+
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users SET password_hash = $1, updated_at = NOW()
+		WHERE id = $2
+	`, hashedPassword, userID)
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      actor,
+		Action:     "user.password_changed",
+		TargetType: "user",
+		TargetID:   userID,
+	})
+	s.notify(userID, email, "Your password was changed",
+		"The password for your account was just changed. If this wasn't you, reset your password and contact support.")
+	return nil
+}
+
+// ChangeEmail starts moving the account to newEmail. The address only
+// changes once the link sent to it is followed; until then the user keeps
+// signing in with the current one.
+func (s *UserService) ChangeEmail(userID, currentPassword, newEmail string, actor audit.Actor) error {
+	email, err := s.checkCurrentPassword(userID, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	if strings.EqualFold(newEmail, email) {
+		return ErrSameEmail
+	}
+
+	var taken bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))
+	`, newEmail).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	// A later request replaces an earlier one, whose link stops working
+	_, err = s.db.Exec(`
+		UPDATE users SET pending_email = $1, updated_at = NOW() WHERE id = $2
+	`, newEmail, userID)
+	if err != nil {
+		return err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      actor,
+		Action:     "user.email_change_requested",
+		TargetType: "user",
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"new_email": newEmail},
+	})
+	s.sendEmailChangeVerification(userID, newEmail)
+	s.notify(userID, email, "Email change requested",
+		fmt.Sprintf("A change of your account's email address to %s was requested. "+
+			"Nothing changes until the new address is confirmed. If this wasn't you, change your password.", newEmail))
+	return nil
+}
+
+// confirmEmailChange moves the account to its pending address when the
+// verification token was issued for it. It reports false when the token is
+// for some other address.
+func (s *UserService) confirmEmailChange(userID, email string) (*User, bool, error) {
+	var user User
+	var previous string
+	err := s.db.QueryRow(`
+		UPDATE users u SET email = u.pending_email, pending_email = NULL,
+			email_verified_at = NOW(), updated_at = NOW()
+		FROM users old
+		WHERE u.id = $1 AND old.id = u.id AND lower(u.pending_email) = lower($2)
+		RETURNING u.id, u.email, old.email
+	`, userID, email).Scan(&user.ID, &user.Email, &previous)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, false, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      audit.Actor{UserID: user.ID},
+		Action:     "user.email_changed",
+		TargetType: "user",
+		TargetID:   user.ID,
+		Before:     map[string]interface{}{"email": previous},
+		After:      map[string]interface{}{"email": user.Email},
+	})
+	s.notify(user.ID, previous, "Your email address was changed",
+		fmt.Sprintf("Your account now signs in with %s. If this wasn't you, contact support.", user.Email))
+	return &user, true, nil
+}
+
+// checkCurrentPassword returns the user's email when password is correct
+func (s *UserService) checkCurrentPassword(userID, password string) (string, error) {
+	var email, hashedPassword string
+	err := s.db.QueryRow(`
+		SELECT email, password_hash FROM users WHERE id = $1
+	`, userID).Scan(&email, &hashedPassword)
+	if err != nil {
+		return "", err
+	}
+
+	if !auth.CheckPasswordHash(password, hashedPassword) {
+		return "", ErrIncorrectPassword
+	}
+	return email, nil
+}
+
+func (s *UserService) sendEmailChangeVerification(userID, email string) {
+	token, err := auth.GenerateEmailVerificationToken(userID, email, emailVerificationTTL)
+	if err != nil {
+		logger.Error("Failed to create verification token", err, logger.Fields{"user_id": userID})
+		return
+	}
+
+	body := fmt.Sprintf("Confirm this address for your account:\n%s/verify-email?token=%s\n\n"+
+		"The link expires in %d hours. If you didn't ask for this, you can ignore this email.",
+		config.AppURL(), token, int(emailVerificationTTL.Hours()))
+
+	if err := s.mailer.Send(email, "Confirm your new email address", body); err != nil {
+		logger.Error("Failed to send verification email", err, logger.Fields{"user_id": userID})
+	}
+}
+
+// notify emails a security notice; failures are logged
+func (s *UserService) notify(userID, email, subject, body string) {
+	if err := s.mailer.Send(email, subject, body); err != nil {
+		logger.Error("Failed to send account notification", err, logger.Fields{"user_id": userID})
+	}
+}
+
+func validateProfile(update ProfileUpdate) error {
+	if update.Name != nil && len(*update.Name) > 100 {
+		return fmt.Errorf("%w: name is longer than 100 characters", ErrInvalidProfile)
+	}
+	if update.AvatarURL != nil && *update.AvatarURL != "" {
+		u, err := url.Parse(*update.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%w: avatar_url must be an https URL", ErrInvalidProfile)
+		}
+	}
+	if update.Locale != nil && !localePattern.MatchString(*update.Locale) {
+		return fmt.Errorf("%w: locale must be a language tag such as en or pt-BR", ErrInvalidProfile)
+	}
+	if update.Timezone != nil {
+		// LoadLocation also accepts "" and "Local", which aren't real zones
+		if _, err := time.LoadLocation(*update.Timezone); err != nil || *update.Timezone == "" || *update.Timezone == "Local" {
+			return fmt.Errorf("%w: timezone must be an IANA name such as Europe/Berlin", ErrInvalidProfile)
+		}
+	}
+	return nil
+}
+
+const profileColumns = `id, email, name, COALESCE(avatar_url, ''), locale, timezone,
+	email_verified_at IS NOT NULL, COALESCE(pending_email, ''), mfa_enabled, created_at`
+
+func scanProfile(row *sql.Row) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Email, &user.Name, &user.AvatarURL, &user.Locale, &user.Timezone,
+		&user.EmailVerified, &user.PendingEmail, &user.MFAEnabled, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func profileState(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       user.Name,
+		"avatar_url": user.AvatarURL,
+		"locale":     user.Locale,
+		"timezone":   user.Timezone,
+	}
+}
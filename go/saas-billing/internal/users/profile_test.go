@@ -0,0 +1,34 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProfile(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	assert.NoError(t, validateProfile(ProfileUpdate{}))
+	assert.NoError(t, validateProfile(ProfileUpdate{
+		Name:      str("Jane Doe"),
+		AvatarURL: str("https://cdn.example.com/jane.png"),
+		Locale:    str("pt-BR"),
+		Timezone:  str("Europe/Berlin"),
+	}))
+	// An empty avatar URL clears the avatar
+	assert.NoError(t, validateProfile(ProfileUpdate{AvatarURL: str("")}))
+
+	invalid := []ProfileUpdate{
+		{AvatarURL: str("http://cdn.example.com/jane.png")},
+		{AvatarURL: str("javascript:alert(1)")},
+		{Locale: str("english")},
+		{Locale: str("en_US")},
+		{Timezone: str("Mars/Olympus")},
+		{Timezone: str("")},
+		{Timezone: str("Local")},
+	}
+	for _, update := range invalid {
+		assert.ErrorIs(t, validateProfile(update), ErrInvalidProfile)
+	}
+}
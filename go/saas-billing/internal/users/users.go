@@ -2,25 +2,51 @@ package users
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/linkmeAman/saas-billing/internal/audit"
 	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+	"github.com/linkmeAman/saas-billing/internal/mailer"
 )
 
 type User struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"-"` // Password is never returned in JSON
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Password      string    `json:"-"` // Password is never returned in JSON
+	Name          string    `json:"name"`
+	AvatarURL     string    `json:"avatar_url,omitempty"`
+	Locale        string    `json:"locale"`
+	Timezone      string    `json:"timezone"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrWeakPassword       = errors.New("password does not meet the password policy")
+)
+
 type UserService struct {
-	db *sql.DB
+	db     *sql.DB
+	audit  *audit.AuditService
+	mailer mailer.Mailer
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, auditService *audit.AuditService, m mailer.Mailer) *UserService {
+	return &UserService{db: db, audit: auditService, mailer: m}
 }
 
+// Register creates the user and emails a link to verify the address
 func (s *UserService) Register(email, password string) error {
+	if err := auth.ValidatePassword(password, email); err != nil {
+		return fmt.Errorf("%w: %w", ErrWeakPassword, err)
+	}
+
 	// Hash the password
 	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
@@ -28,38 +54,205 @@ func (s *UserService) Register(email, password string) error {
 	}
 
 	// Insert the user
-	_, err = s.db.Exec(`
+	user := User{Email: email}
+	err = s.db.QueryRow(`
 		INSERT INTO users (email, password_hash)
 		VALUES ($1, $2)
-	`, email, hashedPassword)
+		RETURNING id
+	`, email, hashedPassword).Scan(&user.ID)
+	if err != nil {
+		return err
+	}
+
+	s.sendVerificationEmail(user)
+	return nil
+}
+
+// LoginResult is returned by Login. When the user has MFA enabled, Token is
+// empty and MFAToken must be exchanged via VerifyMFALogin.
+type LoginResult struct {
+	Token       string `json:"token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// LoginAttempt identifies a sign-in for the audit log
+type LoginAttempt struct {
+	OrgID  string // The organization signed in through, for SSO
+	UserID string // Empty when the email doesn't belong to an account
+	Email  string
+	IP     string
+	Method string // password, mfa or sso
+}
 
-	return err
+// AuditLogin records a completed sign-in as user.login, or as
+// user.login_failed with loginErr as the reason. A login that still needs
+// its second factor isn't complete and isn't recorded.
+func (s *UserService) AuditLogin(a LoginAttempt, loginErr error) {
+	e := audit.Event{
+		OrgID:    a.OrgID,
+		Actor:    audit.Actor{UserID: a.UserID, IP: a.IP},
+		Action:   "user.login",
+		Metadata: map[string]interface{}{"method": a.Method},
+	}
+	if a.UserID != "" {
+		e.TargetType = "user"
+		e.TargetID = a.UserID
+	}
+	if loginErr != nil {
+		// Nobody has authenticated yet
+		e.Actor.UserID = ""
+		e.Action = "user.login_failed"
+		e.Metadata["email"] = a.Email
+		e.Metadata["reason"] = loginErr.Error()
+	}
+	s.audit.Emit(e)
 }
 
-func (s *UserService) Login(email, password string) (string, error) {
+// Login checks credentials for a request from ip. Failures are counted per
+// account and per IP; see lockout.go for the throttling rules.
+func (s *UserService) Login(email, password, ip string) (*LoginResult, error) {
+	if err := s.checkIPThrottle(ip); err != nil {
+		return nil, err
+	}
+
 	var user User
 	var hashedPassword string
+	var mfaEnabled bool
+	var failedAttempts int
+	var lastFailedAt, lockedUntil sql.NullTime
 
 	// Get the user
 	err := s.db.QueryRow(`
-		SELECT id, email, password_hash
+		SELECT id, email, password_hash, mfa_enabled,
+			   failed_login_attempts, last_failed_login_at, locked_until
 		FROM users
 		WHERE email = $1
-	`, email).Scan(&user.ID, &user.Email, &hashedPassword)
+	`, email).Scan(&user.ID, &user.Email, &hashedPassword, &mfaEnabled,
+		&failedAttempts, &lastFailedAt, &lockedUntil)
 
+	attempt := LoginAttempt{UserID: user.ID, Email: email, IP: ip, Method: "password"}
 	if err == sql.ErrNoRows {
-		return "", err
+		if err := s.checkUnknownEmailThrottle(email); err != nil {
+			s.AuditLogin(attempt, err)
+			return nil, err
+		}
+
+		// Spend the same time as a real password check so response timing
+		// doesn't reveal which emails are registered
+		auth.CheckPasswordHash(password, dummyPasswordHash())
+		if err := s.recordIPFailure(ip, email); err != nil {
+			return nil, err
+		}
+		s.AuditLogin(attempt, ErrInvalidCredentials)
+		return nil, ErrInvalidCredentials
 	}
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if err := checkAccountThrottle(time.Now(), failedAttempts, lastFailedAt, lockedUntil); err != nil {
+		s.AuditLogin(attempt, err)
+		return nil, err
 	}
 
 	// Check password
 	if !auth.CheckPasswordHash(password, hashedPassword) {
-		return "", err
+		if err := s.recordFailure(user, ip); err != nil {
+			return nil, err
+		}
+		s.AuditLogin(attempt, ErrInvalidCredentials)
+		return nil, ErrInvalidCredentials
+	}
+
+	if failedAttempts > 0 {
+		if err := s.resetFailures(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Upgrade hashes made with an older algorithm or cost while we have the
+	// plaintext password
+	if auth.NeedsRehash(hashedPassword) {
+		s.rehashPassword(user.ID, password)
+	}
+
+	// Second factor required before an access token is issued
+	if mfaEnabled {
+		mfaToken, err := s.startMFAChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
 	}
 
 	// Generate JWT token
-	return auth.GenerateToken(user.ID)
+	token, err := auth.GenerateToken(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.AuditLogin(attempt, nil)
+	return &LoginResult{Token: token}, nil
+}
+
+// ExternalLogin finishes a sign-in for attempt.UserID whose first factor was
+// checked elsewhere, such as by an SSO provider. A locked account is refused
+// and a user with MFA enabled still has to pass the MFA challenge.
+func (s *UserService) ExternalLogin(attempt LoginAttempt) (*LoginResult, error) {
+	var mfaEnabled bool
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT mfa_enabled, locked_until FROM users WHERE id = $1
+	`, attempt.UserID).Scan(&mfaEnabled, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		err := &ThrottleError{RetryAfter: time.Until(lockedUntil.Time), Locked: true}
+		s.AuditLogin(attempt, err)
+		return nil, err
+	}
+
+	if mfaEnabled {
+		mfaToken, err := s.startMFAChallenge(attempt.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	token, err := auth.GenerateToken(attempt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	s.AuditLogin(attempt, nil)
+	return &LoginResult{Token: token}, nil
+}
+
+// IsPlatformAdmin reports whether the user operates the service itself.
+// The flag is set directly in the database.
+func (s *UserService) IsPlatformAdmin(userID string) (bool, error) {
+	var admin bool
+	err := s.db.QueryRow(`
+		SELECT is_platform_admin FROM users WHERE id = $1
+	`, userID).Scan(&admin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return admin, err
+}
+
+func (s *UserService) rehashPassword(userID, password string) {
+	newHash, err := auth.HashPassword(password)
+	if err == nil {
+		_, err = s.db.Exec(`
+			UPDATE users SET password_hash = $1, updated_at = NOW()
+			WHERE id = $2
+		`, newHash, userID)
+	}
+	if err != nil {
+		logger.Error("Failed to rehash password", err, logger.Fields{"user_id": userID})
+	}
 }
@@ -0,0 +1,93 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/saas-billing/internal/audit"
+	"github.com/linkmeAman/saas-billing/internal/auth"
+	"github.com/linkmeAman/saas-billing/internal/config"
+	"github.com/linkmeAman/saas-billing/internal/logger"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+)
+
+// VerifyEmail redeems a verification link and returns the verified user. A
+// link sent to a pending new address completes the email change.
+func (s *UserService) VerifyEmail(token string) (*User, error) {
+	claims, err := auth.ValidateScopedToken(token, auth.ScopeEmailVerify)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// The token names the address it was sent to, so it stops working once
+	// the email changes
+	var user User
+	err = s.db.QueryRow(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE id = $1 AND lower(email) = lower($2)
+		RETURNING id, email
+	`, claims.UserID, claims.Subject).Scan(&user.ID, &user.Email)
+	if err == sql.ErrNoRows {
+		changed, ok, err := s.confirmEmailChange(claims.UserID, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrInvalidVerificationToken
+		}
+		return changed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Emit(audit.Event{
+		Actor:      audit.Actor{UserID: user.ID},
+		Action:     "user.email_verified",
+		TargetType: "user",
+		TargetID:   user.ID,
+	})
+	return &user, nil
+}
+
+// ResendVerification emails a new verification link
+func (s *UserService) ResendVerification(userID string) error {
+	var user User
+	var verified bool
+	err := s.db.QueryRow(`
+		SELECT id, email, email_verified_at IS NOT NULL FROM users WHERE id = $1
+	`, userID).Scan(&user.ID, &user.Email, &verified)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return ErrEmailAlreadyVerified
+	}
+
+	s.sendVerificationEmail(user)
+	return nil
+}
+
+func (s *UserService) sendVerificationEmail(user User) {
+	token, err := auth.GenerateEmailVerificationToken(user.ID, user.Email, emailVerificationTTL)
+	if err != nil {
+		logger.Error("Failed to create verification token", err, logger.Fields{"user_id": user.ID})
+		return
+	}
+
+	body := fmt.Sprintf("Confirm your email address:\n%s/verify-email?token=%s\n\n"+
+		"The link expires in %d hours. If you didn't sign up, you can ignore this email.",
+		config.AppURL(), token, int(emailVerificationTTL.Hours()))
+
+	if err := s.mailer.Send(user.Email, "Confirm your email address", body); err != nil {
+		logger.Error("Failed to send verification email", err, logger.Fields{"user_id": user.ID})
+	}
+}